@@ -5,7 +5,7 @@ import { useEffect, useState } from 'react';
 import Header from '@/components/Header';
 import TaskTable from '@/components/TaskTable';
 import customAxios from '@/utils/axios';
-import type { Task } from '@/types/tasks';
+import type { Task, Tasks } from '@/types/tasks';
 import type { FC } from 'react';
 
 const HomePage: FC = () => {
@@ -14,11 +14,11 @@ const HomePage: FC = () => {
   const foundTasks = tasks.filter((task) => task.title.includes(searchText));
 
   const getTasks = async (): Promise<void> => {
-    const res = await customAxios.get<Task[]>('/tasks', {
+    const res = await customAxios.get<Tasks>('/tasks', {
       withCredentials: true,
     });
 
-    setTasks(res.data);
+    setTasks(res.data.tasks);
   };
 
   const postTask = async (title: string): Promise<void> => {
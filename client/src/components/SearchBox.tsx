@@ -13,7 +13,7 @@ const SearchBox: FC = () => {
   const getSearchedTasks = async (query: string): Promise<void> => {
     try {
       const res: AxiosResponse<Task[]> = await customAxios.get<Task[]>(
-        `/search?q=${query}`,
+        `/tasks/search?q=${encodeURIComponent(query)}`,
         { withCredentials: true }
       );
       setSearchedTasks(res.data);
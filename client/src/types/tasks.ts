@@ -5,3 +5,9 @@ export interface Task {
   isDone: boolean;
   createdAt: string;
 }
+
+// GET /tasks returns a page at a time; nextCursor is null on the last one
+export interface Tasks {
+  tasks: Task[];
+  nextCursor: string | null;
+}
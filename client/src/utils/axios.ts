@@ -1,26 +1,76 @@
 import axios from 'axios';
 import camelcaseKeys from 'camelcase-keys';
-import type { AxiosError, AxiosResponse } from 'axios';
+import type {
+  AxiosError,
+  AxiosResponse,
+  InternalAxiosRequestConfig,
+} from 'axios';
 
 const customAxios = axios.create();
 
 const origin = process.env.NEXT_PUBLIC_ORIGIN;
-const isDev = process.env.NODE_ENV === 'development';
 if (!origin) {
   customAxios.defaults.baseURL = 'http://localhost:80/api/v1/';
 } else {
   customAxios.defaults.baseURL = origin;
 }
 
-if (isDev) {
-  customAxios.defaults.headers['Access-Control-Allow-Origin'] = '*';
-  // customAxios.defaults.headers['Access-Control-Allow-Methods'] =
-  //   'GET, POST, PUT, DELETE, OPTIONS';
-  // customAxios.defaults.headers['Access-Control-Allow-Headers'] = '*';
-}
-
 customAxios.defaults.headers.post['Content-Type'] = 'application/json';
 
+// mutations authenticated by the jwt cookie have to echo a token bound to the session
+const csrfMethods = ['post', 'put', 'patch', 'delete'];
+let csrfToken: string | undefined;
+
+const fetchCSRFToken = async (): Promise<string | undefined> => {
+  try {
+    // the plain instance, so that a signed-out user is not sent to the login page from here
+    const res = await axios.get<{ csrf_token: string }>('/auth/csrf', {
+      baseURL: customAxios.defaults.baseURL,
+      withCredentials: true,
+    });
+    return res.data.csrf_token;
+  } catch (e: unknown) {
+    // the request itself will get the 401
+    return undefined;
+  }
+};
+
+// the csrf cookie outlives the access token, which /auth/csrf needs
+const readCSRFCookie = (): string | undefined =>
+  document.cookie
+    .split('; ')
+    .find((cookie) => cookie.startsWith('csrf_token='))
+    ?.slice('csrf_token='.length);
+
+// these read the refresh cookie, so they are guarded like the jwt cookie
+const refreshCookieURLs = ['/auth/refresh', '/auth/signout'];
+
+customAxios.interceptors.request.use(
+  async (config: InternalAxiosRequestConfig) => {
+    if (refreshCookieURLs.includes(config.url ?? '')) {
+      const token = csrfToken ?? readCSRFCookie();
+      if (token) {
+        config.headers['X-CSRF-Token'] = token;
+      }
+      return config;
+    }
+
+    // nothing else under /auth/ sits behind a session
+    if (
+      csrfMethods.includes(config.method ?? '') &&
+      !config.url?.startsWith('/auth/')
+    ) {
+      if (!csrfToken) {
+        csrfToken = await fetchCSRFToken();
+      }
+      if (csrfToken) {
+        config.headers['X-CSRF-Token'] = csrfToken;
+      }
+    }
+    return config;
+  }
+);
+
 customAxios.interceptors.response.use(
   (response: AxiosResponse) => {
     if (response.data) {
@@ -28,7 +78,44 @@ customAxios.interceptors.response.use(
     }
     return response;
   },
-  (error: AxiosError) => {
+  async (error: AxiosError) => {
+    const config = error.config as
+      | (InternalAxiosRequestConfig & {
+          _retried?: boolean;
+          _csrfRetried?: boolean;
+        })
+      | undefined;
+
+    // the token belongs to the session it was issued for; signing in again needs a new one
+    if (
+      error.response?.status === 403 &&
+      config &&
+      !config._csrfRetried &&
+      config.headers['X-CSRF-Token']
+    ) {
+      config._csrfRetried = true;
+      csrfToken = undefined;
+      return customAxios(config);
+    }
+
+    // the access token is short-lived; trade the refresh cookie for a new one once
+    if (
+      error.response?.status === 401 &&
+      config &&
+      !config._retried &&
+      !config.url?.startsWith('/auth/')
+    ) {
+      config._retried = true;
+      try {
+        await customAxios.post('/auth/refresh', undefined, {
+          withCredentials: true,
+        });
+        return customAxios(config);
+      } catch (e: unknown) {
+        // fall through to the login redirect
+      }
+    }
+
     if (error.response?.status === 401) {
       // redirect('/login');
       window.location.href = '/login';
@@ -0,0 +1,330 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/google/uuid"
+)
+
+func TestAdmin(t *testing.T) {
+	var (
+		adminHeader   map[string]string
+		supportHeader map[string]string
+		userHeader    map[string]string
+		target        handler.AdminUserResponse
+	)
+
+	signIn := func(t *testing.T, name string, password string) map[string]string {
+		t.Helper()
+
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", fmt.Sprintf(`{"name":%q,"password":%q,"return_token":true}`, name, password))
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		return map[string]string{"Authorization": "Bearer " + res.Token}
+	}
+
+	t.Run("setup users", func(t *testing.T) {
+		for _, name := range []string{"test_user19", "test_user20"} {
+			rec := doRequest(t, "POST", "/api/v1/auth/signup", fmt.Sprintf(`{"name":%q,"password":"pass"}`, name))
+			assert(t, 200, rec.Code)
+		}
+		setRole(t, "test_user19", handler.RoleAdmin)
+		setRole(t, "test_user20", handler.RoleSupport)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user21","password":"pass","email":"user21@example.com"}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignUpResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, nil, r.VerifyEmail(context.Background(), res.ID, "user21@example.com"))
+		smtp.waitMail(t, "user21@example.com")
+
+		adminHeader = signIn(t, "test_user19", "pass")
+		supportHeader = signIn(t, "test_user20", "pass")
+		userHeader = signIn(t, "test_user21", "pass")
+
+		for _, title := range []string{"admin_task1", "admin_task2"} {
+			rec := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":%q}`, title), userHeader)
+			assert(t, 200, rec.Code)
+		}
+	})
+
+	t.Run("search users", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/admin/users?q=test_user21", "", supportHeader)
+		assert(t, 200, rec.Code)
+
+		res := handler.AdminUsersResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 1, len(res))
+		assert(t, "user21@example.com", res[0].Email)
+		assert(t, handler.RoleUser, res[0].Role)
+		target = res[0]
+
+		rec2 := doRequest(t, "GET", "/api/v1/admin/users?role=admin&q=test_user1", "", supportHeader)
+		assert(t, 200, rec2.Code)
+
+		res2 := handler.AdminUsersResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res2))
+		assert(t, 1, len(res2))
+		assert(t, "test_user19", res2[0].Name)
+
+		// underscores match literally
+		rec3 := doRequest(t, "GET", "/api/v1/admin/users?q=test%5Fuser2%5F", "", supportHeader)
+		assert(t, 200, rec3.Code)
+		assert(t, "[]", rec3.Body.String())
+
+		rec4 := doRequest(t, "GET", "/api/v1/admin/users?limit=1000", "", supportHeader)
+		assert(t, 400, rec4.Code)
+	})
+
+	t.Run("plain users are turned away", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/admin/users", "", userHeader)
+		assert(t, 403, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/admin/users", "")
+		assert(t, 401, rec2.Code)
+	})
+
+	t.Run("task counts", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/admin/users/"+target.ID.String()+"/task-counts", "", supportHeader)
+		assert(t, 200, rec.Code)
+
+		res := handler.TaskCountsResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, handler.TaskCountsResponse{Total: 2, Done: 0, Open: 2}, res)
+
+		rec2 := doRequest(t, "GET", "/api/v1/admin/users/00000000-0000-0000-0000-000000000000/task-counts", "", supportHeader)
+		assert(t, 404, rec2.Code)
+	})
+
+	t.Run("support cannot change users", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/admin/users/"+target.ID.String()+"/disable", "", supportHeader)
+		assert(t, 403, rec.Code)
+
+		rec2 := doRequest(t, "PATCH", "/api/v1/admin/users/"+target.ID.String()+"/role", `{"role":"admin"}`, supportHeader)
+		assert(t, 403, rec2.Code)
+	})
+
+	t.Run("disable and enable", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/admin/users/"+target.ID.String()+"/disable", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks", "", userHeader)
+		assert(t, 401, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user21","password":"pass"}`)
+		assert(t, 403, rec3.Code)
+
+		rec4 := doRequest(t, "POST", "/api/v1/admin/users/"+target.ID.String()+"/enable", "", adminHeader)
+		assert(t, 200, rec4.Code)
+
+		userHeader = signIn(t, "test_user21", "pass")
+	})
+
+	t.Run("admins cannot lock themselves out", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/users/me", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		me := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &me))
+		assert(t, handler.RoleAdmin, me.Role)
+
+		rec2 := doRequest(t, "POST", "/api/v1/admin/users/"+me.ID.String()+"/disable", "", adminHeader)
+		assert(t, 400, rec2.Code)
+	})
+
+	t.Run("change role", func(t *testing.T) {
+		rec := doRequest(t, "PATCH", "/api/v1/admin/users/"+target.ID.String()+"/role", `{"role":"root"}`, adminHeader)
+		assert(t, 400, rec.Code)
+
+		rec2 := doRequest(t, "PATCH", "/api/v1/admin/users/"+target.ID.String()+"/role", `{"role":"support"}`, adminHeader)
+		assert(t, 200, rec2.Code)
+
+		rec3 := doRequest(t, "GET", "/api/v1/admin/users", "", userHeader)
+		assert(t, 200, rec3.Code)
+	})
+
+	t.Run("force password reset", func(t *testing.T) {
+		ctx := context.Background()
+
+		recToken := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":["tasks:read"]}`, userHeader)
+		assert(t, 200, recToken.Code)
+		token := handler.CreateTokenResponse{}
+		assert(t, nil, json.Unmarshal(recToken.Body.Bytes(), &token))
+
+		clientID, err := r.CreateOAuthClient(ctx, repository.CreateOAuthClientParams{
+			UserID:       target.ID,
+			Name:         "admin_client",
+			RedirectURIs: []string{"https://client.example.com/callback"},
+			Scopes:       []string{"tasks:read"},
+		})
+		assert(t, nil, err)
+		assert(t, nil, r.CreateOAuthCode(ctx, repository.CreateOAuthCodeParams{
+			Code:        "admin_code",
+			ClientID:    clientID,
+			UserID:      target.ID,
+			RedirectURI: "https://client.example.com/callback",
+			Scopes:      []string{"tasks:read"},
+			ExpiresAt:   time.Now().Add(time.Minute),
+		}))
+		grant, err := r.RedeemOAuthCode(ctx, repository.RedeemOAuthCodeParams{
+			Code:         "admin_code",
+			ClientID:     clientID,
+			RefreshToken: "admin_refresh_token",
+			ExpiresAt:    time.Now().Add(time.Hour),
+		})
+		assert(t, nil, err)
+
+		rec := doRequest(t, "POST", "/api/v1/admin/users/"+target.ID.String()+"/password-reset", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/users/me", "", userHeader)
+		assert(t, 401, rec2.Code)
+
+		// tokens and grants outlive a password, so they go with the sessions
+		recToken2 := doRequest(t, "GET", "/api/v1/tasks", "", map[string]string{"Authorization": "Bearer " + token.Token})
+		assert(t, 401, recToken2.Code)
+		grant, err = r.GetOAuthGrant(ctx, grant.ID)
+		assert(t, nil, err)
+		assert(t, true, grant.RevokedAt.Valid)
+
+		rec3 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user21","password":"pass"}`)
+		assert(t, 403, rec3.Code)
+
+		subject, body := smtp.waitMail(t, "user21@example.com")
+		assert(t, "Reset your password", subject)
+		m := regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("no link in %q", body)
+		}
+
+		rec4 := doRequest(t, "POST", "/api/v1/auth/password/reset", fmt.Sprintf(`{"token":%q,"password":"new_pass"}`, m[1]))
+		assert(t, 200, rec4.Code)
+
+		signIn(t, "test_user21", "new_pass")
+
+		// without a verified address there is nowhere to send the link
+		rec5 := doRequest(t, "GET", "/api/v1/admin/users?q=test_user20", "", adminHeader)
+		res := handler.AdminUsersResponse{}
+		assert(t, nil, json.Unmarshal(rec5.Body.Bytes(), &res))
+		assert(t, 1, len(res))
+
+		rec6 := doRequest(t, "POST", "/api/v1/admin/users/"+res[0].ID.String()+"/password-reset", "", adminHeader)
+		assert(t, 409, rec6.Code)
+	})
+}
+
+func TestImpersonation(t *testing.T) {
+	var (
+		adminID     uuid.UUID
+		userID      uuid.UUID
+		adminHeader map[string]string
+		actHeader   map[string]string
+	)
+
+	t.Run("setup users", func(t *testing.T) {
+		for _, name := range []string{"test_user22", "test_user23"} {
+			rec := doRequest(t, "POST", "/api/v1/auth/signup", fmt.Sprintf(`{"name":%q,"password":"pass"}`, name))
+			assert(t, 200, rec.Code)
+
+			res := handler.SignUpResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			userIDMap[name] = res.ID
+		}
+		adminID, userID = userIDMap["test_user22"], userIDMap["test_user23"]
+		setRole(t, "test_user22", handler.RoleAdmin)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user22","password":"pass","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		adminHeader = map[string]string{"Authorization": "Bearer " + res.Token}
+	})
+
+	t.Run("start", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/admin/users/"+adminID.String()+"/impersonate", "", adminHeader)
+		assert(t, 400, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/admin/users/"+userID.String()+"/impersonate", "", adminHeader)
+		assert(t, 200, rec2.Code)
+
+		res := handler.ImpersonateResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+		assert(t, 900, res.ExpiresIn)
+		actHeader = map[string]string{"Authorization": "Bearer " + res.Token}
+	})
+
+	t.Run("act as user", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/users/me", "", actHeader)
+		assert(t, 200, rec.Code)
+
+		me := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &me))
+		assert(t, userID, me.ID)
+		assert(t, &adminID, me.ImpersonatedBy)
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"impersonated_task"}`, actHeader)
+		assert(t, 200, rec2.Code)
+	})
+
+	t.Run("dangerous endpoints are blocked", func(t *testing.T) {
+		rec := doRequest(t, "PATCH", "/api/v1/users/password", `{"password":"taken_over"}`, actHeader)
+		assert(t, 403, rec.Code)
+
+		rec2 := doRequest(t, "DELETE", "/api/v1/users/quit", "", actHeader)
+		assert(t, 403, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":["tasks:read"]}`, actHeader)
+		assert(t, 403, rec3.Code)
+
+		rec4 := doRequest(t, "PATCH", "/api/v1/users/name", `{"name":"taken_over"}`, actHeader)
+		assert(t, 403, rec4.Code)
+
+		rec5 := doRequest(t, "GET", "/api/v1/admin/users", "", actHeader)
+		assert(t, 403, rec5.Code)
+
+		rec6 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user23","password":"pass"}`)
+		assert(t, 200, rec6.Code)
+	})
+
+	t.Run("audit trail", func(t *testing.T) {
+		logs, err := r.GetAuditLogs(context.Background(), userID, 10)
+		assert(t, nil, err)
+
+		requests := []string{}
+		for _, log := range logs {
+			if log.Event == "impersonated_request" {
+				assert(t, adminID.String(), log.ActorID.String)
+				requests = append([]string{log.Detail}, requests...)
+			}
+		}
+		assert(t, []string{
+			"GET /api/v1/users/me",
+			"POST /api/v1/tasks",
+			"PATCH /api/v1/users/password",
+			"DELETE /api/v1/users/quit",
+			"POST /api/v1/users/me/tokens",
+			"PATCH /api/v1/users/name",
+			"GET /api/v1/admin/users",
+		}, requests)
+		assert(t, "impersonation_started", logs[len(logs)-1].Event)
+	})
+
+	t.Run("ends with the admin session", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signout", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/users/me", "", actHeader)
+		assert(t, 401, rec2.Code)
+	})
+}
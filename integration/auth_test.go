@@ -0,0 +1,448 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/keys"
+	"github.com/golang-jwt/jwt"
+)
+
+func TestJWKS(t *testing.T) {
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user7","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignUpResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		userIDMap["user7"] = res.ID
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user7","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		res2 := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res2))
+		jwtMap["user7"] = res2.Token
+	})
+
+	t.Run("get jwks", func(t *testing.T) {
+		t.Run("success", func(t *testing.T) {
+			t.Parallel()
+			rec := doRequest(t, "GET", "/.well-known/jwks.json", "")
+			assert(t, 200, rec.Code)
+
+			res := keys.JWKSet{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, 1, len(res.Keys))
+			assert(t, "OKP", res.Keys[0].KeyType)
+			assert(t, "EdDSA", res.Keys[0].Algorithm)
+
+			// tokens name the key that signed them
+			token, _, err := new(jwt.Parser).ParseUnverified(jwtMap["user7"], &handler.Claims{})
+			assert(t, nil, err)
+			assert(t, res.Keys[0].KeyID, token.Header["kid"])
+		})
+	})
+
+	t.Run("forged token", func(t *testing.T) {
+		t.Run("symmetric algorithm", func(t *testing.T) {
+			t.Parallel()
+			rec := doRequest(t, "GET", "/.well-known/jwks.json", "")
+			assert(t, 200, rec.Code)
+
+			res := keys.JWKSet{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+
+			// sign with the public key as an HMAC secret
+			claims := &handler.Claims{
+				UserID: userIDMap["user7"].String(),
+				StandardClaims: jwt.StandardClaims{
+					ExpiresAt: time.Now().Add(time.Hour).Unix(),
+				},
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			token.Header["kid"] = res.Keys[0].KeyID
+			forged, err := token.SignedString([]byte(res.Keys[0].X))
+			assert(t, nil, err)
+
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", forged),
+			}
+			rec2 := doRequest(t, "GET", "/api/v1/users/me", "", header)
+			assert(t, 401, rec2.Code)
+		})
+
+		t.Run("unknown key", func(t *testing.T) {
+			t.Parallel()
+			key, err := keys.Generate(keys.EdDSA)
+			assert(t, nil, err)
+
+			claims := &handler.Claims{
+				UserID: userIDMap["user7"].String(),
+				StandardClaims: jwt.StandardClaims{
+					ExpiresAt: time.Now().Add(time.Hour).Unix(),
+				},
+			}
+			token := jwt.NewWithClaims(key.SigningMethod(), claims)
+			token.Header["kid"] = key.ID
+			forged, err := token.SignedString(key.PrivateKey())
+			assert(t, nil, err)
+
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", forged),
+			}
+			rec := doRequest(t, "GET", "/api/v1/users/me", "", header)
+			assert(t, 401, rec.Code)
+		})
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	signIn := func(t *testing.T) handler.SignInResponse {
+		t.Helper()
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user8","password":"pass","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, false, res.RefreshToken == "")
+
+		return res
+	}
+
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user8","password":"pass"}`)
+		assert(t, 200, rec.Code)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		t.Parallel()
+		res := signIn(t)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s","return_token":true}`, res.RefreshToken))
+		assert(t, 200, rec.Code)
+
+		res2 := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res2))
+		assert(t, false, res2.RefreshToken == res.RefreshToken)
+
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res2.Token),
+		}
+		rec2 := doRequest(t, "GET", "/api/v1/users/me", "", header)
+		assert(t, 200, rec2.Code)
+
+		// the rotated token is accepted through the cookie too, given the CSRF token
+		cookieHeader := map[string]string{"Cookie": "refresh_token=" + res2.RefreshToken}
+		rec3 := doRequest(t, "POST", "/api/v1/auth/refresh", "", cookieHeader)
+		assert(t, 403, rec3.Code)
+
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "csrf_token" {
+				cookieHeader["X-CSRF-Token"] = cookie.Value
+			}
+		}
+		rec4 := doRequest(t, "POST", "/api/v1/auth/refresh", "", cookieHeader)
+		assert(t, 200, rec4.Code)
+	})
+
+	t.Run("reuse revokes the session", func(t *testing.T) {
+		t.Parallel()
+		res := signIn(t)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s","return_token":true}`, res.RefreshToken))
+		assert(t, 200, rec.Code)
+
+		res2 := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res2))
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, res.RefreshToken))
+		assert(t, 401, rec2.Code)
+
+		// every token of the session is dead now
+		rec3 := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, res2.RefreshToken))
+		assert(t, 401, rec3.Code)
+
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res2.Token),
+		}
+		rec4 := doRequest(t, "GET", "/api/v1/users/me", "", header)
+		assert(t, 401, rec4.Code)
+	})
+
+	t.Run("signout revokes the session", func(t *testing.T) {
+		t.Parallel()
+		res := signIn(t)
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res.Token),
+		}
+
+		rec := doRequest(t, "POST", "/api/v1/auth/signout", "", header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/users/me", "", header)
+		assert(t, 401, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, res.RefreshToken))
+		assert(t, 401, rec3.Code)
+	})
+
+	t.Run("signout rejects a rotated refresh token", func(t *testing.T) {
+		t.Parallel()
+		res := signIn(t)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s","return_token":true}`, res.RefreshToken))
+		assert(t, 200, rec.Code)
+
+		res2 := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res2))
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signout", fmt.Sprintf(`{"refresh_token":"%s"}`, res.RefreshToken))
+		assert(t, 401, rec2.Code)
+		rec3 := doRequest(t, "POST", "/api/v1/auth/signout", `{"refresh_token":"invalid"}`)
+		assert(t, 401, rec3.Code)
+
+		// as with refresh, presenting the rotated token gives the session up
+		rec4 := doRequest(t, "POST", "/api/v1/auth/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, res2.RefreshToken))
+		assert(t, 401, rec4.Code)
+	})
+
+	t.Run("invalid refresh token", func(t *testing.T) {
+		t.Parallel()
+		rec := doRequest(t, "POST", "/api/v1/auth/refresh", `{"refresh_token":"invalid"}`)
+		assert(t, 401, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/refresh", "")
+		assert(t, 401, rec2.Code)
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	tokenIn := func(t *testing.T, body string) string {
+		t.Helper()
+
+		m := regexp.MustCompile(`http://app\.example\.com/[a-z-]+\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("no link in %q", body)
+		}
+		return m[1]
+	}
+
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user17","password":"pass","email":"user17@example.com"}`)
+	assert(t, 200, rec.Code)
+
+	t.Run("unverified email gets no reset", func(t *testing.T) {
+		subject, _ := smtp.waitMail(t, "user17@example.com")
+		assert(t, "Verify your email address", subject)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/password/forgot", `{"email":"user17@example.com"}`)
+		assert(t, 200, rec.Code)
+		smtp.noMail(t, "user17@example.com")
+	})
+
+	t.Run("verify email", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user17","password":"pass","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		header := map[string]string{"Authorization": "Bearer " + res.Token}
+
+		// a changed address is mailed a new link
+		rec2 := doRequest(t, "PATCH", "/api/v1/users/email", `{"email":"user17@example.com"}`, header)
+		assert(t, 200, rec2.Code)
+
+		_, body := smtp.waitMail(t, "user17@example.com")
+		token := tokenIn(t, body)
+
+		rec3 := doRequest(t, "POST", "/api/v1/auth/email/verify", fmt.Sprintf(`{"token":%q}`, token))
+		assert(t, 200, rec3.Code)
+
+		rec4 := doRequest(t, "POST", "/api/v1/auth/email/verify", fmt.Sprintf(`{"token":%q}`, token))
+		assert(t, 400, rec4.Code)
+
+		rec5 := doRequest(t, "GET", "/api/v1/users/me", "", header)
+		assert(t, 200, rec5.Code)
+
+		me := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec5.Body.Bytes(), &me))
+		assert(t, "user17@example.com", me.Email)
+		assert(t, true, me.EmailVerified)
+	})
+
+	t.Run("email in use", func(t *testing.T) {
+		// an unverified claim does not lock the owner out, but cannot be verified either
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user17_other","password":"pass","email":"user17@example.com"}`)
+		assert(t, 200, rec.Code)
+
+		_, body := smtp.waitMail(t, "user17@example.com")
+		rec2 := doRequest(t, "POST", "/api/v1/auth/email/verify", fmt.Sprintf(`{"token":%q}`, tokenIn(t, body)))
+		assert(t, 409, rec2.Code)
+	})
+
+	t.Run("unknown email", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/password/forgot", `{"email":"nobody@example.com"}`)
+		assert(t, 200, rec.Code)
+		smtp.noMail(t, "nobody@example.com")
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user17","password":"pass","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		header := map[string]string{"Authorization": "Bearer " + res.Token}
+
+		recToken := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":["tasks:read"]}`, header)
+		assert(t, 200, recToken.Code)
+		pat := handler.CreateTokenResponse{}
+		assert(t, nil, json.Unmarshal(recToken.Body.Bytes(), &pat))
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/password/forgot", `{"email":"user17@example.com"}`)
+		assert(t, 200, rec2.Code)
+
+		subject, body := smtp.waitMail(t, "user17@example.com")
+		assert(t, "Reset your password", subject)
+		token := tokenIn(t, body)
+
+		// asking again right away sends nothing
+		rec3 := doRequest(t, "POST", "/api/v1/auth/password/forgot", `{"email":"user17@example.com"}`)
+		assert(t, 200, rec3.Code)
+		smtp.noMail(t, "user17@example.com")
+
+		rec4 := doRequest(t, "POST", "/api/v1/auth/password/reset", `{"token":"invalid","password":"reset_pass"}`)
+		assert(t, 400, rec4.Code)
+
+		rec5 := doRequest(t, "POST", "/api/v1/auth/password/reset", fmt.Sprintf(`{"token":%q,"password":"reset_pass"}`, token))
+		assert(t, 200, rec5.Code)
+
+		// single use
+		rec6 := doRequest(t, "POST", "/api/v1/auth/password/reset", fmt.Sprintf(`{"token":%q,"password":"other_pass"}`, token))
+		assert(t, 400, rec6.Code)
+
+		// sessions from before the reset are gone
+		rec7 := doRequest(t, "GET", "/api/v1/users/me", "", header)
+		assert(t, 401, rec7.Code)
+
+		// and so are the personal access tokens made with them
+		recToken2 := doRequest(t, "GET", "/api/v1/tasks", "", map[string]string{"Authorization": "Bearer " + pat.Token})
+		assert(t, 401, recToken2.Code)
+
+		rec8 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user17","password":"pass"}`)
+		assert(t, 401, rec8.Code)
+
+		rec9 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user17","password":"reset_pass"}`)
+		assert(t, 200, rec9.Code)
+	})
+}
+
+func TestCSRF(t *testing.T) {
+	cookies := map[string]string{}
+	var token string
+
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user24","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user24","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+		token = res.Token
+
+		for _, cookie := range rec2.Result().Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		assert(t, false, cookies["csrf_token"] == "")
+	})
+
+	cookieHeader := func(csrf string) map[string]string {
+		header := map[string]string{"Cookie": "jwt=" + cookies["jwt"]}
+		if csrf != "" {
+			header["X-CSRF-Token"] = csrf
+		}
+		return header
+	}
+
+	t.Run("cookie without token", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/tasks", `{"title":"csrf_task"}`, cookieHeader(""))
+		assert(t, 403, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"csrf_task"}`, cookieHeader("forged"))
+		assert(t, 403, rec2.Code)
+
+		// reads are not protected
+		rec3 := doRequest(t, "GET", "/api/v1/tasks", "", cookieHeader(""))
+		assert(t, 200, rec3.Code)
+
+		// an Authorization header that is not what authenticated the request does not exempt it
+		header := cookieHeader("")
+		header["Authorization"] = "Basic Zm9vOmJhcg=="
+		rec4 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"csrf_task"}`, header)
+		assert(t, 403, rec4.Code)
+	})
+
+	t.Run("cookie with token", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/tasks", `{"title":"csrf_task"}`, cookieHeader(cookies["csrf_token"]))
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/auth/csrf", "", cookieHeader(""))
+		assert(t, 200, rec2.Code)
+
+		res := handler.CSRFTokenResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+
+		rec3 := doRequest(t, "PATCH", "/api/v1/users/name", `{"name":"test_user24"}`, cookieHeader(res.CSRFToken))
+		assert(t, 200, rec3.Code)
+	})
+
+	t.Run("token of another session", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user24","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		other := ""
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "csrf_token" {
+				other = cookie.Value
+			}
+		}
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"csrf_task"}`, cookieHeader(other))
+		assert(t, 403, rec2.Code)
+	})
+
+	t.Run("bearer is exempt", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/tasks", `{"title":"csrf_task"}`, map[string]string{"Authorization": "Bearer " + token})
+		assert(t, 200, rec.Code)
+	})
+
+	t.Run("refresh token cookie", func(t *testing.T) {
+		header := map[string]string{"Cookie": "refresh_token=" + cookies["refresh_token"]}
+		rec := doRequest(t, "POST", "/api/v1/auth/refresh", "", header)
+		assert(t, 403, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signout", "", header)
+		assert(t, 403, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/auth/signout", "", cookieHeader(""))
+		assert(t, 403, rec3.Code)
+
+		header["X-CSRF-Token"] = cookies["csrf_token"]
+		rec4 := doRequest(t, "POST", "/api/v1/auth/refresh", "", header)
+		assert(t, 200, rec4.Code)
+
+		for _, cookie := range rec4.Result().Cookies() {
+			if cookie.Name == "refresh_token" {
+				header["Cookie"] = "refresh_token=" + cookie.Value
+			}
+		}
+		rec5 := doRequest(t, "POST", "/api/v1/auth/signout", "", header)
+		assert(t, 200, rec5.Code)
+	})
+}
@@ -0,0 +1,189 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/google/uuid"
+)
+
+func TestLabels(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user32","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user32","password":"pass","return_token":true}`)
+	assert(t, 200, rec2.Code)
+
+	signIn := handler.SignInResponse{}
+	assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", signIn.Token),
+	}
+
+	create := func(t *testing.T, body string) uuid.UUID {
+		t.Helper()
+
+		rec := doRequest(t, "POST", "/api/v1/labels", body, header)
+		assert(t, 200, rec.Code)
+
+		res := handler.CreateLabelResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res.ID
+	}
+	tasks := func(t *testing.T, query string) handler.GetTasksResponse {
+		t.Helper()
+
+		rec := doRequest(t, "GET", "/api/v1/tasks?sort=title"+query, "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+	titles := func(t *testing.T, query string) []string {
+		t.Helper()
+
+		res := tasks(t, query)
+		titles := make([]string, len(res.Tasks))
+		for i, task := range res.Tasks {
+			titles[i] = task.Title
+		}
+		return titles
+	}
+	labels := func(t *testing.T, title string) []string {
+		t.Helper()
+
+		for _, task := range tasks(t, "").Tasks {
+			if task.Title == title {
+				names := make([]string, len(task.Labels))
+				for i, label := range task.Labels {
+					names[i] = label.Name
+				}
+				return names
+			}
+		}
+		t.Fatalf("task %q not found", title)
+		return nil
+	}
+
+	workID := create(t, `{"name":"work","color":"#1E90FF"}`)
+	somedayID := create(t, `{"name":"someday"}`)
+	homeID := create(t, `{"name":"home"}`)
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"title":"report","label_ids":[%q]}`, workID),
+		fmt.Sprintf(`{"title":"plan","label_ids":[%q,%q,%q]}`, workID, somedayID, workID),
+		fmt.Sprintf(`{"title":"dishes","label_ids":[%q]}`, homeID),
+		`{"title":"nothing"}`,
+	} {
+		rec := doRequest(t, "POST", "/api/v1/tasks", body, header)
+		assert(t, 200, rec.Code)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/labels", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetLabelsResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 3, len(res))
+		assert(t, []string{"home", "someday", "work"}, []string{res[0].Name, res[1].Name, res[2].Name})
+		assert(t, "#1e90ff", res[2].Color)
+		assert(t, "#9e9e9e", res[1].Color)
+
+		assert(t, []string{"someday", "work"}, labels(t, "plan"))
+		assert(t, []string{}, labels(t, "nothing"))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, body := range []string{`{"name":""}`, `{"name":"-work"}`, `{"name":"red","color":"red"}`} {
+			rec := doRequest(t, "POST", "/api/v1/labels", body, header)
+			assert(t, 400, rec.Code)
+		}
+		rec := doRequest(t, "POST", "/api/v1/labels", `{"name":"WORK"}`, header)
+		assert(t, 409, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"lost","label_ids":[%q]}`, uuid.New()), header)
+		assert(t, 400, rec2.Code)
+		rec3 := doRequest(t, "GET", "/api/v1/tasks?label=-", "", header)
+		assert(t, 400, rec3.Code)
+		rec4 := doRequest(t, "GET", fmt.Sprintf("/api/v1/labels/%s", uuid.New()), "", header)
+		assert(t, 404, rec4.Code)
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		assert(t, []string{"plan", "report"}, titles(t, "&label=work"))
+		assert(t, []string{"report"}, titles(t, "&label=work&label=-someday"))
+		assert(t, []string{"plan"}, titles(t, "&label=work&label=someday"))
+		assert(t, []string{"dishes", "nothing"}, titles(t, "&label=-work&label=-someday"))
+	})
+
+	t.Run("update task", func(t *testing.T) {
+		report := tasks(t, "&title=report").Tasks[0]
+
+		// an update without label_ids leaves the labels alone
+		rec := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", report.ID), `{"title":"report"}`, header)
+		assert(t, 200, rec.Code)
+		assert(t, []string{"work"}, labels(t, "report"))
+
+		rec2 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", report.ID), fmt.Sprintf(`{"title":"report","label_ids":[%q]}`, homeID), header)
+		assert(t, 200, rec2.Code)
+		assert(t, []string{"home"}, labels(t, "report"))
+
+		rec3 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", report.ID), `{"title":"report","label_ids":[]}`, header)
+		assert(t, 200, rec3.Code)
+		assert(t, []string{}, labels(t, "report"))
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		rec := doRequest(t, "PUT", fmt.Sprintf("/api/v1/labels/%s", workID), `{"name":"job","color":"#ff0000"}`, header)
+		assert(t, 200, rec.Code)
+		assert(t, []string{"job", "someday"}, labels(t, "plan"))
+		assert(t, []string{"plan"}, titles(t, "&label=job"))
+
+		rec2 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/labels/%s", workID), `{"name":"Home"}`, header)
+		assert(t, 409, rec2.Code)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		rec := doRequest(t, "POST", fmt.Sprintf("/api/v1/labels/%s/merge", somedayID), fmt.Sprintf(`{"target_id":%q}`, somedayID), header)
+		assert(t, 400, rec.Code)
+		rec2 := doRequest(t, "POST", fmt.Sprintf("/api/v1/labels/%s/merge", somedayID), fmt.Sprintf(`{"target_id":%q}`, uuid.New()), header)
+		assert(t, 404, rec2.Code)
+
+		rec3 := doRequest(t, "POST", fmt.Sprintf("/api/v1/labels/%s/merge", homeID), fmt.Sprintf(`{"target_id":%q}`, workID), header)
+		assert(t, 200, rec3.Code)
+		assert(t, []string{"dishes", "plan"}, titles(t, "&label=job"))
+		assert(t, []string{}, titles(t, "&label=home"))
+
+		rec4 := doRequest(t, "GET", fmt.Sprintf("/api/v1/labels/%s", homeID), "", header)
+		assert(t, 404, rec4.Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rec := doRequest(t, "DELETE", fmt.Sprintf("/api/v1/labels/%s", somedayID), "", header)
+		assert(t, 200, rec.Code)
+		assert(t, []string{"job"}, labels(t, "plan"))
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user32_other","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user32_other","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		other := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &other))
+		otherHeader := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", other.Token),
+		}
+
+		rec3 := doRequest(t, "GET", fmt.Sprintf("/api/v1/labels/%s", workID), "", otherHeader)
+		assert(t, 404, rec3.Code)
+		rec4 := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"intruder","label_ids":[%q]}`, workID), otherHeader)
+		assert(t, 400, rec4.Code)
+	})
+}
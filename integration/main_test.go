@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"log"
 	"net/http/httptest"
 	"strings"
@@ -9,6 +10,12 @@ import (
 	"github.com/Irori235/system-design-2023-v2/internal/handler"
 	"github.com/Irori235/system-design-2023-v2/internal/migration"
 	"github.com/Irori235/system-design-2023-v2/internal/pkg/config"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/keys"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/mail"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/secretbox"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/security"
 	"github.com/Irori235/system-design-2023-v2/internal/repository"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +28,8 @@ import (
 var (
 	db        *sqlx.DB
 	engine    *gin.Engine
+	smtp      *smtpStandIn
+	issuer    *mockIssuer
 	r         *repository.Repository
 	h         *handler.Handler
 	userIDMap = make(map[string]uuid.UUID)
@@ -67,13 +76,74 @@ func TestMain(m *testing.M) {
 	}
 
 	// setup dependencies
-	r = repository.New(db)
-	h = handler.New(r)
+	keySet, err := keys.NewKeySet(keys.Config{Algorithm: keys.EdDSA, Ephemeral: true})
+	if err != nil {
+		log.Fatal("setup keys: ", err)
+	}
+
+	secrets, err := secretbox.New(make([]byte, secretbox.KeySize))
+	if err != nil {
+		log.Fatal("setup secrets: ", err)
+	}
+
+	// cheap parameters keep the many sign-ins in these tests fast
+	passwords, err := password.NewHasher(password.Params{
+		Algorithm:   password.Argon2id,
+		Memory:      8 * 1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+	if err != nil {
+		log.Fatal("setup passwords: ", err)
+	}
+
+	r, err = repository.New(db, passwords)
+	if err != nil {
+		log.Fatal("setup repository: ", err)
+	}
+	smtp, err = startSMTP()
+	if err != nil {
+		log.Fatal("start smtp: ", err)
+	}
+	defer smtp.Close()
+
+	issuer, err = startMockIssuer("todo", "secret")
+	if err != nil {
+		log.Fatal("start oidc issuer: ", err)
+	}
+	defer issuer.Close()
+
+	h = handler.New(r, keySet, secrets)
+	// test users sign up with short throwaway passwords
+	h.SetPasswordPolicy(password.Policy{})
+	h.SetMailer(&mail.SMTPMailer{Addr: smtp.Addr()}, "Todo <no-reply@example.com>")
+	h.SetPublicURL("http://app.example.com")
+	h.SetOIDCProviders(map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(oidc.Config{
+			Issuer:       issuer.URL(),
+			ClientID:     "todo",
+			ClientSecret: "secret",
+			RedirectURL:  "http://app.example.com/api/v1/auth/oidc/mock/callback",
+			Scopes:       []string{"email", "profile"},
+		}, issuer.server.Client()),
+	})
+	securityPolicy := security.DefaultPolicy()
+	securityPolicy.CORS.AllowOrigins = []string{"http://app.example.com"}
+	h.SetCookiePolicy(securityPolicy.Cookie)
+
 	engine = gin.New()
 	engine.Use(gin.Recovery())
 	// engine.Use(gin.Logger())
+	engine.Use(security.Middleware(securityPolicy))
 
 	h.SetupRoutes(engine.Group("/api/v1"))
+	h.SetupWellKnownRoutes(engine.Group("/.well-known"))
+
+	// the default password policy is exercised under its own prefix
+	strict := handler.New(r, keySet, secrets)
+	strict.SetupRoutes(engine.Group("/strict/api/v1"))
 
 	log.Println("start integration test")
 	m.Run()
@@ -110,3 +180,12 @@ func assert(t *testing.T, expected any, actual any) {
 		t.Fail()
 	}
 }
+
+// setRole gives the user with the name a role directly in the store, the way an admin API call would
+func setRole(t *testing.T, name string, role string) {
+	t.Helper()
+
+	userID, err := r.GetUserID(context.Background(), name)
+	assert(t, nil, err)
+	assert(t, nil, r.UpdateRole(context.Background(), userID, role))
+}
@@ -0,0 +1,268 @@
+package integration
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+)
+
+func TestOAuth(t *testing.T) {
+	var (
+		header   map[string]string
+		client   handler.RegisterClientResponse
+		verifier = oidc.NewRandom()
+	)
+
+	basicHeader := func() map[string]string {
+		return map[string]string{
+			"Content-Type":  "application/x-www-form-urlencoded",
+			"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(client.ClientID.String()+":"+client.ClientSecret)),
+		}
+	}
+
+	authorizeQuery := func(challenge string) string {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID.String()},
+			"redirect_uri":          {"https://app.example.org/callback"},
+			"scope":                 {"tasks:read"},
+			"state":                 {"xyz"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}.Encode()
+	}
+
+	// consent approves the default request and returns the code sent back to the client
+	consent := func(t *testing.T) string {
+		t.Helper()
+
+		body := fmt.Sprintf(`{"response_type":"code","client_id":"%s","redirect_uri":"https://app.example.org/callback","scope":"tasks:read","state":"xyz","code_challenge":"%s","code_challenge_method":"S256","approve":true}`, client.ClientID, oidc.S256Challenge(verifier))
+		rec := doRequest(t, "POST", "/api/v1/oauth/authorize", body, header)
+		assert(t, 200, rec.Code)
+
+		res := handler.ConsentResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+
+		u, err := url.Parse(res.RedirectTo)
+		assert(t, nil, err)
+		assert(t, "app.example.org", u.Host)
+		assert(t, "xyz", u.Query().Get("state"))
+		assert(t, "http://app.example.com", u.Query().Get("iss"))
+		return u.Query().Get("code")
+	}
+
+	exchange := func(t *testing.T, code string) *handler.TokenResponse {
+		t.Helper()
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"https://app.example.org/callback"},
+			"code_verifier": {verifier},
+		}
+		rec := doRequest(t, "POST", "/api/v1/oauth/token", form.Encode(), basicHeader())
+		if rec.Code != 200 {
+			return nil
+		}
+
+		res := &handler.TokenResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), res))
+		return res
+	}
+
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user18","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user18","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+
+		header = map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res.Token),
+		}
+	})
+
+	t.Run("register client", func(t *testing.T) {
+		t.Run("invalid", func(t *testing.T) {
+			rec := doRequest(t, "POST", "/api/v1/oauth/clients", `{"name":"app","redirect_uris":["http://app.example.org/callback"],"scopes":["tasks:read"]}`, header)
+			assert(t, 400, rec.Code)
+
+			rec2 := doRequest(t, "POST", "/api/v1/oauth/clients", `{"name":"app","redirect_uris":["https://app.example.org/callback"],"scopes":["account"]}`, header)
+			assert(t, 400, rec2.Code)
+		})
+
+		t.Run("success", func(t *testing.T) {
+			rec := doRequest(t, "POST", "/api/v1/oauth/clients", `{"name":"app","redirect_uris":["https://app.example.org/callback"],"scopes":["tasks:read","user:read"]}`, header)
+			assert(t, 200, rec.Code)
+
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &client))
+			assert(t, false, client.ClientSecret == "")
+
+			rec2 := doRequest(t, "GET", "/api/v1/oauth/clients", "", header)
+			assert(t, 200, rec2.Code)
+
+			res := handler.GetClientsResponse{}
+			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+			assert(t, 1, len(res))
+			assert(t, client.ClientID, res[0].ClientID)
+			assert(t, false, res[0].Public)
+		})
+	})
+
+	t.Run("consent screen", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/oauth/authorize?"+authorizeQuery(oidc.S256Challenge(verifier)), "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetConsentResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, "app", res.ClientName)
+		assert(t, []string{"tasks:read"}, res.Scopes)
+
+		// PKCE is required
+		rec2 := doRequest(t, "GET", "/api/v1/oauth/authorize?"+authorizeQuery(""), "", header)
+		assert(t, 400, rec2.Code)
+
+		rec3 := doRequest(t, "GET", "/api/v1/oauth/authorize?"+authorizeQuery(oidc.S256Challenge(verifier)), "")
+		assert(t, 401, rec3.Code)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		body := fmt.Sprintf(`{"response_type":"code","client_id":"%s","redirect_uri":"https://app.example.org/callback","state":"xyz","code_challenge":"%s","code_challenge_method":"S256","approve":false}`, client.ClientID, oidc.S256Challenge(verifier))
+		rec := doRequest(t, "POST", "/api/v1/oauth/authorize", body, header)
+		assert(t, 200, rec.Code)
+
+		res := handler.ConsentResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		u, err := url.Parse(res.RedirectTo)
+		assert(t, nil, err)
+		assert(t, "access_denied", u.Query().Get("error"))
+		assert(t, "", u.Query().Get("code"))
+	})
+
+	var tokens *handler.TokenResponse
+
+	t.Run("exchange code", func(t *testing.T) {
+		code := consent(t)
+
+		t.Run("wrong verifier", func(t *testing.T) {
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {code},
+				"redirect_uri":  {"https://app.example.org/callback"},
+				"code_verifier": {oidc.NewRandom()},
+			}
+			rec := doRequest(t, "POST", "/api/v1/oauth/token", form.Encode(), basicHeader())
+			assert(t, 400, rec.Code)
+		})
+
+		t.Run("wrong secret", func(t *testing.T) {
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {code},
+				"redirect_uri":  {"https://app.example.org/callback"},
+				"code_verifier": {verifier},
+				"client_id":     {client.ClientID.String()},
+				"client_secret": {"wrong"},
+			}
+			rec := doRequest(t, "POST", "/api/v1/oauth/token", form.Encode(), map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+			assert(t, 401, rec.Code)
+		})
+
+		t.Run("success", func(t *testing.T) {
+			tokens = exchange(t, code)
+			assert(t, false, tokens == nil)
+			assert(t, "Bearer", tokens.TokenType)
+			assert(t, "tasks:read", tokens.Scope)
+		})
+	})
+
+	t.Run("use token", func(t *testing.T) {
+		oauthHeader := map[string]string{
+			"Authorization": "Bearer " + tokens.AccessToken,
+		}
+
+		rec := doRequest(t, "GET", "/api/v1/tasks", "", oauthHeader)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"from_app"}`, oauthHeader)
+		assert(t, 403, rec2.Code)
+
+		rec3 := doRequest(t, "GET", "/api/v1/oauth/clients", "", oauthHeader)
+		assert(t, 403, rec3.Code)
+	})
+
+	t.Run("introspect", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/oauth/introspect", url.Values{"token": {tokens.AccessToken}}.Encode(), basicHeader())
+		assert(t, 200, rec.Code)
+
+		res := handler.IntrospectionResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, true, res.Active)
+		assert(t, "test_user18", res.Username)
+		assert(t, "tasks:read", res.Scope)
+		assert(t, client.ClientID.String(), res.ClientID)
+
+		rec2 := doRequest(t, "POST", "/api/v1/oauth/introspect", url.Values{"token": {"unknown"}}.Encode(), basicHeader())
+		assert(t, 200, rec2.Code)
+		assert(t, `{"active":false}`, rec2.Body.String())
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {tokens.RefreshToken},
+		}
+		rec := doRequest(t, "POST", "/api/v1/oauth/token", form.Encode(), basicHeader())
+		assert(t, 200, rec.Code)
+
+		res := handler.TokenResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, false, res.RefreshToken == tokens.RefreshToken)
+
+		// refresh tokens are used once
+		rec2 := doRequest(t, "POST", "/api/v1/oauth/token", form.Encode(), basicHeader())
+		assert(t, 400, rec2.Code)
+
+		tokens = &res
+	})
+
+	t.Run("code reuse revokes grant", func(t *testing.T) {
+		code := consent(t)
+
+		first := exchange(t, code)
+		assert(t, false, first == nil)
+
+		second := exchange(t, code)
+		assert(t, true, second == nil)
+
+		rec := doRequest(t, "GET", "/api/v1/tasks", "", map[string]string{"Authorization": "Bearer " + first.AccessToken})
+		assert(t, 401, rec.Code)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/oauth/revoke", url.Values{"token": {tokens.RefreshToken}}.Encode(), basicHeader())
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks", "", map[string]string{"Authorization": "Bearer " + tokens.AccessToken})
+		assert(t, 401, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/oauth/introspect", url.Values{"token": {tokens.AccessToken}}.Encode(), basicHeader())
+		assert(t, `{"active":false}`, rec3.Body.String())
+	})
+
+	t.Run("delete client", func(t *testing.T) {
+		rec := doRequest(t, "DELETE", "/api/v1/oauth/clients/"+client.ClientID.String(), "", header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "DELETE", "/api/v1/oauth/clients/"+client.ClientID.String(), "", header)
+		assert(t, 404, rec2.Code)
+	})
+}
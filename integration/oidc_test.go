@@ -0,0 +1,279 @@
+package integration
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/golang-jwt/jwt"
+)
+
+// mockIssuer is an in-process OpenID Connect provider that signs in whoever login_hint names
+type mockIssuer struct {
+	server       *httptest.Server
+	key          ed25519.PrivateKey
+	clientID     string
+	clientSecret string
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	subject     string
+}
+
+func startMockIssuer(clientID string, clientSecret string) (*mockIssuer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mockIssuer{
+		key:          key,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		grants:       map[string]mockGrant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+	m.server = httptest.NewServer(mux)
+
+	return m, nil
+}
+
+func (m *mockIssuer) URL() string {
+	return m.server.URL
+}
+
+func (m *mockIssuer) Close() {
+	m.server.Close()
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 m.URL(),
+		"authorization_endpoint": m.URL() + "/authorize",
+		"token_endpoint":         m.URL() + "/token",
+		"jwks_uri":               m.URL() + "/jwks",
+	})
+}
+
+func (m *mockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != m.clientID || q.Get("code_challenge_method") != "S256" || q.Get("login_hint") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := oidc.NewRandom()
+	m.mu.Lock()
+	m.grants[code] = mockGrant{
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		subject:     q.Get("login_hint"),
+	}
+	m.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != m.clientID || clientSecret != m.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostFormValue("code")
+	m.mu.Lock()
+	grant, ok := m.grants[code]
+	delete(m.grants, code)
+	m.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != grant.redirectURI ||
+		oidc.S256Challenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"iss":                m.URL(),
+		"sub":                grant.subject,
+		"aud":                []string{m.clientID},
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              grant.nonce,
+		"email":              grant.subject + "@idp.example.com",
+		"email_verified":     true,
+		"preferred_username": grant.subject,
+	})
+	token.Header["kid"] = "mock"
+
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": oidc.NewRandom(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": "mock",
+			"use": "sig",
+			"alg": "EdDSA",
+			"x":   base64.RawURLEncoding.EncodeToString(m.key.Public().(ed25519.PublicKey)),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// oidcSignIn plays the browser through start, the issuer and the callback
+func oidcSignIn(t *testing.T, loginHint string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := doRequest(t, "GET", "/api/v1/auth/oidc/mock/start", "")
+	assert(t, 302, rec.Code)
+
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "oidc_state" {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("no state cookie")
+	}
+	assert(t, "/api/v1/auth", stateCookie.Path)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	res, err := client.Get(rec.Header().Get("Location") + "&login_hint=" + url.QueryEscape(loginHint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert(t, 302, res.StatusCode)
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, "app.example.com", callback.Host)
+
+	return doRequest(t, "GET", callback.RequestURI(), "", map[string]string{
+		"Cookie": fmt.Sprintf("%s=%s", stateCookie.Name, stateCookie.Value),
+	})
+}
+
+func TestOIDC(t *testing.T) {
+	me := func(t *testing.T, rec *httptest.ResponseRecorder) handler.GetMeResponse {
+		t.Helper()
+
+		cookies := []string{}
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == "jwt" {
+				cookies = append(cookies, cookie.Name+"="+cookie.Value)
+			}
+		}
+
+		rec2 := doRequest(t, "GET", "/api/v1/users/me", "", map[string]string{"Cookie": strings.Join(cookies, "; ")})
+		assert(t, 200, rec2.Code)
+
+		res := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+		return res
+	}
+
+	var first handler.GetMeResponse
+
+	t.Run("first sign-in creates a user", func(t *testing.T) {
+		rec := oidcSignIn(t, "oidc_user1")
+		assert(t, 302, rec.Code)
+		assert(t, "http://app.example.com/", rec.Header().Get("Location"))
+
+		first = me(t, rec)
+		assert(t, "oidc_user1", first.Name)
+	})
+
+	t.Run("next sign-in finds the same user", func(t *testing.T) {
+		rec := oidcSignIn(t, "oidc_user1")
+		assert(t, 302, rec.Code)
+		assert(t, first.ID, me(t, rec).ID)
+	})
+
+	t.Run("password reset required", func(t *testing.T) {
+		assert(t, nil, r.RequirePasswordReset(context.Background(), first.ID))
+
+		rec := oidcSignIn(t, "oidc_user1")
+		assert(t, 403, rec.Code)
+	})
+
+	t.Run("name taken by a password user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"oidc_user2","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignUpResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+
+		rec2 := oidcSignIn(t, "oidc_user2")
+		assert(t, 302, rec2.Code)
+
+		user := me(t, rec2)
+		assert(t, true, user.ID != res.ID)
+		assert(t, true, strings.HasPrefix(user.Name, "oidc_user2_"))
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/auth/oidc/mock/start", "")
+		assert(t, 302, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/auth/oidc/mock/callback?code=x&state=forged", "", map[string]string{
+			"Cookie": rec.Header().Get("Set-Cookie"),
+		})
+		assert(t, 400, rec2.Code)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/auth/oidc/nope/start", "")
+		assert(t, 404, rec.Code)
+	})
+}
@@ -0,0 +1,183 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/google/uuid"
+)
+
+func TestProjects(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user31","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user31","password":"pass","return_token":true}`)
+	assert(t, 200, rec2.Code)
+
+	signIn := handler.SignInResponse{}
+	assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", signIn.Token),
+	}
+
+	projects := func(t *testing.T, query string) handler.GetProjectsResponse {
+		t.Helper()
+
+		rec := doRequest(t, "GET", "/api/v1/projects"+query, "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetProjectsResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+	tasks := func(t *testing.T, projectID uuid.UUID) []string {
+		t.Helper()
+
+		rec := doRequest(t, "GET", fmt.Sprintf("/api/v1/projects/%s/tasks?sort=title", projectID), "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		titles := make([]string, len(res.Tasks))
+		for i, task := range res.Tasks {
+			titles[i] = task.Title
+		}
+		return titles
+	}
+	create := func(t *testing.T, name string) uuid.UUID {
+		t.Helper()
+
+		rec := doRequest(t, "POST", "/api/v1/projects", fmt.Sprintf(`{"name":%q}`, name), header)
+		assert(t, 200, rec.Code)
+
+		res := handler.CreateProjectResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res.ID
+	}
+
+	// a new user has an Inbox only, which tasks go to by default
+	inbox := projects(t, "")
+	assert(t, 1, len(inbox))
+	assert(t, "Inbox", inbox[0].Name)
+	assert(t, true, inbox[0].IsInbox)
+	inboxID := inbox[0].ID
+
+	rec3 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"errand"}`, header)
+	assert(t, 200, rec3.Code)
+	assert(t, []string{"errand"}, tasks(t, inboxID))
+
+	workID := create(t, "work")
+
+	t.Run("invalid", func(t *testing.T) {
+		rec0 := doRequest(t, "POST", "/api/v1/projects", `{"name":""}`, header)
+		assert(t, 400, rec0.Code)
+		rec1 := doRequest(t, "POST", "/api/v1/projects", `{"name":"WORK"}`, header)
+		assert(t, 409, rec1.Code)
+
+		rec := doRequest(t, "PUT", fmt.Sprintf("/api/v1/projects/%s", inboxID), `{"name":"Outbox"}`, header)
+		assert(t, 409, rec.Code)
+		rec2 := doRequest(t, "DELETE", fmt.Sprintf("/api/v1/projects/%s", inboxID), "", header)
+		assert(t, 409, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"lost","project_id":%q}`, uuid.New()), header)
+		assert(t, 400, rec3.Code)
+		rec4 := doRequest(t, "GET", fmt.Sprintf("/api/v1/projects/%s", uuid.New()), "", header)
+		assert(t, 404, rec4.Code)
+	})
+
+	t.Run("tasks", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"report","project_id":%q}`, workID), header)
+		assert(t, 200, rec.Code)
+		assert(t, []string{"report"}, tasks(t, workID))
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks?title=report", "", header)
+		assert(t, 200, rec2.Code)
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+		assert(t, 1, len(res.Tasks))
+		assert(t, workID, res.Tasks[0].ProjectID)
+
+		// an update without project_id leaves the task where it is
+		rec3 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", res.Tasks[0].ID), `{"title":"weekly report"}`, header)
+		assert(t, 200, rec3.Code)
+		assert(t, []string{"weekly report"}, tasks(t, workID))
+
+		rec4 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", res.Tasks[0].ID), fmt.Sprintf(`{"title":"weekly report","project_id":%q}`, inboxID), header)
+		assert(t, 200, rec4.Code)
+		assert(t, []string{"errand", "weekly report"}, tasks(t, inboxID))
+		assert(t, []string{}, tasks(t, workID))
+	})
+
+	t.Run("move", func(t *testing.T) {
+		rec := doRequest(t, "GET", fmt.Sprintf("/api/v1/projects/%s/tasks", inboxID), "", header)
+		assert(t, 200, rec.Code)
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 2, len(res.Tasks))
+
+		// nothing moves when a task is not found
+		body := fmt.Sprintf(`{"task_ids":[%q,%q]}`, res.Tasks[0].ID, uuid.New())
+		rec2 := doRequest(t, "POST", fmt.Sprintf("/api/v1/projects/%s/tasks/move", workID), body, header)
+		assert(t, 404, rec2.Code)
+		assert(t, 2, len(tasks(t, inboxID)))
+
+		body = fmt.Sprintf(`{"task_ids":[%q,%q,%q]}`, res.Tasks[0].ID, res.Tasks[1].ID, res.Tasks[0].ID)
+		rec3 := doRequest(t, "POST", fmt.Sprintf("/api/v1/projects/%s/tasks/move", workID), body, header)
+		assert(t, 200, rec3.Code)
+		assert(t, []string{"errand", "weekly report"}, tasks(t, workID))
+
+		rec4 := doRequest(t, "POST", fmt.Sprintf("/api/v1/projects/%s/tasks/move", workID), `{"task_ids":[]}`, header)
+		assert(t, 400, rec4.Code)
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		archivedID := create(t, "someday")
+		rec := doRequest(t, "PUT", fmt.Sprintf("/api/v1/projects/%s", archivedID), `{"name":"someday","archived":true}`, header)
+		assert(t, 200, rec.Code)
+
+		assert(t, 2, len(projects(t, "")))
+		all := projects(t, "?archived=true")
+		assert(t, 3, len(all))
+		assert(t, []string{"Inbox", "someday", "work"}, []string{all[0].Name, all[1].Name, all[2].Name})
+		assert(t, true, all[1].ArchivedAt != nil)
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"dream","project_id":%q}`, archivedID), header)
+		assert(t, 409, rec2.Code)
+		rec3 := doRequest(t, "POST", fmt.Sprintf("/api/v1/projects/%s/tasks/move", archivedID), fmt.Sprintf(`{"task_ids":[%q]}`, uuid.New()), header)
+		assert(t, 409, rec3.Code)
+
+		rec4 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/projects/%s", archivedID), `{"name":"someday"}`, header)
+		assert(t, 200, rec4.Code)
+		assert(t, 3, len(projects(t, "")))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		rec := doRequest(t, "DELETE", fmt.Sprintf("/api/v1/projects/%s", workID), "", header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", fmt.Sprintf("/api/v1/projects/%s", workID), "", header)
+		assert(t, 404, rec2.Code)
+		assert(t, []string{"errand", "weekly report"}, tasks(t, inboxID))
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user31_other","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user31_other","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		other := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &other))
+		otherHeader := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", other.Token),
+		}
+
+		rec3 := doRequest(t, "GET", fmt.Sprintf("/api/v1/projects/%s/tasks", inboxID), "", otherHeader)
+		assert(t, 404, rec3.Code)
+		rec4 := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"intruder","project_id":%q}`, inboxID), otherHeader)
+		assert(t, 400, rec4.Code)
+	})
+}
@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/security"
+)
+
+func TestSecurityPolicy(t *testing.T) {
+	t.Run("headers", func(t *testing.T) {
+		t.Parallel()
+		rec := doRequest(t, "GET", "/api/v1/ping", "")
+		assert(t, 200, rec.Code)
+
+		assert(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		assert(t, "DENY", rec.Header().Get("X-Frame-Options"))
+		assert(t, "no-referrer", rec.Header().Get("Referrer-Policy"))
+		assert(t, "default-src 'none'; frame-ancestors 'none'", rec.Header().Get("Content-Security-Policy"))
+		assert(t, "max-age=15552000", rec.Header().Get("Strict-Transport-Security"))
+	})
+
+	t.Run("cors", func(t *testing.T) {
+		t.Parallel()
+		preflight := func(origin string) *http.Response {
+			rec := doRequest(t, "OPTIONS", "/api/v1/tasks", "", map[string]string{
+				"Origin":                        origin,
+				"Access-Control-Request-Method": "POST",
+			})
+			return rec.Result()
+		}
+
+		res := preflight("http://app.example.com")
+		assert(t, 204, res.StatusCode)
+		assert(t, "http://app.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+		assert(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+
+		res2 := preflight("https://evil.example.com")
+		assert(t, 403, res2.StatusCode)
+		assert(t, "", res2.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("cookies", func(t *testing.T) {
+		t.Parallel()
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user25","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user25","password":"pass"}`)
+		assert(t, 200, rec2.Code)
+
+		for _, cookie := range rec2.Result().Cookies() {
+			assert(t, true, cookie.Secure)
+		}
+	})
+
+	t.Run("invalid combinations", func(t *testing.T) {
+		t.Parallel()
+		policy := security.DefaultPolicy()
+		assert(t, nil, policy.Validate())
+
+		insecure := security.DefaultPolicy()
+		insecure.Cookie.Secure = false
+		insecure.Cookie.SameSite = http.SameSiteNoneMode
+		insecure.HSTSMaxAge = 0
+		assert(t, "SameSite=None cookies must be Secure", insecure.Validate().Error())
+
+		wildcard := security.DefaultPolicy()
+		wildcard.CORS.AllowOrigins = []string{"*"}
+		assert(t, `CORS origin "*" must be scheme://host[:port]`, wildcard.Validate().Error())
+
+		preload := security.DefaultPolicy()
+		preload.HSTSPreload = true
+		assert(t, "HSTS preload needs includeSubDomains and a max-age of at least a year", preload.Validate().Error())
+	})
+}
@@ -0,0 +1,168 @@
+package integration
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is just enough of an SMTP server to receive what net/smtp sends
+type smtpStandIn struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	inbox map[string][]*mail.Message
+}
+
+func startSMTP() (*smtpStandIn, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &smtpStandIn{
+		listener: listener,
+		inbox:    map[string][]*mail.Message{},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *smtpStandIn) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *smtpStandIn) Close() error {
+	return s.listener.Close()
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = io.WriteString(conn, line+"\r\n")
+	}
+
+	reply("220 localhost ESMTP")
+
+	recipients := []string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			recipients = recipients[:0]
+			reply("250 OK")
+		case "RCPT":
+			addr := line[strings.Index(line, "<")+1 : strings.LastIndex(line, ">")]
+			recipients = append(recipients, addr)
+			reply("250 OK")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+
+			data := &strings.Builder{}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(data.String()))
+			if err != nil {
+				reply("554 " + err.Error())
+				continue
+			}
+
+			s.mu.Lock()
+			for _, to := range recipients {
+				s.inbox[to] = append(s.inbox[to], msg)
+			}
+			s.mu.Unlock()
+
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// waitMail returns the subject and decoded body of the oldest mail to addr not yet taken
+func (s *smtpStandIn) waitMail(t *testing.T, addr string) (string, string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		if msgs := s.inbox[addr]; len(msgs) > 0 {
+			msg := msgs[0]
+			s.inbox[addr] = msgs[1:]
+			s.mu.Unlock()
+
+			body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return subject, string(body)
+		}
+		s.mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("no mail to %s", addr)
+	return "", ""
+}
+
+// noMail checks that nothing arrives for addr for a while
+func (s *smtpStandIn) noMail(t *testing.T, addr string) {
+	t.Helper()
+
+	time.Sleep(200 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.inbox[addr]); n > 0 {
+		t.Errorf("%d unexpected mails to %s", n, addr)
+	}
+}
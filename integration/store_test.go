@@ -0,0 +1,11 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, r)
+}
@@ -1,11 +1,17 @@
 package integration
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
 	"github.com/google/uuid"
 	"golang.org/x/exp/slices"
 )
@@ -21,7 +27,7 @@ func TestTask(t *testing.T) {
 
 		userIDMap["user3"] = res.ID
 
-		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user3","password":"pass"}`)
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user3","password":"pass","return_token":true}`)
 		assert(t, 200, rec2.Code)
 
 		res2 := handler.SignInResponse{}
@@ -80,20 +86,20 @@ func TestTask(t *testing.T) {
 
 			res := handler.GetTasksResponse{}
 			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
-			assert(t, 3, len(res))
+			assert(t, 3, len(res.Tasks))
 
 			titles := []string{"test_title", "test_title2", "test_title3"}
 
-			for _, task := range res {
+			for _, task := range res.Tasks {
 				assert(t, false, uuid.Nil == task.ID)
 				assert(t, true, task.UserID == userIDMap["user3"])
 				assert(t, true, slices.Contains(titles, task.Title))
 				assert(t, false, task.IsDone)
 			}
 
-			taskMap["task1"] = res[0]
-			taskMap["task2"] = res[1]
-			taskMap["task3"] = res[2]
+			taskMap["task1"] = res.Tasks[0]
+			taskMap["task2"] = res.Tasks[1]
+			taskMap["task3"] = res.Tasks[2]
 		})
 	})
 
@@ -112,10 +118,10 @@ func TestTask(t *testing.T) {
 
 			res := handler.GetTasksResponse{}
 			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
-			assert(t, 3, len(res))
+			assert(t, 3, len(res.Tasks))
 
 			task1res := handler.GetTaskResponse{}
-			for _, task := range res {
+			for _, task := range res.Tasks {
 				if task.ID == taskMap["task1"].ID {
 					task1res = handler.GetTaskResponse{
 						ID:     task.ID,
@@ -154,10 +160,10 @@ func TestTask(t *testing.T) {
 
 			res := handler.GetTasksResponse{}
 			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
-			assert(t, 3, len(res))
+			assert(t, 3, len(res.Tasks))
 
 			task2res := handler.GetTaskResponse{}
-			for _, task := range res {
+			for _, task := range res.Tasks {
 				if task.ID == taskMap["task2"].ID {
 					task2res = handler.GetTaskResponse{
 						ID:     task.ID,
@@ -196,10 +202,10 @@ func TestTask(t *testing.T) {
 
 			res := handler.GetTasksResponse{}
 			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
-			assert(t, 3, len(res))
+			assert(t, 3, len(res.Tasks))
 
 			task3res := handler.GetTaskResponse{}
-			for _, task := range res {
+			for _, task := range res.Tasks {
 				if task.ID == taskMap["task3"].ID {
 					task3res = handler.GetTaskResponse{
 						ID:     task.ID,
@@ -251,11 +257,646 @@ func TestTask(t *testing.T) {
 
 			res := handler.GetTasksResponse{}
 			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
-			assert(t, 2, len(res))
+			assert(t, 2, len(res.Tasks))
 
-			for _, task := range res {
+			for _, task := range res.Tasks {
 				assert(t, false, task.ID == taskMap["task1"].ID)
 			}
 		})
 	})
 }
+
+func TestSearchTask(t *testing.T) {
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user4","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignUpResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		userIDMap["user4"] = res.ID
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user4","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		res2 := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res2))
+		jwtMap["user4"] = res2.Token
+
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res2.Token),
+		}
+		for _, title := range []string{"buy milk tea", "write report", "牛乳を買う"} {
+			rec := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"%s"}`, title), header)
+			assert(t, 200, rec.Code)
+		}
+	})
+
+	t.Run("search tasks", func(t *testing.T) {
+		t.Run("success", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user4"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/tasks/search?q=milk", "", header)
+			assert(t, 200, rec.Code)
+
+			res := handler.SearchTasksResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, 1, len(res))
+			assert(t, "buy milk tea", res[0].Title)
+			assert(t, "buy <mark>milk</mark> tea", res[0].Highlight)
+			assert(t, userIDMap["user4"], res[0].UserID)
+		})
+
+		t.Run("phrase and prefix", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user4"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/tasks/search?q=%22milk+tea%22", "", header)
+			assert(t, 200, rec.Code)
+
+			res := handler.SearchTasksResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, 1, len(res))
+
+			rec2 := doRequest(t, "GET", "/api/v1/tasks/search?q=rep*", "", header)
+			assert(t, 200, rec2.Code)
+
+			res2 := handler.SearchTasksResponse{}
+			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res2))
+			assert(t, 1, len(res2))
+			assert(t, "write report", res2[0].Title)
+		})
+
+		t.Run("japanese", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user4"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/tasks/search?q=%E7%89%9B%E4%B9%B3", "", header)
+			assert(t, 200, rec.Code)
+
+			res := handler.SearchTasksResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, 1, len(res))
+			assert(t, "<mark>牛乳</mark>を買う", res[0].Highlight)
+		})
+
+		t.Run("other user's tasks", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user3"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/tasks/search?q=milk", "", header)
+			assert(t, 200, rec.Code)
+
+			res := handler.SearchTasksResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, 0, len(res))
+		})
+
+		t.Run("empty query", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user4"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/tasks/search", "", header)
+			assert(t, 400, rec.Code)
+		})
+	})
+}
+
+func TestTaskOwnership(t *testing.T) {
+	t.Run("setup users", func(t *testing.T) {
+		for _, user := range []string{"user5", "user6"} {
+			rec := doRequest(t, "POST", "/api/v1/auth/signup", fmt.Sprintf(`{"name":"test_%s","password":"pass"}`, user))
+			assert(t, 200, rec.Code)
+
+			res := handler.SignUpResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			userIDMap[user] = res.ID
+
+			rec2 := doRequest(t, "POST", "/api/v1/auth/signin", fmt.Sprintf(`{"name":"test_%s","password":"pass","return_token":true}`, user))
+			assert(t, 200, rec2.Code)
+
+			res2 := handler.SignInResponse{}
+			assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res2))
+			jwtMap[user] = res2.Token
+		}
+
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user5"]),
+		}
+		rec := doRequest(t, "POST", "/api/v1/tasks", `{"title":"private_title"}`, header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks", "", header)
+		assert(t, 200, rec2.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+		assert(t, 1, len(res.Tasks))
+
+		taskMap["task5"] = res.Tasks[0]
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user5"]),
+		}
+		rec := doRequest(t, "GET", "/api/v1/tasks/"+taskMap["task5"].ID.String(), "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTaskResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, taskMap["task5"].ID, res.ID)
+
+		// updating with the same values still succeeds
+		rec2 := doRequest(t, "PUT", "/api/v1/tasks/"+taskMap["task5"].ID.String(), `{"title":"private_title","is_done":false}`, header)
+		assert(t, 200, rec2.Code)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user6"]),
+		}
+		path := "/api/v1/tasks/" + taskMap["task5"].ID.String()
+		missing := "/api/v1/tasks/" + uuid.New().String()
+
+		for _, p := range []string{path, missing} {
+			rec := doRequest(t, "GET", p, "", header)
+			assert(t, 404, rec.Code)
+			assert(t, `{"error":"task not found"}`, rec.Body.String())
+
+			rec2 := doRequest(t, "PUT", p, `{"title":"stolen_title","is_done":true}`, header)
+			assert(t, 404, rec2.Code)
+			assert(t, `{"error":"task not found"}`, rec2.Body.String())
+
+			rec3 := doRequest(t, "DELETE", p, "", header)
+			assert(t, 404, rec3.Code)
+			assert(t, `{"error":"task not found"}`, rec3.Body.String())
+		}
+
+		rec := doRequest(t, "GET", "/api/v1/tasks", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 0, len(res.Tasks))
+	})
+
+	t.Run("task unchanged", func(t *testing.T) {
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", jwtMap["user5"]),
+		}
+		rec := doRequest(t, "GET", "/api/v1/tasks/"+taskMap["task5"].ID.String(), "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTaskResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, "private_title", res.Title)
+		assert(t, false, res.IsDone)
+	})
+}
+
+func TestListTasks(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user28","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user28","password":"pass","return_token":true}`)
+	assert(t, 200, rec2.Code)
+
+	signIn := handler.SignInResponse{}
+	assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", signIn.Token),
+	}
+
+	for _, body := range []string{
+		`{"title":"list_c","due_at":"2030-01-03T09:00:00+09:00"}`,
+		`{"title":"list_a"}`,
+		`{"title":"list_b","due_at":"2030-01-01T00:00:00Z"}`,
+		`{"title":"other_d"}`,
+		`{"title":"list_e","due_at":"2030-01-02T00:00:00Z"}`,
+	} {
+		rec := doRequest(t, "POST", "/api/v1/tasks", body, header)
+		assert(t, 200, rec.Code)
+	}
+
+	list := func(t *testing.T, query string) handler.GetTasksResponse {
+		t.Helper()
+
+		rec := doRequest(t, "GET", "/api/v1/tasks?"+query, "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+	titles := func(res handler.GetTasksResponse) []string {
+		titles := make([]string, len(res.Tasks))
+		for i, task := range res.Tasks {
+			titles[i] = task.Title
+		}
+		return titles
+	}
+
+	t.Run("sort", func(t *testing.T) {
+		res := list(t, "sort=title")
+		assert(t, []string{"list_a", "list_b", "list_c", "list_e", "other_d"}, titles(res))
+		assert(t, (*string)(nil), res.NextCursor)
+		assert(t, "2030-01-03T00:00:00Z", res.Tasks[2].DueAt.Format(time.RFC3339))
+
+		assert(t, []string{"other_d", "list_e", "list_c", "list_b", "list_a"}, titles(list(t, "sort=title&order=desc")))
+
+		// tasks without a due date come last
+		assert(t, []string{"list_b", "list_e", "list_c"}, titles(list(t, "sort=due"))[:3])
+		assert(t, []string{"list_c", "list_e", "list_b"}, titles(list(t, "sort=due&order=desc"))[:3])
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		assert(t, []string{"list_a", "list_b", "list_c", "list_e"}, titles(list(t, "sort=title&title=LIST")))
+		assert(t, 0, len(list(t, "title=%25").Tasks))
+		assert(t, 0, len(list(t, "is_done=true").Tasks))
+		assert(t, 5, len(list(t, "is_done=false").Tasks))
+
+		hourAgo := url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339))
+		assert(t, 5, len(list(t, "created_after="+hourAgo).Tasks))
+		assert(t, 0, len(list(t, "created_before="+hourAgo).Tasks))
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		walked := []string{}
+		query := "sort=title&limit=2"
+		for i := 0; i < 3; i++ {
+			res := list(t, query)
+			walked = append(walked, titles(res)...)
+			if res.NextCursor == nil {
+				break
+			}
+			query = "sort=title&limit=2&cursor=" + *res.NextCursor
+		}
+		assert(t, []string{"list_a", "list_b", "list_c", "list_e", "other_d"}, walked)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		res := list(t, "sort=title&limit=1")
+
+		for _, query := range []string{
+			"sort=priority",
+			"order=up",
+			"limit=1000",
+			"is_done=maybe",
+			"created_after=yesterday",
+			"cursor=not-a-cursor",
+			// a cursor only continues the sort order it was made for
+			"sort=due&cursor=" + *res.NextCursor,
+		} {
+			rec := doRequest(t, "GET", "/api/v1/tasks?"+query, "", header)
+			assert(t, 400, rec.Code)
+		}
+	})
+}
+
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []reminder.Reminder
+	err  error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, r reminder.Reminder) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, r)
+	return nil
+}
+
+// of returns the reminders sent about taskID, as other tests schedule reminders as well
+func (n *recordingNotifier) of(taskID uuid.UUID) []reminder.Reminder {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sent := []reminder.Reminder{}
+	for _, r := range n.sent {
+		if r.TaskID == taskID {
+			sent = append(sent, r)
+		}
+	}
+	return sent
+}
+
+func TestTaskReminders(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user29","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user29","password":"pass","return_token":true}`)
+	assert(t, 200, rec2.Code)
+
+	signIn := handler.SignInResponse{}
+	assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", signIn.Token),
+	}
+
+	due := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	for _, body := range []string{
+		fmt.Sprintf(`{"title":"remind_me","due_at":%q,"time_zone":"Asia/Tokyo","reminders":[60,10,60]}`, due.Format(time.RFC3339)),
+		fmt.Sprintf(`{"title":"overdue","due_at":%q}`, past.Format(time.RFC3339)),
+		`{"title":"undated"}`,
+	} {
+		rec := doRequest(t, "POST", "/api/v1/tasks", body, header)
+		assert(t, 200, rec.Code)
+	}
+
+	rec3 := doRequest(t, "GET", "/api/v1/tasks?sort=title", "", header)
+	assert(t, 200, rec3.Code)
+
+	list := handler.GetTasksResponse{}
+	assert(t, nil, json.Unmarshal(rec3.Body.Bytes(), &list))
+	assert(t, 3, len(list.Tasks))
+	overdue, remindMe := list.Tasks[0], list.Tasks[1]
+	assert(t, "remind_me", remindMe.Title)
+
+	t.Run("time zone", func(t *testing.T) {
+		assert(t, "Asia/Tokyo", remindMe.TimeZone)
+		assert(t, []int{10, 60}, remindMe.Reminders)
+		assert(t, due.In(time.FixedZone("", 9*60*60)).Format(time.RFC3339), remindMe.DueAt.Format(time.RFC3339))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"title":"no_due","time_zone":"Asia/Tokyo"}`,
+			`{"title":"no_due","reminders":[10]}`,
+			fmt.Sprintf(`{"title":"bad_zone","due_at":%q,"time_zone":"Mars/Olympus"}`, due.Format(time.RFC3339)),
+			fmt.Sprintf(`{"title":"bad_zone","due_at":%q,"time_zone":"Local"}`, due.Format(time.RFC3339)),
+			fmt.Sprintf(`{"title":"many","due_at":%q,"reminders":[1,2,3,4,5,6]}`, due.Format(time.RFC3339)),
+			fmt.Sprintf(`{"title":"negative","due_at":%q,"reminders":[-1]}`, due.Format(time.RFC3339)),
+		} {
+			rec := doRequest(t, "POST", "/api/v1/tasks", body, header)
+			assert(t, 400, rec.Code)
+		}
+	})
+
+	t.Run("overdue", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/tasks/overdue", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 1, len(res.Tasks))
+		assert(t, overdue.ID, res.Tasks[0].ID)
+	})
+
+	t.Run("partial update", func(t *testing.T) {
+		// the client only sends the title and is_done, which leaves the due date and its reminders alone
+		rec := doRequest(t, "PUT", "/api/v1/tasks/"+remindMe.ID.String(), `{"title":"remind_me","is_done":false}`, header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks/"+remindMe.ID.String(), "", header)
+		assert(t, 200, rec2.Code)
+
+		res := handler.GetTaskResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+		assert(t, "Asia/Tokyo", res.TimeZone)
+		assert(t, []int{10, 60}, res.Reminders)
+		assert(t, remindMe.DueAt.Format(time.RFC3339), res.DueAt.Format(time.RFC3339))
+
+		// reminders cannot be sent along with clearing the due date
+		rec3 := doRequest(t, "PUT", "/api/v1/tasks/"+remindMe.ID.String(), `{"title":"remind_me","due_at":null,"reminders":[10]}`, header)
+		assert(t, 400, rec3.Code)
+
+		rec4 := doRequest(t, "PUT", "/api/v1/tasks/"+overdue.ID.String(), `{"title":"overdue","due_at":null}`, header)
+		assert(t, 200, rec4.Code)
+
+		rec5 := doRequest(t, "GET", "/api/v1/tasks/overdue", "", header)
+		assert(t, 200, rec5.Code)
+
+		overdueTasks := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec5.Body.Bytes(), &overdueTasks))
+		assert(t, 0, len(overdueTasks.Tasks))
+	})
+
+	t.Run("clear due date", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/tasks", fmt.Sprintf(`{"title":"clear_me","due_at":%q,"time_zone":"Asia/Tokyo","reminders":[10]}`, due.Format(time.RFC3339)), header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks?sort=title", "", header)
+		assert(t, 200, rec2.Code)
+
+		list := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &list))
+		clearMe := list.Tasks[0]
+		assert(t, "clear_me", clearMe.Title)
+
+		// the time zone and reminders go with the due date
+		rec3 := doRequest(t, "PUT", "/api/v1/tasks/"+clearMe.ID.String(), `{"title":"clear_me","due_at":null}`, header)
+		assert(t, 200, rec3.Code)
+
+		rec4 := doRequest(t, "GET", "/api/v1/tasks/"+clearMe.ID.String(), "", header)
+		assert(t, 200, rec4.Code)
+
+		res := handler.GetTaskResponse{}
+		assert(t, nil, json.Unmarshal(rec4.Body.Bytes(), &res))
+		assert(t, true, res.DueAt == nil)
+		assert(t, "", res.TimeZone)
+		assert(t, 0, len(res.Reminders))
+	})
+
+	t.Run("deliver", func(t *testing.T) {
+		failing := &recordingNotifier{err: errors.New("unavailable")}
+		err := reminder.NewScheduler(r, failing, reminder.Config{Lease: time.Minute}).Dispatch(context.Background(), due.Add(-50*time.Minute))
+		assert(t, true, err != nil)
+
+		// the failed reminder waits for its lease
+		notifier := &recordingNotifier{}
+		scheduler := reminder.NewScheduler(r, notifier, reminder.Config{Lease: time.Minute})
+		assert(t, nil, scheduler.Dispatch(context.Background(), due.Add(-50*time.Minute)))
+		assert(t, 0, len(notifier.of(remindMe.ID)))
+
+		assert(t, nil, scheduler.Dispatch(context.Background(), due.Add(-48*time.Minute)))
+		sent := notifier.of(remindMe.ID)
+		assert(t, 1, len(sent))
+		assert(t, "remind_me", sent[0].Title)
+		assert(t, due.Add(-time.Hour), sent[0].RemindAt.UTC())
+
+		// a restarted server does not send it again
+		restarted := reminder.NewScheduler(r, notifier, reminder.Config{Lease: time.Minute})
+		assert(t, nil, restarted.Dispatch(context.Background(), due.Add(-5*time.Minute)))
+		sent = notifier.of(remindMe.ID)
+		assert(t, 2, len(sent))
+		assert(t, due.Add(-10*time.Minute), sent[1].RemindAt.UTC())
+		assert(t, true, sent[0].ID != sent[1].ID)
+	})
+}
+
+func TestRecurringTasks(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user30","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user30","password":"pass","return_token":true}`)
+	assert(t, 200, rec2.Code)
+
+	signIn := handler.SignInResponse{}
+	assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", signIn.Token),
+	}
+
+	// Mondays at 9:00 in New York, across the start of daylight saving time
+	rec3 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"trash","due_at":"2030-03-04T09:00:00-05:00","time_zone":"America/New_York","recurrence":"rrule:freq=weekly;byday=mo"}`, header)
+	assert(t, 200, rec3.Code)
+
+	open := func(t *testing.T) handler.GetTaskResponse {
+		t.Helper()
+
+		rec := doRequest(t, "GET", "/api/v1/tasks?is_done=false", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 1, len(res.Tasks))
+		return res.Tasks[0]
+	}
+	update := func(t *testing.T, taskID uuid.UUID, body string) {
+		t.Helper()
+
+		rec := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", taskID), body, header)
+		assert(t, 200, rec.Code)
+	}
+	occurrences := func(t *testing.T, taskID uuid.UUID, query string) []string {
+		t.Helper()
+
+		rec := doRequest(t, "GET", fmt.Sprintf("/api/v1/tasks/%s/occurrences?%s", taskID, query), "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTaskOccurrencesResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		dates := make([]string, len(res))
+		for i, date := range res {
+			dates[i] = date.Format(time.RFC3339)
+		}
+		return dates
+	}
+
+	first := open(t)
+	assert(t, "FREQ=WEEKLY;BYDAY=MO", first.Recurrence)
+	assert(t, true, first.SeriesID != nil)
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"title":"no_due","recurrence":"FREQ=DAILY"}`,
+			`{"title":"hourly","due_at":"2030-03-04T09:00:00Z","recurrence":"FREQ=HOURLY"}`,
+			`{"title":"nonsense","due_at":"2030-03-04T09:00:00Z","recurrence":"every monday"}`,
+			`{"title":"both","due_at":"2030-03-04T09:00:00Z","recurrence":"FREQ=DAILY;COUNT=2;UNTIL=20300310"}`,
+		} {
+			rec := doRequest(t, "POST", "/api/v1/tasks", body, header)
+			assert(t, 400, rec.Code)
+		}
+
+		rec := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", first.ID), `{"title":"trash","scope":"all"}`, header)
+		assert(t, 400, rec.Code)
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		assert(t, []string{"2030-03-11T09:00:00-04:00", "2030-03-18T09:00:00-04:00"}, occurrences(t, first.ID, "count=2"))
+		assert(t, 10, len(occurrences(t, first.ID, "")))
+
+		rec := doRequest(t, "GET", fmt.Sprintf("/api/v1/tasks/%s/occurrences?count=101", first.ID), "", header)
+		assert(t, 400, rec.Code)
+	})
+
+	var second handler.GetTaskResponse
+	t.Run("complete", func(t *testing.T) {
+		update(t, first.ID, `{"title":"trash","is_done":true,"due_at":"2030-03-04T09:00:00-05:00","time_zone":"America/New_York","recurrence":"FREQ=WEEKLY;BYDAY=MO"}`)
+
+		second = open(t)
+		assert(t, "trash", second.Title)
+		assert(t, "2030-03-11T09:00:00-04:00", second.DueAt.Format(time.RFC3339))
+		assert(t, *first.SeriesID, *second.SeriesID)
+
+		// a done occurrence cannot be skipped
+		rec := doRequest(t, "POST", fmt.Sprintf("/api/v1/tasks/%s/skip", first.ID), "", header)
+		assert(t, 409, rec.Code)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		// an edit of this occurrence only is dropped with it
+		update(t, second.ID, `{"title":"bulky trash","due_at":"2030-03-12T09:00:00-04:00","time_zone":"America/New_York","recurrence":"FREQ=WEEKLY;BYDAY=MO","scope":"this"}`)
+
+		rec := doRequest(t, "POST", fmt.Sprintf("/api/v1/tasks/%s/skip", second.ID), "", header)
+		assert(t, 200, rec.Code)
+
+		skipped := open(t)
+		assert(t, second.ID, skipped.ID)
+		assert(t, "trash", skipped.Title)
+		assert(t, "2030-03-18T09:00:00-04:00", skipped.DueAt.Format(time.RFC3339))
+	})
+
+	t.Run("this and following", func(t *testing.T) {
+		update(t, second.ID, `{"title":"recycling","due_at":"2030-03-19T08:00:00-04:00","time_zone":"America/New_York","recurrence":"FREQ=WEEKLY;BYDAY=TU;COUNT=2"}`)
+
+		edited := open(t)
+		assert(t, "FREQ=WEEKLY;BYDAY=TU;COUNT=2", edited.Recurrence)
+		assert(t, true, *edited.SeriesID != *first.SeriesID)
+		assert(t, []string{"2030-03-26T08:00:00-04:00"}, occurrences(t, edited.ID, ""))
+
+		update(t, edited.ID, `{"title":"recycling","is_done":true,"due_at":"2030-03-19T08:00:00-04:00","time_zone":"America/New_York","recurrence":"FREQ=WEEKLY;BYDAY=TU;COUNT=2"}`)
+		last := open(t)
+		assert(t, "recycling", last.Title)
+		assert(t, "2030-03-26T08:00:00-04:00", last.DueAt.Format(time.RFC3339))
+
+		rec := doRequest(t, "POST", fmt.Sprintf("/api/v1/tasks/%s/skip", last.ID), "", header)
+		assert(t, 409, rec.Code)
+
+		// stopping the series leaves a plain task
+		update(t, last.ID, `{"title":"recycling","due_at":"2030-03-26T08:00:00-04:00","time_zone":"America/New_York","recurrence":""}`)
+		rec2 := doRequest(t, "GET", fmt.Sprintf("/api/v1/tasks/%s/occurrences", last.ID), "", header)
+		assert(t, 400, rec2.Code)
+	})
+}
+
+func TestCompleteRecurringTask(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user33","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user33","password":"pass","return_token":true}`)
+	assert(t, 200, rec2.Code)
+
+	signIn := handler.SignInResponse{}
+	assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", signIn.Token),
+	}
+
+	rec3 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"water plants","due_at":"2030-03-04T09:00:00Z","recurrence":"FREQ=DAILY"}`, header)
+	assert(t, 200, rec3.Code)
+
+	open := func(t *testing.T) handler.GetTaskResponse {
+		t.Helper()
+
+		rec := doRequest(t, "GET", "/api/v1/tasks?is_done=false", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 1, len(res.Tasks))
+		return res.Tasks[0]
+	}
+
+	// the client only sends the title and is_done, which leaves the task repeating
+	first := open(t)
+	rec4 := doRequest(t, "PUT", fmt.Sprintf("/api/v1/tasks/%s", first.ID), `{"title":"water plants","is_done":true}`, header)
+	assert(t, 200, rec4.Code)
+
+	next := open(t)
+	assert(t, true, next.ID != first.ID)
+	assert(t, "FREQ=DAILY", next.Recurrence)
+	assert(t, *first.SeriesID, *next.SeriesID)
+	assert(t, "2030-03-05T09:00:00Z", next.DueAt.Format(time.RFC3339))
+}
@@ -0,0 +1,295 @@
+package integration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/tlscert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert(t, nil, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Todo Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert(t, nil, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert(t, nil, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, serial int64, subject pkix.Name, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert(t, nil, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert(t, nil, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert(t, nil, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestMutualTLS(t *testing.T) {
+	var (
+		adminHeader map[string]string
+		accountID   string
+		baseURL     string
+	)
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	config := tlscert.Config{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+
+	writeServerCert := func(t *testing.T, serial int64) {
+		t.Helper()
+
+		certPEM, keyPEM := ca.issue(t, serial, pkix.Name{CommonName: "localhost"}, x509.ExtKeyUsageServerAuth)
+		assert(t, nil, os.WriteFile(config.CertFile, certPEM, 0o600))
+		assert(t, nil, os.WriteFile(config.KeyFile, keyPEM, 0o600))
+	}
+
+	newClient := func(t *testing.T, subject *pkix.Name) *http.Client {
+		t.Helper()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		tlsConfig := &tls.Config{RootCAs: roots}
+
+		if subject != nil {
+			certPEM, keyPEM := ca.issue(t, time.Now().UnixNano(), *subject, x509.ExtKeyUsageClientAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			assert(t, nil, err)
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	}
+
+	do := func(t *testing.T, client *http.Client, method string, path string, body string, headers ...map[string]string) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, baseURL+path, strings.NewReader(body))
+		assert(t, nil, err)
+		req.Header.Set("Content-Type", "application/json")
+		for _, header := range headers {
+			for k, v := range header {
+				req.Header.Set(k, v)
+			}
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		b, err := io.ReadAll(res.Body)
+		assert(t, nil, err)
+		return res, string(b)
+	}
+
+	serviceSubject := &pkix.Name{CommonName: "test_service1", Organization: []string{"Todo"}}
+
+	writeServerCert(t, 100)
+	assert(t, nil, os.WriteFile(config.ClientCAFile, ca.pem, 0o600))
+
+	certs, err := tlscert.NewReloader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go certs.Run(ctx)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL = "https://" + ln.Addr().String()
+
+	server := &http.Server{Handler: engine, TLSConfig: certs.TLSConfig()}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	t.Run("reload certificate", func(t *testing.T) {
+		res, _ := do(t, newClient(t, nil), "GET", "/api/v1/ping", "")
+		assert(t, 200, res.StatusCode)
+		assert(t, int64(100), res.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+		// a renewed certificate is served once the files change
+		writeServerCert(t, 101)
+		assert(t, nil, certs.Reload())
+
+		res2, _ := do(t, newClient(t, nil), "GET", "/api/v1/ping", "")
+		assert(t, 200, res2.StatusCode)
+		assert(t, int64(101), res2.TLS.PeerCertificates[0].SerialNumber.Int64())
+	})
+
+	t.Run("setup service account", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user26","password":"pass"}`)
+		assert(t, 200, rec.Code)
+		setRole(t, "test_user26", handler.RoleAdmin)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user26","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		signIn := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &signIn))
+		adminHeader = map[string]string{"Authorization": "Bearer " + signIn.Token}
+
+		rec3 := doRequest(t, "POST", "/api/v1/admin/service-accounts", `{"name":"test_service1"}`, adminHeader)
+		assert(t, 200, rec3.Code)
+
+		account := handler.AdminUserResponse{}
+		assert(t, nil, json.Unmarshal(rec3.Body.Bytes(), &account))
+		assert(t, "test_service1", account.Name)
+		accountID = account.ID.String()
+
+		body := fmt.Sprintf(`{"subject":%q,"scopes":["tasks:read","tasks:write"]}`, serviceSubject.String())
+		rec4 := doRequest(t, "POST", "/api/v1/admin/users/"+accountID+"/certificates", body, adminHeader)
+		assert(t, 200, rec4.Code)
+
+		rec5 := doRequest(t, "POST", "/api/v1/admin/users/"+accountID+"/certificates", body, adminHeader)
+		assert(t, 409, rec5.Code)
+
+		rec6 := doRequest(t, "POST", "/api/v1/admin/users/"+accountID+"/certificates", `{"subject":"CN=test_service2","scopes":["account"]}`, adminHeader)
+		assert(t, 400, rec6.Code)
+	})
+
+	t.Run("client certificate authenticates", func(t *testing.T) {
+		client := newClient(t, serviceSubject)
+
+		res, _ := do(t, client, "POST", "/api/v1/tasks", `{"title":"mtls_task1"}`)
+		assert(t, 200, res.StatusCode)
+
+		res2, body := do(t, client, "GET", "/api/v1/tasks", "")
+		assert(t, 200, res2.StatusCode)
+
+		tasks := handler.GetTasksResponse{}
+		assert(t, nil, json.Unmarshal([]byte(body), &tasks))
+		assert(t, 1, len(tasks.Tasks))
+		assert(t, "mtls_task1", tasks.Tasks[0].Title)
+
+		// only the scopes of the mapping are granted
+		res3, _ := do(t, client, "GET", "/api/v1/users/me", "")
+		assert(t, 403, res3.StatusCode)
+
+		res4, _ := do(t, client, "GET", "/api/v1/admin/users", "")
+		assert(t, 403, res4.StatusCode)
+
+		res5, _ := do(t, client, "POST", "/api/v1/tasks", `{"title":"mtls_task2"}`, map[string]string{"Sec-Fetch-Site": "cross-site"})
+		assert(t, 401, res5.StatusCode)
+
+		rec := doRequest(t, "GET", "/api/v1/admin/users/"+accountID+"/certificates", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		certificates := handler.GetClientCertificatesResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &certificates))
+		assert(t, 1, len(certificates))
+		assert(t, serviceSubject.String(), certificates[0].Subject)
+		assert(t, true, certificates[0].LastUsedAt != nil)
+	})
+
+	t.Run("other certificates are turned away", func(t *testing.T) {
+		res, _ := do(t, newClient(t, nil), "GET", "/api/v1/tasks", "")
+		assert(t, 401, res.StatusCode)
+
+		res2, _ := do(t, newClient(t, &pkix.Name{CommonName: "test_service3"}), "GET", "/api/v1/tasks", "")
+		assert(t, 401, res2.StatusCode)
+
+		// a certificate from another CA fails the handshake
+		other := newTestCA(t)
+		certPEM, keyPEM := other.issue(t, 1, *serviceSubject, x509.ExtKeyUsageClientAuth)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		assert(t, nil, err)
+
+		client := newClient(t, nil)
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+		_, err = client.Get(baseURL + "/api/v1/tasks")
+		assert(t, true, err != nil)
+	})
+
+	t.Run("disabled service account", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/admin/users/"+accountID+"/disable", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		res, _ := do(t, newClient(t, serviceSubject), "GET", "/api/v1/tasks", "")
+		assert(t, 403, res.StatusCode)
+	})
+
+	t.Run("removed certificate", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/admin/users/"+accountID+"/certificates", "", adminHeader)
+		assert(t, 200, rec.Code)
+
+		certificates := handler.GetClientCertificatesResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &certificates))
+		assert(t, 1, len(certificates))
+
+		rec2 := doRequest(t, "DELETE", "/api/v1/admin/users/"+accountID+"/certificates/"+certificates[0].ID.String(), "", adminHeader)
+		assert(t, 200, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/admin/users/"+accountID+"/enable", "", adminHeader)
+		assert(t, 200, rec3.Code)
+
+		res, _ := do(t, newClient(t, serviceSubject), "GET", "/api/v1/tasks", "")
+		assert(t, 401, res.StatusCode)
+	})
+}
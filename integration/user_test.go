@@ -1,13 +1,20 @@
 package integration
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Irori235/system-design-2023-v2/internal/handler"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/totp"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestUser(t *testing.T) {
@@ -43,15 +50,31 @@ func TestUser(t *testing.T) {
 	t.Run("signin user", func(t *testing.T) {
 		t.Run("success", func(t *testing.T) {
 			t.Parallel()
-			rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user","password":"pass"}`)
+			rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user","password":"pass","return_token":true}`)
 			assert(t, 200, rec.Code)
 
 			res := handler.SignInResponse{}
 			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, false, res.Token == "")
 
 			jwtMap["user1"] = res.Token
 		})
 
+		t.Run("cookie only", func(t *testing.T) {
+			t.Parallel()
+			rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user","password":"pass"}`)
+			assert(t, 200, rec.Code)
+
+			res := handler.SignInResponse{}
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert(t, "", res.Token)
+			names := []string{}
+			for _, cookie := range rec.Result().Cookies() {
+				names = append(names, cookie.Name)
+			}
+			assert(t, []string{"jwt", "refresh_token", "csrf_token"}, names)
+		})
+
 		t.Run("invalid json", func(t *testing.T) {
 			t.Parallel()
 			rec := doRequest(t, "POST", "/api/v1/auth/signin", `"name":"test_user","password":`)
@@ -104,7 +127,37 @@ func TestUser(t *testing.T) {
 			}
 			rec := doRequest(t, "GET", "/api/v1/users/me", "", header)
 
-			assert(t, 400, rec.Code)
+			assert(t, 401, rec.Code)
+		})
+
+		t.Run("jwt cookie", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Cookie": fmt.Sprintf("theme=dark; jwt=%s", jwtMap["user1"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/users/me", "", header)
+			assert(t, 200, rec.Code)
+		})
+
+		t.Run("similar cookie name", func(t *testing.T) {
+			t.Parallel()
+			header := map[string]string{
+				"Cookie": fmt.Sprintf("xjwt=%s", jwtMap["user1"]),
+			}
+			rec := doRequest(t, "GET", "/api/v1/users/me", "", header)
+			assert(t, 401, rec.Code)
+		})
+
+		t.Run("query token", func(t *testing.T) {
+			t.Parallel()
+			path := "/api/v1/users/me?access_token=" + jwtMap["user1"]
+
+			rec := doRequest(t, "GET", path, "", map[string]string{"Accept": "text/event-stream"})
+			assert(t, 200, rec.Code)
+
+			// query tokens are only accepted for event streams
+			rec2 := doRequest(t, "GET", path, "")
+			assert(t, 401, rec2.Code)
 		})
 	})
 
@@ -135,6 +188,19 @@ func TestUser(t *testing.T) {
 				},
 			)
 		})
+
+		t.Run("taken", func(t *testing.T) {
+			t.Parallel()
+			jwt := jwtMap["user1"]
+			header := map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", jwt),
+			}
+			rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user27","password":"pass"}`)
+			assert(t, 200, rec.Code)
+
+			rec2 := doRequest(t, "PATCH", "/api/v1/users/name", `{"name":"test_user27"}`, header)
+			assert(t, 409, rec2.Code)
+		})
 	})
 
 	t.Run("update password", func(t *testing.T) {
@@ -147,7 +213,7 @@ func TestUser(t *testing.T) {
 			rec := doRequest(t, "PATCH", "/api/v1/users/password", `{"password":"updated_pass"}`, header)
 			assert(t, 200, rec.Code)
 
-			rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"updated_name","password":"updated_pass"}`)
+			rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"updated_name","password":"updated_pass","return_token":true}`)
 			assert(t, 200, rec2.Code)
 
 			res := handler.SignInResponse{}
@@ -167,7 +233,7 @@ func TestUser(t *testing.T) {
 			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
 			assert(t, false, uuid.Nil == res.ID)
 
-			rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user2","password":"pass"}`)
+			rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user2","password":"pass","return_token":true}`)
 			assert(t, 200, rec2.Code)
 
 			res2 := handler.SignInResponse{}
@@ -180,8 +246,552 @@ func TestUser(t *testing.T) {
 			assert(t, 200, rec3.Code)
 
 			rec4 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user2","password":"pass"}`)
-			assert(t, 500, rec4.Code) //  sql: no rows in result set
+			assert(t, 401, rec4.Code)
 		})
 	})
 
 }
+
+func TestSessions(t *testing.T) {
+	signIn := func(t *testing.T, userAgent string) map[string]string {
+		t.Helper()
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user9","password":"pass","return_token":true}`, map[string]string{"User-Agent": userAgent})
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+
+		return map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res.Token),
+		}
+	}
+
+	getSessions := func(t *testing.T, header map[string]string) handler.GetSessionsResponse {
+		t.Helper()
+		rec := doRequest(t, "GET", "/api/v1/users/me/sessions", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetSessionsResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+
+		return res
+	}
+
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user9","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	laptop := signIn(t, "laptop")
+	phone := signIn(t, "phone")
+	tablet := signIn(t, "tablet")
+
+	t.Run("list sessions", func(t *testing.T) {
+		res := getSessions(t, laptop)
+		assert(t, 3, len(res))
+
+		current := 0
+		for _, session := range res {
+			if session.Current {
+				current++
+				assert(t, "laptop", session.UserAgent)
+			}
+		}
+		assert(t, 1, current)
+
+		rec := doRequest(t, "GET", "/api/v1/users/me", "", laptop)
+		assert(t, 200, rec.Code)
+
+		me := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &me))
+		for _, session := range res {
+			assert(t, session.Current, session.ID == me.SessionID)
+		}
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/users/me", "", phone)
+		assert(t, 200, rec.Code)
+
+		me := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &me))
+
+		rec2 := doRequest(t, "DELETE", "/api/v1/users/me/sessions/"+me.SessionID.String(), "", laptop)
+		assert(t, 200, rec2.Code)
+
+		rec3 := doRequest(t, "GET", "/api/v1/users/me", "", phone)
+		assert(t, 401, rec3.Code)
+		assert(t, 2, len(getSessions(t, laptop)))
+	})
+
+	t.Run("revoke other user's session", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/users/me", "", laptop)
+		assert(t, 200, rec.Code)
+
+		me := handler.GetMeResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &me))
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user10","password":"pass"}`)
+		assert(t, 200, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user10","password":"pass","return_token":true}`)
+		assert(t, 200, rec3.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec3.Body.Bytes(), &res))
+
+		header := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res.Token),
+		}
+		rec4 := doRequest(t, "DELETE", "/api/v1/users/me/sessions/"+me.SessionID.String(), "", header)
+		assert(t, 404, rec4.Code)
+
+		rec5 := doRequest(t, "GET", "/api/v1/users/me", "", laptop)
+		assert(t, 200, rec5.Code)
+	})
+
+	t.Run("sign out everywhere else", func(t *testing.T) {
+		rec := doRequest(t, "DELETE", "/api/v1/users/me/sessions", "", laptop)
+		assert(t, 200, rec.Code)
+
+		res := handler.RevokeSessionsResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, int64(1), res.Revoked)
+
+		rec2 := doRequest(t, "GET", "/api/v1/users/me", "", tablet)
+		assert(t, 401, rec2.Code)
+
+		sessions := getSessions(t, laptop)
+		assert(t, 1, len(sessions))
+		assert(t, true, sessions[0].Current)
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	var (
+		header        map[string]string
+		secret        string
+		recoveryCodes []string
+	)
+
+	code := func(t *testing.T, offset int64) string {
+		t.Helper()
+		c, err := totp.Code(secret, totp.Step(time.Now())+offset)
+		assert(t, nil, err)
+
+		return c
+	}
+
+	signIn := func(t *testing.T) handler.SignInResponse {
+		t.Helper()
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user11","password":"pass","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+
+		return res
+	}
+
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user11","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		res := signIn(t)
+		assert(t, false, res.MFARequired)
+
+		header = map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res.Token),
+		}
+	})
+
+	t.Run("enroll", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/users/me/2fa", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.EnrollTwoFactorResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, true, strings.HasPrefix(res.ProvisioningURI, "otpauth://totp/"))
+		assert(t, true, strings.Contains(res.ProvisioningURI, "secret="+res.Secret))
+		secret = res.Secret
+
+		// not enabled until confirmed
+		assert(t, false, signIn(t).MFARequired)
+
+		rec2 := doRequest(t, "POST", "/api/v1/users/me/2fa/confirm", `{"code":"000000"}`, header)
+		assert(t, 400, rec2.Code)
+
+		rec3 := doRequest(t, "POST", "/api/v1/users/me/2fa/confirm", fmt.Sprintf(`{"code":"%s"}`, code(t, -1)), header)
+		assert(t, 200, rec3.Code)
+
+		res3 := handler.RecoveryCodesResponse{}
+		assert(t, nil, json.Unmarshal(rec3.Body.Bytes(), &res3))
+		assert(t, 10, len(res3.RecoveryCodes))
+		recoveryCodes = res3.RecoveryCodes
+
+		rec4 := doRequest(t, "GET", "/api/v1/users/me/2fa", "", header)
+		assert(t, 200, rec4.Code)
+
+		res4 := handler.GetTwoFactorResponse{}
+		assert(t, nil, json.Unmarshal(rec4.Body.Bytes(), &res4))
+		assert(t, handler.GetTwoFactorResponse{Enabled: true, RecoveryCodesRemaining: 10}, res4)
+
+		rec5 := doRequest(t, "POST", "/api/v1/users/me/2fa", "", header)
+		assert(t, 409, rec5.Code)
+	})
+
+	t.Run("sign in with totp", func(t *testing.T) {
+		res := signIn(t)
+		assert(t, true, res.MFARequired)
+		assert(t, "", res.Token)
+
+		// the pending token is not an access token
+		rec := doRequest(t, "GET", "/api/v1/users/me", "", map[string]string{"Authorization": "Bearer " + res.MFAToken})
+		assert(t, 401, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin/2fa", fmt.Sprintf(`{"mfa_token":"%s","code":"000000"}`, res.MFAToken))
+		assert(t, 401, rec2.Code)
+
+		body := fmt.Sprintf(`{"mfa_token":"%s","code":"%s","return_token":true}`, res.MFAToken, code(t, 0))
+		rec3 := doRequest(t, "POST", "/api/v1/auth/signin/2fa", body)
+		assert(t, 200, rec3.Code)
+
+		res3 := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec3.Body.Bytes(), &res3))
+		assert(t, false, res3.Token == "")
+
+		// a code is accepted only once
+		rec4 := doRequest(t, "POST", "/api/v1/auth/signin/2fa", body)
+		assert(t, 401, rec4.Code)
+	})
+
+	t.Run("sign in with recovery code", func(t *testing.T) {
+		res := signIn(t)
+		body := fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, res.MFAToken, strings.ToUpper(recoveryCodes[0]))
+
+		rec := doRequest(t, "POST", "/api/v1/auth/signin/2fa", body)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin/2fa", body)
+		assert(t, 401, rec2.Code)
+	})
+
+	t.Run("throttle", func(t *testing.T) {
+		// one wrong code is left over from signing in, two more start the backoff
+		for i := 0; i < 2; i++ {
+			rec := doRequest(t, "POST", "/api/v1/users/me/2fa/recovery-codes", `{"code":"000000"}`, header)
+			assert(t, 400, rec.Code)
+		}
+
+		// a right code is refused with the same answer while backing off
+		rec := doRequest(t, "DELETE", "/api/v1/users/me/2fa", fmt.Sprintf(`{"code":"%s"}`, recoveryCodes[1]), header)
+		assert(t, 400, rec.Code)
+		assert(t, `{"error":"invalid code"}`, rec.Body.String())
+
+		assert(t, nil, r.ResetAuthThrottle(context.Background(), "account:test_user11"))
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		rec := doRequest(t, "DELETE", "/api/v1/users/me/2fa", `{"code":"000000"}`, header)
+		assert(t, 400, rec.Code)
+
+		rec2 := doRequest(t, "DELETE", "/api/v1/users/me/2fa", fmt.Sprintf(`{"code":"%s"}`, recoveryCodes[1]), header)
+		assert(t, 200, rec2.Code)
+
+		assert(t, false, signIn(t).MFARequired)
+	})
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	var (
+		header    map[string]string
+		readToken handler.CreateTokenResponse
+	)
+
+	t.Run("setup user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user12","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user12","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec2.Body.Bytes(), &res))
+
+		header = map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", res.Token),
+		}
+	})
+
+	t.Run("create token", func(t *testing.T) {
+		t.Run("success", func(t *testing.T) {
+			rec := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":["tasks:read"],"expires_in_days":7}`, header)
+			assert(t, 200, rec.Code)
+
+			assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &readToken))
+			assert(t, true, strings.HasPrefix(readToken.Token, "pat_"))
+		})
+
+		t.Run("invalid scope", func(t *testing.T) {
+			t.Parallel()
+			rec := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":["account"]}`, header)
+			assert(t, 400, rec.Code)
+
+			rec2 := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":[]}`, header)
+			assert(t, 400, rec2.Code)
+		})
+	})
+
+	t.Run("use token", func(t *testing.T) {
+		patHeader := map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", readToken.Token),
+		}
+
+		rec := doRequest(t, "GET", "/api/v1/tasks", "", patHeader)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/tasks", `{"title":"from_script"}`, patHeader)
+		assert(t, 403, rec2.Code)
+
+		rec3 := doRequest(t, "GET", "/api/v1/users/me", "", patHeader)
+		assert(t, 403, rec3.Code)
+
+		// tokens cannot mint more tokens
+		rec4 := doRequest(t, "POST", "/api/v1/users/me/tokens", `{"name":"ci","scopes":["tasks:read"]}`, patHeader)
+		assert(t, 403, rec4.Code)
+
+		rec5 := doRequest(t, "GET", "/api/v1/tasks", "", map[string]string{"Authorization": "Bearer pat_invalid"})
+		assert(t, 401, rec5.Code)
+	})
+
+	t.Run("list tokens", func(t *testing.T) {
+		rec := doRequest(t, "GET", "/api/v1/users/me/tokens", "", header)
+		assert(t, 200, rec.Code)
+
+		res := handler.GetTokensResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert(t, 1, len(res))
+		assert(t, readToken.ID, res[0].ID)
+		assert(t, []string{"tasks:read"}, res[0].Scopes)
+		assert(t, false, res[0].LastUsedAt == nil)
+		assert(t, false, strings.Contains(rec.Body.String(), readToken.Token))
+	})
+
+	t.Run("delete token", func(t *testing.T) {
+		rec := doRequest(t, "DELETE", "/api/v1/users/me/tokens/"+readToken.ID.String(), "", header)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "GET", "/api/v1/tasks", "", map[string]string{"Authorization": "Bearer " + readToken.Token})
+		assert(t, 401, rec2.Code)
+
+		rec3 := doRequest(t, "DELETE", "/api/v1/users/me/tokens/"+readToken.ID.String(), "", header)
+		assert(t, 404, rec3.Code)
+	})
+}
+
+func TestSignInThrottle(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user13","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	// a client address of its own keeps other tests out of the address throttle
+	header := map[string]string{"X-Forwarded-For": "198.51.100.13"}
+
+	t.Run("unknown user", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user13","password":"invalid_pass"}`, header)
+		assert(t, 401, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"no_such_user","password":"invalid_pass"}`, header)
+		assert(t, 401, rec2.Code)
+		assert(t, rec.Body.String(), rec2.Body.String())
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user13","password":"invalid_pass"}`, header)
+			assert(t, 401, rec.Code)
+		}
+
+		// the right password is refused with the same answer while backing off
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user13","password":"pass"}`, header)
+		assert(t, 401, rec.Code)
+		assert(t, `{"error":"invalid name or password"}`, rec.Body.String())
+		assert(t, "", rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		// skip the backoff delays: 3 failures so far, the next real one is the 10th
+		skipBackoff(t, "account:test_user13", 6)
+
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user13","password":"invalid_pass"}`, header)
+		assert(t, 401, rec.Code)
+
+		// the right password does not get through either
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user13","password":"pass"}`, header)
+		assert(t, 401, rec2.Code)
+		assert(t, rec.Body.String(), rec2.Body.String())
+
+		userID, err := r.GetUserID(context.Background(), "test_user13")
+		assert(t, nil, err)
+
+		logs, err := r.GetAuditLogs(context.Background(), userID, 10)
+		assert(t, nil, err)
+		assert(t, 1, len(logs))
+		assert(t, "account_locked", logs[0].Event)
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user13_other","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		// one failure short of the lockout, a burst of guesses gets a single one through
+		skipBackoff(t, "account:test_user13_other", 9)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				header := map[string]string{"X-Forwarded-For": fmt.Sprintf("198.51.100.%d", 100+i)}
+				doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user13_other","password":"invalid_pass"}`, header)
+			}(i)
+		}
+		wg.Wait()
+
+		throttle, err := r.GetAuthThrottle(context.Background(), "account:test_user13_other")
+		assert(t, nil, err)
+		assert(t, 10, throttle.Failures)
+		assert(t, true, throttle.IsLocked(time.Now()))
+
+		userID, err := r.GetUserID(context.Background(), "test_user13_other")
+		assert(t, nil, err)
+
+		logs, err := r.GetAuditLogs(context.Background(), userID, 10)
+		assert(t, nil, err)
+		assert(t, 1, len(logs))
+		assert(t, "account_locked", logs[0].Event)
+	})
+
+	t.Run("other accounts", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user14","password":"pass"}`)
+		assert(t, 200, rec.Code)
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user14","password":"pass"}`, header)
+		assert(t, 200, rec2.Code)
+	})
+}
+
+// skipBackoff counts failures against the key without the delays they would earn, and lifts its lock
+func skipBackoff(t *testing.T, key string, failures int) {
+	t.Helper()
+
+	assert(t, nil, r.LockAuthThrottle(context.Background(), key, time.Now()))
+	params := repository.ReserveAuthAttemptParams{
+		Key:    key,
+		Window: time.Hour,
+		Delay:  func(int) time.Duration { return 0 },
+	}
+	for i := 0; i < failures; i++ {
+		_, err := r.ReserveAuthAttempt(context.Background(), params)
+		assert(t, nil, err)
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	rec := doRequest(t, "POST", "/api/v1/auth/signup", `{"name":"test_user15","password":"pass"}`)
+	assert(t, 200, rec.Code)
+
+	userID, err := r.GetUserID(context.Background(), "test_user15")
+	assert(t, nil, err)
+
+	// a hash as stored before argon2id, zero-padded the way binary(64) did
+	legacy, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	assert(t, nil, err)
+	legacy = append(legacy, make([]byte, 64-len(legacy))...)
+	_, err = db.Exec("UPDATE users SET password = ? WHERE id = ?", legacy, userID)
+	assert(t, nil, err)
+
+	t.Run("wrong password keeps the hash", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user15","password":"invalid_pass"}`)
+		assert(t, 401, rec.Code)
+
+		user, err := r.GetUser(context.Background(), userID)
+		assert(t, nil, err)
+		assert(t, legacy, user.Password)
+	})
+
+	t.Run("signin rehashes", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user15","password":"pass","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		user, err := r.GetUser(context.Background(), userID)
+		assert(t, nil, err)
+		assert(t, true, strings.HasPrefix(string(user.Password), "$argon2id$v=19$"))
+
+		rec2 := doRequest(t, "POST", "/api/v1/auth/signin", `{"name":"test_user15","password":"pass","return_token":true}`)
+		assert(t, 200, rec2.Code)
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	violations := func(t *testing.T, body string) []string {
+		t.Helper()
+
+		res := handler.PasswordPolicyErrorResponse{}
+		assert(t, nil, json.Unmarshal([]byte(body), &res))
+
+		rules := []string{}
+		for _, v := range res.Violations {
+			rules = append(rules, v.Rule)
+		}
+		return rules
+	}
+
+	t.Run("too short and breached", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/strict/api/v1/auth/signup", `{"name":"test_user16","password":"pass"}`)
+		assert(t, 400, rec.Code)
+		assert(t, []string{"min_length", "strength", "breached"}, violations(t, rec.Body.String()))
+	})
+
+	t.Run("breached", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/strict/api/v1/auth/signup", `{"name":"test_user16","password":"Password123"}`)
+		assert(t, 400, rec.Code)
+		assert(t, []string{"strength", "breached"}, violations(t, rec.Body.String()))
+	})
+
+	t.Run("like the username", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/strict/api/v1/auth/signup", `{"name":"test_user16","password":"test_user16!!"}`)
+		assert(t, 400, rec.Code)
+		assert(t, []string{"username_similarity", "strength"}, violations(t, rec.Body.String()))
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"test_user16","password":"%s"}`, strings.Repeat("velvet kayak orbit ", 20))
+		rec := doRequest(t, "POST", "/strict/api/v1/auth/signup", body)
+		assert(t, 400, rec.Code)
+		assert(t, []string{"max_length"}, violations(t, rec.Body.String()))
+
+		// far longer passwords are not even read
+		body2 := fmt.Sprintf(`{"name":"test_user16","password":"%s"}`, strings.Repeat("velvet kayak orbit ", 1000))
+		rec2 := doRequest(t, "POST", "/strict/api/v1/auth/signup", body2)
+		assert(t, 413, rec2.Code)
+	})
+
+	t.Run("strong", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/strict/api/v1/auth/signup", `{"name":"test_user16","password":"velvet kayak orbit"}`)
+		assert(t, 200, rec.Code)
+	})
+
+	t.Run("update password", func(t *testing.T) {
+		rec := doRequest(t, "POST", "/strict/api/v1/auth/signin", `{"name":"test_user16","password":"velvet kayak orbit","return_token":true}`)
+		assert(t, 200, rec.Code)
+
+		res := handler.SignInResponse{}
+		assert(t, nil, json.Unmarshal(rec.Body.Bytes(), &res))
+		header := map[string]string{"Authorization": "Bearer " + res.Token}
+
+		rec2 := doRequest(t, "PATCH", "/strict/api/v1/users/password", `{"password":"qwertyuiop"}`, header)
+		assert(t, 400, rec2.Code)
+		assert(t, []string{"strength", "breached"}, violations(t, rec2.Body.String()))
+
+		rec3 := doRequest(t, "PATCH", "/strict/api/v1/users/password", `{"password":"lantern gravel mosaic"}`, header)
+		assert(t, 200, rec3.Code)
+	})
+}
@@ -0,0 +1,311 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+)
+
+// スキーマ定義
+type (
+	SearchUsersRequest struct {
+		Query  string `form:"q"`
+		Role   string `form:"role"`
+		Limit  int    `form:"limit"`
+		Offset int    `form:"offset"`
+	}
+
+	UpdateRoleRequest struct {
+		Role string `json:"role"`
+	}
+
+	AdminUsersResponse []AdminUserResponse
+	AdminUserResponse  struct {
+		ID                    uuid.UUID  `json:"id"`
+		Name                  string     `json:"name"`
+		Email                 string     `json:"email"`
+		EmailVerified         bool       `json:"email_verified"`
+		Role                  string     `json:"role"`
+		DisabledAt            *time.Time `json:"disabled_at"`
+		PasswordResetRequired bool       `json:"password_reset_required"`
+		CreatedAt             time.Time  `json:"created_at"`
+	}
+
+	TaskCountsResponse struct {
+		Total int `json:"total"`
+		Done  int `json:"done"`
+		Open  int `json:"open"`
+	}
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+// GET /api/v1/admin/users
+func (h *Handler) SearchUsers(c *gin.Context) {
+	req := new(SearchUsersRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Limit == 0 {
+		req.Limit = defaultAdminPageSize
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Query, vd.RuneLength(0, 254)),
+		vd.Field(&req.Role, vd.In(RoleUser, RoleSupport, RoleAdmin)),
+		vd.Field(&req.Limit, vd.Min(1), vd.Max(maxAdminPageSize)),
+		vd.Field(&req.Offset, vd.Min(0)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request query: %w", err).Error()})
+		return
+	}
+
+	params := repository.SearchUsersParams{
+		Query:  req.Query,
+		Role:   req.Role,
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	}
+
+	users, err := h.users.SearchUsers(c, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make(AdminUsersResponse, len(users))
+	for i := range users {
+		res[i] = adminUserResponse(&users[i])
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /api/v1/admin/users/:userID
+func (h *Handler) GetUser(c *gin.Context) {
+	user, _ := c.Get("target_user")
+
+	c.JSON(http.StatusOK, adminUserResponse(user.(*repository.User)))
+}
+
+// GET /api/v1/admin/users/:userID/task-counts
+func (h *Handler) GetTaskCounts(c *gin.Context) {
+	user, _ := c.Get("target_user")
+
+	counts, err := h.tasks.GetTaskCounts(c, user.(*repository.User).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := TaskCountsResponse{
+		Total: counts.Total,
+		Done:  counts.Done,
+		Open:  counts.Total - counts.Done,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// PATCH /api/v1/admin/users/:userID/role
+func (h *Handler) UpdateRole(c *gin.Context) {
+	req := new(UpdateRoleRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Role, vd.Required, vd.In(RoleUser, RoleSupport, RoleAdmin)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	user, ok := h.otherUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.users.UpdateRole(c, user.ID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "role_changed", user.ID, fmt.Sprintf("from=%s to=%s", user.Role, req.Role)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// POST /api/v1/admin/users/:userID/disable
+func (h *Handler) DisableUser(c *gin.Context) {
+	user, ok := h.otherUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.users.SetUserDisabled(c, user.ID, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// AuthMiddleware turns away every credential of a disabled user; this also keeps sessions from being refreshed
+	if _, err := h.accounts.RevokeOtherSessions(c, user.ID, uuid.Nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "account_disabled", user.ID, "") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// POST /api/v1/admin/users/:userID/enable
+func (h *Handler) EnableUser(c *gin.Context) {
+	user, ok := h.otherUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.users.SetUserDisabled(c, user.ID, false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "account_enabled", user.ID, "") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// POST /api/v1/admin/users/:userID/password-reset
+func (h *Handler) ForcePasswordReset(c *gin.Context) {
+	user, ok := h.otherUser(c)
+	if !ok {
+		return
+	}
+
+	// the reset link is the only way back in, so there has to be somewhere to send it
+	if !user.EmailVerifiedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "user has no verified email to send a reset link to"})
+		return
+	}
+
+	if err := h.users.RequirePasswordReset(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.revokeCredentials(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sendPasswordResetMail(c, user, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "password_reset_forced", user.ID, "") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// AdminUserMiddleware loads the user in :userID and aborts with 404 when there is none.
+// It must be used after AuthMiddleware.
+func (h *Handler) AdminUserMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		user, err := h.users.GetUser(c, userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("target_user", user)
+		c.Next()
+	}
+}
+
+// otherUser returns the user in :userID unless it is the admin making the request,
+// who could otherwise lock themselves out. It writes the error response and returns false then.
+func (h *Handler) otherUser(c *gin.Context) (*repository.User, bool) {
+	user, _ := c.Get("target_user")
+	userID, _ := c.Get("user_id")
+
+	if user.(*repository.User).ID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "admins cannot change their own account here"})
+		return nil, false
+	}
+
+	return user.(*repository.User), true
+}
+
+// auditAdminAction records that the signed-in admin acted on userID.
+// It writes the error response and returns false when the entry cannot be written.
+func (h *Handler) auditAdminAction(c *gin.Context, event string, userID uuid.UUID, detail string) bool {
+	actorID, _ := c.Get("user_id")
+
+	params := repository.CreateAuditLogParams{
+		Event:   event,
+		UserID:  userID,
+		ActorID: actorID.(uuid.UUID),
+		IP:      c.ClientIP(),
+		Detail:  detail,
+	}
+
+	if err := h.accounts.CreateAuditLog(c, params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func adminUserResponse(user *repository.User) AdminUserResponse {
+	return AdminUserResponse{
+		ID:                    user.ID,
+		Name:                  user.Name,
+		Email:                 user.Email.String,
+		EmailVerified:         user.EmailVerifiedAt.Valid,
+		Role:                  user.Role,
+		DisabledAt:            nullTime(user.DisabledAt.Time, user.DisabledAt.Valid),
+		PasswordResetRequired: user.PasswordResetRequired,
+		CreatedAt:             user.CreatedAt,
+	}
+}
@@ -1,10 +1,15 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/keys"
 	"github.com/Irori235/system-design-2023-v2/internal/repository"
 	"github.com/gin-gonic/gin"
 	vd "github.com/go-ozzo/ozzo-validation"
@@ -16,27 +21,64 @@ type (
 	SignUpRequest struct {
 		Name     string `json:"name"`
 		Password string `json:"password"`
+		// Email is optional and needed only to reset a forgotten password
+		Email string `json:"email"`
 	}
 
 	SignInRequest struct {
 		Name     string `json:"name"`
 		Password string `json:"password"`
+		// ReturnToken asks for the token in the response body for clients that cannot use cookies
+		ReturnToken bool `json:"return_token"`
+	}
+
+	RefreshRequest struct {
+		// RefreshToken falls back to the refresh_token cookie
+		RefreshToken string `json:"refresh_token"`
+		ReturnToken  bool   `json:"return_token"`
+	}
+
+	SignOutRequest struct {
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	Claims struct {
-		UserID string `json:"user_id"`
+		UserID    string `json:"user_id"`
+		SessionID string `json:"sid"`
+		// Actor is set while an admin impersonates UserID; SessionID is then the admin's session
+		Actor *ActorClaim `json:"act,omitempty"`
 		jwt.StandardClaims
 	}
 
+	// ActorClaim is the act claim of RFC 8693 section 4.1
+	ActorClaim struct {
+		Subject string `json:"sub"`
+	}
+
 	SignUpResponse struct {
 		ID uuid.UUID `json:"id"`
 	}
 
 	SignInResponse struct {
-		Token string `json:"token"`
+		// MFARequired means MFAToken must be sent with a code to /auth/signin/2fa
+		MFARequired  bool   `json:"mfa_required,omitempty"`
+		MFAToken     string `json:"mfa_token,omitempty"`
+		Token        string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		ExpiresIn    int    `json:"expires_in,omitempty"`
 	}
 )
 
+const (
+	accessTokenTTL    = 15 * time.Minute
+	sessionTTL        = 30 * 24 * time.Hour
+	refreshCookieName = "refresh_token"
+)
+
+// MaxTokenTTL is the longest lifetime of a JWT signed here, that of an OAuth access token.
+// A superseded signing key has to keep verifying for at least this long.
+const MaxTokenTTL = oauthAccessTokenTTL
+
 func (h *Handler) SignUp(c *gin.Context) {
 	req := new(SignUpRequest)
 	if err := c.Bind(req); err != nil {
@@ -44,27 +86,45 @@ func (h *Handler) SignUp(c *gin.Context) {
 		return
 	}
 
+	req.Email = strings.TrimSpace(req.Email)
 	err := vd.ValidateStruct(
 		req,
 		vd.Field(&req.Name, vd.Required),
 		vd.Field(&req.Password, vd.Required),
+		vd.Field(&req.Email, vd.Length(0, 254), vd.By(isEmail)),
 	)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
 		return
 	}
 
+	if !h.checkPasswordPolicy(c, req.Password, req.Name) {
+		return
+	}
+
 	params := repository.CreateUserParams{
 		Name:     req.Name,
 		Password: req.Password,
+		Email:    req.Email,
 	}
 
-	userID, err := h.repo.CreateUser(c, params)
+	userID, err := h.users.CreateUser(c, params)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name already in use"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if req.Email != "" {
+		if err := h.sendVerificationMail(c, userID, req.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	res := SignUpResponse{
 		ID: userID,
 	}
@@ -89,87 +149,403 @@ func (h *Handler) SignIn(c *gin.Context) {
 		return
 	}
 
-	userID, err := h.repo.GetUserID(c, req.Name)
+	attempt, err := h.reserveSignInAttempt(c, req.Name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if attempt == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid name or password"})
+		return
+	}
+
+	userID, err := h.users.Authenticate(c, req.Name, req.Password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		if err := h.recordSignInFailure(c, attempt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid name or password"})
+		return
+	}
+	if err != nil && !errors.Is(err, repository.ErrAccountDisabled) && !errors.Is(err, repository.ErrPasswordResetRequired) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the password was right, which is not a failure even if the second factor or the account stops the sign-in
+	if err := h.releaseSignInAttempt(c, attempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// only said to whoever knows the password
+	if errors.Is(err, repository.ErrAccountDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	if errors.Is(err, repository.ErrPasswordResetRequired) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required"})
+		return
+	}
+
+	t, err := h.accounts.GetTOTP(c, userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	ok, err := h.repo.CheckPass(c, userID, req.Password)
+	// the session is only created once the second factor is verified
+	if t != nil && t.ConfirmedAt.Valid {
+		mfaToken, err := generateMFAToken(userID.String(), h.keys.Signing())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := SignInResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		}
+
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
+	if err := h.resetSignInFailures(c, req.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.startSession(c, userID, req.ReturnToken)
+}
+
+// startSession creates a session for a fully authenticated user and issues its tokens
+func (h *Handler) startSession(c *gin.Context, userID uuid.UUID, returnToken bool) {
+	sessionID, refreshToken, err := h.createSession(c, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user_id or password"})
+	h.issueTokens(c, userID, sessionID, refreshToken, returnToken)
+}
+
+// createSession records a new session from the requesting client and returns its first refresh token
+func (h *Handler) createSession(c *gin.Context, userID uuid.UUID) (uuid.UUID, string, error) {
+	refreshToken := newOpaqueToken()
+	params := repository.CreateSessionParams{
+		UserID:       userID,
+		UserAgent:    c.Request.UserAgent(),
+		IP:           c.ClientIP(),
+		ExpiresAt:    time.Now().Add(sessionTTL),
+		RefreshToken: refreshToken,
+	}
+
+	sessionID, err := h.accounts.CreateSession(c, params)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	return sessionID, refreshToken, nil
+}
+
+// POST /api/v1/auth/refresh
+func (h *Handler) Refresh(c *gin.Context) {
+	req := new(RefreshRequest)
+	if err := c.ShouldBindJSON(req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RefreshToken == "" {
+		if cookie, err := c.Request.Cookie(refreshCookieName); err == nil && cookie.Value != "" {
+			if !h.checkRefreshCSRF(c, cookie.Value) {
+				return
+			}
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token is required"})
 		return
 	}
 
-	token, err := generateJWT(userID.String(), h.jwtSecret)
+	refreshToken := newOpaqueToken()
+	params := repository.RotateRefreshTokenParams{
+		RefreshToken:    req.RefreshToken,
+		NewRefreshToken: refreshToken,
+	}
+
+	session, err := h.accounts.RotateRefreshToken(c, params)
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrSessionRevoked) || errors.Is(err, repository.ErrRefreshTokenReused) {
+		h.clearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// res := SignInResponse{
-	// 	Token: token,
-	// }
+	h.issueTokens(c, session.UserID, session.ID, refreshToken, req.ReturnToken)
+}
+
+// SignOut revokes the session of the refresh token, or else of the access token, the request carries.
+// A refresh token that is unknown or was already exchanged is rejected like in Refresh.
+// Tokens read from cookies need a CSRF token, as in Refresh.
+func (h *Handler) SignOut(c *gin.Context) {
+	req := new(SignOutRequest)
+	if err := c.ShouldBindJSON(req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RefreshToken == "" {
+		if cookie, err := c.Request.Cookie(refreshCookieName); err == nil && cookie.Value != "" {
+			if !h.checkRefreshCSRF(c, cookie.Value) {
+				return
+			}
+			req.RefreshToken = cookie.Value
+		}
+	}
+
+	if req.RefreshToken != "" {
+		err := h.accounts.RevokeSessionByRefreshToken(c, req.RefreshToken)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrRefreshTokenReused) {
+			h.clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		h.clearAuthCookies(c)
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	if tokenString, ok := extractToken(c, h.extractors); ok {
+		session, err := h.accessTokenSession(c, tokenString)
+		if errors.Is(err, repository.ErrNotFound) {
+			h.clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set("session_id", session.ID)
+		if !h.checkCSRF(c) {
+			return
+		}
+
+		err = h.accounts.RevokeSession(c, session.UserID, session.ID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	h.clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// accessTokenSession finds the session an access token was issued for.
+// It returns ErrNotFound when the token is not valid or its session is gone.
+func (h *Handler) accessTokenSession(c *gin.Context, tokenString string) (*repository.Session, error) {
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, h.verificationKey); err != nil {
+		return nil, repository.ErrNotFound
+	}
+
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil, repository.ErrNotFound
+	}
+
+	return h.accounts.GetSession(c, sessionID)
+}
+
+// issueTokens sets the access and refresh token cookies and writes the sign-in response
+func (h *Handler) issueTokens(c *gin.Context, userID uuid.UUID, sessionID uuid.UUID, refreshToken string, returnToken bool) {
+	token, err := h.setAuthCookies(c, userID, sessionID, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := SignInResponse{}
+	if returnToken {
+		res.Token = token
+		res.RefreshToken = refreshToken
+		res.ExpiresIn = int(accessTokenTTL.Seconds())
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// setAuthCookies signs an access token for the session and sets it and the refresh token as cookies
+func (h *Handler) setAuthCookies(c *gin.Context, userID uuid.UUID, sessionID uuid.UUID, refreshToken string) (string, error) {
+	token, err := generateJWT(userID.String(), sessionID.String(), h.keys.Signing())
+	if err != nil {
+		return "", err
+	}
 
-	// set cookie
 	cookie := &http.Cookie{
-		Name:     "jwt",
+		Name:     jwtCookieName,
 		Value:    token,
-		Expires:  time.Now().Add(3 * time.Hour),
+		Expires:  time.Now().Add(accessTokenTTL),
 		HttpOnly: true,
-		// Secure:   true,
-		Path: "/",
-		// SameSite: http.SameSiteStrictMode,
-		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		SameSite: h.cookies.SameSite,
 	}
 
-	http.SetCookie(c.Writer, cookie)
-	// http.Header.Add(c.Writer.Header(), "Access-Control-Allow-Credentials", "true")
+	h.setCookie(c, cookie)
+
+	// the refresh token is only sent to the auth endpoints
+	refreshCookie := &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    refreshToken,
+		Expires:  time.Now().Add(sessionTTL),
+		HttpOnly: true,
+		Path:     authCookiePath(c),
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	h.setCookie(c, refreshCookie)
 
-	// c.JSON(http.StatusOK, res)
+	// mutations authenticated by the cookie have to echo the csrf token
+	if _, err := h.setCSRFCookie(c, sessionID); err != nil {
+		return "", err
+	}
+
+	return token, nil
 }
 
-func (h *Handler) SignOut(c *gin.Context) {
-	// delete cookie
+func (h *Handler) clearAuthCookies(c *gin.Context) {
 	cookie := &http.Cookie{
-		Name:     "jwt",
+		Name:     jwtCookieName,
 		Value:    "expired",
 		Expires:  time.Now().Add(-time.Hour),
 		HttpOnly: true,
-		// Secure:   true,
-		Path: "/",
-		// SameSite: http.SameSiteStrictMode,
-		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		SameSite: h.cookies.SameSite,
+	}
+
+	h.setCookie(c, cookie)
+
+	refreshCookie := &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    "expired",
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: true,
+		Path:     authCookiePath(c),
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	h.setCookie(c, refreshCookie)
+
+	csrfCookie := &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    "expired",
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: false,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	h.setCookie(c, csrfCookie)
+}
+
+// setCookie sets cookie with the Secure and Domain attributes of the cookie policy.
+// A frontend on another site only gets cookies marked SameSite=None, so that policy applies to every cookie,
+// and the endpoints reading the refresh token cookie run checkCSRF like the rest of the API.
+func (h *Handler) setCookie(c *gin.Context, cookie *http.Cookie) {
+	cookie.Secure = h.cookies.Secure
+	cookie.Domain = h.cookies.Domain
+	if h.cookies.SameSite == http.SameSiteNoneMode {
+		cookie.SameSite = http.SameSiteNoneMode
 	}
 
 	http.SetCookie(c.Writer, cookie)
-	// http.Header.Add(c.Writer.Header(), "Access-Control-Allow-Credentials", "true")
+}
 
-	c.JSON(http.StatusOK, gin.H{})
+// authCookiePath is the path of the auth group the request was routed through
+func authCookiePath(c *gin.Context) string {
+	fullPath := c.FullPath()
+	if i := strings.LastIndex(fullPath, "/auth/"); i >= 0 {
+		return fullPath[:i+len("/auth")]
+	}
+
+	return path.Dir(fullPath)
 }
 
-func generateJWT(userID string, jwtSecret string) (string, error) {
+// GET /.well-known/jwks.json
+func (h *Handler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
 
-	expiresAt := time.Now().Add(3 * time.Hour)
+func generateJWT(userID string, sessionID string, key *keys.Key) (string, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
 
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expiresAt.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenStr, err := token.SignedString([]byte(jwtSecret))
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+	tokenStr, err := token.SignedString(key.PrivateKey())
 	if err != nil {
 		return "", fmt.Errorf("generate jwt: %w", err)
 	}
 
 	return tokenStr, nil
+}
+
+// generateMFAToken proves the password was checked while the second factor is pending.
+// It carries no session, so AuthMiddleware rejects it.
+func generateMFAToken(userID string, key *keys.Key) (string, error) {
+	claims := &MFAClaims{
+		UserID: userID,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  mfaAudience,
+			ExpiresAt: time.Now().Add(mfaTokenTTL).Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+	tokenStr, err := token.SignedString(key.PrivateKey())
+	if err != nil {
+		return "", fmt.Errorf("generate mfa token: %w", err)
+	}
+
+	return tokenStr, nil
+}
+
+// verificationKey is the jwt.Keyfunc resolving the kid header against the key set
+func (h *Handler) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := h.keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+
+	if token.Method.Alg() != string(key.Algorithm) {
+		return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+	}
 
+	return key.PublicKey(), nil
 }
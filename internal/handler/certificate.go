@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+)
+
+// スキーマ定義
+type (
+	CreateServiceAccountRequest struct {
+		Name string `json:"name"`
+	}
+
+	CreateClientCertificateRequest struct {
+		// Subject is the certificate subject in RFC 2253 form, e.g. "CN=backup,O=Example"
+		Subject string   `json:"subject"`
+		Scopes  []string `json:"scopes"`
+	}
+
+	GetClientCertificatesResponse []GetClientCertificateResponse
+	GetClientCertificateResponse  struct {
+		ID         uuid.UUID  `json:"id"`
+		Subject    string     `json:"subject"`
+		Scopes     []string   `json:"scopes"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+
+	CreateClientCertificateResponse struct {
+		ID uuid.UUID `json:"id"`
+	}
+)
+
+// POST /api/v1/admin/service-accounts
+func (h *Handler) CreateServiceAccount(c *gin.Context) {
+	req := new(CreateServiceAccountRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 50)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	// a service account signs in with client certificates only; nobody ever learns its password
+	params := repository.CreateUserParams{
+		Name:     req.Name,
+		Password: newOpaqueToken(),
+	}
+
+	userID, err := h.users.CreateUser(c, params)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "user already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.GetUser(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "service_account_created", userID, "") {
+		return
+	}
+
+	c.JSON(http.StatusOK, adminUserResponse(user))
+}
+
+// GET /api/v1/admin/users/:userID/certificates
+func (h *Handler) GetClientCertificates(c *gin.Context) {
+	user, _ := c.Get("target_user")
+
+	certificates, err := h.accounts.GetClientCertificates(c, user.(*repository.User).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make(GetClientCertificatesResponse, len(certificates))
+	for i, certificate := range certificates {
+		res[i] = GetClientCertificateResponse{
+			ID:         certificate.ID,
+			Subject:    certificate.Subject,
+			Scopes:     certificate.ScopeList(),
+			LastUsedAt: nullTime(certificate.LastUsedAt.Time, certificate.LastUsedAt.Valid),
+			CreatedAt:  certificate.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/admin/users/:userID/certificates
+func (h *Handler) CreateClientCertificate(c *gin.Context) {
+	req := new(CreateClientCertificateRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := make([]interface{}, len(grantableScopes))
+	for i, scope := range grantableScopes {
+		scopes[i] = scope
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Subject, vd.Required, vd.RuneLength(1, 255)),
+		vd.Field(&req.Scopes, vd.Required, vd.Each(vd.In(scopes...))),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	user, _ := c.Get("target_user")
+	userID := user.(*repository.User).ID
+
+	params := repository.CreateClientCertificateParams{
+		UserID:  userID,
+		Subject: req.Subject,
+		Scopes:  req.Scopes,
+	}
+
+	certificateID, err := h.accounts.CreateClientCertificate(c, params)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "subject is mapped to a user already"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "client_certificate_added", userID, fmt.Sprintf("subject=%q", req.Subject)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, CreateClientCertificateResponse{ID: certificateID})
+}
+
+// DELETE /api/v1/admin/users/:userID/certificates/:certificateID
+func (h *Handler) DeleteClientCertificate(c *gin.Context) {
+	certificateID, err := uuid.Parse(c.Param("certificateID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := c.Get("target_user")
+	userID := user.(*repository.User).ID
+
+	err = h.accounts.DeleteClientCertificate(c, userID, certificateID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.auditAdminAction(c, "client_certificate_removed", userID, "") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// authenticateClientCertificate accepts a client certificate the TLS handshake verified against the client CAs,
+// for the user its subject is mapped to. Only the scopes of the mapping are granted.
+// It writes the error response and returns false when there is no such certificate.
+func (h *Handler) authenticateClientCertificate(c *gin.Context) bool {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization token is required"})
+		return false
+	}
+
+	// certificates are meant for services, but browsers holding one present it to cross-site requests as well
+	switch c.GetHeader("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "client certificates are not accepted on cross-site requests"})
+		return false
+	}
+
+	subject := c.Request.TLS.VerifiedChains[0][0].Subject.String()
+	certificate, err := h.accounts.GetClientCertificateBySubject(c, subject)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unknown client certificate"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if !certificate.LastUsedAt.Valid || time.Since(certificate.LastUsedAt.Time) > touchInterval {
+		if err := h.accounts.TouchClientCertificate(c, certificate.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+
+	c.Set("user_id", certificate.UserID)
+	c.Set("scopes", certificate.ScopeList())
+	return true
+}
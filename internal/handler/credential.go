@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenExtractor finds a raw token in the request.
+// It reports false when the request does not carry a token in its place.
+type TokenExtractor func(c *gin.Context) (string, bool)
+
+const (
+	jwtCookieName  = "jwt"
+	queryTokenName = "access_token"
+)
+
+// defaultExtractors is the order AuthMiddleware looks for a token in
+func defaultExtractors() []TokenExtractor {
+	return []TokenExtractor{
+		BearerTokenExtractor(),
+		CookieTokenExtractor(jwtCookieName),
+		EventStreamQueryExtractor(queryTokenName),
+	}
+}
+
+// BearerTokenExtractor reads `Authorization: Bearer <token>`
+func BearerTokenExtractor() TokenExtractor {
+	return func(c *gin.Context) (string, bool) {
+		scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+
+		token = strings.TrimSpace(token)
+		return token, token != ""
+	}
+}
+
+// CookieTokenExtractor reads the cookie with exactly the given name.
+// It marks the request as "token_from_cookie", since browsers attach cookies on their own and checkCSRF has to know.
+func CookieTokenExtractor(name string) TokenExtractor {
+	return func(c *gin.Context) (string, bool) {
+		cookie, err := c.Request.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+
+		c.Set("token_from_cookie", true)
+		return cookie.Value, true
+	}
+}
+
+// EventStreamQueryExtractor reads a query parameter, since EventSource cannot set headers.
+// It only applies to GET requests accepting text/event-stream so tokens stay out of other URLs.
+func EventStreamQueryExtractor(name string) TokenExtractor {
+	return func(c *gin.Context) (string, bool) {
+		if c.Request.Method != http.MethodGet || !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			return "", false
+		}
+
+		token := c.Query(name)
+		return token, token != ""
+	}
+}
+
+// extractToken returns the first token found by the extractors
+func extractToken(c *gin.Context, extractors []TokenExtractor) (string, bool) {
+	for _, extract := range extractors {
+		if token, ok := extract(c); ok {
+			return token, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// スキーマ定義
+type (
+	CSRFTokenResponse struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+)
+
+const (
+	// csrfCookieName is readable by scripts so the client can echo it in csrfHeaderName
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// GET /api/v1/auth/csrf
+func (h *Handler) GetCSRFToken(c *gin.Context) {
+	sessionID, ok := c.Get("session_id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "csrf tokens are only used with cookie sessions"})
+		return
+	}
+
+	token, err := h.setCSRFCookie(c, sessionID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, CSRFTokenResponse{CSRFToken: token})
+}
+
+// checkCSRF requires requests authenticated by a token cookie to prove they come from our own pages,
+// by sending back in csrfHeaderName a token only those pages can read.
+// Safe methods and requests whose token came from elsewhere, which browsers never attach on their own, are exempt.
+// It writes the error response and returns false when the proof is missing.
+func (h *Handler) checkCSRF(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	// whatever else the request carries, a cross-site page can make the browser send the cookie
+	if !c.GetBool("token_from_cookie") {
+		return true
+	}
+
+	sessionID, ok := c.Get("session_id")
+	if ok && h.verifyCSRFToken(c.GetHeader(csrfHeaderName), sessionID.(uuid.UUID)) {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("a valid %s header is required", csrfHeaderName)})
+	return false
+}
+
+// checkRefreshCSRF runs checkCSRF for a refresh token read from its cookie, with the token's session.
+// An unknown token passes, since the caller rejects it anyway.
+func (h *Handler) checkRefreshCSRF(c *gin.Context, refreshToken string) bool {
+	session, err := h.accounts.GetSessionByRefreshToken(c, refreshToken)
+	if errors.Is(err, repository.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	c.Set("token_from_cookie", true)
+	c.Set("session_id", session.ID)
+	return h.checkCSRF(c)
+}
+
+// newCSRFToken binds a token to the session: it is sealed with the session id as additional data,
+// so it verifies for that session only and needs no server-side storage.
+func (h *Handler) newCSRFToken(sessionID uuid.UUID) (string, error) {
+	sealed, err := h.secrets.Seal(nil, csrfAdditionalData(sessionID))
+	if err != nil {
+		return "", fmt.Errorf("seal csrf token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (h *Handler) verifyCSRFToken(token string, sessionID uuid.UUID) bool {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+
+	_, err = h.secrets.Open(sealed, csrfAdditionalData(sessionID))
+	return err == nil
+}
+
+// setCSRFCookie issues a token for the session in a cookie the client's scripts can read, and returns it
+func (h *Handler) setCSRFCookie(c *gin.Context, sessionID uuid.UUID) (string, error) {
+	token, err := h.newCSRFToken(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	cookie := &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Expires:  time.Now().Add(sessionTTL),
+		HttpOnly: false,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	h.setCookie(c, cookie)
+
+	return token, nil
+}
+
+func csrfAdditionalData(sessionID uuid.UUID) []byte {
+	return []byte("csrf:" + sessionID.String())
+}
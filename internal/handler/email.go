@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	pkgmail "github.com/Irori235/system-design-2023-v2/internal/pkg/mail"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+)
+
+// スキーマ定義
+type (
+	UpdateEmailRequest struct {
+		Email string `json:"email"`
+	}
+
+	VerifyEmailRequest struct {
+		Token string `json:"token"`
+	}
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	mailTimeout          = 30 * time.Second
+)
+
+// PATCH /api/v1/users/email
+func (h *Handler) UpdateEmail(c *gin.Context) {
+	req := new(UpdateEmailRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Email, vd.Required, vd.Length(0, 254), vd.By(isEmail)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	params := repository.UpdateEmailParams{
+		ID:    userID.(uuid.UUID),
+		Email: req.Email,
+	}
+
+	err = h.users.UpdateEmail(c, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.GetUser(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.EmailVerifiedAt.Valid {
+		if err := h.sendVerificationMail(c, user.ID, req.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// POST /api/v1/auth/email/verify
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	req := new(VerifyEmailRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Token, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	token, err := h.accounts.GetUserToken(c, repository.TokenPurposeEmailVerification, req.Token)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.accounts.UseUserToken(c, repository.TokenPurposeEmailVerification, req.Token)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the link is stale once the address has been changed again
+	err = h.users.VerifyEmail(c, token.UserID, token.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// sendVerificationMail mails a link proving the user owns email
+func (h *Handler) sendVerificationMail(ctx context.Context, userID uuid.UUID, email string) error {
+	token := newOpaqueToken()
+
+	params := repository.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   repository.TokenPurposeEmailVerification,
+		Email:     email,
+		Token:     token,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}
+
+	if err := h.accounts.CreateUserToken(ctx, params); err != nil {
+		return err
+	}
+
+	h.sendMail(email, "Verify your email address", fmt.Sprintf(
+		"Open this link to verify your email address:\n\n%s\n\nThe link expires in %s. If you did not add this address, ignore this mail.\n",
+		h.link("/verify-email", token), formatTTL(emailVerificationTTL),
+	))
+
+	return nil
+}
+
+// sendMail delivers in the background,
+// so response times depend neither on the mail server nor on whether a mail was sent at all
+func (h *Handler) sendMail(to string, subject string, body string) {
+	msg := pkgmail.Message{
+		From:    h.mailFrom,
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			log.Printf("send mail to %s: %v", to, err)
+		}
+	}()
+}
+
+// link points to a page of the client carrying token
+func (h *Handler) link(path string, token string) string {
+	return h.publicURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+func formatTTL(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", d/time.Minute)
+}
+
+func isEmail(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return errors.New("must be a valid email address")
+	}
+
+	return nil
+}
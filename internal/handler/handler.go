@@ -2,26 +2,83 @@ package handler
 
 import (
 	"crypto/rand"
+	"encoding/base64"
+	"net/http"
 
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/keys"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/mail"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/secretbox"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/security"
 	"github.com/Irori235/system-design-2023-v2/internal/repository"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	jwtSecret string
-	repo      *repository.Repository
+	keys           *keys.KeySet
+	secrets        *secretbox.Box
+	tasks          repository.TaskStore
+	users          repository.UserStore
+	accounts       repository.AccountStore
+	extractors     []TokenExtractor
+	passwordPolicy password.Policy
+	mailer         mail.Mailer
+	mailFrom       string
+	publicURL      string
+	oidcProviders  map[string]*oidc.Provider
+	cookies        security.CookiePolicy
 }
 
-func New(repo *repository.Repository) *Handler {
-	jwtSecret := randomString()
-
+func New(store repository.Store, keySet *keys.KeySet, secrets *secretbox.Box) *Handler {
 	return &Handler{
-		jwtSecret: jwtSecret,
-		repo:      repo,
+		keys:           keySet,
+		secrets:        secrets,
+		tasks:          store,
+		users:          store,
+		accounts:       store,
+		extractors:     defaultExtractors(),
+		passwordPolicy: password.DefaultPolicy(),
+		mailer:         mail.LogMailer{},
+		mailFrom:       "no-reply@localhost",
+		publicURL:      "http://localhost",
+		oidcProviders:  map[string]*oidc.Provider{},
+		cookies:        security.CookiePolicy{SameSite: http.SameSiteLaxMode},
 	}
 }
 
+// SetTokenExtractors replaces the order AuthMiddleware looks for a token in
+func (h *Handler) SetTokenExtractors(extractors ...TokenExtractor) {
+	h.extractors = extractors
+}
+
+// SetPasswordPolicy replaces the rules new passwords are checked against
+func (h *Handler) SetPasswordPolicy(policy password.Policy) {
+	h.passwordPolicy = policy
+}
+
+// SetMailer replaces how and from whom password reset and verification mails are sent
+func (h *Handler) SetMailer(mailer mail.Mailer, from string) {
+	h.mailer = mailer
+	h.mailFrom = from
+}
+
+// SetPublicURL sets where the links in mails point to
+func (h *Handler) SetPublicURL(url string) {
+	h.publicURL = url
+}
+
+// SetOIDCProviders replaces the issuers users can sign in with, keyed by the name used in their routes
+func (h *Handler) SetOIDCProviders(providers map[string]*oidc.Provider) {
+	h.oidcProviders = providers
+}
+
+// SetCookiePolicy replaces the Secure, Domain and SameSite attributes of the cookies the handlers set
+func (h *Handler) SetCookiePolicy(policy security.CookiePolicy) {
+	h.cookies = policy
+}
+
 func (h *Handler) SetupRoutes(group *gin.RouterGroup) {
 	// ping group
 	pingAPI := group.Group("/ping")
@@ -33,45 +90,140 @@ func (h *Handler) SetupRoutes(group *gin.RouterGroup) {
 	userAPI := group.Group("/users")
 	userAPI.Use(h.AuthMiddleware())
 	{
-		userAPI.GET("/me", h.GetMe)
-		userAPI.PATCH("/name", h.UpdateName)
-		userAPI.PATCH("/password", h.UpdatePass)
-		userAPI.DELETE("/quit", h.Quit)
+		userAPI.GET("/me", h.RequireScope(ScopeUserRead), h.GetMe)
+	}
+
+	// account settings are not reachable with personal access tokens
+	accountAPI := userAPI.Group("", h.RequireScope(ScopeAccount))
+	{
+		accountAPI.GET("/me/sessions", h.GetSessions)
+		accountAPI.GET("/me/2fa", h.GetTwoFactor)
+		accountAPI.GET("/me/tokens", h.GetTokens)
+	}
+
+	// nor are credentials and the account itself changed by an admin impersonating the user
+	credentialAPI := accountAPI.Group("", h.DenyImpersonation())
+	{
+		credentialAPI.DELETE("/me/sessions", h.RevokeOtherSessions)
+		credentialAPI.DELETE("/me/sessions/:sessionID", h.RevokeSession)
+		credentialAPI.POST("/me/2fa", h.EnrollTwoFactor)
+		credentialAPI.DELETE("/me/2fa", h.DisableTwoFactor)
+		credentialAPI.POST("/me/2fa/confirm", h.ConfirmTwoFactor)
+		credentialAPI.POST("/me/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+		credentialAPI.POST("/me/tokens", h.CreateToken)
+		credentialAPI.DELETE("/me/tokens/:tokenID", h.DeleteToken)
+		credentialAPI.PATCH("/name", h.UpdateName)
+		credentialAPI.PATCH("/email", h.UpdateEmail)
+		credentialAPI.PATCH("/password", h.LimitBody(maxCredentialBodySize), h.UpdatePass)
+		credentialAPI.DELETE("/quit", h.Quit)
 	}
 
 	// task group
 	taskAPI := group.Group("/tasks")
 	taskAPI.Use(h.AuthMiddleware())
 	{
-		taskAPI.GET("", h.GetTasks)
-		taskAPI.POST("", h.CreateTask)
-		taskAPI.PUT("/:taskID", h.UpdateTask)
-		taskAPI.DELETE("/:taskID", h.DeleteTask)
+		taskAPI.GET("", h.RequireScope(ScopeTasksRead), h.GetTasks)
+		taskAPI.GET("/search", h.RequireScope(ScopeTasksRead), h.SearchTasks)
+		taskAPI.GET("/overdue", h.RequireScope(ScopeTasksRead), h.GetOverdueTasks)
+		taskAPI.POST("", h.RequireScope(ScopeTasksWrite), h.CreateTask)
+		taskAPI.GET("/:taskID", h.RequireScope(ScopeTasksRead), h.TaskOwnerMiddleware(), h.GetTask)
+		taskAPI.PUT("/:taskID", h.RequireScope(ScopeTasksWrite), h.TaskOwnerMiddleware(), h.UpdateTask)
+		taskAPI.DELETE("/:taskID", h.RequireScope(ScopeTasksWrite), h.TaskOwnerMiddleware(), h.DeleteTask)
+		taskAPI.POST("/:taskID/skip", h.RequireScope(ScopeTasksWrite), h.TaskOwnerMiddleware(), h.SkipOccurrence)
+		taskAPI.GET("/:taskID/occurrences", h.RequireScope(ScopeTasksRead), h.TaskOwnerMiddleware(), h.GetTaskOccurrences)
+	}
+
+	// project group
+	projectAPI := group.Group("/projects")
+	projectAPI.Use(h.AuthMiddleware())
+	{
+		projectAPI.GET("", h.RequireScope(ScopeTasksRead), h.GetProjects)
+		projectAPI.POST("", h.RequireScope(ScopeTasksWrite), h.CreateProject)
+		projectAPI.GET("/:projectID", h.RequireScope(ScopeTasksRead), h.ProjectOwnerMiddleware(), h.GetProject)
+		projectAPI.PUT("/:projectID", h.RequireScope(ScopeTasksWrite), h.ProjectOwnerMiddleware(), h.UpdateProject)
+		projectAPI.DELETE("/:projectID", h.RequireScope(ScopeTasksWrite), h.ProjectOwnerMiddleware(), h.DeleteProject)
+		projectAPI.GET("/:projectID/tasks", h.RequireScope(ScopeTasksRead), h.ProjectOwnerMiddleware(), h.GetProjectTasks)
+		projectAPI.POST("/:projectID/tasks/move", h.RequireScope(ScopeTasksWrite), h.ProjectOwnerMiddleware(), h.MoveTasks)
+	}
+
+	// label group
+	labelAPI := group.Group("/labels")
+	labelAPI.Use(h.AuthMiddleware())
+	{
+		labelAPI.GET("", h.RequireScope(ScopeTasksRead), h.GetLabels)
+		labelAPI.POST("", h.RequireScope(ScopeTasksWrite), h.CreateLabel)
+		labelAPI.GET("/:labelID", h.RequireScope(ScopeTasksRead), h.LabelOwnerMiddleware(), h.GetLabel)
+		labelAPI.PUT("/:labelID", h.RequireScope(ScopeTasksWrite), h.LabelOwnerMiddleware(), h.UpdateLabel)
+		labelAPI.DELETE("/:labelID", h.RequireScope(ScopeTasksWrite), h.LabelOwnerMiddleware(), h.DeleteLabel)
+		labelAPI.POST("/:labelID/merge", h.RequireScope(ScopeTasksWrite), h.LabelOwnerMiddleware(), h.MergeLabel)
 	}
 
 	// auth group
 	authAPI := group.Group("/auth")
 	{
-		authAPI.POST("/signup", h.SignUp)
-		authAPI.POST("/signin", h.SignIn)
+		authAPI.POST("/signup", h.LimitBody(maxCredentialBodySize), h.SignUp)
+		authAPI.POST("/signin", h.LimitBody(maxCredentialBodySize), h.SignIn)
+		authAPI.POST("/signin/2fa", h.SignInTwoFactor)
 		authAPI.POST("/signout", h.SignOut)
+		authAPI.POST("/refresh", h.Refresh)
+		authAPI.POST("/password/forgot", h.ForgotPassword)
+		authAPI.POST("/password/reset", h.LimitBody(maxCredentialBodySize), h.ResetPassword)
+		authAPI.POST("/email/verify", h.VerifyEmail)
+		authAPI.GET("/oidc/:provider/start", h.StartOIDC)
+		authAPI.GET("/oidc/:provider/callback", h.OIDCCallback)
+		authAPI.GET("/csrf", h.AuthMiddleware(), h.GetCSRFToken)
+	}
+
+	// oauth group, where third-party apps get and manage tokens on behalf of users
+	oauthAPI := group.Group("/oauth")
+	{
+		oauthAPI.POST("/token", h.OAuthToken)
+		oauthAPI.POST("/introspect", h.IntrospectToken)
+		oauthAPI.POST("/revoke", h.RevokeOAuthToken)
+	}
+
+	// admin group, for signed-in staff only
+	adminAPI := group.Group("/admin")
+	adminAPI.Use(h.AuthMiddleware(), h.RequireScope(ScopeAccount), h.DenyImpersonation(), h.RequireRole(RoleSupport))
+	{
+		adminAPI.GET("/users", h.SearchUsers)
+		adminAPI.GET("/users/:userID", h.AdminUserMiddleware(), h.GetUser)
+		adminAPI.GET("/users/:userID/task-counts", h.AdminUserMiddleware(), h.GetTaskCounts)
+		adminAPI.PATCH("/users/:userID/role", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.UpdateRole)
+		adminAPI.POST("/users/:userID/disable", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.DisableUser)
+		adminAPI.POST("/users/:userID/enable", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.EnableUser)
+		adminAPI.POST("/users/:userID/password-reset", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.ForcePasswordReset)
+		adminAPI.POST("/users/:userID/impersonate", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.Impersonate)
+		adminAPI.GET("/users/:userID/certificates", h.AdminUserMiddleware(), h.GetClientCertificates)
+		adminAPI.POST("/users/:userID/certificates", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.CreateClientCertificate)
+		adminAPI.DELETE("/users/:userID/certificates/:certificateID", h.RequireRole(RoleAdmin), h.AdminUserMiddleware(), h.DeleteClientCertificate)
+		adminAPI.POST("/service-accounts", h.RequireRole(RoleAdmin), h.CreateServiceAccount)
+	}
+
+	// registering clients and consenting is left to signed-in users
+	oauthAccountAPI := oauthAPI.Group("", h.AuthMiddleware(), h.RequireScope(ScopeAccount), h.DenyImpersonation())
+	{
+		oauthAccountAPI.GET("/clients", h.GetClients)
+		oauthAccountAPI.POST("/clients", h.RegisterClient)
+		oauthAccountAPI.DELETE("/clients/:clientID", h.DeleteClient)
+		oauthAccountAPI.GET("/authorize", h.GetConsent)
+		oauthAccountAPI.POST("/authorize", h.Consent)
 	}
 }
 
-func randomString() string {
-	length := 32
-	letters := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// SetupWellKnownRoutes registers the routes served under /.well-known
+func (h *Handler) SetupWellKnownRoutes(group *gin.RouterGroup) {
+	group.GET("/jwks.json", h.GetJWKS)
+}
 
-	b := make([]byte, length)
+// newOpaqueToken returns a random url-safe token with 256 bits of entropy
+func newOpaqueToken() string {
+	b := make([]byte, 32)
 
 	_, err := rand.Read(b)
 	if err != nil {
 		panic(err)
 	}
 
-	for i := 0; i < length; i++ {
-		b[i] = letters[b[i]%byte(len(letters))]
-	}
-
-	return string(b)
+	return base64.RawURLEncoding.EncodeToString(b)
 }
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+type (
+	GetLabelsResponse []GetLabelResponse
+	GetLabelResponse  struct {
+		ID    uuid.UUID `json:"id"`
+		Name  string    `json:"name"`
+		Color string    `json:"color"`
+	}
+
+	CreateLabelRequest struct {
+		Name string `json:"name"`
+		// Color is like "#1e90ff", gray unless set
+		Color string `json:"color"`
+	}
+
+	CreateLabelResponse struct {
+		ID uuid.UUID `json:"id"`
+	}
+
+	UpdateLabelRequest struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	}
+
+	MergeLabelRequest struct {
+		// TargetID is the label that takes over the tasks
+		TargetID uuid.UUID `json:"target_id"`
+	}
+)
+
+const (
+	defaultLabelColor = "#9e9e9e"
+
+	maxTaskLabels = 20
+	// maxLabelFilters bounds the label parameters of GET /api/v1/tasks
+	maxLabelFilters = 10
+)
+
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// GET /api/v1/labels
+func (h *Handler) GetLabels(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	labels, err := h.tasks.GetLabels(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make(GetLabelsResponse, len(labels))
+	for i := range labels {
+		res[i] = labelResponse(&labels[i])
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /api/v1/labels/:labelID
+func (h *Handler) GetLabel(c *gin.Context) {
+	label := c.MustGet("label").(*repository.Label)
+
+	c.JSON(http.StatusOK, labelResponse(label))
+}
+
+// POST /api/v1/labels
+func (h *Handler) CreateLabel(c *gin.Context) {
+	req := new(CreateLabelRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 50), vd.By(isLabelName)),
+		vd.Field(&req.Color, vd.Match(labelColorPattern)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	params := repository.CreateLabelParams{
+		UserID: userID.(uuid.UUID),
+		Name:   req.Name,
+		Color:  labelColor(req.Color),
+	}
+
+	labelID, err := h.tasks.CreateLabel(c, params)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, CreateLabelResponse{ID: labelID})
+}
+
+// PUT /api/v1/labels/:labelID
+// A new name shows on every task with the label at once.
+func (h *Handler) UpdateLabel(c *gin.Context) {
+	label := c.MustGet("label").(*repository.Label)
+
+	req := new(UpdateLabelRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 50), vd.By(isLabelName)),
+		vd.Field(&req.Color, vd.Match(labelColorPattern)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	params := repository.UpdateLabelParams{
+		ID:     label.ID,
+		UserID: label.UserID,
+		Name:   req.Name,
+		Color:  labelColor(req.Color),
+	}
+
+	err = h.tasks.UpdateLabel(c, params)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// DELETE /api/v1/labels/:labelID
+// The label is taken off its tasks.
+func (h *Handler) DeleteLabel(c *gin.Context) {
+	label := c.MustGet("label").(*repository.Label)
+
+	err := h.tasks.DeleteLabel(c, label.UserID, label.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// POST /api/v1/labels/:labelID/merge
+// It puts the target label on every task with this one and deletes this one, all at once.
+func (h *Handler) MergeLabel(c *gin.Context) {
+	label := c.MustGet("label").(*repository.Label)
+
+	req := new(MergeLabelRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.TargetID, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	if req.TargetID == label.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a label cannot be merged into itself"})
+		return
+	}
+
+	params := repository.MergeLabelsParams{
+		UserID:   label.UserID,
+		SourceID: label.ID,
+		TargetID: req.TargetID,
+	}
+
+	err = h.tasks.MergeLabels(c, params)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// isLabelName keeps names from reading as an exclusion in GET /api/v1/tasks?label=-name
+func isLabelName(value interface{}) error {
+	s, _ := value.(string)
+	if strings.HasPrefix(s, "-") {
+		return errors.New("must not start with -")
+	}
+
+	return nil
+}
+
+// isLabelFilter accepts a label name, or one after "-" to leave the label out
+func isLabelFilter(value interface{}) error {
+	s, _ := value.(string)
+	name := strings.TrimPrefix(s, "-")
+	if name == "" || len([]rune(name)) > 50 {
+		return errors.New("must be a label name, optionally after -")
+	}
+
+	return isLabelName(name)
+}
+
+// labelColor stores colors in lower case
+func labelColor(s string) string {
+	if s == "" {
+		return defaultLabelColor
+	}
+
+	return strings.ToLower(s)
+}
+
+// uniqueLabelIDs drops duplicates, keeping nil as nil
+func uniqueLabelIDs(labelIDs []uuid.UUID) []uuid.UUID {
+	if labelIDs == nil {
+		return nil
+	}
+
+	unique := []uuid.UUID{}
+	for _, labelID := range labelIDs {
+		if !slices.Contains(unique, labelID) {
+			unique = append(unique, labelID)
+		}
+	}
+
+	return unique
+}
+
+func labelResponse(label *repository.Label) GetLabelResponse {
+	return GetLabelResponse{
+		ID:    label.ID,
+		Name:  label.Name,
+		Color: label.Color,
+	}
+}
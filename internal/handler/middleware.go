@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -11,52 +15,302 @@ import (
 
 func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cookieHeader := c.GetHeader("cookie")
-		if cookieHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Cookie header is required"})
+		tokenString, ok := extractToken(c, h.extractors)
+
+		// without a token, a client certificate verified during the TLS handshake may stand in for one
+		authenticate := h.authenticateSession
+		if !ok {
+			authenticate = func(c *gin.Context, _ string) bool { return h.authenticateClientCertificate(c) }
+		} else if strings.HasPrefix(tokenString, personalAccessTokenPrefix) {
+			authenticate = h.authenticatePersonalAccessToken
+		} else if isOAuthAccessToken(tokenString) {
+			authenticate = h.authenticateOAuthAccessToken
+		}
+
+		if !authenticate(c, tokenString) {
 			c.Abort()
 			return
 		}
 
-		cookies := strings.Split(cookieHeader, ";")
-		var tokenString string
-		for _, cookie := range cookies {
-			if strings.Contains(cookie, "jwt=") {
-				tokenString = strings.Replace(cookie, "jwt=", "", 1)
-			}
+		if !h.loadAccount(c) {
+			c.Abort()
+			return
 		}
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "jwt cookie is required"})
+
+		if !h.checkCSRF(c) {
 			c.Abort()
 			return
 		}
 
-		claims := &Claims{}
+		c.Next()
+	}
+}
+
+// authenticateSession accepts an access token issued at sign-in.
+// It writes the error response and returns false when the token is not valid.
+func (h *Handler) authenticateSession(c *gin.Context, tokenString string) bool {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, h.verificationKey)
+
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+
+	str := claims.UserID
+	userID, err := uuid.Parse(str)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
 
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(h.jwtSecret), nil
-		})
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
 
+	// an impersonation token rides on the session of the admin who asked for it
+	sessionUserID := userID
+	if claims.Actor != nil {
+		sessionUserID, err = uuid.Parse(claims.Actor.Subject)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return false
+		}
+	}
+
+	// access tokens die with their session even before they expire
+	session, err := h.accounts.GetSession(c, sessionID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if session == nil || session.UserID != sessionUserID || !session.IsActive(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+		return false
+	}
+
+	if time.Since(session.LastSeenAt) > touchInterval {
+		if err := h.accounts.TouchSession(c, sessionID, c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+
+	if claims.Actor != nil {
+		if !h.checkImpersonator(c, userID, sessionUserID) {
+			return false
+		}
+
+		// the session belongs to the admin, so it is not handed to the user's session endpoints
+		c.Set("user_id", userID)
+		c.Set("actor_id", sessionUserID)
+		c.Set("scopes", sessionScopes)
+		return true
+	}
+
+	c.Set("user_id", userID)
+	c.Set("session_id", sessionID)
+	c.Set("scopes", sessionScopes)
+	return true
+}
+
+// authenticatePersonalAccessToken accepts a token created under /users/me/tokens.
+// It writes the error response and returns false when the token is not valid.
+func (h *Handler) authenticatePersonalAccessToken(c *gin.Context, tokenString string) bool {
+	pat, err := h.accounts.GetPersonalAccessTokenByToken(c, tokenString)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if !pat.IsActive(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token expired"})
+		return false
+	}
+
+	if !pat.LastUsedAt.Valid || time.Since(pat.LastUsedAt.Time) > touchInterval {
+		if err := h.accounts.TouchPersonalAccessToken(c, pat.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+
+	c.Set("user_id", pat.UserID)
+	c.Set("scopes", pat.ScopeList())
+	return true
+}
+
+// loadAccount looks up the user a credential was accepted for, rejecting disabled accounts
+// whatever credential they come with, and sets their role.
+// It writes the error response and returns false when the user may not go on.
+func (h *Handler) loadAccount(c *gin.Context) bool {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return false
+	}
+
+	user, err := h.users.GetUser(c, userID.(uuid.UUID))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if user.DisabledAt.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return false
+	}
+
+	c.Set("role", user.Role)
+	return true
+}
+
+// RequireScope aborts with 403 unless the credential grants scope.
+// It must be used after AuthMiddleware.
+func (h *Handler) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s scope is required", scope)})
 			c.Abort()
 			return
 		}
 
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		c.Next()
+	}
+}
+
+// LimitBody aborts with 413 when the request body is larger than n bytes
+func (h *Handler) LimitBody(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > n {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			c.Abort()
+			return
+		}
+
+		// a body without a length fails to bind once it goes past n
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		c.Next()
+	}
+}
+
+// TaskOwnerMiddleware loads the task in :taskID and aborts with 404 unless it belongs to the signed-in user.
+// It must be used after AuthMiddleware.
+func (h *Handler) TaskOwnerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		taskID, err := uuid.Parse(c.Param("taskID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		userID, ok := c.Get("user_id")
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			c.Abort()
 			return
 		}
 
-		str := claims.UserID
-		userID, err := uuid.Parse(str)
+		task, err := h.tasks.GetTask(c, userID.(uuid.UUID), taskID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
+		c.Set("task", task)
+		c.Next()
+	}
+}
+
+// ProjectOwnerMiddleware loads the project in :projectID and aborts with 404 unless it belongs to the signed-in user.
+// It must be used after AuthMiddleware.
+func (h *Handler) ProjectOwnerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID, err := uuid.Parse(c.Param("projectID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		userID, ok := c.Get("user_id")
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort()
+			return
+		}
+
+		project, err := h.tasks.GetProject(c, userID.(uuid.UUID), projectID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("project", project)
+		c.Next()
+	}
+}
+
+// LabelOwnerMiddleware loads the label in :labelID and aborts with 404 unless it belongs to the signed-in user.
+// It must be used after AuthMiddleware.
+func (h *Handler) LabelOwnerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		labelID, err := uuid.Parse(c.Param("labelID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		userID, ok := c.Get("user_id")
+		if !ok {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort()
+			return
+		}
+
+		label, err := h.tasks.GetLabel(c, userID.(uuid.UUID), labelID)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("label", label)
 		c.Next()
 	}
 }
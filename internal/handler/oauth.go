@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// スキーマ定義
+type (
+	RegisterClientRequest struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		// Public clients, such as single page or native apps, get no secret
+		Public bool `json:"public"`
+	}
+
+	RegisterClientResponse struct {
+		ClientID uuid.UUID `json:"client_id"`
+		// ClientSecret is only ever shown in this response
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	GetClientsResponse []GetClientResponse
+	GetClientResponse  struct {
+		ClientID     uuid.UUID `json:"client_id"`
+		Name         string    `json:"name"`
+		RedirectURIs []string  `json:"redirect_uris"`
+		Scopes       []string  `json:"scopes"`
+		Public       bool      `json:"public"`
+		CreatedAt    time.Time `json:"created_at"`
+	}
+
+	// AuthorizeRequest carries the parameters of RFC 6749 section 4.1.1 with PKCE
+	AuthorizeRequest struct {
+		ResponseType        string `form:"response_type" json:"response_type"`
+		ClientID            string `form:"client_id" json:"client_id"`
+		RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+		Scope               string `form:"scope" json:"scope"`
+		State               string `form:"state" json:"state"`
+		CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+		CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	}
+
+	ConsentRequest struct {
+		AuthorizeRequest
+		Approve bool `json:"approve"`
+	}
+
+	// GetConsentResponse is what the consent screen shows the user
+	GetConsentResponse struct {
+		ClientID    uuid.UUID `json:"client_id"`
+		ClientName  string    `json:"client_name"`
+		RedirectURI string    `json:"redirect_uri"`
+		Scopes      []string  `json:"scopes"`
+	}
+
+	ConsentResponse struct {
+		// RedirectTo sends the user agent back to the client with a code or an error
+		RedirectTo string `json:"redirect_to"`
+	}
+)
+
+const (
+	oauthCodeTTL    = time.Minute
+	maxRedirectURIs = 10
+)
+
+// pkceChallenge matches a base64url SHA-256 digest
+var pkceChallenge = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// GET /api/v1/oauth/clients
+func (h *Handler) GetClients(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	clients, err := h.accounts.GetOAuthClients(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make(GetClientsResponse, len(clients))
+	for i, client := range clients {
+		res[i] = GetClientResponse{
+			ClientID:     client.ID,
+			Name:         client.Name,
+			RedirectURIs: client.RedirectURIList(),
+			Scopes:       client.ScopeList(),
+			Public:       client.IsPublic(),
+			CreatedAt:    client.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/oauth/clients
+func (h *Handler) RegisterClient(c *gin.Context) {
+	req := new(RegisterClientRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := make([]interface{}, len(grantableScopes))
+	for i, scope := range grantableScopes {
+		scopes[i] = scope
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 100)),
+		vd.Field(&req.RedirectURIs, vd.Required, vd.Length(1, maxRedirectURIs), vd.Each(vd.By(isRedirectURI))),
+		vd.Field(&req.Scopes, vd.Required, vd.Each(vd.In(scopes...))),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	params := repository.CreateOAuthClientParams{
+		UserID:       userID.(uuid.UUID),
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+	}
+	if !req.Public {
+		params.Secret = newOpaqueToken()
+	}
+
+	clientID, err := h.accounts.CreateOAuthClient(c, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := RegisterClientResponse{
+		ClientID:     clientID,
+		ClientSecret: params.Secret,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DELETE /api/v1/oauth/clients/:clientID
+func (h *Handler) DeleteClient(c *gin.Context) {
+	clientID, err := uuid.Parse(c.Param("clientID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	err = h.accounts.DeleteOAuthClient(c, userID.(uuid.UUID), clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// GET /api/v1/oauth/authorize
+func (h *Handler) GetConsent(c *gin.Context) {
+	req := new(AuthorizeRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, scopes, ok := h.checkAuthorizeRequest(c, req)
+	if !ok {
+		return
+	}
+
+	res := GetConsentResponse{
+		ClientID:    client.ID,
+		ClientName:  client.Name,
+		RedirectURI: req.RedirectURI,
+		Scopes:      scopes,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/oauth/authorize
+func (h *Handler) Consent(c *gin.Context) {
+	req := new(ConsentRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, scopes, ok := h.checkAuthorizeRequest(c, &req.AuthorizeRequest)
+	if !ok {
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !req.Approve {
+		c.JSON(http.StatusOK, ConsentResponse{
+			RedirectTo: h.authorizationRedirect(req.RedirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}}),
+		})
+		return
+	}
+
+	code := newOpaqueToken()
+	params := repository.CreateOAuthCodeParams{
+		Code:          code,
+		ClientID:      client.ID,
+		UserID:        userID.(uuid.UUID),
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	}
+
+	if err := h.accounts.CreateOAuthCode(c, params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ConsentResponse{
+		RedirectTo: h.authorizationRedirect(req.RedirectURI, url.Values{"code": {code}, "state": {req.State}}),
+	})
+}
+
+// checkAuthorizeRequest validates an authorization request against the registered client
+// and returns the scopes to grant. It writes the error response and returns false when the request is not valid.
+func (h *Handler) checkAuthorizeRequest(c *gin.Context, req *AuthorizeRequest) (*repository.OAuthClient, []string, bool) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown client"})
+		return nil, nil, false
+	}
+
+	client, err := h.accounts.GetOAuthClient(c, clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown client"})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	// an unregistered redirect_uri could hand the code to anyone, so it is never redirected to
+	if !slices.Contains(client.RedirectURIList(), req.RedirectURI) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "redirect_uri is not registered for the client"})
+		return nil, nil, false
+	}
+
+	if req.ResponseType != "code" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "response_type must be code"})
+		return nil, nil, false
+	}
+
+	// PKCE is required of every client, as in OAuth 2.1
+	if req.CodeChallengeMethod != "S256" || !pkceChallenge.MatchString(req.CodeChallenge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code_challenge with code_challenge_method S256 is required"})
+		return nil, nil, false
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = client.ScopeList()
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.ScopeList(), scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("scope %s is not allowed for the client", scope)})
+			return nil, nil, false
+		}
+	}
+
+	return client, scopes, true
+}
+
+// authorizationRedirect adds the response parameters to the client's redirect_uri.
+// iss tells the client which server answered (RFC 9207).
+func (h *Handler) authorizationRedirect(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		// registered redirect URIs have been parsed before
+		return redirectURI
+	}
+
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+	}
+	q.Set("iss", h.publicURL)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// isRedirectURI accepts absolute https URLs, and http only on loopback for native apps (RFC 8252 section 7.3)
+func isRedirectURI(value interface{}) error {
+	s, _ := value.(string)
+
+	u, err := url.Parse(s)
+	if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || strings.ContainsAny(s, " #") {
+		return errors.New("must be an absolute URL without fragment")
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := u.Hostname(); host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+
+	return errors.New("must use https, or http on a loopback address")
+}
@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// スキーマ定義
+type (
+	// TokenRequest is the form of RFC 6749 sections 4.1.3 and 6
+	TokenRequest struct {
+		GrantType    string `form:"grant_type"`
+		Code         string `form:"code"`
+		RedirectURI  string `form:"redirect_uri"`
+		CodeVerifier string `form:"code_verifier"`
+		RefreshToken string `form:"refresh_token"`
+		ClientID     string `form:"client_id"`
+		ClientSecret string `form:"client_secret"`
+	}
+
+	// TokenActionRequest is the form of the introspection (RFC 7662) and revocation (RFC 7009) endpoints
+	TokenActionRequest struct {
+		Token         string `form:"token"`
+		TokenTypeHint string `form:"token_type_hint"`
+		ClientID      string `form:"client_id"`
+		ClientSecret  string `form:"client_secret"`
+	}
+
+	TokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	IntrospectionResponse struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Username  string `json:"username,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+	}
+
+	// OAuthClaims is the JWT access token profile of RFC 9068
+	OAuthClaims struct {
+		ClientID string `json:"client_id"`
+		Scope    string `json:"scope"`
+		GrantID  string `json:"gid"`
+		jwt.StandardClaims
+	}
+)
+
+const (
+	oauthAccessTokenTTL = time.Hour
+	oauthGrantTTL       = 30 * 24 * time.Hour
+	oauthAudience       = "oauth"
+	// oauthTokenType is the typ header telling OAuth access tokens from session ones
+	oauthTokenType = "at+jwt"
+)
+
+// POST /api/v1/oauth/token
+func (h *Handler) OAuthToken(c *gin.Context) {
+	req := new(TokenRequest)
+	if err := c.ShouldBind(req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if !ok {
+		return
+	}
+
+	var grant *repository.OAuthGrant
+	refreshToken := newOpaqueToken()
+
+	switch req.GrantType {
+	case "authorization_code":
+		code, err := h.accounts.GetOAuthCode(c, req.Code)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		if code == nil || code.ClientID != client.ID || code.RedirectURI != req.RedirectURI ||
+			subtle.ConstantTimeCompare([]byte(oidc.S256Challenge(req.CodeVerifier)), []byte(code.CodeChallenge)) != 1 {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+			return
+		}
+
+		grant, err = h.accounts.RedeemOAuthCode(c, repository.RedeemOAuthCodeParams{
+			Code:         req.Code,
+			ClientID:     client.ID,
+			RefreshToken: refreshToken,
+			ExpiresAt:    time.Now().Add(oauthGrantTTL),
+		})
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrOAuthCodeReused) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+			return
+		}
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+	case "refresh_token":
+		var err error
+		grant, err = h.accounts.RotateOAuthRefreshToken(c, repository.RotateOAuthRefreshTokenParams{
+			ClientID:        client.ID,
+			RefreshToken:    req.RefreshToken,
+			NewRefreshToken: refreshToken,
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+			return
+		}
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	accessToken, err := h.generateOAuthAccessToken(grant)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	res := TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        grant.Scopes,
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/oauth/introspect
+func (h *Handler) IntrospectToken(c *gin.Context) {
+	req := new(TokenActionRequest)
+	if err := c.ShouldBind(req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+
+	grant, claims, err := h.lookupOAuthToken(c, req.Token)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	// a client only learns about its own tokens
+	if grant == nil || grant.ClientID != client.ID || !grant.IsActive(time.Now()) {
+		c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	// grants go with their user, so the user is there
+	user, err := h.users.GetUser(c, grant.UserID)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	res := IntrospectionResponse{
+		Active:    true,
+		Scope:     grant.Scopes,
+		ClientID:  grant.ClientID.String(),
+		Username:  user.Name,
+		Subject:   grant.UserID.String(),
+		TokenType: "refresh_token",
+		ExpiresAt: grant.ExpiresAt.Unix(),
+		IssuedAt:  grant.CreatedAt.Unix(),
+	}
+	if claims != nil {
+		res.Scope = claims.Scope
+		res.TokenType = "Bearer"
+		res.ExpiresAt = claims.ExpiresAt
+		res.IssuedAt = claims.IssuedAt
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/oauth/revoke
+func (h *Handler) RevokeOAuthToken(c *gin.Context) {
+	req := new(TokenActionRequest)
+	if err := c.ShouldBind(req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := h.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if !ok {
+		return
+	}
+
+	grant, _, err := h.lookupOAuthToken(c, req.Token)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	// unknown tokens and tokens of other clients are answered the same way (RFC 7009 section 2.2)
+	if grant != nil && grant.ClientID == client.ID {
+		if err := h.accounts.RevokeOAuthGrant(c, grant.ID); err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// authenticateClient checks the client credentials sent with HTTP Basic or in the form.
+// Public clients send only their client_id. It writes the error response and returns false when they are not valid.
+func (h *Handler) authenticateClient(c *gin.Context, clientID string, clientSecret string) (*repository.OAuthClient, bool) {
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		// the credentials are form-urlencoded before going into the header (RFC 6749 section 2.3.1)
+		id, err1 := url.QueryUnescape(username)
+		secret, err2 := url.QueryUnescape(password)
+		if err1 != nil || err2 != nil {
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "malformed client credentials")
+			return nil, false
+		}
+		clientID, clientSecret = id, secret
+	}
+
+	id, err := uuid.Parse(clientID)
+	if err != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "unknown client")
+		return nil, false
+	}
+
+	client, err := h.accounts.GetOAuthClient(c, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "unknown client")
+		return nil, false
+	}
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return nil, false
+	}
+
+	if client.IsPublic() != (clientSecret == "") || (!client.IsPublic() && !client.CheckSecret(clientSecret)) {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return nil, false
+	}
+
+	return client, true
+}
+
+// lookupOAuthToken finds the grant behind an access or refresh token.
+// claims is set only for an access token; both are nil when the token is unknown or invalid.
+func (h *Handler) lookupOAuthToken(c *gin.Context, token string) (*repository.OAuthGrant, *OAuthClaims, error) {
+	if claims, err := h.parseOAuthAccessToken(token); err == nil {
+		grantID, err := uuid.Parse(claims.GrantID)
+		if err != nil {
+			return nil, nil, nil
+		}
+
+		grant, err := h.accounts.GetOAuthGrant(c, grantID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return grant, claims, nil
+	}
+
+	grant, err := h.accounts.GetOAuthGrantByRefreshToken(c, token)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return grant, nil, nil
+}
+
+// authenticateOAuthAccessToken accepts an access token issued at /oauth/token.
+// It writes the error response and returns false when the token is not valid.
+func (h *Handler) authenticateOAuthAccessToken(c *gin.Context, tokenString string) bool {
+	claims, err := h.parseOAuthAccessToken(tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+
+	grantID, err := uuid.Parse(claims.GrantID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+
+	// access tokens die with their grant even before they expire
+	grant, err := h.accounts.GetOAuthGrant(c, grantID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if grant == nil || grant.UserID != userID || grant.ClientID.String() != claims.ClientID || !grant.IsActive(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "grant revoked"})
+		return false
+	}
+
+	// never more than a personal access token could hold, whatever the token says
+	scopes := []string{}
+	for _, scope := range grant.ScopeList() {
+		if slices.Contains(grantableScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	c.Set("user_id", userID)
+	c.Set("oauth_client_id", grant.ClientID)
+	c.Set("scopes", scopes)
+	return true
+}
+
+func (h *Handler) parseOAuthAccessToken(tokenString string) (*OAuthClaims, error) {
+	claims := &OAuthClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, h.verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid || token.Header["typ"] != oauthTokenType || !claims.VerifyAudience(oauthAudience, true) {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+func (h *Handler) generateOAuthAccessToken(grant *repository.OAuthGrant) (string, error) {
+	key := h.keys.Signing()
+	now := time.Now()
+
+	claims := &OAuthClaims{
+		ClientID: grant.ClientID.String(),
+		Scope:    grant.Scopes,
+		GrantID:  grant.ID.String(),
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    h.publicURL,
+			Subject:   grant.UserID.String(),
+			Audience:  oauthAudience,
+			Id:        uuid.NewString(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(oauthAccessTokenTTL).Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = oauthTokenType
+	tokenStr, err := token.SignedString(key.PrivateKey())
+	if err != nil {
+		return "", fmt.Errorf("generate oauth access token: %w", err)
+	}
+
+	return tokenStr, nil
+}
+
+// isOAuthAccessToken tells from the unverified header whether AuthMiddleware should check tokenString as an OAuth access token
+func isOAuthAccessToken(tokenString string) bool {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &OAuthClaims{})
+	return err == nil && token.Header["typ"] == oauthTokenType
+}
+
+// oauthError writes an error response in the format of RFC 6749 section 5.2
+func oauthError(c *gin.Context, status int, code string, description string) {
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
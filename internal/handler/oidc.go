@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcStateCookieName = "oidc_state"
+	// oidcStateTTL is how long the user has to sign in at the issuer
+	oidcStateTTL = 10 * time.Minute
+	// maxNameAttempts bounds the retries when a derived user name is taken
+	maxNameAttempts = 5
+)
+
+// oidcState travels through the issuer in a sealed cookie, so nothing is stored before the user comes back
+type oidcState struct {
+	Provider  string `json:"provider"`
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// GET /api/v1/auth/oidc/:provider/start
+func (h *Handler) StartOIDC(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := h.oidcProviders[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+
+	state := oidcState{
+		Provider:  name,
+		State:     oidc.NewRandom(),
+		Nonce:     oidc.NewRandom(),
+		Verifier:  oidc.NewRandom(),
+		ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+	}
+
+	redirectURL, err := provider.AuthCodeURL(c, state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sealed, err := h.secrets.Seal(plaintext, []byte(oidcStateCookieName))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Lax, because the issuer sends the user back with a cross-site redirect
+	h.setCookie(c, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Path:     authCookiePath(c),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// GET /api/v1/auth/oidc/:provider/callback
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	name := c.Param("provider")
+	provider, ok := h.oidcProviders[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+
+	state, err := h.readOIDCState(c)
+
+	// the state is single use whatever happens next
+	h.setCookie(c, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "expired",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     authCookiePath(c),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if err != nil || state.Provider != name ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
+		return
+	}
+
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("sign-in at %s failed: %s", name, e)})
+		return
+	}
+
+	idToken, err := provider.Exchange(c, c.Query("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.oidcUser(c, name, idToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.GetUser(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.DisabledAt.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	// the issuer vouches for who the user is, not for a password that has to be replaced
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required"})
+		return
+	}
+
+	t, err := h.accounts.GetTOTP(c, userID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// two-factor authentication still applies; the fragment keeps the token out of server logs
+	if t != nil && t.ConfirmedAt.Valid {
+		mfaToken, err := generateMFAToken(userID.String(), h.keys.Signing())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Redirect(http.StatusFound, h.publicURL+"/login#"+url.Values{"mfa_token": {mfaToken}}.Encode())
+		return
+	}
+
+	sessionID, refreshToken, err := h.createSession(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.setAuthCookies(c, userID, sessionID, refreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.publicURL+"/")
+}
+
+func (h *Handler) readOIDCState(c *gin.Context) (*oidcState, error) {
+	cookie, err := c.Request.Cookie(oidcStateCookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := h.secrets.Open(sealed, []byte(oidcStateCookieName))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &oidcState{}
+	if err := json.Unmarshal(plaintext, state); err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > state.ExpiresAt {
+		return nil, errors.New("state expired")
+	}
+
+	return state, nil
+}
+
+// oidcUser returns the user linked to the external account, creating one on first sign-in.
+// Existing users are never matched by email, since the issuer may not own the address.
+func (h *Handler) oidcUser(c *gin.Context, provider string, idToken *oidc.IDToken) (uuid.UUID, error) {
+	identity, err := h.users.GetUserIdentity(c, provider, idToken.Subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return uuid.Nil, err
+	}
+
+	email := ""
+	if idToken.EmailVerified {
+		email = idToken.Email
+	}
+
+	base := oidcUserName(idToken)
+	for i := 0; i < maxNameAttempts; i++ {
+		params := repository.CreateUserWithIdentityParams{
+			Name:     base,
+			Provider: provider,
+			Subject:  idToken.Subject,
+			Email:    email,
+		}
+		if i > 0 {
+			suffix := "_" + newOpaqueToken()[:6]
+			params.Name = truncateName(base, 50-len(suffix)) + suffix
+		}
+
+		userID, err := h.users.CreateUserWithIdentity(c, params)
+		if err == nil {
+			return userID, nil
+		}
+		if !errors.Is(err, repository.ErrAlreadyExists) {
+			return uuid.Nil, err
+		}
+
+		// a concurrent callback may have linked the identity meanwhile
+		identity, err := h.users.GetUserIdentity(c, provider, idToken.Subject)
+		if err == nil {
+			return identity.UserID, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return uuid.Nil, err
+		}
+	}
+
+	return uuid.Nil, errors.New("could not find a free user name")
+}
+
+// oidcUserName derives a name from what the issuer tells about the user
+func oidcUserName(idToken *oidc.IDToken) string {
+	candidates := []string{idToken.PreferredUsername}
+	if at := strings.LastIndex(idToken.Email, "@"); at > 0 {
+		candidates = append(candidates, idToken.Email[:at])
+	}
+	candidates = append(candidates, idToken.Name)
+
+	for _, candidate := range candidates {
+		name := strings.Trim(invalidNameChars.ReplaceAllString(candidate, "_"), "_")
+		if name != "" {
+			return truncateName(name, 50)
+		}
+	}
+
+	return "user"
+}
+
+func truncateName(name string, n int) string {
+	if len(name) <= n {
+		return name
+	}
+
+	return name[:n]
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+)
+
+// スキーマ定義
+type (
+	ForgotPasswordRequest struct {
+		Email string `json:"email"`
+	}
+
+	ResetPasswordRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	PasswordPolicyErrorResponse struct {
+		Error      string                      `json:"error"`
+		Violations []PasswordViolationResponse `json:"violations"`
+	}
+
+	PasswordViolationResponse struct {
+		Rule    string `json:"rule"`
+		Message string `json:"message"`
+	}
+)
+
+// maxCredentialBodySize bounds the requests carrying a password, which is hashed and checked against the policy
+const maxCredentialBodySize = 8 << 10
+
+// checkPasswordPolicy writes a 400 listing every violated rule and returns false when password is not acceptable
+func (h *Handler) checkPasswordPolicy(c *gin.Context, password string, username string) bool {
+	violations, err := h.passwordPolicy.Check(password, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	res := PasswordPolicyErrorResponse{
+		Error:      "password does not meet the policy",
+		Violations: []PasswordViolationResponse{},
+	}
+	for _, v := range violations {
+		res.Violations = append(res.Violations, PasswordViolationResponse{
+			Rule:    v.Rule,
+			Message: v.Message,
+		})
+	}
+
+	c.JSON(http.StatusBadRequest, res)
+	return false
+}
+
+const (
+	passwordResetTTL = time.Hour
+	// passwordResetCooldown keeps /auth/password/forgot from flooding a mailbox
+	passwordResetCooldown = time.Minute
+)
+
+// POST /api/v1/auth/password/forgot
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	req := new(ForgotPasswordRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Email, vd.Required, vd.By(isEmail)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	// the response is the same whether or not a mail goes out, so addresses cannot be probed.
+	// Only a verified address is looked up, as an unverified one may belong to someone else.
+	user, err := h.users.GetUserByEmail(c, req.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.sendPasswordResetMail(c, user, passwordResetCooldown)
+	if err != nil && !errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// sendPasswordResetMail mails a reset link to the user's verified address.
+// It returns ErrAlreadyExists when a link went out less than cooldown ago.
+func (h *Handler) sendPasswordResetMail(c *gin.Context, user *repository.User, cooldown time.Duration) error {
+	token := newOpaqueToken()
+
+	params := repository.CreateUserTokenParams{
+		UserID:    user.ID,
+		Purpose:   repository.TokenPurposePasswordReset,
+		Email:     user.Email.String,
+		Token:     token,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+		Cooldown:  cooldown,
+	}
+
+	if err := h.accounts.CreateUserToken(c, params); err != nil {
+		return err
+	}
+
+	h.sendMail(user.Email.String, "Reset your password", fmt.Sprintf(
+		"Open this link to choose a new password for %s:\n\n%s\n\nThe link expires in %s and works once. If you did not ask for it, ignore this mail.\n",
+		user.Name, h.link("/reset-password", token), formatTTL(passwordResetTTL),
+	))
+
+	return nil
+}
+
+// POST /api/v1/auth/password/reset
+func (h *Handler) ResetPassword(c *gin.Context) {
+	req := new(ResetPasswordRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Token, vd.Required),
+		vd.Field(&req.Password, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	token, err := h.accounts.GetUserToken(c, repository.TokenPurposePasswordReset, req.Token)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.GetUser(c, token.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the link is stale once the address has been changed
+	if !user.EmailVerifiedAt.Valid || !strings.EqualFold(user.Email.String, token.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	// checked before the token is spent so that a rejected password can be retried with the same link
+	if !h.checkPasswordPolicy(c, req.Password, user.Name) {
+		return
+	}
+
+	err = h.accounts.UseUserToken(c, repository.TokenPurposePasswordReset, req.Token)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	params := repository.UpdatePassParams{
+		ID:       user.ID,
+		Password: req.Password,
+	}
+
+	if err := h.users.UpdatePass(c, params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// whoever knew the old password is shut out, and a locked out owner gets back in
+	if err := h.revokeCredentials(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.resetSignInFailures(c, user.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	auditParams := repository.CreateAuditLogParams{
+		Event:  "password_reset",
+		UserID: user.ID,
+		IP:     c.ClientIP(),
+	}
+
+	if err := h.accounts.CreateAuditLog(c, auditParams); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// revokeCredentials revokes every credential that outlives the password: sessions, personal access tokens
+// and OAuth grants. A reset uses it so that whoever knew the old password cannot keep access through them.
+func (h *Handler) revokeCredentials(c *gin.Context, userID uuid.UUID) error {
+	if _, err := h.accounts.RevokeOtherSessions(c, userID, uuid.Nil); err != nil {
+		return err
+	}
+
+	if err := h.accounts.DeletePersonalAccessTokens(c, userID); err != nil {
+		return err
+	}
+
+	return h.accounts.RevokeOAuthGrants(c, userID)
+}
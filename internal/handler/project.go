@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+type (
+	GetProjectsRequest struct {
+		// Archived lists archived projects too
+		Archived bool `form:"archived"`
+	}
+
+	GetProjectsResponse []GetProjectResponse
+	GetProjectResponse  struct {
+		ID   uuid.UUID `json:"id"`
+		Name string    `json:"name"`
+		// IsInbox marks the project tasks go to unless told otherwise. It cannot be changed or deleted.
+		IsInbox    bool       `json:"is_inbox"`
+		ArchivedAt *time.Time `json:"archived_at"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+
+	CreateProjectRequest struct {
+		Name string `json:"name"`
+	}
+
+	CreateProjectResponse struct {
+		ID uuid.UUID `json:"id"`
+	}
+
+	UpdateProjectRequest struct {
+		Name string `json:"name"`
+		// Archived hides the project from listings and keeps tasks from being added to it
+		Archived bool `json:"archived"`
+	}
+
+	MoveTasksRequest struct {
+		TaskIDs []uuid.UUID `json:"task_ids"`
+	}
+)
+
+const maxMovedTasks = 200
+
+// GET /api/v1/projects
+func (h *Handler) GetProjects(c *gin.Context) {
+	req := new(GetProjectsRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	projects, err := h.tasks.GetProjects(c, userID.(uuid.UUID), req.Archived)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make(GetProjectsResponse, len(projects))
+	for i := range projects {
+		res[i] = projectResponse(&projects[i])
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /api/v1/projects/:projectID
+func (h *Handler) GetProject(c *gin.Context) {
+	project := c.MustGet("project").(*repository.Project)
+
+	c.JSON(http.StatusOK, projectResponse(project))
+}
+
+// POST /api/v1/projects
+func (h *Handler) CreateProject(c *gin.Context) {
+	req := new(CreateProjectRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 50)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	projectID, err := h.tasks.CreateProject(c, repository.CreateProjectParams{UserID: userID.(uuid.UUID), Name: req.Name})
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, CreateProjectResponse{ID: projectID})
+}
+
+// PUT /api/v1/projects/:projectID
+func (h *Handler) UpdateProject(c *gin.Context) {
+	project := c.MustGet("project").(*repository.Project)
+
+	req := new(UpdateProjectRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 50)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	if project.IsInbox {
+		c.JSON(http.StatusConflict, gin.H{"error": "the inbox cannot be changed"})
+		return
+	}
+
+	params := repository.UpdateProjectParams{
+		ID:       project.ID,
+		UserID:   project.UserID,
+		Name:     req.Name,
+		Archived: req.Archived,
+	}
+
+	err = h.tasks.UpdateProject(c, params)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name already in use"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// DELETE /api/v1/projects/:projectID
+// The tasks of the project are moved to the inbox.
+func (h *Handler) DeleteProject(c *gin.Context) {
+	project := c.MustGet("project").(*repository.Project)
+
+	if project.IsInbox {
+		c.JSON(http.StatusConflict, gin.H{"error": "the inbox cannot be deleted"})
+		return
+	}
+
+	err := h.tasks.DeleteProject(c, project.UserID, project.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// GET /api/v1/projects/:projectID/tasks
+// It takes the query of GET /api/v1/tasks.
+func (h *Handler) GetProjectTasks(c *gin.Context) {
+	project := c.MustGet("project").(*repository.Project)
+
+	req := new(GetTasksRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ProjectID = project.ID
+
+	h.listTasks(c, req)
+}
+
+// POST /api/v1/projects/:projectID/tasks/move
+// It moves all of the tasks or, when one of them is not found, none.
+func (h *Handler) MoveTasks(c *gin.Context) {
+	project := c.MustGet("project").(*repository.Project)
+
+	req := new(MoveTasksRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.TaskIDs, vd.Required, vd.Length(1, maxMovedTasks)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	// the same task may be listed twice
+	taskIDs := []uuid.UUID{}
+	for _, taskID := range req.TaskIDs {
+		if !slices.Contains(taskIDs, taskID) {
+			taskIDs = append(taskIDs, taskID)
+		}
+	}
+
+	params := repository.MoveTasksParams{
+		UserID:    project.UserID,
+		ProjectID: project.ID,
+		TaskIDs:   taskIDs,
+	}
+
+	err = h.tasks.MoveTasks(c, params)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrProjectArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is archived"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func projectResponse(project *repository.Project) GetProjectResponse {
+	res := GetProjectResponse{
+		ID:        project.ID,
+		Name:      project.Name,
+		IsInbox:   project.IsInbox,
+		CreatedAt: project.CreatedAt,
+	}
+	if project.ArchivedAt.Valid {
+		res.ArchivedAt = &project.ArchivedAt.Time
+	}
+
+	return res
+}
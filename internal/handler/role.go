@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	RoleUser = "user"
+	// RoleSupport may look users up through the admin API but not change them
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// roles is ordered from least to most privileged; each role holds the ones before it
+var roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+// hasRole reports whether the signed-in user's role is role or above it
+func hasRole(c *gin.Context, role string) bool {
+	current, ok := c.Get("role")
+	if !ok {
+		return false
+	}
+
+	return slices.Index(roles, current.(string)) >= slices.Index(roles, role)
+}
+
+// RequireRole aborts with 403 unless the signed-in user holds role.
+// It must be used after AuthMiddleware.
+func (h *Handler) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasRole(c, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s role is required", role)})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+	ScopeUserRead   = "user:read"
+	// ScopeAccount covers credentials and account settings. Only signed-in sessions hold it.
+	ScopeAccount = "account"
+)
+
+var (
+	// grantableScopes may be given to a personal access token
+	grantableScopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeUserRead}
+	sessionScopes   = []string{ScopeTasksRead, ScopeTasksWrite, ScopeUserRead, ScopeAccount}
+)
+
+// hasScope reports whether the credential AuthMiddleware accepted grants scope
+func hasScope(c *gin.Context, scope string) bool {
+	scopes, ok := c.Get("scopes")
+	if !ok {
+		return false
+	}
+
+	return slices.Contains(scopes.([]string), scope)
+}
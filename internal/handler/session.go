@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type (
+	GetSessionsResponse []GetSessionResponse
+	GetSessionResponse  struct {
+		ID         uuid.UUID `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		IP         string    `json:"ip"`
+		Current    bool      `json:"current"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+
+	RevokeSessionsResponse struct {
+		Revoked int64 `json:"revoked"`
+	}
+)
+
+// touchInterval throttles last-seen updates so that not every request writes to the sessions table
+const touchInterval = time.Minute
+
+// GET /api/v1/users/me/sessions
+func (h *Handler) GetSessions(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	sessions, err := h.accounts.GetActiveSessions(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentID, _ := c.Get("session_id")
+
+	res := make(GetSessionsResponse, len(sessions))
+	for i, session := range sessions {
+		res[i] = GetSessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.ID == currentID,
+			LastSeenAt: session.LastSeenAt,
+			CreatedAt:  session.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DELETE /api/v1/users/me/sessions/:sessionID
+func (h *Handler) RevokeSession(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	err = h.accounts.RevokeSession(c, userID.(uuid.UUID), sessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// DELETE /api/v1/users/me/sessions
+// signs out every session except the current one
+func (h *Handler) RevokeOtherSessions(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	sessionID, ok := c.Get("session_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	n, err := h.accounts.RevokeOtherSessions(c, userID.(uuid.UUID), sessionID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := RevokeSessionsResponse{
+		Revoked: n,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
@@ -1,64 +1,309 @@
 package handler
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/rrule"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/search"
 	"github.com/Irori235/system-design-2023-v2/internal/repository"
 	"github.com/gin-gonic/gin"
 	vd "github.com/go-ozzo/ozzo-validation"
 	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
 )
 
 type (
-	GetTasksResponse []GetTaskResponse
-	GetTaskResponse  struct {
-		ID        uuid.UUID `json:"id"`
-		UserID    uuid.UUID `json:"user_id"`
-		Title     string    `json:"title"`
-		IsDone    bool      `json:"is_done"`
-		CreatedAt string    `json:"created_at"`
+	GetTasksRequest struct {
+		IsDone        *bool     `form:"is_done"`
+		CreatedAfter  time.Time `form:"created_after"`
+		CreatedBefore time.Time `form:"created_before"`
+		DueBefore     time.Time `form:"due_before"`
+		Title         string    `form:"title"`
+		// Sort is one of created, title and due
+		Sort   string `form:"sort"`
+		Order  string `form:"order"`
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+		// Labels are names the tasks must all have, or must not have when after "-" like "-someday"
+		Labels []string `form:"label"`
+		// ProjectID narrows the listing down to a project's tasks, from the path of GET /api/v1/projects/:projectID/tasks
+		ProjectID uuid.UUID `form:"-"`
 	}
 
+	GetTasksResponse struct {
+		Tasks []GetTaskResponse `json:"tasks"`
+		// NextCursor is null on the last page
+		NextCursor *string `json:"next_cursor"`
+	}
+	GetTaskResponse struct {
+		ID        uuid.UUID  `json:"id"`
+		UserID    uuid.UUID  `json:"user_id"`
+		ProjectID uuid.UUID  `json:"project_id"`
+		Title     string     `json:"title"`
+		IsDone    bool       `json:"is_done"`
+		DueAt     *time.Time `json:"due_at"`
+		TimeZone  string     `json:"time_zone"`
+		Reminders []int      `json:"reminders"`
+		// SeriesID groups the occurrences of a recurring task. Editing the following occurrences starts a new series.
+		SeriesID   *uuid.UUID         `json:"series_id"`
+		Recurrence string             `json:"recurrence"`
+		Labels     []GetLabelResponse `json:"labels"`
+		CreatedAt  string             `json:"created_at"`
+	}
+
+	GetTaskOccurrencesRequest struct {
+		Count int `form:"count"`
+	}
+
+	// GetTaskOccurrencesResponse lists the due dates of the next occurrences
+	GetTaskOccurrencesResponse []time.Time
+
 	SearchTasksRequest struct {
+		Query string `form:"q"`
+	}
+
+	SearchTasksResponse []SearchTaskResponse
+	SearchTaskResponse  struct {
+		GetTaskResponse
+		Score     float64 `json:"score"`
+		Highlight string  `json:"highlight"`
 	}
 
 	CreateTaskRequest struct {
-		Title string `json:"title"`
+		// ProjectID is the inbox unless set
+		ProjectID *uuid.UUID `json:"project_id"`
+		Title     string     `json:"title"`
+		DueAt     *time.Time `json:"due_at"`
+		// TimeZone is an IANA name like "Asia/Tokyo" that due_at is shown in
+		TimeZone string `json:"time_zone"`
+		// Reminders are minutes before due_at
+		Reminders []int `json:"reminders"`
+		// Recurrence is an RFC 5545 RRULE like "FREQ=WEEKLY;BYDAY=MO" repeating the task from due_at.
+		// The next occurrence is created when one is done.
+		Recurrence string      `json:"recurrence"`
+		LabelIDs   []uuid.UUID `json:"label_ids"`
 	}
 
 	UpdateTaskRequest struct {
-		Title  string `json:"title"`
-		IsDone bool   `json:"is_done"`
+		// ProjectID moves the task to another project unless null
+		ProjectID *uuid.UUID `json:"project_id"`
+		Title     string     `json:"title"`
+		IsDone    bool       `json:"is_done"`
+		// DueAt, TimeZone and Reminders keep their values unless sent.
+		// A null due_at clears the due date along with its time zone and reminders.
+		DueAt     *time.Time `json:"due_at"`
+		TimeZone  string     `json:"time_zone"`
+		Reminders []int      `json:"reminders"`
+		// Recurrence keeps the task's rule unless sent. An empty one stops the task repeating.
+		Recurrence *string `json:"recurrence"`
+		// LabelIDs replace the labels of the task unless null
+		LabelIDs []uuid.UUID `json:"label_ids"`
+		// Scope is "this" to edit this occurrence of a recurring task only, or "following" to edit the following
+		// occurrences too. A changed recurrence always applies to the following ones.
+		Scope string `json:"scope"`
+	}
+
+	// taskCursor is what an opaque next_cursor holds.
+	// It carries the sort order too, as it cannot continue a listing in another one.
+	taskCursor struct {
+		Sort  repository.TaskSort `json:"s"`
+		Desc  bool                `json:"d"`
+		ID    uuid.UUID           `json:"i"`
+		Title string              `json:"t,omitempty"`
+		At    time.Time           `json:"a"`
 	}
 )
 
+const (
+	defaultTaskPageSize = 50
+	maxTaskPageSize     = 200
+
+	maxReminders = 5
+	// maxReminderMinutes is four weeks
+	maxReminderMinutes = 4 * 7 * 24 * 60
+
+	defaultOccurrenceCount = 10
+	maxOccurrenceCount     = 100
+)
+
 // GET /api/v1/tasks
 func (h *Handler) GetTasks(c *gin.Context) {
+	req := new(GetTasksRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.listTasks(c, req)
+}
+
+// GET /api/v1/tasks/overdue
+func (h *Handler) GetOverdueTasks(c *gin.Context) {
+	req := new(GetTasksRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	notDone := false
+	req.IsDone = &notDone
+	if now := time.Now(); req.DueBefore.IsZero() || req.DueBefore.After(now) {
+		req.DueBefore = now
+	}
+	if req.Sort == "" {
+		req.Sort = string(repository.TaskSortDue)
+	}
+
+	h.listTasks(c, req)
+}
+
+// listTasks writes a page of the user's tasks matching req
+func (h *Handler) listTasks(c *gin.Context, req *GetTasksRequest) {
+	if req.Sort == "" {
+		req.Sort = string(repository.TaskSortCreated)
+	}
+	if req.Order == "" {
+		req.Order = "asc"
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultTaskPageSize
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Title, vd.RuneLength(0, 50)),
+		vd.Field(&req.Sort, vd.In(string(repository.TaskSortCreated), string(repository.TaskSortTitle), string(repository.TaskSortDue))),
+		vd.Field(&req.Order, vd.In("asc", "desc")),
+		vd.Field(&req.Limit, vd.Min(1), vd.Max(maxTaskPageSize)),
+		vd.Field(&req.Labels, vd.Length(0, maxLabelFilters), vd.Each(vd.By(isLabelFilter))),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request query: %w", err).Error()})
+		return
+	}
+
 	userID, ok := c.Get("user_id")
 	if !ok {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	tasks, err := h.repo.GetTasks(c, userID.(uuid.UUID))
+	params := repository.GetTasksParams{
+		UserID:    userID.(uuid.UUID),
+		ProjectID: req.ProjectID,
+		IsDone:    req.IsDone,
+		// the columns only hold seconds
+		CreatedAfter:  req.CreatedAfter.Truncate(time.Second),
+		CreatedBefore: req.CreatedBefore.Truncate(time.Second),
+		DueBefore:     req.DueBefore,
+		TitleContains: req.Title,
+		Sort:          repository.TaskSort(req.Sort),
+		Desc:          req.Order == "desc",
+		// one more than asked for tells whether there is a next page
+		Limit: req.Limit + 1,
+	}
+	for _, label := range req.Labels {
+		if name, ok := strings.CutPrefix(label, "-"); ok {
+			params.ExcludeLabels = append(params.ExcludeLabels, name)
+		} else {
+			params.Labels = append(params.Labels, label)
+		}
+	}
+
+	if req.Cursor != "" {
+		after, err := decodeTaskCursor(req.Cursor, params.Sort, params.Desc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		params.After = after
+	}
+
+	tasks, err := h.tasks.GetTasks(c, params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	res := make(GetTasksResponse, len(tasks))
-	for i, task := range tasks {
-		res[i] = GetTaskResponse{
-			ID:        task.ID,
-			UserID:    task.UserID,
-			Title:     task.Title,
-			IsDone:    task.IsDone,
-			CreatedAt: task.CreatedAt,
+	res := GetTasksResponse{}
+	if len(tasks) > req.Limit {
+		tasks = tasks[:req.Limit]
+
+		next, err := encodeTaskCursor(tasks[len(tasks)-1], params.Sort, params.Desc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
+		res.NextCursor = &next
+	}
+
+	res.Tasks = make([]GetTaskResponse, len(tasks))
+	for i := range tasks {
+		res.Tasks[i] = taskResponse(&tasks[i])
 	}
 
 	c.JSON(http.StatusOK, res)
+}
+
+// GET /api/v1/tasks/:taskID
+func (h *Handler) GetTask(c *gin.Context) {
+	task := c.MustGet("task").(*repository.Task)
+
+	c.JSON(http.StatusOK, taskResponse(task))
+}
+
+// GET /api/v1/tasks/search?q=
+func (h *Handler) SearchTasks(c *gin.Context) {
+	req := new(SearchTasksRequest)
+	if err := c.BindQuery(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Query, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request query: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	query := search.Parse(req.Query)
+	params := repository.SearchTasksParams{
+		UserID: userID.(uuid.UUID),
+		Target: query,
+	}
+
+	tasks, err := h.tasks.SearchTasks(c, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
+	res := make(SearchTasksResponse, len(tasks))
+	for i, task := range tasks {
+		res[i] = SearchTaskResponse{
+			GetTaskResponse: taskResponse(&task.Task),
+			Score:           task.Score,
+			Highlight:       search.Highlight(task.Title, query),
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
 }
 
 // POST /api/v1/tasks
@@ -75,12 +320,45 @@ func (h *Handler) CreateTask(c *gin.Context) {
 		return
 	}
 
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.DueAt, dueAtRules(req.TimeZone, req.Reminders, req.Recurrence)...),
+		vd.Field(&req.TimeZone, vd.By(isTimeZone)),
+		vd.Field(&req.Reminders, vd.Length(0, maxReminders), vd.Each(vd.Min(0), vd.Max(maxReminderMinutes))),
+		vd.Field(&req.Recurrence, vd.By(isRecurrence)),
+		vd.Field(&req.LabelIDs, vd.Length(0, maxTaskLabels)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
 	params := repository.CreateTaskParams{
-		UserID: userID.(uuid.UUID),
-		Title:  req.Title,
+		UserID:      userID.(uuid.UUID),
+		Title:       req.Title,
+		DueAt:       dueAt(req.DueAt),
+		DueTimeZone: req.TimeZone,
+		Reminders:   uniqueReminders(req.Reminders),
+		Recurrence:  normalizeRecurrence(req.Recurrence),
+		LabelIDs:    uniqueLabelIDs(req.LabelIDs),
+	}
+	if req.ProjectID != nil {
+		params.ProjectID = *req.ProjectID
 	}
 
-	err := h.repo.CreateTask(c, params)
+	err = h.tasks.CreateTask(c, params)
+	if errors.Is(err, repository.ErrUnknownProject) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "project not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrProjectArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is archived"})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownLabel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -91,21 +369,47 @@ func (h *Handler) CreateTask(c *gin.Context) {
 
 // PUT /api/v1/tasks/:taskID
 func (h *Handler) UpdateTask(c *gin.Context) {
-	taskID, err := uuid.Parse(c.Param("taskID"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	task := c.MustGet("task").(*repository.Task)
 
-	req := new(UpdateTaskRequest)
+	// the body is decoded over the task's due date, so that fields left out of it keep their values
+	req := &UpdateTaskRequest{
+		TimeZone:  task.DueTimeZone.String,
+		Reminders: task.ReminderList(),
+	}
+	if task.DueAt.Valid {
+		due := task.DueAt.Time
+		req.DueAt = &due
+	}
 	if err := c.Bind(req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err = vd.ValidateStruct(
+	// a null due_at takes the time zone and reminders it came with along, unless others are sent
+	if req.DueAt == nil && task.DueAt.Valid {
+		if req.TimeZone == task.DueTimeZone.String {
+			req.TimeZone = ""
+		}
+		if slices.Equal(req.Reminders, task.ReminderList()) {
+			req.Reminders = nil
+		}
+	}
+
+	// a recurrence left out keeps the task in its series
+	recurrence := task.Recurrence
+	if req.Recurrence != nil {
+		recurrence = normalizeRecurrence(*req.Recurrence)
+	}
+
+	err := vd.ValidateStruct(
 		req,
 		vd.Field(&req.Title, vd.Required),
+		vd.Field(&req.DueAt, dueAtRules(req.TimeZone, req.Reminders, recurrence)...),
+		vd.Field(&req.TimeZone, vd.By(isTimeZone)),
+		vd.Field(&req.Reminders, vd.Length(0, maxReminders), vd.Each(vd.Min(0), vd.Max(maxReminderMinutes))),
+		vd.Field(&req.Recurrence, vd.By(isRecurrence)),
+		vd.Field(&req.LabelIDs, vd.Length(0, maxTaskLabels)),
+		vd.Field(&req.Scope, vd.In("this", "following")),
 	)
 
 	if err != nil {
@@ -114,12 +418,38 @@ func (h *Handler) UpdateTask(c *gin.Context) {
 	}
 
 	params := repository.UpdateTaskParams{
-		ID:     taskID,
-		Title:  req.Title,
-		IsDone: req.IsDone,
+		ID:          task.ID,
+		UserID:      task.UserID,
+		Title:       req.Title,
+		IsDone:      req.IsDone,
+		DueAt:       dueAt(req.DueAt),
+		DueTimeZone: req.TimeZone,
+		Reminders:   uniqueReminders(req.Reminders),
+		Following:   req.Scope == "following" || recurrence != task.Recurrence,
+		Recurrence:  recurrence,
+		LabelIDs:    uniqueLabelIDs(req.LabelIDs),
+	}
+	if req.ProjectID != nil {
+		params.ProjectID = *req.ProjectID
 	}
 
-	err = h.repo.UpdateTask(c, params)
+	err = h.tasks.UpdateTask(c, params)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownProject) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "project not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrProjectArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": "project is archived"})
+		return
+	}
+	if errors.Is(err, repository.ErrUnknownLabel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -128,15 +458,91 @@ func (h *Handler) UpdateTask(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-// DELETE /api/v1/tasks/:taskID
-func (h *Handler) DeleteTask(c *gin.Context) {
-	taskID, err := uuid.Parse(c.Param("taskID"))
+// POST /api/v1/tasks/:taskID/skip
+func (h *Handler) SkipOccurrence(c *gin.Context) {
+	task := c.MustGet("task").(*repository.Task)
+
+	if !task.SeriesID.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task does not repeat"})
+		return
+	}
+	if task.IsDone {
+		c.JSON(http.StatusConflict, gin.H{"error": "task is done already"})
+		return
+	}
+
+	err := h.tasks.SkipOccurrence(c, task.UserID, task.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrSeriesEnded) {
+		c.JSON(http.StatusConflict, gin.H{"error": "no occurrence is left to skip to"})
+		return
+	}
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the next occurrence exists already"})
+		return
+	}
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// GET /api/v1/tasks/:taskID/occurrences?count=
+func (h *Handler) GetTaskOccurrences(c *gin.Context) {
+	task := c.MustGet("task").(*repository.Task)
+
+	req := new(GetTaskOccurrencesRequest)
+	if err := c.ShouldBindQuery(req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Count == 0 {
+		req.Count = defaultOccurrenceCount
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Count, vd.Min(1), vd.Max(maxOccurrenceCount)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request query: %w", err).Error()})
+		return
+	}
+
+	if !task.SeriesID.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task does not repeat"})
+		return
+	}
+
+	series, err := h.tasks.GetTaskSeries(c, task.UserID, task.SeriesID.UUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	occurrences, err := series.Occurrences(task.OccurrenceAt.Time, req.Count)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, GetTaskOccurrencesResponse(occurrences))
+}
+
+// DELETE /api/v1/tasks/:taskID
+func (h *Handler) DeleteTask(c *gin.Context) {
+	task := c.MustGet("task").(*repository.Task)
 
-	err = h.repo.DeleteTask(c, taskID)
+	err := h.tasks.DeleteTask(c, task.UserID, task.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -144,3 +550,135 @@ func (h *Handler) DeleteTask(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func taskResponse(task *repository.Task) GetTaskResponse {
+	res := GetTaskResponse{
+		ID:         task.ID,
+		UserID:     task.UserID,
+		ProjectID:  task.ProjectID,
+		Title:      task.Title,
+		IsDone:     task.IsDone,
+		TimeZone:   task.DueTimeZone.String,
+		Reminders:  task.ReminderList(),
+		Recurrence: task.Recurrence,
+		Labels:     make([]GetLabelResponse, len(task.Labels)),
+		CreatedAt:  task.CreatedAt,
+	}
+	for i := range task.Labels {
+		res.Labels[i] = labelResponse(&task.Labels[i])
+	}
+	if task.SeriesID.Valid {
+		res.SeriesID = &task.SeriesID.UUID
+	}
+
+	if task.DueAt.Valid {
+		dueAt := task.DueAt.Time
+		if loc, err := time.LoadLocation(task.DueTimeZone.String); err == nil {
+			dueAt = dueAt.In(loc)
+		}
+		res.DueAt = &dueAt
+	}
+
+	return res
+}
+
+// dueAt drops what the due_at column cannot hold
+func dueAt(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC().Truncate(time.Second), Valid: true}
+}
+
+// dueAtRules require a due date when the fields depending on it are set
+func dueAtRules(timeZone string, reminders []int, recurrence string) []vd.Rule {
+	if timeZone == "" && len(reminders) == 0 && recurrence == "" {
+		return nil
+	}
+
+	return []vd.Rule{vd.NotNil.Error("is required with time_zone, reminders or recurrence")}
+}
+
+func isTimeZone(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+
+	// Local is whatever zone the server happens to run in
+	if _, err := time.LoadLocation(s); err != nil || s == "Local" {
+		return errors.New("must be an IANA time zone name")
+	}
+
+	return nil
+}
+
+func isRecurrence(value interface{}) error {
+	value, _ = vd.Indirect(value)
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+
+	if _, err := rrule.Parse(s); err != nil {
+		return fmt.Errorf("must be an RRULE: %w", err)
+	}
+
+	return nil
+}
+
+// normalizeRecurrence formats a valid RRULE the way it is stored, so that equal rules compare equal
+func normalizeRecurrence(s string) string {
+	rule, err := rrule.Parse(s)
+	if err != nil {
+		return ""
+	}
+
+	return rule.String()
+}
+
+// uniqueReminders sorts reminders and drops duplicates
+func uniqueReminders(reminders []int) []int {
+	reminders = slices.Clone(reminders)
+	slices.Sort(reminders)
+
+	return slices.Compact(reminders)
+}
+
+func encodeTaskCursor(task repository.Task, sort repository.TaskSort, desc bool) (string, error) {
+	position, err := task.Cursor(sort)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(taskCursor{
+		Sort:  sort,
+		Desc:  desc,
+		ID:    position.ID,
+		Title: position.Title,
+		At:    position.At,
+	})
+	if err != nil {
+		return "", fmt.Errorf("encode cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeTaskCursor(s string, sort repository.TaskSort, desc bool) (*repository.TaskCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	cursor := taskCursor{}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.Sort != sort || cursor.Desc != desc {
+		return nil, errors.New("cursor belongs to another sort order")
+	}
+
+	return &repository.TaskCursor{ID: cursor.ID, Title: cursor.Title, At: cursor.At}, nil
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// throttlePolicy slows down and then locks out a source of failed sign-ins
+type throttlePolicy struct {
+	prefix string
+	// backoffAfter failures start an exponential delay between attempts
+	backoffAfter int
+	maxBackoff   time.Duration
+	// lockAfter failures lock attempts out for lockFor
+	lockAfter int
+	lockFor   time.Duration
+	// event is recorded in the audit log when the lockout starts
+	event string
+}
+
+var (
+	accountThrottle = throttlePolicy{
+		prefix:       "account:",
+		backoffAfter: 3,
+		maxBackoff:   5 * time.Minute,
+		lockAfter:    10,
+		lockFor:      30 * time.Minute,
+		event:        "account_locked",
+	}
+
+	// addresses may be shared behind NAT, so they get more room
+	ipThrottle = throttlePolicy{
+		prefix:       "ip:",
+		backoffAfter: 20,
+		maxBackoff:   5 * time.Minute,
+		lockAfter:    100,
+		lockFor:      time.Hour,
+		event:        "ip_locked",
+	}
+)
+
+// throttleWindow is how long failures are remembered
+const throttleWindow = time.Hour
+
+// delay is how long to refuse attempts after the given number of failures
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures >= p.lockAfter {
+		return p.lockFor
+	}
+	if failures < p.backoffAfter {
+		return 0
+	}
+
+	d := time.Second * time.Duration(math.Pow(2, float64(failures-p.backoffAfter)))
+	if d > p.maxBackoff {
+		return p.maxBackoff
+	}
+
+	return d
+}
+
+func accountThrottleKey(name string) string {
+	return accountThrottle.prefix + strings.ToLower(name)
+}
+
+func ipThrottleKey(ip string) string {
+	return ipThrottle.prefix + ip
+}
+
+// signInAttempt is an attempt reserved against the account and the client address before its credentials are checked
+type signInAttempt struct {
+	name string
+	// reserved holds the throttle rows as the reservation left them, by key
+	reserved map[string]*repository.AuthThrottle
+}
+
+// reserveSignInAttempt counts an attempt as failed against the account and the client address up front,
+// so that a burst of parallel attempts cannot get past the throttle before the first failure is recorded.
+// It returns nil when either is locked out. Callers answer that exactly like a failed attempt,
+// so that lockouts tell nothing about the account.
+func (h *Handler) reserveSignInAttempt(c *gin.Context, name string) (*signInAttempt, error) {
+	attempt := &signInAttempt{name: name, reserved: map[string]*repository.AuthThrottle{}}
+	policies := []struct {
+		key    string
+		policy throttlePolicy
+	}{
+		{accountThrottleKey(name), accountThrottle},
+		{ipThrottleKey(c.ClientIP()), ipThrottle},
+	}
+
+	for _, p := range policies {
+		params := repository.ReserveAuthAttemptParams{
+			Key:    p.key,
+			Window: throttleWindow,
+			Delay:  p.policy.delay,
+		}
+
+		throttle, err := h.accounts.ReserveAuthAttempt(c, params)
+		if errors.Is(err, repository.ErrThrottled) {
+			// a refused attempt does not count against the other key either
+			return nil, h.releaseSignInAttempt(c, attempt)
+		}
+		if err != nil {
+			return nil, err
+		}
+		attempt.reserved[p.key] = throttle
+	}
+
+	return attempt, nil
+}
+
+// releaseSignInAttempt takes the attempt back once its credentials turn out to be right
+func (h *Handler) releaseSignInAttempt(c *gin.Context, attempt *signInAttempt) error {
+	for _, reserved := range attempt.reserved {
+		if err := h.accounts.ReleaseAuthAttempt(c, reserved); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordSignInFailure keeps the attempt counted as failed and writes lockouts it caused to the audit log
+func (h *Handler) recordSignInFailure(c *gin.Context, attempt *signInAttempt) error {
+	policies := map[string]throttlePolicy{
+		accountThrottleKey(attempt.name): accountThrottle,
+		ipThrottleKey(c.ClientIP()):      ipThrottle,
+	}
+
+	for key, policy := range policies {
+		throttle := attempt.reserved[key]
+		if throttle == nil || throttle.Failures < policy.lockAfter {
+			continue
+		}
+
+		auditParams := repository.CreateAuditLogParams{
+			Event:  policy.event,
+			IP:     c.ClientIP(),
+			Detail: fmt.Sprintf("name=%q failures=%d locked_until=%s", attempt.name, throttle.Failures, throttle.LockedUntil.Time.UTC().Format(time.RFC3339)),
+		}
+
+		// unknown names are locked out too, without a user to attach the entry to
+		userID, err := h.users.GetUserID(c, attempt.name)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		auditParams.UserID = userID
+
+		if err := h.accounts.CreateAuditLog(c, auditParams); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signInSucceeded takes the attempt back and forgets the earlier failures of the account
+func (h *Handler) signInSucceeded(c *gin.Context, attempt *signInAttempt) error {
+	if err := h.releaseSignInAttempt(c, attempt); err != nil {
+		return err
+	}
+
+	return h.resetSignInFailures(c, attempt.name)
+}
+
+// resetSignInFailures forgets the failures of an account after it signs in.
+// The address keeps its count so one known password cannot clear it.
+func (h *Handler) resetSignInFailures(c *gin.Context, name string) error {
+	return h.accounts.ResetAuthThrottle(c, accountThrottleKey(name))
+}
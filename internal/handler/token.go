@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+)
+
+type (
+	CreateTokenRequest struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresInDays defaults to defaultTokenLifetimeDays
+		ExpiresInDays int `json:"expires_in_days"`
+	}
+
+	GetTokensResponse []GetTokenResponse
+	GetTokenResponse  struct {
+		ID         uuid.UUID  `json:"id"`
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+
+	CreateTokenResponse struct {
+		ID uuid.UUID `json:"id"`
+		// Token is only ever shown in this response
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+)
+
+const (
+	// personalAccessTokenPrefix tells personal access tokens apart from JWTs and makes leaked ones easy to scan for
+	personalAccessTokenPrefix = "pat_"
+	defaultTokenLifetimeDays  = 30
+	maxTokenLifetimeDays      = 365
+)
+
+// GET /api/v1/users/me/tokens
+func (h *Handler) GetTokens(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	tokens, err := h.accounts.GetPersonalAccessTokens(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make(GetTokensResponse, len(tokens))
+	for i, token := range tokens {
+		res[i] = GetTokenResponse{
+			ID:         token.ID,
+			Name:       token.Name,
+			Scopes:     token.ScopeList(),
+			ExpiresAt:  nullTime(token.ExpiresAt.Time, token.ExpiresAt.Valid),
+			LastUsedAt: nullTime(token.LastUsedAt.Time, token.LastUsedAt.Valid),
+			CreatedAt:  token.CreatedAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/users/me/tokens
+func (h *Handler) CreateToken(c *gin.Context) {
+	req := new(CreateTokenRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes := make([]interface{}, len(grantableScopes))
+	for i, scope := range grantableScopes {
+		scopes[i] = scope
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Name, vd.Required, vd.RuneLength(1, 100)),
+		vd.Field(&req.Scopes, vd.Required, vd.Each(vd.In(scopes...))),
+		vd.Field(&req.ExpiresInDays, vd.Min(0), vd.Max(maxTokenLifetimeDays)),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultTokenLifetimeDays
+	}
+	expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour).UTC().Truncate(time.Second)
+
+	token := personalAccessTokenPrefix + newOpaqueToken()
+	params := repository.CreatePersonalAccessTokenParams{
+		UserID:    userID.(uuid.UUID),
+		Name:      req.Name,
+		Token:     token,
+		Scopes:    req.Scopes,
+		ExpiresAt: &expiresAt,
+	}
+
+	tokenID, err := h.accounts.CreatePersonalAccessToken(c, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := CreateTokenResponse{
+		ID:        tokenID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DELETE /api/v1/users/me/tokens/:tokenID
+func (h *Handler) DeleteToken(c *gin.Context) {
+	tokenID, err := uuid.Parse(c.Param("tokenID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	err = h.accounts.DeletePersonalAccessToken(c, userID.(uuid.UUID), tokenID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// nullTime turns a nullable column into an optional JSON field
+func nullTime(t time.Time, valid bool) *time.Time {
+	if !valid {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,489 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/totp"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/gin-gonic/gin"
+	vd "github.com/go-ozzo/ozzo-validation"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+type (
+	TwoFactorCodeRequest struct {
+		// Code is a TOTP code or, where noted, a recovery code
+		Code string `json:"code"`
+	}
+
+	SignInTwoFactorRequest struct {
+		MFAToken    string `json:"mfa_token"`
+		Code        string `json:"code"`
+		ReturnToken bool   `json:"return_token"`
+	}
+
+	GetTwoFactorResponse struct {
+		Enabled                bool `json:"enabled"`
+		RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+	}
+
+	EnrollTwoFactorResponse struct {
+		// Secret is the base32 key for manual entry; ProvisioningURI is what a QR code encodes
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+
+	RecoveryCodesResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	MFAClaims struct {
+		UserID string `json:"user_id"`
+		jwt.StandardClaims
+	}
+)
+
+const (
+	totpIssuer        = "system-design-2023"
+	mfaAudience       = "mfa"
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is 160 bits, so that codes stored as plain SHA-256 hashes cannot be brute-forced offline
+	recoveryCodeBytes = 20
+	// recoveryCodeGroup is how many characters go between dashes
+	recoveryCodeGroup = 8
+)
+
+// GET /api/v1/users/me/2fa
+func (h *Handler) GetTwoFactor(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	res := GetTwoFactorResponse{}
+
+	t, err := h.accounts.GetTOTP(c, userID.(uuid.UUID))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if t != nil && t.ConfirmedAt.Valid {
+		n, err := h.accounts.CountRecoveryCodes(c, userID.(uuid.UUID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res.Enabled = true
+		res.RecoveryCodesRemaining = n
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/users/me/2fa
+// starts enrollment; the secret is not used until it is confirmed
+func (h *Handler) EnrollTwoFactor(c *gin.Context) {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	user, err := h.users.GetUser(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sealed, err := h.secrets.Seal([]byte(secret), user.ID[:])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	params := repository.SetTOTPParams{
+		UserID: user.ID,
+		Secret: sealed,
+	}
+
+	err = h.accounts.SetPendingTOTP(c, params)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := EnrollTwoFactorResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(totpIssuer, user.Name, secret),
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/users/me/2fa/confirm
+// enables two-factor authentication and returns the recovery codes once
+func (h *Handler) ConfirmTwoFactor(c *gin.Context) {
+	req := new(TwoFactorCodeRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Code, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	t, err := h.accounts.GetTOTP(c, userID.(uuid.UUID))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "two-factor enrollment not started"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if t.ConfirmedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	ok, err = h.checkTOTP(c, t, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.accounts.ConfirmTOTP(c, t.UserID, normalizeRecoveryCodes(codes)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// POST /api/v1/users/me/2fa/recovery-codes
+// replaces every recovery code; requires a current TOTP code
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, ok := h.requireSecondFactor(c, false)
+	if !ok {
+		return
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.accounts.ReplaceRecoveryCodes(c, userID, normalizeRecoveryCodes(codes)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// DELETE /api/v1/users/me/2fa
+// requires a current TOTP code or a recovery code
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	userID, ok := h.requireSecondFactor(c, true)
+	if !ok {
+		return
+	}
+
+	if err := h.accounts.DeleteTOTP(c, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// POST /api/v1/auth/signin/2fa
+// second step of signing in for users with two-factor authentication enabled
+func (h *Handler) SignInTwoFactor(c *gin.Context) {
+	req := new(SignInTwoFactorRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.MFAToken, vd.Required),
+		vd.Field(&req.Code, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return
+	}
+
+	claims := &MFAClaims{}
+	token, err := jwt.ParseWithClaims(req.MFAToken, claims, h.verificationKey)
+	if err != nil || !token.Valid || !claims.VerifyAudience(mfaAudience, true) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid mfa token"})
+		return
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid mfa token"})
+		return
+	}
+
+	user, err := h.users.GetUser(c, userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid mfa token"})
+		return
+	}
+
+	// the account may have been disabled, or its password reset forced, after the password was checked
+	if user.DisabledAt.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required"})
+		return
+	}
+
+	// wrong codes count towards the same lockout as wrong passwords
+	attempt, err := h.reserveSignInAttempt(c, user.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if attempt == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	ok, err := h.verifySecondFactor(c, userID, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		if err := h.recordSignInFailure(c, attempt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	if err := h.signInSucceeded(c, attempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.startSession(c, userID, req.ReturnToken)
+}
+
+// requireSecondFactor binds a TwoFactorCodeRequest and checks it for the signed-in user.
+// Wrong codes count towards the sign-in lockout of the account.
+// It writes the error response and returns false when the code is missing or wrong.
+func (h *Handler) requireSecondFactor(c *gin.Context, allowRecoveryCode bool) (uuid.UUID, bool) {
+	req := new(TwoFactorCodeRequest)
+	if err := c.Bind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+
+	err := vd.ValidateStruct(
+		req,
+		vd.Field(&req.Code, vd.Required),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid request body: %w", err).Error()})
+		return uuid.Nil, false
+	}
+
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return uuid.Nil, false
+	}
+
+	user, err := h.users.GetUser(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+
+	// a stolen session must not be able to guess codes any faster than a sign-in can
+	attempt, err := h.reserveSignInAttempt(c, user.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+	if attempt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return uuid.Nil, false
+	}
+
+	ok, err = h.verifySecondFactor(c, user.ID, req.Code, allowRecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+	if !ok {
+		if err := h.recordSignInFailure(c, attempt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return uuid.Nil, false
+		}
+
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return uuid.Nil, false
+	}
+
+	if err := h.signInSucceeded(c, attempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+
+	return user.ID, true
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code if allowed, against enabled two-factor authentication
+func (h *Handler) verifySecondFactor(c *gin.Context, userID uuid.UUID, code string, allowRecoveryCode bool) (bool, error) {
+	t, err := h.accounts.GetTOTP(c, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !t.ConfirmedAt.Valid {
+		return false, nil
+	}
+
+	ok, err := h.checkTOTP(c, t, code)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	if !allowRecoveryCode {
+		return false, nil
+	}
+
+	err = h.accounts.UseRecoveryCode(c, userID, normalizeRecoveryCode(code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkTOTP validates code and marks its time step used so it cannot be replayed
+func (h *Handler) checkTOTP(c *gin.Context, t *repository.UserTOTP, code string) (bool, error) {
+	secret, err := h.secrets.Open(t.Secret, t.UserID[:])
+	if err != nil {
+		return false, fmt.Errorf("decrypt totp secret: %w", err)
+	}
+
+	step, ok := totp.Validate(string(secret), code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err = h.accounts.UseTOTPStep(c, t.UserID, step)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func newRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))
+		groups := []string{}
+		for len(code) > recoveryCodeGroup {
+			groups = append(groups, code[:recoveryCodeGroup])
+			code = code[recoveryCodeGroup:]
+		}
+		codes[i] = strings.Join(append(groups, code), "-")
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type codes without the dash or in upper case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func normalizeRecoveryCodes(codes []string) []string {
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = normalizeRecoveryCode(code)
+	}
+
+	return normalized
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -23,10 +24,16 @@ type (
 	}
 
 	GetMeResponse struct {
-		ID        uuid.UUID `json:"id"`
-		Name      string    `json:"name"`
-		UpdatedAt time.Time `json:"updated_at"`
-		CreatedAt time.Time `json:"created_at"`
+		ID            uuid.UUID `json:"id"`
+		Name          string    `json:"name"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		Role          string    `json:"role"`
+		SessionID     uuid.UUID `json:"session_id"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		CreatedAt     time.Time `json:"created_at"`
+		// ImpersonatedBy is the admin acting as the user, if any
+		ImpersonatedBy *uuid.UUID `json:"impersonated_by,omitempty"`
 	}
 )
 
@@ -38,17 +45,31 @@ func (h *Handler) GetMe(c *gin.Context) {
 		return
 	}
 
-	user, err := h.repo.GetUser(c, userID.(uuid.UUID))
+	user, err := h.users.GetUser(c, userID.(uuid.UUID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// personal access tokens and impersonation have no session of the user's
+	sessionID, ok := c.Get("session_id")
+	if !ok {
+		sessionID = uuid.Nil
+	}
+
 	res := GetMeResponse{
-		ID:        user.ID,
-		Name:      user.Name,
-		UpdatedAt: user.UpdatedAt,
-		CreatedAt: user.CreatedAt,
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email.String,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
+		SessionID:     sessionID.(uuid.UUID),
+		UpdatedAt:     user.UpdatedAt,
+		CreatedAt:     user.CreatedAt,
+	}
+	if actorID, ok := c.Get("actor_id"); ok {
+		id := actorID.(uuid.UUID)
+		res.ImpersonatedBy = &id
 	}
 
 	c.JSON(http.StatusOK, res)
@@ -83,7 +104,11 @@ func (h *Handler) UpdateName(c *gin.Context) {
 		Name: req.Name,
 	}
 
-	err = h.repo.UpdateName(c, params)
+	err = h.users.UpdateName(c, params)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "name already in use"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -116,12 +141,22 @@ func (h *Handler) UpdatePass(c *gin.Context) {
 		return
 	}
 
+	user, err := h.users.GetUser(c, userID.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.checkPasswordPolicy(c, req.Password, user.Name) {
+		return
+	}
+
 	params := repository.UpdatePassParams{
 		ID:       userID.(uuid.UUID),
 		Password: req.Password,
 	}
 
-	err = h.repo.UpdatePass(c, params)
+	err = h.users.UpdatePass(c, params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -138,7 +173,7 @@ func (h *Handler) Quit(c *gin.Context) {
 		return
 	}
 
-	err := h.repo.DeleteUser(c, userID.(uuid.UUID))
+	err := h.users.DeleteUser(c, userID.(uuid.UUID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
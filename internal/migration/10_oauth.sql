@@ -0,0 +1,50 @@
+-- +goose Up
+CREATE TABLE `oauth_clients` (
+    `id`            varchar(36) NOT NULL,
+    `user_id`       varchar(36) NOT NULL,
+    `name`          varchar(100) NOT NULL,
+    `secret_hash`   binary(32) NULL DEFAULT NULL,
+    `redirect_uris` text NOT NULL,
+    `scopes`        varchar(255) NOT NULL,
+    `created_at`    datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    INDEX `idx_oauth_clients_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `oauth_grants` (
+    `id`                 varchar(36) NOT NULL,
+    `client_id`          varchar(36) NOT NULL,
+    `user_id`            varchar(36) NOT NULL,
+    `scopes`             varchar(255) NOT NULL,
+    `refresh_token_hash` binary(32) NOT NULL,
+    `expires_at`         datetime NOT NULL,
+    `revoked_at`         datetime NULL DEFAULT NULL,
+    `created_at`         datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_oauth_grants_refresh_token_hash` (`refresh_token_hash`),
+    INDEX `idx_oauth_grants_user_id` (`user_id`),
+    FOREIGN KEY (`client_id`) REFERENCES `oauth_clients`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `oauth_codes` (
+    `code_hash`      binary(32) NOT NULL,
+    `client_id`      varchar(36) NOT NULL,
+    `user_id`        varchar(36) NOT NULL,
+    `redirect_uri`   varchar(2000) NOT NULL,
+    `scopes`         varchar(255) NOT NULL,
+    `code_challenge` varchar(128) NOT NULL,
+    `expires_at`     datetime NOT NULL,
+    `used_at`        datetime NULL DEFAULT NULL,
+    `grant_id`       varchar(36) NULL DEFAULT NULL,
+    `created_at`     datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`code_hash`),
+    FOREIGN KEY (`client_id`) REFERENCES `oauth_clients`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `oauth_codes`;
+DROP TABLE IF EXISTS `oauth_grants`;
+DROP TABLE IF EXISTS `oauth_clients`;
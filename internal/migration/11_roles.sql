@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE `users`
+    ADD COLUMN `role`                    varchar(16) NOT NULL DEFAULT 'user' AFTER `email_verified_at`,
+    ADD COLUMN `disabled_at`             datetime NULL DEFAULT NULL AFTER `role`,
+    ADD COLUMN `password_reset_required` boolean NOT NULL DEFAULT b'0' AFTER `disabled_at`;
+
+-- +goose Down
+ALTER TABLE `users`
+    DROP COLUMN `password_reset_required`,
+    DROP COLUMN `disabled_at`,
+    DROP COLUMN `role`;
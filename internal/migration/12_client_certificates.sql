@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE `client_certificates` (
+    `id`           varchar(36) NOT NULL,
+    `user_id`      varchar(36) NOT NULL,
+    `subject`      varchar(255) NOT NULL,
+    `scopes`       varchar(255) NOT NULL,
+    `last_used_at` datetime NULL DEFAULT NULL,
+    `created_at`   datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_client_certificates_subject` (`subject`),
+    INDEX `idx_client_certificates_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `client_certificates`;
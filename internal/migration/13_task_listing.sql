@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE `tasks`
+    ADD COLUMN `due_at` datetime NULL DEFAULT NULL AFTER `is_done`,
+    ADD INDEX `idx_tasks_user_id_created_at` (`user_id`, `created_at`, `id`),
+    ADD INDEX `idx_tasks_user_id_title` (`user_id`, `title`, `id`),
+    ADD INDEX `idx_tasks_user_id_due_at` (`user_id`, `due_at`, `id`);
+
+-- +goose Down
+ALTER TABLE `tasks`
+    DROP INDEX `idx_tasks_user_id_due_at`,
+    DROP INDEX `idx_tasks_user_id_title`,
+    DROP INDEX `idx_tasks_user_id_created_at`,
+    DROP COLUMN `due_at`;
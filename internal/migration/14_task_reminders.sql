@@ -0,0 +1,27 @@
+-- +goose Up
+ALTER TABLE `tasks`
+    ADD COLUMN `due_time_zone` varchar(64) NULL DEFAULT NULL AFTER `due_at`,
+    ADD COLUMN `reminders`     varchar(255) NOT NULL DEFAULT '' AFTER `due_time_zone`;
+
+CREATE TABLE `task_reminders` (
+    `id`             varchar(36) NOT NULL,
+    `task_id`        varchar(36) NOT NULL,
+    `minutes_before` int NOT NULL,
+    `remind_at`      datetime NOT NULL,
+    `claimed_by`     varchar(36) NULL DEFAULT NULL,
+    `claimed_until`  datetime NULL DEFAULT NULL,
+    `sent_at`        datetime NULL DEFAULT NULL,
+    `created_at`     datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_task_reminders_task_id_minutes_before` (`task_id`, `minutes_before`),
+    INDEX `idx_task_reminders_sent_at_remind_at` (`sent_at`, `remind_at`),
+    INDEX `idx_task_reminders_claimed_by` (`claimed_by`),
+    FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `task_reminders`;
+
+ALTER TABLE `tasks`
+    DROP COLUMN `reminders`,
+    DROP COLUMN `due_time_zone`;
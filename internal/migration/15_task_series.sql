@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE `task_series` (
+    `id`            varchar(36) NOT NULL,
+    `user_id`       varchar(36) NOT NULL,
+    `recurrence`    varchar(255) NOT NULL,
+    `starts_at`     datetime NOT NULL,
+    `title`         varchar(50) NOT NULL,
+    `due_time_zone` varchar(64) NULL DEFAULT NULL,
+    `reminders`     varchar(255) NOT NULL DEFAULT '',
+    `created_at`    datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    INDEX `idx_task_series_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE `tasks`
+    ADD COLUMN `series_id`     varchar(36) NULL DEFAULT NULL AFTER `reminders`,
+    ADD COLUMN `recurrence`    varchar(255) NOT NULL DEFAULT '' AFTER `series_id`,
+    ADD COLUMN `occurrence_at` datetime NULL DEFAULT NULL AFTER `recurrence`,
+    ADD UNIQUE INDEX `idx_tasks_series_id_occurrence_at` (`series_id`, `occurrence_at`),
+    ADD CONSTRAINT `fk_tasks_series_id` FOREIGN KEY (`series_id`) REFERENCES `task_series`(`id`) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE `tasks`
+    DROP FOREIGN KEY `fk_tasks_series_id`,
+    DROP INDEX `idx_tasks_series_id_occurrence_at`,
+    DROP COLUMN `occurrence_at`,
+    DROP COLUMN `recurrence`,
+    DROP COLUMN `series_id`;
+
+DROP TABLE IF EXISTS `task_series`;
@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE `projects` (
+    `id`          varchar(36) NOT NULL,
+    `user_id`     varchar(36) NOT NULL,
+    `name`        varchar(50) NOT NULL,
+    `is_inbox`    boolean NOT NULL DEFAULT FALSE,
+    `archived_at` datetime NULL DEFAULT NULL,
+    `created_at`  datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_projects_user_id_name` (`user_id`, `name`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- every user has an Inbox, which their tasks so far go to
+INSERT INTO `projects` (`id`, `user_id`, `name`, `is_inbox`)
+    SELECT UUID(), `id`, 'Inbox', TRUE FROM `users`;
+
+ALTER TABLE `tasks` ADD COLUMN `project_id` varchar(36) NULL DEFAULT NULL AFTER `user_id`;
+
+UPDATE `tasks` JOIN `projects` ON `projects`.`user_id` = `tasks`.`user_id` AND `projects`.`is_inbox`
+    SET `tasks`.`project_id` = `projects`.`id`;
+
+ALTER TABLE `tasks`
+    MODIFY COLUMN `project_id` varchar(36) NOT NULL,
+    ADD INDEX `idx_tasks_project_id` (`project_id`),
+    ADD CONSTRAINT `fk_tasks_project_id` FOREIGN KEY (`project_id`) REFERENCES `projects`(`id`);
+
+-- +goose Down
+ALTER TABLE `tasks`
+    DROP FOREIGN KEY `fk_tasks_project_id`,
+    DROP INDEX `idx_tasks_project_id`,
+    DROP COLUMN `project_id`;
+
+DROP TABLE IF EXISTS `projects`;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE `labels` (
+    `id`         varchar(36) NOT NULL,
+    `user_id`    varchar(36) NOT NULL,
+    `name`       varchar(50) NOT NULL,
+    `color`      char(7) NOT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_labels_user_id_name` (`user_id`, `name`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `task_labels` (
+    `task_id`  varchar(36) NOT NULL,
+    `label_id` varchar(36) NOT NULL,
+    PRIMARY KEY (`task_id`, `label_id`),
+    INDEX `idx_task_labels_label_id` (`label_id`),
+    FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`label_id`) REFERENCES `labels`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `task_labels`;
+DROP TABLE IF EXISTS `labels`;
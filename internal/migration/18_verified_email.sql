@@ -0,0 +1,12 @@
+-- +goose Up
+-- an address is only claimed once verified, so an unverified one cannot lock its owner out
+ALTER TABLE `users`
+    DROP INDEX `idx_users_email`,
+    ADD INDEX `idx_users_email` (`email`),
+    ADD UNIQUE INDEX `idx_users_verified_email` ((IF(`email_verified_at` IS NULL, NULL, `email`)));
+
+-- +goose Down
+ALTER TABLE `users`
+    DROP INDEX `idx_users_verified_email`,
+    DROP INDEX `idx_users_email`,
+    ADD UNIQUE INDEX `idx_users_email` (`email`);
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE `tasks` ADD FULLTEXT INDEX `ft_tasks_title` (`title`) WITH PARSER ngram;
+
+-- +goose Down
+ALTER TABLE `tasks` DROP INDEX `ft_tasks_title`;
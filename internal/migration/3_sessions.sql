@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE `sessions` (
+    `id`           varchar(36) NOT NULL,
+    `user_id`      varchar(36) NOT NULL,
+    `user_agent`   varchar(255) NOT NULL DEFAULT '',
+    `ip`           varchar(45) NOT NULL DEFAULT '',
+    `expires_at`   datetime NOT NULL,
+    `revoked_at`   datetime NULL DEFAULT NULL,
+    `last_seen_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `created_at`   datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    INDEX `idx_sessions_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- every refresh token ever issued for a session, so that a replayed one can be detected
+CREATE TABLE `refresh_tokens` (
+    `token_hash` binary(32) NOT NULL,
+    `session_id` varchar(36) NOT NULL,
+    `used_at`    datetime NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`token_hash`),
+    FOREIGN KEY (`session_id`) REFERENCES `sessions`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `refresh_tokens`;
+DROP TABLE IF EXISTS `sessions`;
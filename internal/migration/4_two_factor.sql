@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE `user_totp` (
+    `user_id`          varchar(36) NOT NULL,
+    `secret`           varbinary(255) NOT NULL,
+    `last_used_step`   bigint NOT NULL DEFAULT 0,
+    `confirmed_at`     datetime NULL DEFAULT NULL,
+    `created_at`       datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_recovery_codes` (
+    `code_hash`  binary(32) NOT NULL,
+    `user_id`    varchar(36) NOT NULL,
+    `used_at`    datetime NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`code_hash`),
+    INDEX `idx_user_recovery_codes_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `user_recovery_codes`;
+DROP TABLE IF EXISTS `user_totp`;
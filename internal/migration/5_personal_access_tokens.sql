@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE `personal_access_tokens` (
+    `id`           varchar(36) NOT NULL,
+    `user_id`      varchar(36) NOT NULL,
+    `name`         varchar(100) NOT NULL,
+    `token_hash`   binary(32) NOT NULL,
+    `scopes`       varchar(255) NOT NULL,
+    `expires_at`   datetime NULL DEFAULT NULL,
+    `last_used_at` datetime NULL DEFAULT NULL,
+    `created_at`   datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_personal_access_tokens_token_hash` (`token_hash`),
+    INDEX `idx_personal_access_tokens_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `personal_access_tokens`;
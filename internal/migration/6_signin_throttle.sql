@@ -0,0 +1,27 @@
+-- +goose Up
+-- failed sign-in attempts per account name and per client address
+CREATE TABLE `auth_throttles` (
+    `throttle_key`    varchar(191) NOT NULL,
+    `failures`        int NOT NULL DEFAULT 0,
+    `locked_until`    datetime NULL DEFAULT NULL,
+    `last_failure_at` datetime NOT NULL,
+    PRIMARY KEY (`throttle_key`)
+) DEFAULT CHARSET=utf8mb4;
+
+-- user_id and actor_id have no foreign keys so that entries outlive the users they mention
+CREATE TABLE `audit_logs` (
+    `id`         bigint NOT NULL AUTO_INCREMENT,
+    `event`      varchar(50) NOT NULL,
+    `user_id`    varchar(36) NULL DEFAULT NULL,
+    `actor_id`   varchar(36) NULL DEFAULT NULL,
+    `ip`         varchar(45) NOT NULL DEFAULT '',
+    `detail`     text NOT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    INDEX `idx_audit_logs_user_id` (`user_id`),
+    INDEX `idx_audit_logs_event` (`event`, `created_at`)
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `audit_logs`;
+DROP TABLE IF EXISTS `auth_throttles`;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- binary(64) cannot hold argon2id hashes, and zero-pads the 60 byte bcrypt hashes stored in it
+ALTER TABLE `users` MODIFY `password` varbinary(255) NOT NULL;
+UPDATE `users` SET `password` = TRIM(TRAILING 0x00 FROM `password`);
+
+-- +goose Down
+-- fails rather than truncates while argon2id hashes remain
+ALTER TABLE `users` MODIFY `password` binary(64) NOT NULL;
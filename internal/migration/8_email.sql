@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE `users`
+    ADD COLUMN `email`             varchar(254) NULL DEFAULT NULL AFTER `password`,
+    ADD COLUMN `email_verified_at` datetime NULL DEFAULT NULL AFTER `email`,
+    ADD UNIQUE INDEX `idx_users_email` (`email`);
+
+CREATE TABLE `user_tokens` (
+    `token_hash` binary(32) NOT NULL,
+    `user_id`    varchar(36) NOT NULL,
+    `purpose`    varchar(32) NOT NULL,
+    `email`      varchar(254) NOT NULL,
+    `expires_at` datetime NOT NULL,
+    `used_at`    datetime NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`token_hash`),
+    INDEX `idx_user_tokens_user_id_purpose` (`user_id`, `purpose`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `user_tokens`;
+
+ALTER TABLE `users`
+    DROP INDEX `idx_users_email`,
+    DROP COLUMN `email_verified_at`,
+    DROP COLUMN `email`;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE `user_identities` (
+    `provider`   varchar(50) NOT NULL,
+    `subject`    varchar(255) NOT NULL,
+    `user_id`    varchar(36) NOT NULL,
+    `email`      varchar(254) NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`provider`, `subject`),
+    INDEX `idx_user_identities_user_id` (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+DROP TABLE IF EXISTS `user_identities`;
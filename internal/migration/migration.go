@@ -11,6 +11,9 @@ import (
 //go:embed *.sql
 var embedMigrations embed.FS
 
+//go:embed sqlite/*.sql
+var embedSQLiteMigrations embed.FS
+
 func MigrateTables(db *sql.DB) error {
 	goose.SetBaseFS(embedMigrations)
 
@@ -24,3 +27,18 @@ func MigrateTables(db *sql.DB) error {
 
 	return nil
 }
+
+// MigrateSQLiteTables creates the tables of the SQLite store
+func MigrateSQLiteTables(db *sql.DB) error {
+	goose.SetBaseFS(embedSQLiteMigrations)
+
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return fmt.Errorf("set dialect: %w", err)
+	}
+
+	if err := goose.Up(db, "sqlite"); err != nil {
+		return fmt.Errorf("up migration: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE `users` (
+    `id`                      text NOT NULL,
+    `name`                    text NOT NULL COLLATE NOCASE UNIQUE,
+    `password`                blob NOT NULL,
+    `email`                   text NULL DEFAULT NULL COLLATE NOCASE UNIQUE,
+    `email_verified_at`       datetime NULL DEFAULT NULL,
+    `role`                    text NOT NULL DEFAULT 'user',
+    `disabled_at`             datetime NULL DEFAULT NULL,
+    `password_reset_required` boolean NOT NULL DEFAULT 0,
+    `updated_at`              datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `created_at`              datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`)
+);
+
+CREATE TABLE `tasks` (
+    `id`         text NOT NULL,
+    `user_id`    text NOT NULL,
+    `title`      text NOT NULL,
+    `is_done`    boolean NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_tasks_user_id` ON `tasks` (`user_id`);
+
+-- +goose Down
+DROP TABLE IF EXISTS `tasks`;
+DROP TABLE IF EXISTS `users`;
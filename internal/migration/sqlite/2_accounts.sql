@@ -0,0 +1,184 @@
+-- +goose Up
+CREATE TABLE `sessions` (
+    `id`           text NOT NULL,
+    `user_id`      text NOT NULL,
+    `user_agent`   text NOT NULL DEFAULT '',
+    `ip`           text NOT NULL DEFAULT '',
+    `expires_at`   datetime NOT NULL,
+    `revoked_at`   datetime NULL DEFAULT NULL,
+    `last_seen_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `created_at`   datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_sessions_user_id` ON `sessions` (`user_id`);
+
+-- every refresh token ever issued for a session, so that a replayed one can be detected
+CREATE TABLE `refresh_tokens` (
+    `token_hash` blob NOT NULL,
+    `session_id` text NOT NULL,
+    `used_at`    datetime NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`token_hash`),
+    FOREIGN KEY (`session_id`) REFERENCES `sessions`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `user_totp` (
+    `user_id`        text NOT NULL,
+    `secret`         blob NOT NULL,
+    `last_used_step` integer NOT NULL DEFAULT 0,
+    `confirmed_at`   datetime NULL DEFAULT NULL,
+    `created_at`     datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `user_recovery_codes` (
+    `code_hash`  blob NOT NULL,
+    `user_id`    text NOT NULL,
+    `used_at`    datetime NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`code_hash`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_user_recovery_codes_user_id` ON `user_recovery_codes` (`user_id`);
+
+CREATE TABLE `personal_access_tokens` (
+    `id`           text NOT NULL,
+    `user_id`      text NOT NULL,
+    `name`         text NOT NULL,
+    `token_hash`   blob NOT NULL UNIQUE,
+    `scopes`       text NOT NULL,
+    `expires_at`   datetime NULL DEFAULT NULL,
+    `last_used_at` datetime NULL DEFAULT NULL,
+    `created_at`   datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_personal_access_tokens_user_id` ON `personal_access_tokens` (`user_id`);
+
+CREATE TABLE `user_tokens` (
+    `token_hash` blob NOT NULL,
+    `user_id`    text NOT NULL,
+    `purpose`    text NOT NULL,
+    `email`      text NOT NULL,
+    `expires_at` datetime NOT NULL,
+    `used_at`    datetime NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`token_hash`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_user_tokens_user_id_purpose` ON `user_tokens` (`user_id`, `purpose`);
+
+CREATE TABLE `user_identities` (
+    `provider`   text NOT NULL,
+    `subject`    text NOT NULL,
+    `user_id`    text NOT NULL,
+    `email`      text NULL DEFAULT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`provider`, `subject`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_user_identities_user_id` ON `user_identities` (`user_id`);
+
+CREATE TABLE `oauth_clients` (
+    `id`            text NOT NULL,
+    `user_id`       text NOT NULL,
+    `name`          text NOT NULL,
+    `secret_hash`   blob NULL DEFAULT NULL,
+    `redirect_uris` text NOT NULL,
+    `scopes`        text NOT NULL,
+    `created_at`    datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_oauth_clients_user_id` ON `oauth_clients` (`user_id`);
+
+CREATE TABLE `oauth_grants` (
+    `id`                 text NOT NULL,
+    `client_id`          text NOT NULL,
+    `user_id`            text NOT NULL,
+    `scopes`             text NOT NULL,
+    `refresh_token_hash` blob NOT NULL UNIQUE,
+    `expires_at`         datetime NOT NULL,
+    `revoked_at`         datetime NULL DEFAULT NULL,
+    `created_at`         datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`client_id`) REFERENCES `oauth_clients`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_oauth_grants_user_id` ON `oauth_grants` (`user_id`);
+
+CREATE TABLE `oauth_codes` (
+    `code_hash`      blob NOT NULL,
+    `client_id`      text NOT NULL,
+    `user_id`        text NOT NULL,
+    `redirect_uri`   text NOT NULL,
+    `scopes`         text NOT NULL,
+    `code_challenge` text NOT NULL,
+    `expires_at`     datetime NOT NULL,
+    `used_at`        datetime NULL DEFAULT NULL,
+    `grant_id`       text NULL DEFAULT NULL,
+    `created_at`     datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`code_hash`),
+    FOREIGN KEY (`client_id`) REFERENCES `oauth_clients`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `client_certificates` (
+    `id`           text NOT NULL,
+    `user_id`      text NOT NULL,
+    `subject`      text NOT NULL UNIQUE,
+    `scopes`       text NOT NULL,
+    `last_used_at` datetime NULL DEFAULT NULL,
+    `created_at`   datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_client_certificates_user_id` ON `client_certificates` (`user_id`);
+
+-- failed sign-in attempts per account name and per client address
+CREATE TABLE `auth_throttles` (
+    `throttle_key`    text NOT NULL,
+    `failures`        integer NOT NULL DEFAULT 0,
+    `locked_until`    datetime NULL DEFAULT NULL,
+    `last_failure_at` datetime NOT NULL,
+    PRIMARY KEY (`throttle_key`)
+);
+
+-- user_id and actor_id have no foreign keys so that entries outlive the users they mention
+CREATE TABLE `audit_logs` (
+    `id`         integer PRIMARY KEY AUTOINCREMENT,
+    `event`      text NOT NULL,
+    `user_id`    text NULL DEFAULT NULL,
+    `actor_id`   text NULL DEFAULT NULL,
+    `ip`         text NOT NULL DEFAULT '',
+    `detail`     text NOT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX `idx_audit_logs_user_id` ON `audit_logs` (`user_id`);
+CREATE INDEX `idx_audit_logs_event` ON `audit_logs` (`event`, `created_at`);
+
+-- +goose Down
+DROP TABLE IF EXISTS `audit_logs`;
+DROP TABLE IF EXISTS `auth_throttles`;
+DROP TABLE IF EXISTS `client_certificates`;
+DROP TABLE IF EXISTS `oauth_codes`;
+DROP TABLE IF EXISTS `oauth_grants`;
+DROP TABLE IF EXISTS `oauth_clients`;
+DROP TABLE IF EXISTS `user_identities`;
+DROP TABLE IF EXISTS `user_tokens`;
+DROP TABLE IF EXISTS `personal_access_tokens`;
+DROP TABLE IF EXISTS `user_recovery_codes`;
+DROP TABLE IF EXISTS `user_totp`;
+DROP TABLE IF EXISTS `refresh_tokens`;
+DROP TABLE IF EXISTS `sessions`;
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE `tasks` ADD COLUMN `due_at` datetime NULL DEFAULT NULL;
+
+CREATE INDEX `idx_tasks_user_id_created_at` ON `tasks` (`user_id`, `created_at`, `id`);
+CREATE INDEX `idx_tasks_user_id_title` ON `tasks` (`user_id`, `title`, `id`);
+CREATE INDEX `idx_tasks_user_id_due_at` ON `tasks` (`user_id`, `due_at`, `id`);
+
+-- +goose Down
+DROP INDEX IF EXISTS `idx_tasks_user_id_due_at`;
+DROP INDEX IF EXISTS `idx_tasks_user_id_title`;
+DROP INDEX IF EXISTS `idx_tasks_user_id_created_at`;
+
+ALTER TABLE `tasks` DROP COLUMN `due_at`;
@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE `tasks` ADD COLUMN `due_time_zone` text NULL DEFAULT NULL;
+ALTER TABLE `tasks` ADD COLUMN `reminders` text NOT NULL DEFAULT '';
+
+CREATE TABLE `task_reminders` (
+    `id`             text NOT NULL,
+    `task_id`        text NOT NULL,
+    `minutes_before` integer NOT NULL,
+    `remind_at`      datetime NOT NULL,
+    `claimed_by`     text NULL DEFAULT NULL,
+    `claimed_until`  datetime NULL DEFAULT NULL,
+    `sent_at`        datetime NULL DEFAULT NULL,
+    `created_at`     datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE (`task_id`, `minutes_before`),
+    FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_task_reminders_sent_at_remind_at` ON `task_reminders` (`sent_at`, `remind_at`);
+CREATE INDEX `idx_task_reminders_claimed_by` ON `task_reminders` (`claimed_by`);
+
+-- +goose Down
+DROP TABLE IF EXISTS `task_reminders`;
+
+ALTER TABLE `tasks` DROP COLUMN `reminders`;
+ALTER TABLE `tasks` DROP COLUMN `due_time_zone`;
@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE `task_series` (
+    `id`            text NOT NULL,
+    `user_id`       text NOT NULL,
+    `recurrence`    text NOT NULL,
+    `starts_at`     datetime NOT NULL,
+    `title`         text NOT NULL,
+    `due_time_zone` text NULL DEFAULT NULL,
+    `reminders`     text NOT NULL DEFAULT '',
+    `created_at`    datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_task_series_user_id` ON `task_series` (`user_id`);
+
+ALTER TABLE `tasks` ADD COLUMN `series_id` text NULL DEFAULT NULL REFERENCES `task_series`(`id`) ON DELETE SET NULL;
+ALTER TABLE `tasks` ADD COLUMN `recurrence` text NOT NULL DEFAULT '';
+ALTER TABLE `tasks` ADD COLUMN `occurrence_at` datetime NULL DEFAULT NULL;
+
+CREATE UNIQUE INDEX `idx_tasks_series_id_occurrence_at` ON `tasks` (`series_id`, `occurrence_at`);
+
+-- +goose Down
+DROP INDEX IF EXISTS `idx_tasks_series_id_occurrence_at`;
+
+ALTER TABLE `tasks` DROP COLUMN `occurrence_at`;
+ALTER TABLE `tasks` DROP COLUMN `recurrence`;
+ALTER TABLE `tasks` DROP COLUMN `series_id`;
+
+DROP TABLE IF EXISTS `task_series`;
@@ -0,0 +1,35 @@
+-- +goose Up
+CREATE TABLE `projects` (
+    `id`          text NOT NULL,
+    `user_id`     text NOT NULL,
+    `name`        text NOT NULL COLLATE NOCASE,
+    `is_inbox`    boolean NOT NULL DEFAULT 0,
+    `archived_at` datetime NULL DEFAULT NULL,
+    `created_at`  datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE (`user_id`, `name`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+-- every user has an Inbox, which their tasks so far go to. SQLite has no UUID(), so one is put together from random bytes.
+INSERT INTO `projects` (`id`, `user_id`, `name`, `is_inbox`)
+    SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-'
+        || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))),
+        `id`, 'Inbox', 1
+    FROM `users`;
+
+-- SQLite cannot make an added column NOT NULL, so the stores see to it that every task has a project
+ALTER TABLE `tasks` ADD COLUMN `project_id` text NULL DEFAULT NULL REFERENCES `projects`(`id`);
+
+UPDATE `tasks` SET `project_id` = (
+    SELECT `id` FROM `projects` WHERE `projects`.`user_id` = `tasks`.`user_id` AND `projects`.`is_inbox`
+);
+
+CREATE INDEX `idx_tasks_project_id` ON `tasks` (`project_id`);
+
+-- +goose Down
+DROP INDEX IF EXISTS `idx_tasks_project_id`;
+
+ALTER TABLE `tasks` DROP COLUMN `project_id`;
+
+DROP TABLE IF EXISTS `projects`;
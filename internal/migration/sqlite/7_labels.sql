@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE `labels` (
+    `id`         text NOT NULL,
+    `user_id`    text NOT NULL,
+    `name`       text NOT NULL COLLATE NOCASE,
+    `color`      text NOT NULL,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE (`user_id`, `name`),
+    FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `task_labels` (
+    `task_id`  text NOT NULL,
+    `label_id` text NOT NULL,
+    PRIMARY KEY (`task_id`, `label_id`),
+    FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`label_id`) REFERENCES `labels`(`id`) ON DELETE CASCADE
+);
+
+CREATE INDEX `idx_task_labels_label_id` ON `task_labels` (`label_id`);
+
+-- +goose Down
+DROP TABLE IF EXISTS `task_labels`;
+DROP TABLE IF EXISTS `labels`;
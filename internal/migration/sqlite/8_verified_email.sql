@@ -0,0 +1,63 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- an address is only claimed once verified, so an unverified one cannot lock its owner out.
+-- SQLite cannot drop the UNIQUE of a column, so the table is rebuilt with the foreign keys of its children off.
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE `users_new` (
+    `id`                      text NOT NULL,
+    `name`                    text NOT NULL COLLATE NOCASE UNIQUE,
+    `password`                blob NOT NULL,
+    `email`                   text NULL DEFAULT NULL COLLATE NOCASE,
+    `email_verified_at`       datetime NULL DEFAULT NULL,
+    `role`                    text NOT NULL DEFAULT 'user',
+    `disabled_at`             datetime NULL DEFAULT NULL,
+    `password_reset_required` boolean NOT NULL DEFAULT 0,
+    `updated_at`              datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `created_at`              datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`)
+);
+
+INSERT INTO `users_new` SELECT `id`, `name`, `password`, `email`, `email_verified_at`, `role`, `disabled_at`, `password_reset_required`, `updated_at`, `created_at` FROM `users`;
+
+DROP TABLE `users`;
+
+ALTER TABLE `users_new` RENAME TO `users`;
+
+CREATE INDEX `idx_users_email` ON `users` (`email`);
+CREATE UNIQUE INDEX `idx_users_verified_email` ON `users` (`email`) WHERE `email_verified_at` IS NOT NULL;
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
+
+-- +goose Down
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE `users_old` (
+    `id`                      text NOT NULL,
+    `name`                    text NOT NULL COLLATE NOCASE UNIQUE,
+    `password`                blob NOT NULL,
+    `email`                   text NULL DEFAULT NULL COLLATE NOCASE UNIQUE,
+    `email_verified_at`       datetime NULL DEFAULT NULL,
+    `role`                    text NOT NULL DEFAULT 'user',
+    `disabled_at`             datetime NULL DEFAULT NULL,
+    `password_reset_required` boolean NOT NULL DEFAULT 0,
+    `updated_at`              datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `created_at`              datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`)
+);
+
+INSERT INTO `users_old` SELECT `id`, `name`, `password`, `email`, `email_verified_at`, `role`, `disabled_at`, `password_reset_required`, `updated_at`, `created_at` FROM `users`;
+
+DROP TABLE `users`;
+
+ALTER TABLE `users_old` RENAME TO `users`;
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
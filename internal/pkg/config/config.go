@@ -1,10 +1,24 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"math"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/keys"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/mail"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/security"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/tlscert"
 	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
 )
 
 func getEnv(key, defaultValue string) string {
@@ -16,6 +30,78 @@ func getEnv(key, defaultValue string) string {
 	return v
 }
 
+func getDurationEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return d, nil
+}
+
+func getIntEnv(key string, defaultValue int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return n, nil
+}
+
+func getFloatEnv(key string, defaultValue float64) (float64, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return f, nil
+}
+
+func getBoolEnv(key string, defaultValue bool) (bool, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("parse %s: %w", key, err)
+	}
+
+	return b, nil
+}
+
+func getListEnv(key string, defaultValue []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
 func AppEnv() string {
 	return getEnv("APP_ENV", "development")
 }
@@ -24,6 +110,124 @@ func AppAddr() string {
 	return getEnv("APP_ADDR", ":8080")
 }
 
+// TLS reads where the certificate for serving HTTPS directly is read from.
+// It is disabled when TLS_CERT_FILE is not set, leaving TLS to the proxy in front.
+// TLS_CLIENT_CA_FILE turns on client certificate authentication.
+func TLS() (tlscert.Config, error) {
+	require, err := getBoolEnv("TLS_REQUIRE_CLIENT_CERT", false)
+	if err != nil {
+		return tlscert.Config{}, err
+	}
+
+	reload, err := getDurationEnv("TLS_RELOAD_INTERVAL", time.Minute)
+	if err != nil {
+		return tlscert.Config{}, err
+	}
+
+	return tlscert.Config{
+		CertFile:          getEnv("TLS_CERT_FILE", ""),
+		KeyFile:           getEnv("TLS_KEY_FILE", ""),
+		ClientCAFile:      getEnv("TLS_CLIENT_CA_FILE", ""),
+		RequireClientCert: require,
+		ReloadInterval:    reload,
+	}, nil
+}
+
+// PublicURL is where users reach the app, used for links in mails
+func PublicURL() string {
+	return strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost"), "/")
+}
+
+// TrustedProxies lists the proxy addresses or CIDRs whose X-Forwarded-For is believed.
+// Nothing is trusted by default, so the client address is the peer address.
+func TrustedProxies() []string {
+	proxies := getListEnv("TRUSTED_PROXIES", nil)
+	if len(proxies) == 0 {
+		return nil
+	}
+
+	return proxies
+}
+
+// AdminUserIDs lists the user IDs in ADMIN_USER_IDS who are made admins at startup while there is no admin yet.
+// It is how the first admin comes to be; later ones are appointed through the admin API.
+// IDs are used rather than names because anyone can sign up with a name before its owner does.
+func AdminUserIDs() ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	for _, v := range getListEnv("ADMIN_USER_IDS", nil) {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse ADMIN_USER_IDS: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// SecurityPolicy reads the cookie, CORS and security header settings.
+// Development defaults to plain HTTP cookies, no HSTS and the Next.js dev server as the only CORS origin;
+// elsewhere cookies have to be Secure.
+func SecurityPolicy() (security.Policy, error) {
+	policy := security.DefaultPolicy()
+	development := AppEnv() == "development"
+	if development {
+		policy.Cookie.Secure = false
+		policy.HSTSMaxAge = 0
+		policy.CORS.AllowOrigins = []string{"http://localhost:3000"}
+	}
+
+	var err error
+	if policy.Cookie.Secure, err = getBoolEnv("COOKIE_SECURE", policy.Cookie.Secure); err != nil {
+		return security.Policy{}, err
+	}
+	policy.Cookie.Domain = getEnv("COOKIE_DOMAIN", policy.Cookie.Domain)
+	if v, ok := os.LookupEnv("COOKIE_SAMESITE"); ok {
+		if policy.Cookie.SameSite, err = security.ParseSameSite(v); err != nil {
+			return security.Policy{}, fmt.Errorf("parse COOKIE_SAMESITE: %w", err)
+		}
+	}
+
+	policy.CORS.AllowOrigins = getListEnv("CORS_ALLOW_ORIGINS", policy.CORS.AllowOrigins)
+	if policy.CORS.MaxAge, err = getDurationEnv("CORS_MAX_AGE", policy.CORS.MaxAge); err != nil {
+		return security.Policy{}, err
+	}
+
+	if policy.HSTSMaxAge, err = getDurationEnv("HSTS_MAX_AGE", policy.HSTSMaxAge); err != nil {
+		return security.Policy{}, err
+	}
+	if policy.HSTSIncludeSubdomains, err = getBoolEnv("HSTS_INCLUDE_SUBDOMAINS", policy.HSTSIncludeSubdomains); err != nil {
+		return security.Policy{}, err
+	}
+	if policy.HSTSPreload, err = getBoolEnv("HSTS_PRELOAD", policy.HSTSPreload); err != nil {
+		return security.Policy{}, err
+	}
+
+	policy.ContentSecurityPolicy = getEnv("CONTENT_SECURITY_POLICY", policy.ContentSecurityPolicy)
+	policy.FrameOptions = getEnv("X_FRAME_OPTIONS", policy.FrameOptions)
+	policy.ReferrerPolicy = getEnv("REFERRER_POLICY", policy.ReferrerPolicy)
+
+	if !development && !policy.Cookie.Secure {
+		return security.Policy{}, fmt.Errorf("COOKIE_SECURE cannot be false when APP_ENV is %s", AppEnv())
+	}
+
+	if err := policy.Validate(); err != nil {
+		return security.Policy{}, fmt.Errorf("invalid security policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// Store picks where data is kept with STORE: mysql (the default), sqlite or memory
+func Store() string {
+	return getEnv("STORE", "mysql")
+}
+
+// SQLitePath is the database file of the sqlite store
+func SQLitePath() string {
+	return getEnv("SQLITE_PATH", "app.db")
+}
+
 func MySQL() *mysql.Config {
 	return &mysql.Config{
 		User:   getEnv("DB_USER", "root"),
@@ -38,5 +242,222 @@ func MySQL() *mysql.Config {
 		Collation:            "utf8mb4_general_ci",
 		AllowNativePasswords: true,
 		ParseTime:            true,
+		ClientFoundRows:      true,
+	}
+}
+
+// JWTKeys configures the signing keys of tokens living up to maxTokenTTL
+func JWTKeys(maxTokenTTL time.Duration) (keys.Config, error) {
+	rotation, err := getDurationEnv("JWT_KEY_ROTATION_INTERVAL", 0)
+	if err != nil {
+		return keys.Config{}, err
+	}
+
+	grace, err := getDurationEnv("JWT_KEY_GRACE_PERIOD", 6*time.Hour)
+	if err != nil {
+		return keys.Config{}, err
+	}
+	// a shorter grace period would drop keys while tokens they signed are still valid
+	if grace < maxTokenTTL {
+		return keys.Config{}, fmt.Errorf("JWT_KEY_GRACE_PERIOD must be at least %s", maxTokenTTL)
+	}
+
+	reload, err := getDurationEnv("JWT_KEY_RELOAD_INTERVAL", time.Minute)
+	if err != nil {
+		return keys.Config{}, err
+	}
+
+	// outside development, JWT_PRIVATE_KEYS or JWT_KEY_DIR is required so that tokens survive restarts
+	return keys.Config{
+		Algorithm:        keys.Algorithm(getEnv("JWT_ALGORITHM", string(keys.EdDSA))),
+		PEM:              getEnv("JWT_PRIVATE_KEYS", ""),
+		Dir:              getEnv("JWT_KEY_DIR", ""),
+		Ephemeral:        AppEnv() == "development",
+		RotationInterval: rotation,
+		GracePeriod:      grace,
+		ReloadInterval:   reload,
+	}, nil
+}
+
+// SecretKey is the base64 key encrypting secrets at rest. It is nil when SECRET_KEY is not set.
+func SecretKey() ([]byte, error) {
+	v := getEnv("SECRET_KEY", "")
+	if v == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("parse SECRET_KEY: %w", err)
+	}
+
+	return key, nil
+}
+
+// PasswordHash is the algorithm and cost used for new password hashes.
+// Stored hashes made with other settings are replaced when their owner next signs in.
+func PasswordHash() (password.Params, error) {
+	params := password.DefaultParams()
+	params.Algorithm = password.Algorithm(getEnv("PASSWORD_HASH_ALGORITHM", string(params.Algorithm)))
+
+	memory, err := getIntEnv("ARGON2_MEMORY_KIB", int(params.Memory))
+	if err != nil {
+		return password.Params{}, err
+	}
+	// anything out of range would wrap around when converted
+	if memory < 1 || uint64(memory) > math.MaxUint32 {
+		return password.Params{}, fmt.Errorf("ARGON2_MEMORY_KIB must be between 1 and %d", uint32(math.MaxUint32))
+	}
+
+	iterations, err := getIntEnv("ARGON2_ITERATIONS", int(params.Iterations))
+	if err != nil {
+		return password.Params{}, err
+	}
+	if iterations < 1 || uint64(iterations) > math.MaxUint32 {
+		return password.Params{}, fmt.Errorf("ARGON2_ITERATIONS must be between 1 and %d", uint32(math.MaxUint32))
+	}
+
+	parallelism, err := getIntEnv("ARGON2_PARALLELISM", int(params.Parallelism))
+	if err != nil {
+		return password.Params{}, err
+	}
+	if parallelism < 1 || parallelism > 255 {
+		return password.Params{}, fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255")
+	}
+
+	cost, err := getIntEnv("BCRYPT_COST", params.BcryptCost)
+	if err != nil {
+		return password.Params{}, err
 	}
+
+	params.Memory = uint32(memory)
+	params.Iterations = uint32(iterations)
+	params.Parallelism = uint8(parallelism)
+	params.BcryptCost = cost
+
+	return params, params.Validate()
+}
+
+// PasswordPolicy decides which new passwords are accepted.
+// PASSWORD_BREACHED_DIR points at a full k-anonymity corpus to use instead of the embedded one.
+func PasswordPolicy() (password.Policy, error) {
+	policy := password.DefaultPolicy()
+
+	var err error
+	if policy.MinLength, err = getIntEnv("PASSWORD_MIN_LENGTH", policy.MinLength); err != nil {
+		return password.Policy{}, err
+	}
+	if policy.MaxLength, err = getIntEnv("PASSWORD_MAX_LENGTH", policy.MaxLength); err != nil {
+		return password.Policy{}, err
+	}
+	if policy.MinCharClasses, err = getIntEnv("PASSWORD_MIN_CHAR_CLASSES", policy.MinCharClasses); err != nil {
+		return password.Policy{}, err
+	}
+	if policy.MaxUsernameSimilarity, err = getFloatEnv("PASSWORD_MAX_USERNAME_SIMILARITY", policy.MaxUsernameSimilarity); err != nil {
+		return password.Policy{}, err
+	}
+	if policy.MinStrength, err = getIntEnv("PASSWORD_MIN_STRENGTH", policy.MinStrength); err != nil {
+		return password.Policy{}, err
+	}
+
+	if dir := getEnv("PASSWORD_BREACHED_DIR", ""); dir != "" {
+		policy.Breached = password.DirBreached(dir)
+	}
+
+	return policy, nil
+}
+
+func MailFrom() string {
+	return getEnv("MAIL_FROM", "no-reply@localhost")
+}
+
+// Mailer picks how mail is delivered with MAIL_DRIVER: log (the default), file or smtp
+func Mailer() (mail.Mailer, error) {
+	switch driver := getEnv("MAIL_DRIVER", "log"); driver {
+	case "log":
+		return mail.LogMailer{}, nil
+	case "file":
+		return &mail.FileMailer{Dir: getEnv("MAIL_DIR", "mail")}, nil
+	case "smtp":
+		return &mail.SMTPMailer{
+			Addr: fmt.Sprintf(
+				"%s:%s",
+				getEnv("SMTP_HOST", "localhost"),
+				getEnv("SMTP_PORT", "587"),
+			),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_DRIVER: %q", driver)
+	}
+}
+
+// Reminders reads how often due reminders are looked for and how long a claimed reminder waits before it is retried.
+// A delivery may take half of REMINDER_LEASE.
+func Reminders() (reminder.Config, error) {
+	interval, err := getDurationEnv("REMINDER_INTERVAL", 30*time.Second)
+	if err != nil {
+		return reminder.Config{}, err
+	}
+
+	lease, err := getDurationEnv("REMINDER_LEASE", 5*time.Minute)
+	if err != nil {
+		return reminder.Config{}, err
+	}
+
+	return reminder.Config{Interval: interval, Lease: lease}, nil
+}
+
+// Notifier picks how reminders are delivered with REMINDER_NOTIFIER: log (the default), webhook or mail.
+// The mail notifier sends through mailer.
+func Notifier(mailer mail.Mailer) (reminder.Notifier, error) {
+	switch notifier := getEnv("REMINDER_NOTIFIER", "log"); notifier {
+	case "log":
+		return reminder.LogNotifier{}, nil
+	case "webhook":
+		url := getEnv("REMINDER_WEBHOOK_URL", "")
+		if url == "" {
+			return nil, fmt.Errorf("REMINDER_WEBHOOK_URL is required for the webhook notifier")
+		}
+
+		return &reminder.WebhookNotifier{
+			URL:    url,
+			Secret: []byte(getEnv("REMINDER_WEBHOOK_SECRET", "")),
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case "mail":
+		return &reminder.MailNotifier{Mailer: mailer, From: MailFrom()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported REMINDER_NOTIFIER: %q", notifier)
+	}
+}
+
+// OIDCProviders reads the issuers listed in OIDC_PROVIDERS, e.g. "google,corp".
+// Each is configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and optionally _SCOPES and _REDIRECT_URL.
+func OIDCProviders() (map[string]oidc.Config, error) {
+	providers := map[string]oidc.Config{}
+
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := oidc.Config{
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", PublicURL()+"/api/v1/auth/oidc/"+name+"/callback"),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "email profile")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+
+		providers[name] = provider
+	}
+
+	return providers, nil
 }
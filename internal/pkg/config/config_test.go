@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJWTKeysGracePeriod(t *testing.T) {
+	tests := []struct {
+		grace   string
+		wantErr bool
+	}{
+		{"", false},
+		{"2h", false},
+		{"1h", false},
+		{"59m", true},
+		{"0s", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.grace, func(t *testing.T) {
+			if tt.grace != "" {
+				t.Setenv("JWT_KEY_GRACE_PERIOD", tt.grace)
+			}
+
+			_, err := JWTKeys(time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("JWTKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package keys
+
+type (
+	// JWK is the public part of a key as defined in RFC 7517
+	JWK struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid,omitempty"`
+		Use       string `json:"use,omitempty"`
+		Algorithm string `json:"alg,omitempty"`
+
+		// RSA
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+
+		// OKP
+		Curve string `json:"crv,omitempty"`
+		X     string `json:"x,omitempty"`
+	}
+
+	JWKSet struct {
+		Keys []JWK `json:"keys"`
+	}
+)
@@ -0,0 +1,225 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type Algorithm string
+
+const (
+	RS256 Algorithm = "RS256"
+	EdDSA Algorithm = "EdDSA"
+)
+
+const rsaKeySize = 2048
+
+// PEM headers stored next to the PKCS#8 private key
+const (
+	headerKeyID     = "Key-Id"
+	headerAlgorithm = "Algorithm"
+	headerCreated   = "Created"
+)
+
+// Key is an asymmetric signing key identified by its kid
+type Key struct {
+	ID        string
+	Algorithm Algorithm
+	CreatedAt time.Time
+	private   crypto.Signer
+}
+
+// Generate creates a new key for the algorithm
+func Generate(alg Algorithm) (*Key, error) {
+	var private crypto.Signer
+	switch alg {
+	case RS256:
+		k, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("generate rsa key: %w", err)
+		}
+		private = k
+	case EdDSA:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate ed25519 key: %w", err)
+		}
+		private = k
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %q", alg)
+	}
+
+	return newKey(private, time.Now().UTC().Truncate(time.Second))
+}
+
+// ParsePEM reads a single PKCS#8 "PRIVATE KEY" block.
+// The algorithm is taken from the key type and the kid defaults to the key's thumbprint.
+func ParsePEM(block *pem.Block) (*Key, error) {
+	if block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("unexpected pem block type: %q", block.Type)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse pkcs8 key: %w", err)
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+
+	var createdAt time.Time
+	if v, ok := block.Headers[headerCreated]; ok {
+		createdAt, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("parse created header: %w", err)
+		}
+	}
+
+	key, err := newKey(private, createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := block.Headers[headerAlgorithm]; ok && Algorithm(v) != key.Algorithm {
+		return nil, fmt.Errorf("algorithm header %q does not match %s key", v, key.Algorithm)
+	}
+	if v, ok := block.Headers[headerKeyID]; ok && v != "" {
+		key.ID = v
+	}
+
+	return key, nil
+}
+
+// ParseAllPEM reads every private key in data
+func ParseAllPEM(data []byte) ([]*Key, error) {
+	keys := []*Key{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, err := ParsePEM(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// MarshalPEM encodes the key with its kid, algorithm and creation time as PEM headers
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, fmt.Errorf("marshal pkcs8 key: %w", err)
+	}
+
+	block := &pem.Block{
+		Type: "PRIVATE KEY",
+		Headers: map[string]string{
+			headerKeyID:     k.ID,
+			headerAlgorithm: string(k.Algorithm),
+			headerCreated:   k.CreatedAt.Format(time.RFC3339),
+		},
+		Bytes: der,
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// SigningMethod returns the jwt signing method matching the key
+func (k *Key) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(string(k.Algorithm))
+}
+
+// PrivateKey returns the key in the form jwt.SigningMethod.Sign expects
+func (k *Key) PrivateKey() crypto.PrivateKey {
+	return k.private
+}
+
+// PublicKey returns the key in the form jwt.SigningMethod.Verify expects
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JWK returns the public half of the key
+func (k *Key) JWK() JWK {
+	jwk := thumbprintMembers(k.private.Public())
+	jwk.KeyID = k.ID
+	jwk.Use = "sig"
+	jwk.Algorithm = string(k.Algorithm)
+
+	return jwk
+}
+
+func newKey(private crypto.Signer, createdAt time.Time) (*Key, error) {
+	var alg Algorithm
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		alg = RS256
+	case ed25519.PrivateKey:
+		alg = EdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", private)
+	}
+
+	return &Key{
+		ID:        thumbprint(private.Public()),
+		Algorithm: alg,
+		CreatedAt: createdAt,
+		private:   private,
+	}, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint
+func thumbprint(public crypto.PublicKey) string {
+	jwk := thumbprintMembers(public)
+
+	// required members in lexicographic order, as RFC 7638 requires
+	var canonical string
+	switch jwk.KeyType {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Curve, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func thumbprintMembers(public crypto.PublicKey) JWK {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+
+	return JWK{}
+}
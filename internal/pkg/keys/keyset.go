@@ -0,0 +1,328 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Algorithm is used for generated keys
+	Algorithm Algorithm
+	// PEM holds private keys given directly through configuration
+	PEM string
+	// Dir persists keys as <kid>.pem so they survive restarts and are shared between replicas.
+	// Keys stay in memory when it is empty.
+	Dir string
+	// Ephemeral allows generating keys without Dir to persist them to. Tokens signed with such a key
+	// stop verifying on restart and are never accepted by other replicas, so it is for development only.
+	Ephemeral bool
+	// RotationInterval is how long a key signs before a new one is generated. Zero disables rotation.
+	RotationInterval time.Duration
+	// GracePeriod is how long a superseded key is still accepted for verification,
+	// counted from when the next key starts signing
+	GracePeriod time.Duration
+	// ReloadInterval is how often Dir is re-read to pick up keys created by other replicas.
+	// A new key only starts signing once this much time has passed, so every replica knows it by then.
+	ReloadInterval time.Duration
+}
+
+// KeySet holds every key that may verify a token and picks the one that signs new tokens
+type KeySet struct {
+	mu     sync.RWMutex
+	config Config
+	keys   map[string]*Key
+	now    func() time.Time
+}
+
+// NewKeySet loads keys from the configuration and Dir.
+// A key is generated when none is found, which needs Dir to persist it to unless Ephemeral is set.
+func NewKeySet(config Config) (*KeySet, error) {
+	if config.Algorithm == "" {
+		config.Algorithm = EdDSA
+	}
+	if config.Algorithm != RS256 && config.Algorithm != EdDSA {
+		return nil, fmt.Errorf("unsupported algorithm: %q", config.Algorithm)
+	}
+	if config.RotationInterval > 0 && config.Dir == "" && !config.Ephemeral {
+		return nil, errors.New("rotating keys requires a key dir to share them through")
+	}
+
+	s := &KeySet{
+		config: config,
+		keys:   map[string]*Key{},
+		now:    time.Now,
+	}
+
+	if config.PEM != "" {
+		keys, err := ParseAllPEM([]byte(config.PEM))
+		if err != nil {
+			return nil, fmt.Errorf("parse configured keys: %w", err)
+		}
+		for _, key := range keys {
+			s.keys[key.ID] = key
+		}
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	if len(s.keys) == 0 {
+		if config.Dir == "" && !config.Ephemeral {
+			return nil, errors.New("no signing key is configured and there is no key dir to persist a generated one to")
+		}
+		if config.Dir == "" {
+			log.Println("WARNING: no signing key is configured; using a generated one kept in memory only. " +
+				"Tokens will stop verifying on restart and will not verify on other replicas.")
+		}
+
+		if err := s.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Signing returns the key new tokens are signed with
+func (s *KeySet) Signing() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.sorted()
+	activeBefore := s.now().Add(-s.config.ReloadInterval)
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !keys[i].CreatedAt.After(activeBefore) {
+			return keys[i]
+		}
+	}
+
+	// every key is too new to be known everywhere; use the oldest one
+	return keys[0]
+}
+
+// Lookup returns the key with the kid if it may still verify tokens
+func (s *KeySet) Lookup(kid string) (*Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// JWKS returns the public keys of every key that may verify tokens
+func (s *KeySet) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.sorted() {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+
+	return set
+}
+
+// Rotate generates a new key and persists it to Dir
+func (s *KeySet) Rotate() error {
+	key, err := Generate(s.config.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if s.config.Dir != "" {
+		if err := s.save(key); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.keys[key.ID] = key
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Reload reads every *.pem file in Dir
+func (s *KeySet) Reload() error {
+	if s.config.Dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.config.Dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("list key files: %w", err)
+	}
+
+	loaded := []*Key{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read key file: %w", err)
+		}
+
+		keys, err := ParseAllPEM(data)
+		if err != nil {
+			return fmt.Errorf("parse key file %s: %w", filepath.Base(path), err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("stat key file: %w", err)
+		}
+		for _, key := range keys {
+			if key.CreatedAt.IsZero() {
+				key.CreatedAt = info.ModTime().UTC().Truncate(time.Second)
+			}
+		}
+		loaded = append(loaded, keys...)
+	}
+
+	s.mu.Lock()
+	for _, key := range loaded {
+		if _, ok := s.keys[key.ID]; !ok {
+			s.keys[key.ID] = key
+		}
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Prune drops keys superseded longer than GracePeriod ago and removes their files
+func (s *KeySet) Prune() error {
+	s.mu.Lock()
+	keys := s.sorted()
+	expired := []*Key{}
+	for i := 0; i < len(keys)-1; i++ {
+		// a key keeps signing until the next one becomes active, ReloadInterval after it is created
+		supersededAt := keys[i+1].CreatedAt.Add(s.config.ReloadInterval)
+		if s.now().Sub(supersededAt) > s.config.GracePeriod {
+			expired = append(expired, keys[i])
+			delete(s.keys, keys[i].ID)
+		}
+	}
+	s.mu.Unlock()
+
+	if s.config.Dir == "" {
+		return nil
+	}
+
+	for _, key := range expired {
+		err := os.Remove(s.path(key))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove key file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Run reloads keys until ctx is done.
+// With rotation enabled it also rotates and prunes them.
+func (s *KeySet) Run(ctx context.Context) {
+	interval := s.config.ReloadInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.Reload(); err != nil {
+			log.Printf("reload signing keys: %v", err)
+			continue
+		}
+
+		if s.config.RotationInterval > 0 && s.now().Sub(s.newest().CreatedAt) >= s.config.RotationInterval {
+			if err := s.Rotate(); err != nil {
+				log.Printf("rotate signing key: %v", err)
+				continue
+			}
+		}
+
+		if s.config.RotationInterval > 0 {
+			if err := s.Prune(); err != nil {
+				log.Printf("prune signing keys: %v", err)
+			}
+		}
+	}
+}
+
+func (s *KeySet) newest() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.sorted()
+	return keys[len(keys)-1]
+}
+
+// sorted returns keys from oldest to newest. s.mu must be held.
+func (s *KeySet) sorted() []*Key {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+func (s *KeySet) path(key *Key) string {
+	// kids are base64url thumbprints unless configured otherwise
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(key.ID)
+	return filepath.Join(s.config.Dir, name+".pem")
+}
+
+func (s *KeySet) save(key *Key) error {
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.config.Dir, 0o700); err != nil {
+		return fmt.Errorf("create key dir: %w", err)
+	}
+
+	// write to a temporary file first so other replicas never read a partial key
+	tmp, err := os.CreateTemp(s.config.Dir, ".key-*")
+	if err != nil {
+		return fmt.Errorf("create key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close key file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("rename key file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package keys
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneKeepsKeyUntilTokensItSignedExpire(t *testing.T) {
+	const (
+		reload = time.Minute
+		grace  = time.Hour // as long as the longest token lifetime
+	)
+
+	old, err := Generate(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := Generate(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	old.CreatedAt = start
+	next.CreatedAt = start.Add(24 * time.Hour)
+
+	// the last token the old key signs is signed just before the next key becomes active
+	lastSigned := next.CreatedAt.Add(reload - time.Second)
+	lastExpiry := lastSigned.Add(grace)
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		wantKept bool
+	}{
+		{"grace timed from the next key's creation is over", next.CreatedAt.Add(grace + time.Second), true},
+		{"last signed token expires", lastExpiry, true},
+		{"grace is over", next.CreatedAt.Add(reload + grace + time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &KeySet{
+				config: Config{GracePeriod: grace, ReloadInterval: reload},
+				keys:   map[string]*Key{old.ID: old, next.ID: next},
+				now:    func() time.Time { return lastSigned },
+			}
+			if got := s.Signing(); got != old {
+				t.Fatalf("Signing() = %s, want the old key %s", got.ID, old.ID)
+			}
+
+			s.now = func() time.Time { return tt.now }
+			if err := s.Prune(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := s.Lookup(old.ID); ok != tt.wantKept {
+				t.Errorf("old key kept = %v, want %v", ok, tt.wantKept)
+			}
+			if _, ok := s.Lookup(next.ID); !ok {
+				t.Error("next key was pruned")
+			}
+		})
+	}
+}
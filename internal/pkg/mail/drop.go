@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogMailer prints messages to the log instead of sending them, for development
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each message to its own .eml file in Dir
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return fmt.Errorf("create mail dir: %w", err)
+	}
+
+	// names sort in the order the messages were sent
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), uuid.NewString())
+	tmp := filepath.Join(m.Dir, "."+name)
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+
+	// readers never see a partially written file
+	if err := os.Rename(tmp, filepath.Join(m.Dir, name)); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+
+	return nil
+}
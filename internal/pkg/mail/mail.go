@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Message is a plain text mail to a single recipient
+type Message struct {
+	// ID is the local part of the Message-ID, random when empty.
+	// Mails sent again under the same ID read as the same message.
+	ID      string
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Bytes renders msg in RFC 5322 format
+func (msg Message) Bytes() ([]byte, error) {
+	for _, v := range []string{msg.From, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("header contains a line break: %q", v)
+		}
+	}
+	if strings.ContainsAny(msg.ID, "\r\n<>@ ") {
+		return nil, fmt.Errorf("invalid message id: %q", msg.ID)
+	}
+
+	id := msg.ID
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate message id: %w", err)
+		}
+		id = hex.EncodeToString(b)
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(msg.From, "@"); i >= 0 {
+		domain = strings.Trim(msg.From[i+1:], "> ")
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <%s@%s>\r\n", id, domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(buf)
+	if _, err := w.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// envelopeAddress extracts the bare address from a header value like "Name <addr>"
+func envelopeAddress(v string) string {
+	addr, err := mail.ParseAddress(v)
+	if err != nil {
+		return v
+	}
+
+	return addr.Address
+}
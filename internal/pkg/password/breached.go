@@ -0,0 +1,109 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BreachedRanges answers k-anonymity range queries in the style of Have I Been Pwned:
+// given the first 5 hex digits of a SHA-1 it returns the other 35 of every breached password under that prefix,
+// so the password itself never has to leave the caller.
+type BreachedRanges interface {
+	Range(prefix string) ([]string, error)
+}
+
+// IsBreached reports whether password appears in ranges
+func IsBreached(ranges BreachedRanges, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := ranges.Range(hash[:5])
+	if err != nil {
+		return false, fmt.Errorf("breached range %s: %w", hash[:5], err)
+	}
+
+	for _, suffix := range suffixes {
+		if suffix == hash[5:] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//go:embed breached.txt
+var embeddedBreached string
+
+// EmbeddedBreached is the small corpus shipped in the binary: the most common passwords and their usual decorations
+func EmbeddedBreached() BreachedRanges {
+	return sortedHashes(strings.Fields(embeddedBreached))
+}
+
+// sortedHashes holds full uppercase SHA-1 hex digests in order
+type sortedHashes []string
+
+func (s sortedHashes) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	i := sort.SearchStrings(s, prefix)
+
+	suffixes := []string{}
+	for ; i < len(s) && strings.HasPrefix(s[i], prefix); i++ {
+		suffixes = append(suffixes, s[i][len(prefix):])
+	}
+
+	return suffixes, nil
+}
+
+// DirBreached reads a corpus downloaded range by range into dir,
+// one file per prefix named like 5BAA6 or 5BAA6.txt holding SUFFIX:COUNT lines.
+func DirBreached(dir string) BreachedRanges {
+	return dirRanges(dir)
+}
+
+type dirRanges string
+
+func (d dirRanges) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		f, err := os.Open(filepath.Join(string(d), name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return readRange(f)
+	}
+
+	// every prefix is present in a complete corpus, so a missing file is not a miss
+	return nil, fmt.Errorf("no range file for %s in %s", prefix, string(d))
+}
+
+func readRange(r io.Reader) ([]string, error) {
+	suffixes := []string{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		suffix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if suffix != "" {
+			suffixes = append(suffixes, strings.ToUpper(suffix))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return suffixes, nil
+}
@@ -0,0 +1,187 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Algorithm string
+
+const (
+	Argon2id Algorithm = "argon2id"
+	Bcrypt   Algorithm = "bcrypt"
+)
+
+// ErrUnknownFormat is returned for a stored hash no algorithm recognizes
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+type Params struct {
+	// Algorithm is used for new hashes
+	Algorithm Algorithm
+
+	// argon2id parameters, see RFC 9106
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+
+	BcryptCost int
+}
+
+// DefaultParams follow the second recommended option of RFC 9106
+func DefaultParams() Params {
+	return Params{
+		Algorithm:   Argon2id,
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+		BcryptCost:  bcrypt.DefaultCost,
+	}
+}
+
+// Validate rejects parameters that would produce weak or unusable hashes
+func (p Params) Validate() error {
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 {
+			return errors.New("invalid argon2id parameters")
+		}
+		if p.SaltLength < 8 || p.KeyLength < 16 {
+			return errors.New("argon2id salt or key too short")
+		}
+	case Bcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm: %q", p.Algorithm)
+	}
+
+	return nil
+}
+
+// Hasher produces self-describing hashes: PHC strings for argon2id and
+// modular crypt strings for bcrypt, so either can be verified later
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Hasher{params: params}, nil
+}
+
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	switch h.params.Algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		if err != nil {
+			return nil, fmt.Errorf("bcrypt: %w", err)
+		}
+		return hash, nil
+	default:
+		salt := make([]byte, h.params.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("generate salt: %w", err)
+		}
+
+		p := h.params
+		key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+		return []byte(fmt.Sprintf(
+			"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		)), nil
+	}
+}
+
+// Verify checks password against a stored hash of any supported format.
+// needsRehash is true when the hash should be replaced with one made with the current parameters.
+func (h *Hasher) Verify(password string, encoded []byte) (ok bool, needsRehash bool, err error) {
+	// hashes stored in the old binary(64) column are padded with zero bytes
+	encoded = bytes.TrimRight(encoded, "\x00")
+
+	switch {
+	case bytes.HasPrefix(encoded, []byte("$argon2id$")):
+		return h.verifyArgon2id(password, string(encoded))
+	case bytes.HasPrefix(encoded, []byte("$2")):
+		return h.verifyBcrypt(password, encoded)
+	default:
+		return false, false, ErrUnknownFormat
+	}
+}
+
+func (h *Hasher) verifyArgon2id(password string, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrUnknownFormat
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrUnknownFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrUnknownFormat
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrUnknownFormat
+	}
+
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	p := h.params
+	needsRehash := p.Algorithm != Argon2id ||
+		memory != p.Memory || iterations != p.Iterations || parallelism != p.Parallelism ||
+		uint32(len(salt)) != p.SaltLength || uint32(len(key)) != p.KeyLength
+
+	return true, needsRehash, nil
+}
+
+func (h *Hasher) verifyBcrypt(password string, encoded []byte) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword(encoded, []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("bcrypt: %w", err)
+	}
+
+	cost, err := bcrypt.Cost(encoded)
+	if err != nil {
+		return false, false, fmt.Errorf("bcrypt: %w", err)
+	}
+
+	needsRehash := h.params.Algorithm != Bcrypt || cost != h.params.BcryptCost
+
+	return true, needsRehash, nil
+}
@@ -0,0 +1,184 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules reported in a Violation
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleCharClass = "character_classes"
+	RuleUsername  = "username_similarity"
+	RuleStrength  = "strength"
+	RuleBreached  = "breached"
+)
+
+// Violation is one rule a password fails
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Policy decides which new passwords are acceptable. Zero values disable a rule.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MinCharClasses is how many of lowercase, uppercase, digits and symbols must appear
+	MinCharClasses int
+	// MaxUsernameSimilarity rejects passwords at least this similar to the username, from 0 to 1
+	MaxUsernameSimilarity float64
+	// MinStrength is the lowest acceptable EstimateStrength score, from 0 to 4
+	MinStrength int
+	// Breached is consulted last, so cheap rules reject the obvious passwords first
+	Breached BreachedRanges
+}
+
+// DefaultPolicy follows NIST SP 800-63B: long enough, not guessable, not breached, and no composition rules
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:             8,
+		MaxLength:             128,
+		MinCharClasses:        0,
+		MaxUsernameSimilarity: 0.7,
+		MinStrength:           2,
+		Breached:              EmbeddedBreached(),
+	}
+}
+
+// Check returns every rule password fails for the user named username.
+// The error is only for a breached corpus that could not be read.
+func (p Policy) Check(password string, username string) ([]Violation, error) {
+	violations := []Violation{}
+	length := utf8.RuneCountInString(password)
+
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters", p.MinLength),
+		})
+	}
+
+	// the other rules cost more than linear time, so an overlong password is rejected on its length alone
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("must be at most %d characters", p.MaxLength),
+		})
+		return violations, nil
+	}
+
+	if p.MinCharClasses > 0 && charClasses(password) < p.MinCharClasses {
+		violations = append(violations, Violation{
+			Rule:    RuleCharClass,
+			Message: fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses),
+		})
+	}
+
+	if p.MaxUsernameSimilarity > 0 && username != "" && similarity(password, username) >= p.MaxUsernameSimilarity {
+		violations = append(violations, Violation{
+			Rule:    RuleUsername,
+			Message: "must not resemble the user name",
+		})
+	}
+
+	if p.MinStrength > 0 {
+		if strength := EstimateStrength(password, username); strength.Score < p.MinStrength {
+			violations = append(violations, Violation{
+				Rule:    RuleStrength,
+				Message: fmt.Sprintf("is too easy to guess: strength %d of 4, %d required", strength.Score, p.MinStrength),
+			})
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := IsBreached(p.Breached, password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Rule:    RuleBreached,
+				Message: "has appeared in a data breach",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+// similarity is 1 when password contains the username forwards or backwards,
+// and otherwise 1 minus their edit distance relative to the longer of the two
+func similarity(password string, username string) float64 {
+	password = strings.ToLower(password)
+	username = strings.ToLower(username)
+
+	if utf8.RuneCountInString(username) >= 3 &&
+		(strings.Contains(password, username) || strings.Contains(password, reverse(username))) {
+		return 1
+	}
+
+	a, b := []rune(password), []rune(username)
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
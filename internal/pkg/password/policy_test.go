@@ -0,0 +1,30 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckLongPassword(t *testing.T) {
+	policy := DefaultPolicy()
+	password := strings.Repeat("correct horse battery staple ", 4000)
+
+	violations, err := policy.Check(password, "someone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the costly rules are not run at all
+	if len(violations) != 1 || violations[0].Rule != RuleMaxLength {
+		t.Errorf("violations = %v, want only %s", violations, RuleMaxLength)
+	}
+}
+
+func TestEstimateStrengthLongPassword(t *testing.T) {
+	// only the first maxEstimatedLength characters are scored, so this returns at once
+	password := strings.Repeat("x7#kQ", 20000)
+
+	if got := EstimateStrength(password, "someone"); got.Score != 4 {
+		t.Errorf("score = %d, want 4", got.Score)
+	}
+}
@@ -0,0 +1,320 @@
+package password
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+//go:embed common.txt
+var commonText string
+
+// commonRanks maps each common password to its 1-based popularity rank
+var commonRanks = func() map[string]int {
+	ranks := map[string]int{}
+	for i, word := range strings.Fields(commonText) {
+		ranks[word] = i + 1
+	}
+	return ranks
+}()
+
+// keyboardRows are walked by passwords like qwerty and asdfgh
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'0': 'o', '5': 's', '$': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// maxEstimatedLength bounds the work of EstimateStrength
+const maxEstimatedLength = 256
+
+// Strength is an estimate of how many guesses an attacker needs, after zxcvbn.
+// Score runs from 0 (too guessable) to 4 (very unguessable) on the same scale.
+type Strength struct {
+	Guesses float64
+	Score   int
+}
+
+// EstimateStrength finds the cheapest way to build password out of common passwords, the user's own inputs,
+// sequences, repeats, keyboard walks and years, falling back to brute force for whatever is left.
+// Only the first maxEstimatedLength characters are scored, as the cost grows with the cube of the length.
+func EstimateStrength(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes) > maxEstimatedLength {
+		// more characters only ever take more guesses
+		runes = runes[:maxEstimatedLength]
+	}
+	n := len(runes)
+	if n == 0 {
+		return Strength{Guesses: 1, Score: 0}
+	}
+
+	matches := findMatches(runes, userInputs)
+
+	// best[i][l] is the fewest guesses for the first i characters split into l matches,
+	// counted in log10 to avoid overflow
+	best := make([][]float64, n+1)
+	for i := range best {
+		best[i] = make([]float64, n+1)
+		for l := range best[i] {
+			best[i][l] = math.Inf(1)
+		}
+	}
+	best[0][0] = 0
+
+	for j := 1; j <= n; j++ {
+		for _, m := range matches[j] {
+			guesses := math.Log10(math.Max(m.guesses, minGuesses(j-m.start)))
+			for l := 1; l <= j; l++ {
+				if g := best[m.start][l-1] + guesses; g < best[j][l] {
+					best[j][l] = g
+				}
+			}
+		}
+	}
+
+	// an attacker also has to guess how many patterns there are and in which order
+	guesses := math.Inf(1)
+	for l := 1; l <= n; l++ {
+		if math.IsInf(best[n][l], 1) {
+			continue
+		}
+		lgamma, _ := math.Lgamma(float64(l + 1))
+		g := math.Pow(10, best[n][l]+lgamma/math.Ln10) + math.Pow(10000, float64(l-1))
+		guesses = math.Min(guesses, g)
+	}
+
+	return Strength{Guesses: guesses, Score: scoreGuesses(guesses)}
+}
+
+func minGuesses(length int) float64 {
+	if length == 1 {
+		return 10
+	}
+	return 50
+}
+
+func scoreGuesses(guesses float64) int {
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return 0
+	case guesses < 1e6+delta:
+		return 1
+	case guesses < 1e8+delta:
+		return 2
+	case guesses < 1e10+delta:
+		return 3
+	default:
+		return 4
+	}
+}
+
+type match struct {
+	start   int
+	guesses float64
+}
+
+// findMatches returns the patterns found in password indexed by the position they end at
+func findMatches(runes []rune, userInputs []string) map[int][]match {
+	n := len(runes)
+	matches := map[int][]match{}
+	add := func(start, end int, guesses float64) {
+		// every pattern costs at least one guess
+		if guesses < 1 {
+			guesses = 1
+		}
+		matches[end] = append(matches[end], match{start: start, guesses: guesses})
+	}
+
+	// anything can be brute forced
+	cardinality := bruteforceCardinality(runes)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j <= n; j++ {
+			add(i, j, math.Pow(cardinality, float64(j-i)))
+		}
+	}
+
+	lower := make([]rune, n)
+	unleet := make([]rune, n)
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+		if s, ok := leetSubstitutions[lower[i]]; ok {
+			unleet[i] = s
+		} else {
+			unleet[i] = lower[i]
+		}
+	}
+
+	inputs := map[string]bool{}
+	for _, input := range userInputs {
+		if input = strings.ToLower(input); len([]rune(input)) >= 3 {
+			inputs[input] = true
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 1; j <= n; j++ {
+			variations := caseVariations(runes[i:j])
+
+			// dictionary words, reversed words and l33t spellings
+			for _, candidate := range []struct {
+				word  string
+				extra float64
+			}{
+				{string(lower[i:j]), 1},
+				{reverse(string(lower[i:j])), 2},
+				{string(unleet[i:j]), 2},
+			} {
+				if rank, ok := commonRanks[candidate.word]; ok {
+					add(i, j, float64(rank)*variations*candidate.extra)
+				}
+				if inputs[candidate.word] {
+					add(i, j, variations*candidate.extra)
+				}
+			}
+
+			if j-i >= 3 {
+				if guesses, ok := sequenceGuesses(runes[i:j]); ok {
+					add(i, j, guesses)
+				}
+				if guesses, ok := repeatGuesses(runes[i:j]); ok {
+					add(i, j, guesses)
+				}
+			}
+
+			if j-i >= 4 && isKeyboardWalk(string(lower[i:j])) {
+				add(i, j, float64(len(keyboardRows))*10*float64(j-i)*variations)
+			}
+
+			if j-i == 4 && isYear(string(runes[i:j])) {
+				add(i, j, 120)
+			}
+		}
+	}
+
+	return matches
+}
+
+// caseVariations counts the capitalizations a guesser tries before reaching this one
+func caseVariations(runes []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0, upper == 1 && unicode.IsUpper(runes[0]):
+		return 2
+	default:
+		return math.Pow(2, float64(upper+lower)) / 2
+	}
+}
+
+// sequenceGuesses matches runs like abc, 7531 or zyx
+func sequenceGuesses(runes []rune) (float64, bool) {
+	delta := runes[1] - runes[0]
+	if delta == 0 || delta > 5 || delta < -5 {
+		return 0, false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != delta {
+			return 0, false
+		}
+	}
+
+	base := 26.0
+	switch {
+	case strings.ContainsRune("aAzZ019", runes[0]):
+		base = 4
+	case unicode.IsDigit(runes[0]):
+		base = 10
+	}
+	if delta < 0 {
+		base *= 2
+	}
+	if delta != 1 && delta != -1 {
+		base *= 2
+	}
+
+	return base * float64(len(runes)), true
+}
+
+// repeatGuesses matches a single character repeated
+func repeatGuesses(runes []rune) (float64, bool) {
+	for _, r := range runes[1:] {
+		if r != runes[0] {
+			return 0, false
+		}
+	}
+
+	return bruteforceCardinality(runes[:1]) * float64(len(runes)), true
+}
+
+func isKeyboardWalk(s string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isYear(s string) bool {
+	return s >= "1900" && s <= "2039"
+}
+
+// bruteforceCardinality is the size of the smallest alphabet of character classes covering runes
+func bruteforceCardinality(runes []rune) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 0x7f:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	cardinality := 0.0
+	for _, class := range []struct {
+		present bool
+		size    float64
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			cardinality += class.size
+		}
+	}
+
+	return cardinality
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+
+	return string(runes)
+}
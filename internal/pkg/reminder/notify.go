@@ -0,0 +1,119 @@
+package reminder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/mail"
+	"github.com/google/uuid"
+)
+
+// LogNotifier prints reminders to the log, for development
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, r Reminder) error {
+	log.Printf("reminder %s for user %s: %q is due %s", r.IdempotencyKey(), r.UserID, r.Title, r.LocalDueAt().Format(time.RFC3339))
+	return nil
+}
+
+// WebhookNotifier posts each reminder as JSON to URL.
+// The idempotency key is sent as the Idempotency-Key header, and with Secret set
+// the body is signed with HMAC-SHA256 in the X-Signature header.
+type WebhookNotifier struct {
+	URL    string
+	Secret []byte
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	ID       uuid.UUID `json:"id"`
+	TaskID   uuid.UUID `json:"task_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Title    string    `json:"title"`
+	DueAt    time.Time `json:"due_at"`
+	TimeZone string    `json:"time_zone"`
+	RemindAt time.Time `json:"remind_at"`
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, r Reminder) error {
+	body, err := json.Marshal(webhookPayload{
+		ID:       r.ID,
+		TaskID:   r.TaskID,
+		UserID:   r.UserID,
+		Title:    r.Title,
+		DueAt:    r.LocalDueAt(),
+		TimeZone: r.TimeZone,
+		RemindAt: r.RemindAt,
+	})
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", r.IdempotencyKey())
+	if len(n.Secret) > 0 {
+		mac := hmac.New(sha256.New, n.Secret)
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook: unexpected status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// MailNotifier mails reminders to the user's verified address.
+// The Message-ID is made from the idempotency key, so a repeated mail can be recognized as the same one.
+// Reminders of users without one are dropped, as retrying would not help.
+type MailNotifier struct {
+	Mailer mail.Mailer
+	From   string
+}
+
+func (n *MailNotifier) Notify(ctx context.Context, r Reminder) error {
+	if r.Email == "" {
+		log.Printf("reminder %s: user %s has no verified email", r.ID, r.UserID)
+		return nil
+	}
+
+	// a title may hold line breaks, which a header may not
+	subject := "Reminder: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(r.Title)
+	msg := mail.Message{
+		ID:      "reminder." + r.IdempotencyKey(),
+		From:    n.From,
+		To:      r.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Your task %q is due %s.\n", r.Title, r.LocalDueAt().Format("Mon, 02 Jan 2006 15:04 MST")),
+	}
+	if err := n.Mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("send reminder mail: %w", err)
+	}
+
+	return nil
+}
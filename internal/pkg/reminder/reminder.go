@@ -0,0 +1,152 @@
+// Package reminder delivers the reminders of tasks once they are due.
+//
+// A reminder is recorded as sent under the claim it was delivered under, and its delivery is cut off
+// before that claim runs out, so no two dispatches ever deliver it at the same time.
+// Delivery is still at least once rather than exactly once: a reminder is sent again when the server dies
+// between handing it to the notifier and recording it as sent, or when a notifier fails after its
+// receiver got the reminder. Every notifier hands the receiver the reminder's IdempotencyKey,
+// so receivers that drop repeats by it see each reminder exactly once.
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrClaimLost is returned by Store.MarkReminderSent when the lease ran out and the reminder was claimed again
+var ErrClaimLost = errors.New("reminder claimed again")
+
+// Reminder is one notification about a task coming due
+type Reminder struct {
+	// ID stays the same when a delivery is retried
+	ID uuid.UUID
+	// ClaimID identifies the claim the reminder was handed out under
+	ClaimID  uuid.UUID
+	TaskID   uuid.UUID
+	UserID   uuid.UUID
+	Title    string
+	DueAt    time.Time
+	TimeZone string
+	RemindAt time.Time
+	// Email is the user's verified address, empty when there is none
+	Email string
+}
+
+// IdempotencyKey is the same for every delivery of the reminder, so receivers can drop duplicates by it
+func (r Reminder) IdempotencyKey() string {
+	return r.ID.String()
+}
+
+// LocalDueAt returns DueAt in the time zone of the task, or UTC when it has none
+func (r Reminder) LocalDueAt() time.Time {
+	loc, err := time.LoadLocation(r.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return r.DueAt.In(loc)
+}
+
+// Notifier delivers reminders, passing IdempotencyKey on to the receiver.
+// Implementations must be safe for concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, r Reminder) error
+}
+
+// Store hands out reminders that are due.
+// A claimed reminder is not handed out again until its lease runs out, unless it is marked sent by then.
+// MarkReminderSent returns ErrClaimLost unless claimID still holds the reminder.
+type Store interface {
+	ClaimDueReminders(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Reminder, error)
+	MarkReminderSent(ctx context.Context, reminderID uuid.UUID, claimID uuid.UUID) error
+}
+
+type Config struct {
+	// Interval is how often due reminders are looked for
+	Interval time.Duration
+	// Lease is how long a claimed reminder waits for its delivery to be confirmed before it is retried.
+	// Deliveries are cut off halfway through it, leaving the rest to record them.
+	Lease time.Duration
+	// BatchSize caps the reminders claimed at once
+	BatchSize int
+}
+
+// Scheduler delivers due reminders through a notifier.
+// Progress is kept in the store, so no reminder is lost across restarts, and replicas can share the work.
+// A reminder is sent twice only in the cases the package doc lists.
+type Scheduler struct {
+	store    Store
+	notifier Notifier
+	config   Config
+}
+
+func NewScheduler(store Store, notifier Notifier, config Config) *Scheduler {
+	if config.Interval <= 0 {
+		config.Interval = 30 * time.Second
+	}
+	if config.Lease <= 0 {
+		config.Lease = 5 * time.Minute
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+
+	return &Scheduler{store: store, notifier: notifier, config: config}
+}
+
+// Run delivers due reminders until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Dispatch(ctx, time.Now()); err != nil {
+			log.Printf("dispatch reminders: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch delivers the reminders due at now.
+// A failed delivery is left to be retried once its lease runs out.
+func (s *Scheduler) Dispatch(ctx context.Context, now time.Time) error {
+	for {
+		claimedAt := time.Now()
+		reminders, err := s.store.ClaimDueReminders(ctx, now, s.config.Lease, s.config.BatchSize)
+		if err != nil {
+			return fmt.Errorf("claim reminders: %w", err)
+		}
+
+		// a delivery still running when the claim runs out would race the dispatch claiming the reminder next
+		notifyCtx, cancel := context.WithDeadline(ctx, claimedAt.Add(s.config.Lease/2))
+
+		var errs []error
+		for _, r := range reminders {
+			if err := s.notifier.Notify(notifyCtx, r); err != nil {
+				errs = append(errs, fmt.Errorf("notify reminder %s: %w", r.ID, err))
+				continue
+			}
+
+			// with the claim lost, another dispatch has sent it again or is about to
+			if err := s.store.MarkReminderSent(ctx, r.ID, r.ClaimID); err != nil {
+				errs = append(errs, fmt.Errorf("mark reminder %s sent: %w", r.ID, err))
+			}
+		}
+
+		cancel()
+
+		if err := errors.Join(errs...); err != nil || len(reminders) < s.config.BatchSize {
+			return err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// claimOnce hands out its reminders on the first claim only
+type claimOnce struct {
+	reminders []Reminder
+	sent      []uuid.UUID
+}
+
+func (s *claimOnce) ClaimDueReminders(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Reminder, error) {
+	reminders := s.reminders
+	s.reminders = nil
+	return reminders, nil
+}
+
+func (s *claimOnce) MarkReminderSent(ctx context.Context, reminderID uuid.UUID, claimID uuid.UUID) error {
+	s.sent = append(s.sent, reminderID)
+	return nil
+}
+
+// hangingNotifier waits for its context, like a receiver that never answers
+type hangingNotifier struct{}
+
+func (hangingNotifier) Notify(ctx context.Context, r Reminder) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDispatchCutsDeliveryOffBeforeTheLeaseRunsOut(t *testing.T) {
+	const lease = 100 * time.Millisecond
+
+	store := &claimOnce{reminders: []Reminder{{ID: uuid.New(), ClaimID: uuid.New()}}}
+	scheduler := NewScheduler(store, hangingNotifier{}, Config{Lease: lease})
+
+	start := time.Now()
+	err := scheduler.Dispatch(context.Background(), start)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Dispatch() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed >= lease {
+		t.Errorf("delivery ran for %s, as long as the lease of %s", elapsed, lease)
+	}
+	if len(store.sent) != 0 {
+		t.Errorf("a reminder that was not delivered was marked sent")
+	}
+}
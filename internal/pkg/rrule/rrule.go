@@ -0,0 +1,532 @@
+// Package rrule expands RFC 5545 recurrence rules of daily or coarser frequency
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencies = []string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}
+
+func (f Frequency) String() string {
+	return frequencies[f]
+}
+
+var weekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Weekday is a BYDAY entry. N picks the nth such day of the month or year, counting from the end when negative,
+// and every such day when 0.
+type Weekday struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w Weekday) String() string {
+	if w.N == 0 {
+		return weekdays[w.Day]
+	}
+
+	return strconv.Itoa(w.N) + weekdays[w.Day]
+}
+
+// Rule is a parsed RRULE
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	// Count caps the occurrences, the start included, unless 0
+	Count int
+	// Until is the last moment an occurrence may fall on unless zero
+	Until time.Time
+	// UntilDate makes Until a date, in which case occurrences may fall on any time of that day
+	UntilDate  bool
+	ByMonth    []time.Month
+	ByMonthDay []int
+	ByDay      []Weekday
+	WeekStart  time.Weekday
+}
+
+// maxEmptyPeriods ends a rule whose parts hardly ever or never match, like the 30th of February
+const maxEmptyPeriods = 5000
+
+// Parse parses an RRULE value, with or without the "RRULE:" prefix.
+// The parts narrowing occurrences below a day, as well as BYSETPOS, BYWEEKNO and BYYEARDAY, are not supported.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty rule")
+	}
+
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given twice", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			freq := slices.Index(frequencies, value)
+			if freq < 0 {
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+			r.Freq = Frequency(freq)
+		case "INTERVAL":
+			r.Interval, err = parseInt(value, 1, 1000)
+		case "COUNT":
+			r.Count, err = parseInt(value, 1, 10000)
+		case "UNTIL":
+			r.Until, r.UntilDate, err = parseUntil(value)
+		case "BYMONTH":
+			err = eachValue(value, func(v string) error {
+				month, err := parseInt(v, 1, 12)
+				r.ByMonth = append(r.ByMonth, time.Month(month))
+				return err
+			})
+		case "BYMONTHDAY":
+			err = eachValue(value, func(v string) error {
+				day, err := parseOrdinal(v, 31)
+				r.ByMonthDay = append(r.ByMonthDay, day)
+				return err
+			})
+		case "BYDAY":
+			err = eachValue(value, func(v string) error {
+				day, err := parseWeekday(v)
+				r.ByDay = append(r.ByDay, day)
+				return err
+			})
+		case "WKST":
+			r.WeekStart, err = parseDay(value)
+		default:
+			return nil, fmt.Errorf("unsupported part %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if err := r.validate(seen); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Rule) validate(seen map[string]bool) error {
+	if !seen["FREQ"] {
+		return errors.New("FREQ is required")
+	}
+	if seen["COUNT"] && seen["UNTIL"] {
+		return errors.New("COUNT and UNTIL cannot be given together")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return errors.New("BYMONTHDAY cannot be given with FREQ=WEEKLY")
+	}
+
+	for _, day := range r.ByDay {
+		switch {
+		case day.N == 0:
+		case r.Freq != Monthly && r.Freq != Yearly:
+			return errors.New("BYDAY can only number days with FREQ=MONTHLY or FREQ=YEARLY")
+		case (r.Freq == Monthly || len(r.ByMonth) > 0) && (day.N > 5 || day.N < -5):
+			return fmt.Errorf("a month has no %s", day)
+		}
+	}
+
+	return nil
+}
+
+// String formats the rule with its parts in a fixed order, so that equal rules read the same
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq.String()}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+join(r.ByMonth, func(m time.Month) string { return strconv.Itoa(int(m)) }))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+join(r.ByMonthDay, strconv.Itoa))
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+join(r.ByDay, Weekday.String))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdays[r.WeekStart])
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.UntilDate {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	} else if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Iterator yields the occurrences of a rule in order
+type Iterator struct {
+	rule  *Rule
+	start time.Time
+	// period is the index of the next period to expand
+	period  int
+	pending []time.Time
+	emitted int
+	done    bool
+}
+
+// Iterate returns the occurrences of the rule from start, which is always the first of them.
+// The other occurrences take the time of day and the location of start.
+func (r *Rule) Iterate(start time.Time) *Iterator {
+	return &Iterator{rule: r, start: start}
+}
+
+// Next returns the next occurrence, or false when there are no more
+func (it *Iterator) Next() (time.Time, bool) {
+	if it.emitted == 0 && !it.done {
+		it.emitted++
+		return it.start, true
+	}
+
+	for !it.done {
+		if len(it.pending) == 0 {
+			it.expand()
+			continue
+		}
+
+		t := it.pending[0]
+		it.pending = it.pending[1:]
+		if !t.After(it.start) {
+			continue
+		}
+
+		if it.rule.Count > 0 && it.emitted >= it.rule.Count || it.afterUntil(t) {
+			it.done = true
+			break
+		}
+
+		it.emitted++
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// After returns up to n occurrences later than t
+func (r *Rule) After(start, t time.Time, n int) []time.Time {
+	occurrences := []time.Time{}
+	it := r.Iterate(start)
+	for len(occurrences) < n {
+		occurrence, ok := it.Next()
+		if !ok {
+			break
+		}
+		if occurrence.After(t) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	return occurrences
+}
+
+func (it *Iterator) afterUntil(t time.Time) bool {
+	switch {
+	case it.rule.UntilDate:
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).After(it.rule.Until)
+	case it.rule.Until.IsZero():
+		return false
+	default:
+		return t.After(it.rule.Until)
+	}
+}
+
+// expand queues the occurrences of the next period, giving up on rules that stopped matching
+func (it *Iterator) expand() {
+	for empty := 0; empty < maxEmptyPeriods; empty++ {
+		days := it.periodDays(it.period)
+		it.period++
+
+		if len(days) == 0 {
+			continue
+		}
+
+		h, m, s := it.start.Clock()
+		for _, day := range days {
+			it.pending = append(it.pending, time.Date(day.Year(), day.Month(), day.Day(), h, m, s, it.start.Nanosecond(), it.start.Location()))
+		}
+		return
+	}
+
+	it.done = true
+}
+
+// periodDays returns the sorted days of the nth period, as dates at midnight UTC
+func (it *Iterator) periodDays(n int) []time.Time {
+	r := it.rule
+	y, m, d := it.start.Date()
+	first := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	step := n * r.Interval
+
+	days := []time.Time{}
+	switch r.Freq {
+	case Daily:
+		day := first.AddDate(0, 0, step)
+		if r.inMonths(day) && r.onMonthDays(day) && r.onWeekdays(day) {
+			days = append(days, day)
+		}
+	case Weekly:
+		weekStart := first.AddDate(0, 0, -int((7+first.Weekday()-r.WeekStart)%7)+7*step)
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			if r.inMonths(day) && ((len(r.ByDay) == 0 && day.Weekday() == first.Weekday()) || (len(r.ByDay) > 0 && r.onWeekdays(day))) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		month := time.Date(y, m+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		if r.inMonths(month) {
+			days = r.monthDays(month, d)
+		}
+	case Yearly:
+		year := y + step
+		if len(r.ByDay) > 0 && len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 {
+			days = yearWeekdays(year, r.ByDay)
+			break
+		}
+
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{m}
+			if len(r.ByMonthDay) > 0 {
+				months = []time.Month{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), d)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return dedupe(days)
+}
+
+// monthDays returns the days of the month starting at month that the rule picks.
+// Without BYMONTHDAY and BYDAY that is the day of the start, when the month has it.
+func (r *Rule) monthDays(month time.Time, startDay int) []time.Time {
+	length := month.AddDate(0, 1, -1).Day()
+
+	days := []time.Time{}
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, n := range r.ByMonthDay {
+			if n < 0 {
+				n += length + 1
+			}
+			if n < 1 || n > length {
+				continue
+			}
+			day := month.AddDate(0, 0, n-1)
+			if r.onWeekdays(day) {
+				days = append(days, day)
+			}
+		}
+	case len(r.ByDay) > 0:
+		days = nthWeekdays(month, length, r.ByDay)
+	case startDay <= length:
+		days = append(days, month.AddDate(0, 0, startDay-1))
+	}
+
+	return days
+}
+
+// nthWeekdays returns the days among the length days from first that match one of weekdays,
+// with N counted within them
+func nthWeekdays(first time.Time, length int, weekdays []Weekday) []time.Time {
+	days := []time.Time{}
+	for _, w := range weekdays {
+		// the first and the last such day within the span
+		head := first.AddDate(0, 0, int((7+w.Day-first.Weekday())%7))
+		last := first.AddDate(0, 0, length-1)
+		tail := last.AddDate(0, 0, -int((7+last.Weekday()-w.Day)%7))
+
+		switch {
+		case w.N == 0:
+			for day := head; !day.After(tail); day = day.AddDate(0, 0, 7) {
+				days = append(days, day)
+			}
+		case w.N > 0:
+			if day := head.AddDate(0, 0, 7*(w.N-1)); !day.After(tail) {
+				days = append(days, day)
+			}
+		default:
+			if day := tail.AddDate(0, 0, 7*(w.N+1)); !day.Before(head) {
+				days = append(days, day)
+			}
+		}
+	}
+
+	return days
+}
+
+func yearWeekdays(year int, weekdays []Weekday) []time.Time {
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	length := first.AddDate(1, 0, 0).Sub(first).Hours() / 24
+
+	return nthWeekdays(first, int(length), weekdays)
+}
+
+func (r *Rule) inMonths(day time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+
+	for _, month := range r.ByMonth {
+		if day.Month() == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) onMonthDays(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+
+	length := day.AddDate(0, 1, -day.Day()).Day()
+	for _, n := range r.ByMonthDay {
+		if n == day.Day() || n < 0 && n+length+1 == day.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// onWeekdays ignores N, which only the expansion of months and years uses
+func (r *Rule) onWeekdays(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+
+	for _, w := range r.ByDay {
+		if day.Weekday() == w.Day {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupe(days []time.Time) []time.Time {
+	unique := days[:0]
+	for i, day := range days {
+		if i == 0 || !day.Equal(days[i-1]) {
+			unique = append(unique, day)
+		}
+	}
+
+	return unique
+}
+
+func parseInt(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q is not a number from %d to %d", s, min, max)
+	}
+
+	return n, nil
+}
+
+// parseOrdinal parses a number from 1 to max or from -max to -1
+func parseOrdinal(s string, max int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil || n == 0 || n > max || n < -max {
+		return 0, fmt.Errorf("%q is not a number from 1 to %d or from -%d to -1", s, max, max)
+	}
+
+	return n, nil
+}
+
+func parseDay(s string) (time.Weekday, error) {
+	day := slices.Index(weekdays, s)
+	if day < 0 {
+		return 0, fmt.Errorf("%q is not a weekday", s)
+	}
+
+	return time.Weekday(day), nil
+}
+
+func parseWeekday(s string) (Weekday, error) {
+	if len(s) < 2 {
+		return Weekday{}, fmt.Errorf("%q is not a weekday", s)
+	}
+
+	day, err := parseDay(s[len(s)-2:])
+	if err != nil {
+		return Weekday{}, err
+	}
+
+	w := Weekday{Day: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		if w.N, err = parseOrdinal(prefix, 53); err != nil {
+			return Weekday{}, err
+		}
+	}
+
+	return w, nil
+}
+
+func parseUntil(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102", s); err == nil {
+		return t, true, nil
+	}
+
+	// a local time is meaningless without the location of the start, which rules are parsed apart from
+	t, err := time.Parse("20060102T150405Z", s)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither a date nor a UTC time", s)
+	}
+
+	return t, false, nil
+}
+
+func eachValue(s string, parse func(string) error) error {
+	for _, v := range strings.Split(s, ",") {
+		if err := parse(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func join[T any](values []T, format func(T) string) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = format(v)
+	}
+
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,136 @@
+package rrule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIterate(t *testing.T) {
+	// a Monday
+	monday := time.Date(2024, time.January, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		rule  string
+		start time.Time
+		want  []string
+	}{
+		// DAILY
+		{"FREQ=DAILY", monday, []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04", "2024-01-05"}},
+		{"FREQ=DAILY;INTERVAL=3", monday, []string{"2024-01-01", "2024-01-04", "2024-01-07", "2024-01-10", "2024-01-13"}},
+		{"FREQ=DAILY;BYDAY=SA,SU", monday, []string{"2024-01-01", "2024-01-06", "2024-01-07", "2024-01-13", "2024-01-14"}},
+		{"FREQ=DAILY;BYMONTHDAY=1,15", monday, []string{"2024-01-01", "2024-01-15", "2024-02-01", "2024-02-15", "2024-03-01"}},
+		{"FREQ=DAILY;COUNT=3", monday, []string{"2024-01-01", "2024-01-02", "2024-01-03"}},
+		{"FREQ=DAILY;UNTIL=20240103", monday, []string{"2024-01-01", "2024-01-02", "2024-01-03"}},
+		{"FREQ=DAILY;UNTIL=20240103T090000Z", monday, []string{"2024-01-01", "2024-01-02"}},
+
+		// WEEKLY
+		{"FREQ=WEEKLY", monday, []string{"2024-01-01", "2024-01-08", "2024-01-15", "2024-01-22", "2024-01-29"}},
+		{"FREQ=WEEKLY;INTERVAL=2", monday, []string{"2024-01-01", "2024-01-15", "2024-01-29", "2024-02-12", "2024-02-26"}},
+		{"FREQ=WEEKLY;BYDAY=TU,TH", monday, []string{"2024-01-01", "2024-01-02", "2024-01-04", "2024-01-09", "2024-01-11"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH", monday, []string{"2024-01-01", "2024-01-02", "2024-01-04", "2024-01-16", "2024-01-18"}},
+		{"FREQ=WEEKLY;COUNT=2", monday, []string{"2024-01-01", "2024-01-08"}},
+		{"FREQ=WEEKLY;UNTIL=20240115", monday, []string{"2024-01-01", "2024-01-08", "2024-01-15"}},
+
+		// MONTHLY
+		{"FREQ=MONTHLY", monday, []string{"2024-01-01", "2024-02-01", "2024-03-01", "2024-04-01", "2024-05-01"}},
+		{"FREQ=MONTHLY", time.Date(2024, time.January, 31, 9, 30, 0, 0, time.UTC), []string{"2024-01-31", "2024-03-31", "2024-05-31", "2024-07-31", "2024-08-31"}},
+		{"FREQ=MONTHLY;INTERVAL=2", monday, []string{"2024-01-01", "2024-03-01", "2024-05-01", "2024-07-01", "2024-09-01"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", monday, []string{"2024-01-01", "2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"}},
+		{"FREQ=MONTHLY;BYDAY=2FR", monday, []string{"2024-01-01", "2024-01-12", "2024-02-09", "2024-03-08", "2024-04-12"}},
+		{"FREQ=MONTHLY;BYDAY=-1MO", monday, []string{"2024-01-01", "2024-01-29", "2024-02-26", "2024-03-25", "2024-04-29"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=13;BYDAY=FR", monday, []string{"2024-01-01", "2024-09-13", "2024-12-13", "2025-06-13", "2026-02-13"}},
+		{"FREQ=MONTHLY;COUNT=3", monday, []string{"2024-01-01", "2024-02-01", "2024-03-01"}},
+		{"FREQ=MONTHLY;UNTIL=20240301T093000Z", monday, []string{"2024-01-01", "2024-02-01", "2024-03-01"}},
+
+		// YEARLY
+		{"FREQ=YEARLY;COUNT=3", monday, []string{"2024-01-01", "2025-01-01", "2026-01-01"}},
+		{"FREQ=YEARLY;COUNT=3", time.Date(2024, time.February, 29, 9, 30, 0, 0, time.UTC), []string{"2024-02-29", "2028-02-29", "2032-02-29"}},
+		{"FREQ=YEARLY;INTERVAL=2;COUNT=3", monday, []string{"2024-01-01", "2026-01-01", "2028-01-01"}},
+		{"FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU;COUNT=4", monday, []string{"2024-01-01", "2024-03-31", "2025-03-30", "2026-03-29"}},
+		{"FREQ=YEARLY;BYDAY=20MO;COUNT=3", monday, []string{"2024-01-01", "2024-05-13", "2025-05-19"}},
+		{"FREQ=YEARLY;BYMONTHDAY=1;COUNT=3", monday, []string{"2024-01-01", "2024-02-01", "2024-03-01"}},
+		{"FREQ=YEARLY;UNTIL=20250101", monday, []string{"2024-01-01", "2025-01-01"}},
+
+		// parts that never match leave the start alone
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", monday, []string{"2024-01-01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			it := rule.Iterate(tt.start)
+			for len(got) < 5 {
+				occurrence, ok := it.Next()
+				if !ok {
+					break
+				}
+				if h, m, _ := occurrence.Clock(); h != 9 || m != 30 {
+					t.Errorf("occurrence %s does not keep the time of the start", occurrence)
+				}
+				got = append(got, occurrence.Format("2006-01-02"))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:byday=tu,th;freq=weekly", "FREQ=WEEKLY;BYDAY=TU,TH"},
+		{"FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=+1,-1;COUNT=10", "FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=10"},
+		{"FREQ=YEARLY;UNTIL=20300101T000000Z;BYMONTH=3;BYDAY=-1SU;WKST=SU", "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU;WKST=SU;UNTIL=20300101T000000Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := rule.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"FREQ",
+		"FREQ=",
+		"FREQ=HOURLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240101",
+		"FREQ=DAILY;UNTIL=20240101T090000",
+		"FREQ=DAILY;BYMONTH=13",
+		"FREQ=DAILY;BYMONTHDAY=0",
+		"FREQ=DAILY;BYMONTHDAY=32",
+		"FREQ=DAILY;BYDAY=XX",
+		"FREQ=DAILY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=DAILY;BYSETPOS=1",
+	} {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("%q parsed without an error", rule)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type (
+	// Term is a single search term parsed from a user query
+	Term struct {
+		Text   string
+		Prefix bool
+		Phrase bool
+	}
+
+	Query struct {
+		Terms []Term
+	}
+)
+
+// operators reserved by MySQL boolean full-text mode
+const booleanOperators = `+-<>()~*"@`
+
+// Parse splits a user query into terms.
+// `"foo bar"` becomes a phrase and `foo*` becomes a prefix term.
+// Operators at the edges of a word are dropped, and a word with operators inside, like `e-mail`, becomes a phrase.
+func Parse(q string) Query {
+	query := Query{}
+
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			var phrase string
+			if end < 0 {
+				phrase, rest = rest[1:], ""
+			} else {
+				phrase, rest = rest[1:end+1], rest[end+2:]
+			}
+
+			// a phrase is taken literally, as MySQL does not read operators between the quotes
+			if text := strings.Join(strings.Fields(phrase), " "); text != "" {
+				query.Terms = append(query.Terms, Term{Text: text, Phrase: true})
+			}
+			rest = strings.TrimSpace(rest)
+
+			continue
+		}
+
+		word := rest
+		if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+			word, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+		rest = strings.TrimSpace(rest)
+
+		text := sanitize(word)
+		if text == "" {
+			continue
+		}
+		if strings.ContainsAny(text, booleanOperators) {
+			// a quote would end the phrase, so it is the only operator not kept
+			text = strings.Join(strings.FieldsFunc(text, func(r rune) bool { return r == '"' }), " ")
+			query.Terms = append(query.Terms, Term{Text: text, Phrase: true})
+			continue
+		}
+		query.Terms = append(query.Terms, Term{Text: text, Prefix: strings.HasSuffix(word, "*")})
+	}
+
+	return query
+}
+
+// IsEmpty reports whether the query has no searchable terms
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0
+}
+
+// Boolean builds an expression for MATCH ... AGAINST (... IN BOOLEAN MODE).
+// Every term is required.
+func (q Query) Boolean() string {
+	parts := make([]string, 0, len(q.Terms))
+	for _, term := range q.Terms {
+		switch {
+		case term.Phrase:
+			parts = append(parts, `+"`+term.Text+`"`)
+		case term.Prefix:
+			parts = append(parts, "+"+term.Text+"*")
+		default:
+			parts = append(parts, "+"+term.Text)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Score rates how well text matches the query for stores without a full-text index.
+// Like the ngram index, terms match anywhere in the text regardless of case and every term is required.
+// It returns 0 when text does not match, otherwise the number of times the terms occur.
+func (q Query) Score(text string) float64 {
+	lower := strings.ToLower(text)
+
+	score := 0
+	for _, term := range q.Terms {
+		n := strings.Count(lower, strings.ToLower(term.Text))
+		if n == 0 {
+			return 0
+		}
+		score += n
+	}
+
+	return float64(score)
+}
+
+// Highlight returns text with every match of the query wrapped in <mark> tags.
+// The rest of the text is HTML escaped.
+func Highlight(text string, q Query) string {
+	src := []rune(text)
+	lower := make([]rune, len(src))
+	for i, r := range src {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	type span struct{ start, end int }
+	spans := []span{}
+	for _, term := range q.Terms {
+		needle := []rune(strings.ToLower(term.Text))
+		if len(needle) == 0 {
+			continue
+		}
+
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if equalRunes(lower[i:i+len(needle)], needle) {
+				spans = append(spans, span{i, i + len(needle)})
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	pos := 0
+	for i := 0; i < len(spans); i++ {
+		start, end := spans[i].start, spans[i].end
+		if start < pos {
+			start = pos
+		}
+		// merge overlapping or adjacent matches
+		for i+1 < len(spans) && spans[i+1].start <= end {
+			if spans[i+1].end > end {
+				end = spans[i+1].end
+			}
+			i++
+		}
+		if start >= end {
+			continue
+		}
+
+		b.WriteString(html.EscapeString(string(src[pos:start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(src[start:end])))
+		b.WriteString("</mark>")
+		pos = end
+	}
+	b.WriteString(html.EscapeString(string(src[pos:])))
+
+	return b.String()
+}
+
+// sanitize drops the boolean operators at the edges of a word
+func sanitize(word string) string {
+	return strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(booleanOperators, r)
+	})
+}
+
+func equalRunes(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,122 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []Term
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"report", []Term{{Text: "report"}}},
+		{"  weekly   report ", []Term{{Text: "weekly"}, {Text: "report"}}},
+		{"rep*", []Term{{Text: "rep", Prefix: true}}},
+
+		// operators are dropped at the edges of a word and make a phrase of it inside
+		{"+report -draft", []Term{{Text: "report"}, {Text: "draft"}}},
+		{"(report)", []Term{{Text: "report"}}},
+		{"foo*bar", []Term{{Text: "foo*bar", Phrase: true}}},
+		{"foo*bar*", []Term{{Text: "foo*bar", Phrase: true}}},
+		{"e-mail", []Term{{Text: "e-mail", Phrase: true}}},
+		{"-e-mail-", []Term{{Text: "e-mail", Phrase: true}}},
+		{"a@example.com", []Term{{Text: "a@example.com", Phrase: true}}},
+		{`foo"bar`, []Term{{Text: "foo bar", Phrase: true}}},
+		{"+-<>()~*@", nil},
+
+		// phrases
+		{`"weekly report"`, []Term{{Text: "weekly report", Phrase: true}}},
+		{`"  weekly   report  "`, []Term{{Text: "weekly report", Phrase: true}}},
+		{`"e-mail (draft)"`, []Term{{Text: "e-mail (draft)", Phrase: true}}},
+		{`"unclosed phrase`, []Term{{Text: "unclosed phrase", Phrase: true}}},
+		{`""`, nil},
+		{`fix "weekly report" now*`, []Term{{Text: "fix"}, {Text: "weekly report", Phrase: true}, {Text: "now", Prefix: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := Parse(tt.q).Terms; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"report", "report"},
+		{"+report", "report"},
+		{"report*", "report"},
+		{"~report)", "report"},
+		{"foo*bar", "foo*bar"},
+		{"(foo<>bar)", "foo<>bar"},
+		{`"foo"bar"`, `foo"bar`},
+		{"日本語-テスト", "日本語-テスト"},
+		{"***", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			if got := sanitize(tt.word); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoolean(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"report", "+report"},
+		{`rep* "weekly report"`, `+rep* +"weekly report"`},
+		{"e-mail", `+"e-mail"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := Parse(tt.q).Boolean(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text string
+		q    string
+		want string
+	}{
+		{"weekly report", "report", "weekly <mark>report</mark>"},
+		{"Weekly Report", "report", "Weekly <mark>Report</mark>"},
+		{"report after report", "report", "<mark>report</mark> after <mark>report</mark>"},
+		{"weekly report", "nothing", "weekly report"},
+
+		// overlapping and adjacent matches are merged
+		{"weekly report", "week kly", "<mark>weekly</mark> report"},
+		{"foobar", "foo bar", "<mark>foobar</mark>"},
+		{"foo*bar", "foo*bar", "<mark>foo*bar</mark>"},
+		{"e-mail or mail", "e-mail", "<mark>e-mail</mark> or mail"},
+
+		// the rest of the text is escaped
+		{"<b>report</b> & co", "report", "&lt;b&gt;<mark>report</mark>&lt;/b&gt; &amp; co"},
+		{"a<b", `"a<b"`, "<mark>a&lt;b</mark>"},
+		{"日本語のテスト", "テスト", "日本語の<mark>テスト</mark>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text+"/"+tt.q, func(t *testing.T) {
+			if got := Highlight(tt.text, Parse(tt.q)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+// CookiePolicy sets the attributes of the cookies the handlers issue
+type CookiePolicy struct {
+	Secure bool
+	// Domain shares the cookies with subdomains when set; by default they are host-only
+	Domain   string
+	SameSite http.SameSite
+}
+
+// CORSPolicy lists the browser origins allowed to call the API with credentials
+type CORSPolicy struct {
+	AllowOrigins []string
+	MaxAge       time.Duration
+}
+
+// Policy is the cookie, CORS and response header policy of the server
+type Policy struct {
+	Cookie CookiePolicy
+	CORS   CORSPolicy
+	// HSTSMaxAge enables Strict-Transport-Security when positive
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ContentSecurityPolicy, FrameOptions and ReferrerPolicy are left out of responses when empty
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+}
+
+var (
+	frameOptions     = []string{"DENY", "SAMEORIGIN"}
+	referrerPolicies = []string{
+		"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+		"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url",
+	}
+)
+
+// hstsPreloadMinAge is what browsers' preload lists ask for
+const hstsPreloadMinAge = 365 * 24 * time.Hour
+
+// DefaultPolicy suits an API served over HTTPS to a frontend on the same site
+func DefaultPolicy() Policy {
+	return Policy{
+		Cookie: CookiePolicy{
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		CORS: CORSPolicy{
+			MaxAge: 12 * time.Hour,
+		},
+		HSTSMaxAge:            180 * 24 * time.Hour,
+		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+	}
+}
+
+// Validate rejects settings that are malformed or that browsers would not honor together
+func (p Policy) Validate() error {
+	var errs []error
+
+	switch p.Cookie.SameSite {
+	case http.SameSiteLaxMode, http.SameSiteStrictMode:
+	case http.SameSiteNoneMode:
+		if !p.Cookie.Secure {
+			errs = append(errs, errors.New("SameSite=None cookies must be Secure"))
+		}
+	default:
+		errs = append(errs, errors.New("cookie SameSite must be Lax, Strict or None"))
+	}
+
+	if d := p.Cookie.Domain; d != "" && (strings.ContainsAny(d, ":/ ") || strings.Trim(d, ".") == "") {
+		errs = append(errs, fmt.Errorf("cookie domain %q must be a bare host name", d))
+	}
+
+	for _, origin := range p.CORS.AllowOrigins {
+		if err := validateOrigin(origin); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if p.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("HSTS max-age must not be negative"))
+	}
+	if p.HSTSMaxAge > 0 && !p.Cookie.Secure {
+		errs = append(errs, errors.New("HSTS promises HTTPS only, so cookies must be Secure"))
+	}
+	if p.HSTSIncludeSubdomains && p.HSTSMaxAge == 0 {
+		errs = append(errs, errors.New("HSTS includeSubDomains needs a max-age"))
+	}
+	if p.HSTSPreload && (!p.HSTSIncludeSubdomains || p.HSTSMaxAge < hstsPreloadMinAge) {
+		errs = append(errs, errors.New("HSTS preload needs includeSubDomains and a max-age of at least a year"))
+	}
+
+	if p.FrameOptions != "" && !slices.Contains(frameOptions, p.FrameOptions) {
+		errs = append(errs, fmt.Errorf("X-Frame-Options must be one of %s", strings.Join(frameOptions, ", ")))
+	}
+	if p.ReferrerPolicy != "" && !slices.Contains(referrerPolicies, p.ReferrerPolicy) {
+		errs = append(errs, fmt.Errorf("unknown Referrer-Policy %q", p.ReferrerPolicy))
+	}
+	if strings.ContainsAny(p.ContentSecurityPolicy, "\r\n") {
+		errs = append(errs, errors.New("Content-Security-Policy must be a single line"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateOrigin accepts scheme://host[:port]. "*" is refused since the API is called with credentials.
+func validateOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("CORS origin %q must be scheme://host[:port]", origin)
+	}
+
+	return nil
+}
+
+// Middleware sets the security headers on every response and answers CORS requests
+func Middleware(p Policy) gin.HandlerFunc {
+	headers := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+	}
+	if p.HSTSMaxAge > 0 {
+		v := "max-age=" + strconv.FormatInt(int64(p.HSTSMaxAge.Seconds()), 10)
+		if p.HSTSIncludeSubdomains {
+			v += "; includeSubDomains"
+		}
+		if p.HSTSPreload {
+			v += "; preload"
+		}
+		headers["Strict-Transport-Security"] = v
+	}
+	if p.ContentSecurityPolicy != "" {
+		headers["Content-Security-Policy"] = p.ContentSecurityPolicy
+	}
+	if p.FrameOptions != "" {
+		headers["X-Frame-Options"] = p.FrameOptions
+	}
+	if p.ReferrerPolicy != "" {
+		headers["Referrer-Policy"] = p.ReferrerPolicy
+	}
+
+	var corsHandler gin.HandlerFunc
+	if len(p.CORS.AllowOrigins) > 0 {
+		origins := make([]string, len(p.CORS.AllowOrigins))
+		for i, origin := range p.CORS.AllowOrigins {
+			origins[i] = strings.TrimSuffix(origin, "/")
+		}
+
+		corsHandler = cors.New(cors.Config{
+			AllowOrigins:     origins,
+			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Content-Type", "Authorization", "X-CSRF-Token"},
+			AllowCredentials: true,
+			MaxAge:           p.CORS.MaxAge,
+		})
+	}
+
+	return func(c *gin.Context) {
+		for k, v := range headers {
+			c.Header(k, v)
+		}
+
+		if corsHandler != nil {
+			corsHandler(c)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ParseSameSite reads lax, strict or none, in any case
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown SameSite %q", s)
+	}
+}
@@ -0,0 +1,164 @@
+package tlscert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// CertFile and KeyFile hold the PEM certificate chain and private key the server presents
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the PEM certificates client certificates are verified against.
+	// Mutual TLS is off when it is empty.
+	ClientCAFile string
+	// RequireClientCert refuses handshakes without a client certificate.
+	// Otherwise clients without one fall back to token authentication.
+	RequireClientCert bool
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration
+}
+
+// Enabled reports whether the server should serve HTTPS itself
+func (c Config) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// Reloader keeps the certificate and client CAs in step with their files,
+// so renewed certificates are picked up without a restart
+type Reloader struct {
+	mu        sync.RWMutex
+	config    Config
+	certPEM   []byte
+	keyPEM    []byte
+	caPEM     []byte
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewReloader loads the files in the configuration
+func NewReloader(config Config) (*Reloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+	if config.RequireClientCert && config.ClientCAFile == "" {
+		return nil, errors.New("requiring client certificates needs a client CA file")
+	}
+
+	r := &Reloader{config: config}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the files again and swaps in their contents when they changed.
+// What was loaded before stays in use when they cannot be parsed,
+// e.g. while only one of the certificate and key has been replaced yet.
+func (r *Reloader) Reload() error {
+	certPEM, err := os.ReadFile(r.config.CertFile)
+	if err != nil {
+		return fmt.Errorf("read certificate file: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("read key file: %w", err)
+	}
+
+	var caPEM []byte
+	if r.config.ClientCAFile != "" {
+		caPEM, err = os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client CA file: %w", err)
+		}
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) && bytes.Equal(caPEM, r.caPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("parse key pair: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if caPEM != nil {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return errors.New("no certificates found in client CA file")
+		}
+	}
+
+	r.mu.Lock()
+	r.certPEM, r.keyPEM, r.caPEM = certPEM, keyPEM, caPEM
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Run reloads the files until ctx is done
+func (r *Reloader) Run(ctx context.Context) {
+	interval := r.config.ReloadInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("reload tls certificate: %v", err)
+		}
+	}
+}
+
+// TLSConfig returns a server configuration that hands every handshake
+// the certificate and client CAs loaded last
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2", "http/1.1"},
+				Certificates: []tls.Certificate{*r.cert},
+			}
+
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.config.RequireClientCert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+
+			return config, nil
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	SearchUsersParams struct {
+		// Query matches part of the name or email, and everyone when it is empty
+		Query string
+		// Role narrows the result down to one role when it is not empty
+		Role   string
+		Limit  int
+		Offset int
+	}
+
+	TaskCounts struct {
+		Total int `db:"total"`
+		Done  int `db:"done"`
+	}
+)
+
+// SearchUsers lists users for the admin API, newest first
+func (r *Repository) SearchUsers(ctx context.Context, params SearchUsersParams) ([]User, error) {
+	query := "SELECT * FROM users WHERE 1 = 1"
+	args := []interface{}{}
+
+	if params.Query != "" {
+		pattern := "%" + escapeLike(params.Query) + "%"
+		query += " AND (name LIKE ? OR email LIKE ?)"
+		args = append(args, pattern, pattern)
+	}
+	if params.Role != "" {
+		query += " AND role = ?"
+		args = append(args, params.Role)
+	}
+
+	query += " ORDER BY created_at DESC, id LIMIT ? OFFSET ?"
+	args = append(args, params.Limit, params.Offset)
+
+	users := []User{}
+	if err := r.db.SelectContext(ctx, &users, query, args...); err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateRole returns ErrNotFound for an unknown user
+func (r *Repository) UpdateRole(ctx context.Context, userID uuid.UUID, role string) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("update user role: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// SetUserDisabled disables or enables a user. It returns ErrNotFound for an unknown user.
+func (r *Repository) SetUserDisabled(ctx context.Context, userID uuid.UUID, disabled bool) error {
+	query := "UPDATE users SET disabled_at = NULL WHERE id = ?"
+	args := []interface{}{userID}
+	if disabled {
+		query = "UPDATE users SET disabled_at = COALESCE(disabled_at, ?) WHERE id = ?"
+		args = []interface{}{time.Now(), userID}
+	}
+
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("update user disabled_at: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// RequirePasswordReset stops the user from signing in until the password is changed.
+// It returns ErrNotFound for an unknown user.
+func (r *Repository) RequirePasswordReset(ctx context.Context, userID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET password_reset_required = TRUE WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("update user password_reset_required: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+func (r *Repository) GetTaskCounts(ctx context.Context, userID uuid.UUID) (*TaskCounts, error) {
+	counts := &TaskCounts{}
+	if err := r.db.GetContext(ctx, counts, "SELECT COUNT(*) AS total, COALESCE(SUM(is_done), 0) AS done FROM tasks WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("count tasks: %w", err)
+	}
+
+	return counts, nil
+}
+
+// escapeLike makes s match literally in a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
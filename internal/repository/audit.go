@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// audit_logs table
+	AuditLog struct {
+		ID        int64          `db:"id"`
+		Event     string         `db:"event"`
+		UserID    sql.NullString `db:"user_id"`
+		ActorID   sql.NullString `db:"actor_id"`
+		IP        string         `db:"ip"`
+		Detail    string         `db:"detail"`
+		CreatedAt time.Time      `db:"created_at"`
+	}
+
+	CreateAuditLogParams struct {
+		Event string
+		// UserID is the user the event is about, if any
+		UserID uuid.UUID
+		// ActorID is who caused the event when it is not UserID
+		ActorID uuid.UUID
+		IP      string
+		Detail  string
+	}
+)
+
+func (r *Repository) CreateAuditLog(ctx context.Context, params CreateAuditLogParams) error {
+	query := "INSERT INTO audit_logs (event, user_id, actor_id, ip, detail) VALUES (?, ?, ?, ?, ?)"
+	if _, err := r.db.ExecContext(ctx, query, params.Event, nullUUID(params.UserID), nullUUID(params.ActorID), truncate(params.IP, 45), params.Detail); err != nil {
+		return fmt.Errorf("insert audit log: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditLogs returns the most recent entries about the user
+func (r *Repository) GetAuditLogs(ctx context.Context, userID uuid.UUID, limit int) ([]AuditLog, error) {
+	logs := []AuditLog{}
+	if err := r.db.SelectContext(ctx, &logs, "SELECT * FROM audit_logs WHERE user_id = ? ORDER BY id DESC LIMIT ?", userID, limit); err != nil {
+		return nil, fmt.Errorf("select audit logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// nullUUID stores uuid.Nil as NULL
+func nullUUID(id uuid.UUID) sql.NullString {
+	if id == uuid.Nil {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: id.String(), Valid: true}
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// client_certificates table
+	ClientCertificate struct {
+		ID         uuid.UUID    `db:"id"`
+		UserID     uuid.UUID    `db:"user_id"`
+		Subject    string       `db:"subject"`
+		Scopes     string       `db:"scopes"`
+		LastUsedAt sql.NullTime `db:"last_used_at"`
+		CreatedAt  time.Time    `db:"created_at"`
+	}
+
+	CreateClientCertificateParams struct {
+		UserID  uuid.UUID
+		Subject string
+		Scopes  []string
+	}
+)
+
+// ScopeList splits the space separated scopes column
+func (cc *ClientCertificate) ScopeList() []string {
+	return strings.Fields(cc.Scopes)
+}
+
+// CreateClientCertificate returns ErrAlreadyExists when the subject is mapped to a user already
+func (r *Repository) CreateClientCertificate(ctx context.Context, params CreateClientCertificateParams) (uuid.UUID, error) {
+	certificateID := uuid.New()
+
+	query := "INSERT INTO client_certificates (id, user_id, subject, scopes) VALUES (?, ?, ?, ?)"
+	if _, err := r.db.ExecContext(ctx, query, certificateID, params.UserID, params.Subject, strings.Join(params.Scopes, " ")); err != nil {
+		if isDuplicateEntry(err) {
+			return uuid.Nil, ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert client certificate: %w", err)
+	}
+
+	return certificateID, nil
+}
+
+func (r *Repository) GetClientCertificates(ctx context.Context, userID uuid.UUID) ([]ClientCertificate, error) {
+	certificates := []ClientCertificate{}
+	if err := r.db.SelectContext(ctx, &certificates, "SELECT * FROM client_certificates WHERE user_id = ? ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("select client certificates: %w", err)
+	}
+
+	return certificates, nil
+}
+
+// GetClientCertificateBySubject looks up who a verified certificate belongs to
+func (r *Repository) GetClientCertificateBySubject(ctx context.Context, subject string) (*ClientCertificate, error) {
+	certificate := &ClientCertificate{}
+	if err := r.db.GetContext(ctx, certificate, "SELECT * FROM client_certificates WHERE subject = ?", subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select client certificate: %w", err)
+	}
+
+	return certificate, nil
+}
+
+func (r *Repository) TouchClientCertificate(ctx context.Context, certificateID uuid.UUID) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE client_certificates SET last_used_at = ? WHERE id = ?", time.Now(), certificateID); err != nil {
+		return fmt.Errorf("touch client certificate: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteClientCertificate(ctx context.Context, userID uuid.UUID, certificateID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM client_certificates WHERE id = ? AND user_id = ?", certificateID, userID)
+	if err != nil {
+		return fmt.Errorf("delete client certificate: %w", err)
+	}
+
+	return checkAffected(result)
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// user_identities table
+	UserIdentity struct {
+		Provider  string         `db:"provider"`
+		Subject   string         `db:"subject"`
+		UserID    uuid.UUID      `db:"user_id"`
+		Email     sql.NullString `db:"email"`
+		CreatedAt time.Time      `db:"created_at"`
+	}
+
+	CreateUserWithIdentityParams struct {
+		Name     string
+		Provider string
+		Subject  string
+		Email    string
+	}
+)
+
+// GetUserIdentity returns ErrNotFound when the external account is not linked to a user
+func (r *Repository) GetUserIdentity(ctx context.Context, provider string, subject string) (*UserIdentity, error) {
+	identity := &UserIdentity{}
+	if err := r.db.GetContext(ctx, identity, "SELECT * FROM user_identities WHERE provider = ? AND subject = ?", provider, subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select user identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// CreateUserWithIdentity creates a user who signs in through provider.
+// The password is random and unknown, so it has to be reset before it can be used.
+// It returns ErrAlreadyExists when the name is taken or the identity is already linked.
+func (r *Repository) CreateUserWithIdentity(ctx context.Context, params CreateUserWithIdentityParams) (uuid.UUID, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return uuid.Nil, fmt.Errorf("generate password: %w", err)
+	}
+
+	hashed, err := r.passwords.Hash(base64.RawStdEncoding.EncodeToString(b))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID := uuid.New()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO users (id, name, password) VALUES (?, ?, ?)", userID, params.Name, hashed); err != nil {
+		if isDuplicateEntry(err) {
+			return uuid.Nil, ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert user: %w", err)
+	}
+
+	if err := insertInbox(ctx, tx, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	email := sql.NullString{String: params.Email, Valid: params.Email != ""}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO user_identities (provider, subject, user_id, email) VALUES (?, ?, ?, ?)", params.Provider, params.Subject, userID, email); err != nil {
+		if isDuplicateEntry(err) {
+			return uuid.Nil, ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert user identity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrUnknownLabel is returned when a task is given a label that does not exist or belongs to another user
+var ErrUnknownLabel = errors.New("unknown label")
+
+type (
+	// labels table
+	Label struct {
+		ID     uuid.UUID `db:"id"`
+		UserID uuid.UUID `db:"user_id"`
+		Name   string    `db:"name"`
+		// Color is like "#1e90ff"
+		Color     string    `db:"color"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+
+	CreateLabelParams struct {
+		UserID uuid.UUID
+		Name   string
+		Color  string
+	}
+
+	UpdateLabelParams struct {
+		ID     uuid.UUID
+		UserID uuid.UUID
+		Name   string
+		Color  string
+	}
+
+	// MergeLabelsParams merges the source label into the target one
+	MergeLabelsParams struct {
+		UserID   uuid.UUID
+		SourceID uuid.UUID
+		TargetID uuid.UUID
+	}
+
+	// taskLabel is a row of task_labels joined with its label
+	taskLabel struct {
+		TaskID uuid.UUID `db:"task_id"`
+		Label
+	}
+)
+
+// GetLabels lists the user's labels by name
+func (r *Repository) GetLabels(ctx context.Context, userID uuid.UUID) ([]Label, error) {
+	labels := []Label{}
+	if err := r.db.SelectContext(ctx, &labels, "SELECT * FROM labels WHERE user_id = ? ORDER BY name, id", userID); err != nil {
+		return nil, fmt.Errorf("select labels: %w", err)
+	}
+
+	return labels, nil
+}
+
+// GetLabel returns ErrNotFound unless the label exists and belongs to userID
+func (r *Repository) GetLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) (*Label, error) {
+	label := &Label{}
+	if err := r.db.GetContext(ctx, label, "SELECT * FROM labels WHERE id = ? AND user_id = ?", labelID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select label: %w", err)
+	}
+
+	return label, nil
+}
+
+// CreateLabel returns ErrAlreadyExists when the user has a label of the name
+func (r *Repository) CreateLabel(ctx context.Context, params CreateLabelParams) (uuid.UUID, error) {
+	labelID := uuid.New()
+	if _, err := r.db.ExecContext(ctx, "INSERT INTO labels (id, user_id, name, color) VALUES (?, ?, ?, ?)", labelID, params.UserID, params.Name, params.Color); err != nil {
+		if isDuplicateEntry(err) {
+			return uuid.Nil, ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert label: %w", err)
+	}
+
+	return labelID, nil
+}
+
+// UpdateLabel renames and recolors the label on every task it is on at once.
+// It returns ErrAlreadyExists when the user has another label of the name.
+func (r *Repository) UpdateLabel(ctx context.Context, params UpdateLabelParams) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE labels SET name = ?, color = ? WHERE id = ? AND user_id = ?", params.Name, params.Color, params.ID, params.UserID)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("update label: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteLabel takes the label off every task it is on
+func (r *Repository) DeleteLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM labels WHERE id = ? AND user_id = ?", labelID, userID)
+	if err != nil {
+		return fmt.Errorf("delete label: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// MergeLabels puts the target label on every task with the source label and deletes the source label, all at once.
+// It returns ErrNotFound unless both labels belong to the user.
+func (r *Repository) MergeLabels(ctx context.Context, params MergeLabelsParams) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// locking both labels keeps a concurrent merge or delete from pulling either out from under the relabeling
+	labelIDs := []uuid.UUID{}
+	query := "SELECT id FROM labels WHERE user_id = ? AND id IN (?, ?) ORDER BY id FOR UPDATE"
+	if err := tx.SelectContext(ctx, &labelIDs, query, params.UserID, params.SourceID, params.TargetID); err != nil {
+		return fmt.Errorf("select labels: %w", err)
+	}
+	if len(labelIDs) != 2 {
+		return ErrNotFound
+	}
+
+	// tasks with both labels keep the one link they have to the target
+	query = "INSERT IGNORE INTO task_labels (task_id, label_id) SELECT task_id, ? FROM task_labels WHERE label_id = ?"
+	if _, err := tx.ExecContext(ctx, query, params.TargetID, params.SourceID); err != nil {
+		return fmt.Errorf("relabel tasks: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM labels WHERE id = ?", params.SourceID); err != nil {
+		return fmt.Errorf("delete label: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// setTaskLabels replaces the labels on the task.
+// The labels are locked so that a concurrent delete or merge waits for the tx instead of failing it.
+func setTaskLabels(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, taskID uuid.UUID, labelIDs []uuid.UUID) error {
+	if len(labelIDs) > 0 {
+		query, args, err := sqlx.In("SELECT id FROM labels WHERE user_id = ? AND id IN (?) ORDER BY id FOR SHARE", userID, labelIDs)
+		if err != nil {
+			return fmt.Errorf("build query: %w", err)
+		}
+
+		found := []uuid.UUID{}
+		if err := tx.SelectContext(ctx, &found, query, args...); err != nil {
+			return fmt.Errorf("select labels: %w", err)
+		}
+		if len(found) != len(labelIDs) {
+			return ErrUnknownLabel
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM task_labels WHERE task_id = ?", taskID); err != nil {
+		return fmt.Errorf("delete task labels: %w", err)
+	}
+
+	for _, labelID := range labelIDs {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO task_labels (task_id, label_id) VALUES (?, ?)", taskID, labelID); err != nil {
+			return fmt.Errorf("insert task label: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// loadLabels sets the labels of the tasks, sorted by name
+func loadLabels(ctx context.Context, q sqlx.QueryerContext, tasks []*Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*Task, len(tasks))
+	taskIDs := make([]uuid.UUID, len(tasks))
+	for i, task := range tasks {
+		task.Labels = []Label{}
+		byID[task.ID] = task
+		taskIDs[i] = task.ID
+	}
+
+	query, args, err := sqlx.In(`SELECT tl.task_id, l.* FROM task_labels tl JOIN labels l ON l.id = tl.label_id
+		WHERE tl.task_id IN (?) ORDER BY l.name, l.id`, taskIDs)
+	if err != nil {
+		return fmt.Errorf("build query: %w", err)
+	}
+
+	rows := []taskLabel{}
+	if err := sqlx.SelectContext(ctx, q, &rows, query, args...); err != nil {
+		return fmt.Errorf("select task labels: %w", err)
+	}
+	for _, row := range rows {
+		task := byID[row.TaskID]
+		task.Labels = append(task.Labels, row.Label)
+	}
+
+	return nil
+}
@@ -0,0 +1,997 @@
+package memory
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/google/uuid"
+)
+
+// accounts keeps what the AccountStore methods work on. Hashed tokens are keyed by string(hash).
+type accounts struct {
+	sessions      map[uuid.UUID]*repository.Session
+	refreshTokens map[string]*repository.RefreshToken
+	totps         map[uuid.UUID]*repository.UserTOTP
+	recoveryCodes map[string]*recoveryCode
+	accessTokens  map[uuid.UUID]*repository.PersonalAccessToken
+	userTokens    map[string]*repository.UserToken
+	identities    map[identityKey]*repository.UserIdentity
+	oauthClients  map[uuid.UUID]*repository.OAuthClient
+	oauthCodes    map[string]*repository.OAuthCode
+	oauthGrants   map[uuid.UUID]*repository.OAuthGrant
+	certificates  map[uuid.UUID]*repository.ClientCertificate
+	throttles     map[string]*repository.AuthThrottle
+	auditLogs     []repository.AuditLog
+}
+
+type recoveryCode struct {
+	userID uuid.UUID
+	used   bool
+}
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+func newAccounts() accounts {
+	return accounts{
+		sessions:      map[uuid.UUID]*repository.Session{},
+		refreshTokens: map[string]*repository.RefreshToken{},
+		totps:         map[uuid.UUID]*repository.UserTOTP{},
+		recoveryCodes: map[string]*recoveryCode{},
+		accessTokens:  map[uuid.UUID]*repository.PersonalAccessToken{},
+		userTokens:    map[string]*repository.UserToken{},
+		identities:    map[identityKey]*repository.UserIdentity{},
+		oauthClients:  map[uuid.UUID]*repository.OAuthClient{},
+		oauthCodes:    map[string]*repository.OAuthCode{},
+		oauthGrants:   map[uuid.UUID]*repository.OAuthGrant{},
+		certificates:  map[uuid.UUID]*repository.ClientCertificate{},
+		throttles:     map[string]*repository.AuthThrottle{},
+	}
+}
+
+func (s *Store) CreateSession(ctx context.Context, params repository.CreateSessionParams) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessionID := uuid.New()
+	s.sessions[sessionID] = &repository.Session{
+		ID:         sessionID,
+		UserID:     params.UserID,
+		UserAgent:  params.UserAgent,
+		IP:         params.IP,
+		ExpiresAt:  toSecond(sql.NullTime{Time: params.ExpiresAt, Valid: true}).Time,
+		LastSeenAt: now(),
+		CreatedAt:  now(),
+	}
+	s.insertRefreshToken(sessionID, params.RefreshToken)
+
+	return sessionID, nil
+}
+
+func (s *Store) GetSession(ctx context.Context, sessionID uuid.UUID) (*repository.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	ss := *session
+	return &ss, nil
+}
+
+// GetSessionByRefreshToken finds the session a refresh token was issued for, whether or not the token was exchanged since.
+// It returns ErrNotFound for an unknown token.
+func (s *Store) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*repository.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.refreshTokens[string(repository.HashToken(refreshToken))]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	ss := *s.sessions[token.SessionID]
+	return &ss, nil
+}
+
+// GetActiveSessions lists the sessions of the user that can still be used, most recently seen first
+func (s *Store) GetActiveSessions(ctx context.Context, userID uuid.UUID) ([]repository.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := []repository.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.IsActive(time.Now()) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+
+	return sessions, nil
+}
+
+// TouchSession records that the session was used from ip
+func (s *Store) TouchSession(ctx context.Context, sessionID uuid.UUID, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[sessionID]; ok {
+		session.LastSeenAt = now()
+		session.IP = ip
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges an unused refresh token for NewRefreshToken.
+// Presenting a token that was already exchanged revokes the whole session.
+func (s *Store) RotateRefreshToken(ctx context.Context, params repository.RotateRefreshTokenParams) (*repository.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[string(repository.HashToken(params.RefreshToken))]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	session := s.sessions[token.SessionID]
+	if token.UsedAt.Valid {
+		revoke(&session.RevokedAt)
+		return nil, repository.ErrRefreshTokenReused
+	}
+
+	if !session.IsActive(time.Now()) {
+		return nil, repository.ErrSessionRevoked
+	}
+
+	token.UsedAt = sql.NullTime{Time: now(), Valid: true}
+	s.insertRefreshToken(session.ID, params.NewRefreshToken)
+	session.LastSeenAt = now()
+
+	ss := *session
+	return &ss, nil
+}
+
+// RevokeSession signs the session out. Revoking it twice is not an error.
+func (s *Store) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || session.UserID != userID {
+		return repository.ErrNotFound
+	}
+	revoke(&session.RevokedAt)
+
+	return nil
+}
+
+// RevokeSessionByRefreshToken signs out the session a refresh token was issued for.
+// It returns ErrNotFound for an unknown token. A token that was already exchanged revokes the session too,
+// as in RotateRefreshToken, but returns ErrRefreshTokenReused.
+func (s *Store) RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[string(repository.HashToken(refreshToken))]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	revoke(&s.sessions[token.SessionID].RevokedAt)
+
+	if token.UsedAt.Valid {
+		return repository.ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except keepID and returns how many sessions were revoked
+func (s *Store) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ID != keepID && !session.RevokedAt.Valid {
+			revoke(&session.RevokedAt)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Store) GetTOTP(ctx context.Context, userID uuid.UUID) (*repository.UserTOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	totp, ok := s.totps[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	t := *totp
+	t.Secret = append([]byte(nil), totp.Secret...)
+	return &t, nil
+}
+
+// SetPendingTOTP stores a secret waiting for confirmation, replacing an earlier unconfirmed one.
+// It returns ErrAlreadyExists when two-factor authentication is already enabled.
+func (s *Store) SetPendingTOTP(ctx context.Context, params repository.SetTOTPParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if totp, ok := s.totps[params.UserID]; ok && totp.ConfirmedAt.Valid {
+		return repository.ErrAlreadyExists
+	}
+
+	s.totps[params.UserID] = &repository.UserTOTP{
+		UserID:    params.UserID,
+		Secret:    append([]byte(nil), params.Secret...),
+		CreatedAt: now(),
+	}
+
+	return nil
+}
+
+// UseTOTPStep records a verified code so the same or an earlier one is not accepted again.
+// It returns ErrNotFound when the step was already used.
+func (s *Store) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userID]
+	if !ok || totp.LastUsedStep >= step {
+		return repository.ErrNotFound
+	}
+	totp.LastUsedStep = step
+
+	return nil
+}
+
+// ConfirmTOTP enables two-factor authentication and replaces the recovery codes
+func (s *Store) ConfirmTOTP(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userID]
+	if !ok || totp.ConfirmedAt.Valid {
+		return repository.ErrNotFound
+	}
+	totp.ConfirmedAt = sql.NullTime{Time: now(), Valid: true}
+	s.replaceRecoveryCodes(userID, recoveryCodes)
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates every previous recovery code
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userID, recoveryCodes)
+
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns ErrNotFound when there is none.
+func (s *Store) UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.recoveryCodes[string(repository.HashToken(code))]
+	if !ok || c.userID != userID || c.used {
+		return repository.ErrNotFound
+	}
+	c.used = true
+
+	return nil
+}
+
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, c := range s.recoveryCodes {
+		if c.userID == userID && !c.used {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// DeleteTOTP disables two-factor authentication
+func (s *Store) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userID, nil)
+	delete(s.totps, userID)
+
+	return nil
+}
+
+func (s *Store) CreatePersonalAccessToken(ctx context.Context, params repository.CreatePersonalAccessTokenParams) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expiresAt sql.NullTime
+	if params.ExpiresAt != nil {
+		expiresAt = toSecond(sql.NullTime{Time: *params.ExpiresAt, Valid: true})
+	}
+
+	tokenID := uuid.New()
+	s.accessTokens[tokenID] = &repository.PersonalAccessToken{
+		ID:        tokenID,
+		UserID:    params.UserID,
+		Name:      params.Name,
+		TokenHash: repository.HashToken(params.Token),
+		Scopes:    strings.Join(params.Scopes, " "),
+		ExpiresAt: expiresAt,
+		CreatedAt: now(),
+	}
+
+	return tokenID, nil
+}
+
+func (s *Store) GetPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]repository.PersonalAccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := []repository.PersonalAccessToken{}
+	for _, token := range s.accessTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, *token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+
+	return tokens, nil
+}
+
+// GetPersonalAccessTokenByToken looks a token up by its secret value
+func (s *Store) GetPersonalAccessTokenByToken(ctx context.Context, token string) (*repository.PersonalAccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := repository.HashToken(token)
+	for _, pat := range s.accessTokens {
+		if subtle.ConstantTimeCompare(pat.TokenHash, hash) == 1 {
+			t := *pat
+			return &t, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+func (s *Store) TouchPersonalAccessToken(ctx context.Context, tokenID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.accessTokens[tokenID]; ok {
+		token.LastUsedAt = sql.NullTime{Time: now(), Valid: true}
+	}
+
+	return nil
+}
+
+func (s *Store) DeletePersonalAccessToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.accessTokens[tokenID]
+	if !ok || token.UserID != userID {
+		return repository.ErrNotFound
+	}
+	delete(s.accessTokens, tokenID)
+
+	return nil
+}
+
+// DeletePersonalAccessTokens deletes every token of the user
+func (s *Store) DeletePersonalAccessTokens(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.accessTokens {
+		if token.UserID == userID {
+			delete(s.accessTokens, id)
+		}
+	}
+
+	return nil
+}
+
+// CreateUserToken stores a single-use token and retires the user's earlier ones of the same purpose.
+// It returns ErrAlreadyExists while the previous token is younger than the cooldown.
+func (s *Store) CreateUserToken(ctx context.Context, params repository.CreateUserTokenParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.userTokens {
+		if token.UserID == params.UserID && token.Purpose == params.Purpose && time.Since(token.CreatedAt) < params.Cooldown {
+			return repository.ErrAlreadyExists
+		}
+	}
+
+	for _, token := range s.userTokens {
+		if token.UserID == params.UserID && token.Purpose == params.Purpose && !token.UsedAt.Valid {
+			token.UsedAt = sql.NullTime{Time: now(), Valid: true}
+		}
+	}
+
+	hash := repository.HashToken(params.Token)
+	s.userTokens[string(hash)] = &repository.UserToken{
+		TokenHash: hash,
+		UserID:    params.UserID,
+		Purpose:   params.Purpose,
+		Email:     params.Email,
+		ExpiresAt: toSecond(sql.NullTime{Time: params.ExpiresAt, Valid: true}).Time,
+		CreatedAt: now(),
+	}
+
+	return nil
+}
+
+// GetUserToken looks up a usable token without redeeming it.
+// It returns ErrNotFound for an unknown, used or expired token.
+func (s *Store) GetUserToken(ctx context.Context, purpose string, token string) (*repository.UserToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.userTokens[string(repository.HashToken(token))]
+	if !ok || t.Purpose != purpose || !t.IsUsable(time.Now()) {
+		return nil, repository.ErrNotFound
+	}
+
+	ut := *t
+	return &ut, nil
+}
+
+// UseUserToken redeems a token. Only one caller succeeds; the rest get ErrNotFound.
+func (s *Store) UseUserToken(ctx context.Context, purpose string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.userTokens[string(repository.HashToken(token))]
+	if !ok || t.Purpose != purpose || !t.IsUsable(time.Now()) {
+		return repository.ErrNotFound
+	}
+	t.UsedAt = sql.NullTime{Time: now(), Valid: true}
+
+	return nil
+}
+
+func (s *Store) CreateOAuthClient(ctx context.Context, params repository.CreateOAuthClientParams) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var secretHash []byte
+	if params.Secret != "" {
+		secretHash = repository.HashToken(params.Secret)
+	}
+
+	clientID := uuid.New()
+	s.oauthClients[clientID] = &repository.OAuthClient{
+		ID:           clientID,
+		UserID:       params.UserID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectURIs: strings.Join(params.RedirectURIs, " "),
+		Scopes:       strings.Join(params.Scopes, " "),
+		CreatedAt:    now(),
+	}
+
+	return clientID, nil
+}
+
+// GetOAuthClient returns ErrNotFound for an unknown client
+func (s *Store) GetOAuthClient(ctx context.Context, clientID uuid.UUID) (*repository.OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.oauthClients[clientID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	c := *client
+	return &c, nil
+}
+
+// GetOAuthClients lists the clients a user registered
+func (s *Store) GetOAuthClients(ctx context.Context, userID uuid.UUID) ([]repository.OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := []repository.OAuthClient{}
+	for _, client := range s.oauthClients {
+		if client.UserID == userID {
+			clients = append(clients, *client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.After(clients[j].CreatedAt) })
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes a client with its codes and grants. It returns ErrNotFound unless userID registered it.
+func (s *Store) DeleteOAuthClient(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.oauthClients[clientID]
+	if !ok || client.UserID != userID {
+		return repository.ErrNotFound
+	}
+	s.deleteOAuthClient(clientID)
+
+	return nil
+}
+
+func (s *Store) CreateOAuthCode(ctx context.Context, params repository.CreateOAuthCodeParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := repository.HashToken(params.Code)
+	s.oauthCodes[string(hash)] = &repository.OAuthCode{
+		CodeHash:      hash,
+		ClientID:      params.ClientID,
+		UserID:        params.UserID,
+		RedirectURI:   params.RedirectURI,
+		Scopes:        strings.Join(params.Scopes, " "),
+		CodeChallenge: params.CodeChallenge,
+		ExpiresAt:     toSecond(sql.NullTime{Time: params.ExpiresAt, Valid: true}).Time,
+		CreatedAt:     now(),
+	}
+
+	return nil
+}
+
+// GetOAuthCode looks a code up without redeeming it. It returns ErrNotFound for an unknown code.
+func (s *Store) GetOAuthCode(ctx context.Context, code string) (*repository.OAuthCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.oauthCodes[string(repository.HashToken(code))]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	oc := *c
+	return &oc, nil
+}
+
+// RedeemOAuthCode exchanges a code for a new grant holding its scopes.
+// It returns ErrNotFound for an unknown or expired code or one issued to another client,
+// and ErrOAuthCodeReused, after revoking the grant, when the code was redeemed before.
+func (s *Store) RedeemOAuthCode(ctx context.Context, params repository.RedeemOAuthCodeParams) (*repository.OAuthGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.oauthCodes[string(repository.HashToken(params.Code))]
+	if !ok || code.ClientID != params.ClientID {
+		return nil, repository.ErrNotFound
+	}
+
+	if code.UsedAt.Valid {
+		if code.GrantID.Valid {
+			if grant, ok := s.oauthGrants[uuid.MustParse(code.GrantID.String)]; ok {
+				revoke(&grant.RevokedAt)
+			}
+		}
+		return nil, repository.ErrOAuthCodeReused
+	}
+
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, repository.ErrNotFound
+	}
+
+	grant := &repository.OAuthGrant{
+		ID:               uuid.New(),
+		ClientID:         code.ClientID,
+		UserID:           code.UserID,
+		Scopes:           code.Scopes,
+		RefreshTokenHash: repository.HashToken(params.RefreshToken),
+		ExpiresAt:        toSecond(sql.NullTime{Time: params.ExpiresAt, Valid: true}).Time,
+		CreatedAt:        now(),
+	}
+	s.oauthGrants[grant.ID] = grant
+
+	code.UsedAt = sql.NullTime{Time: now(), Valid: true}
+	code.GrantID = sql.NullString{String: grant.ID.String(), Valid: true}
+
+	g := *grant
+	return &g, nil
+}
+
+// GetOAuthGrant returns ErrNotFound for an unknown grant
+func (s *Store) GetOAuthGrant(ctx context.Context, grantID uuid.UUID) (*repository.OAuthGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant, ok := s.oauthGrants[grantID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	g := *grant
+	return &g, nil
+}
+
+// GetOAuthGrantByRefreshToken returns ErrNotFound for an unknown refresh token
+func (s *Store) GetOAuthGrantByRefreshToken(ctx context.Context, refreshToken string) (*repository.OAuthGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant := s.grantByRefreshToken(refreshToken)
+	if grant == nil {
+		return nil, repository.ErrNotFound
+	}
+
+	g := *grant
+	return &g, nil
+}
+
+// RotateOAuthRefreshToken replaces the refresh token of an active grant held by the client.
+// It returns ErrNotFound when there is no such grant, including for a refresh token already rotated.
+func (s *Store) RotateOAuthRefreshToken(ctx context.Context, params repository.RotateOAuthRefreshTokenParams) (*repository.OAuthGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant := s.grantByRefreshToken(params.RefreshToken)
+	if grant == nil || grant.ClientID != params.ClientID || !grant.IsActive(time.Now()) {
+		return nil, repository.ErrNotFound
+	}
+	grant.RefreshTokenHash = repository.HashToken(params.NewRefreshToken)
+
+	g := *grant
+	return &g, nil
+}
+
+// RevokeOAuthGrant ends a grant. Revoking it twice is not an error.
+func (s *Store) RevokeOAuthGrant(ctx context.Context, grantID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if grant, ok := s.oauthGrants[grantID]; ok {
+		revoke(&grant.RevokedAt)
+	}
+
+	return nil
+}
+
+// RevokeOAuthGrants ends every grant the user gave, and drops the codes not yet redeemed for one
+func (s *Store) RevokeOAuthGrants(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, code := range s.oauthCodes {
+		if code.UserID == userID && !code.UsedAt.Valid {
+			delete(s.oauthCodes, hash)
+		}
+	}
+	for _, grant := range s.oauthGrants {
+		if grant.UserID == userID {
+			revoke(&grant.RevokedAt)
+		}
+	}
+
+	return nil
+}
+
+// CreateClientCertificate returns ErrAlreadyExists when the subject is mapped to a user already
+func (s *Store) CreateClientCertificate(ctx context.Context, params repository.CreateClientCertificateParams) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.certificateBySubject(params.Subject) != nil {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	certificateID := uuid.New()
+	s.certificates[certificateID] = &repository.ClientCertificate{
+		ID:        certificateID,
+		UserID:    params.UserID,
+		Subject:   params.Subject,
+		Scopes:    strings.Join(params.Scopes, " "),
+		CreatedAt: now(),
+	}
+
+	return certificateID, nil
+}
+
+func (s *Store) GetClientCertificates(ctx context.Context, userID uuid.UUID) ([]repository.ClientCertificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	certificates := []repository.ClientCertificate{}
+	for _, certificate := range s.certificates {
+		if certificate.UserID == userID {
+			certificates = append(certificates, *certificate)
+		}
+	}
+	sort.Slice(certificates, func(i, j int) bool { return certificates[i].CreatedAt.After(certificates[j].CreatedAt) })
+
+	return certificates, nil
+}
+
+// GetClientCertificateBySubject looks up who a verified certificate belongs to
+func (s *Store) GetClientCertificateBySubject(ctx context.Context, subject string) (*repository.ClientCertificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	certificate := s.certificateBySubject(subject)
+	if certificate == nil {
+		return nil, repository.ErrNotFound
+	}
+
+	c := *certificate
+	return &c, nil
+}
+
+func (s *Store) TouchClientCertificate(ctx context.Context, certificateID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if certificate, ok := s.certificates[certificateID]; ok {
+		certificate.LastUsedAt = sql.NullTime{Time: now(), Valid: true}
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteClientCertificate(ctx context.Context, userID uuid.UUID, certificateID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certificate, ok := s.certificates[certificateID]
+	if !ok || certificate.UserID != userID {
+		return repository.ErrNotFound
+	}
+	delete(s.certificates, certificateID)
+
+	return nil
+}
+
+// GetAuthThrottle returns ErrNotFound when the key has no recorded failures
+func (s *Store) GetAuthThrottle(ctx context.Context, key string) (*repository.AuthThrottle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	throttle, ok := s.throttles[key]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	t := *throttle
+	return &t, nil
+}
+
+// ReserveAuthAttempt counts an attempt against the key before its credentials are checked.
+// It returns ErrThrottled while attempts are refused, and the updated row otherwise.
+func (s *Store) ReserveAuthAttempt(ctx context.Context, params repository.ReserveAuthAttemptParams) (*repository.AuthThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := now()
+	throttle, ok := s.throttles[params.Key]
+	if !ok {
+		throttle = &repository.AuthThrottle{Key: params.Key, LastFailureAt: now}
+	}
+
+	if err := throttle.Reserve(params, now); err != nil {
+		return nil, err
+	}
+	s.throttles[params.Key] = throttle
+
+	t := *throttle
+	return &t, nil
+}
+
+// ReleaseAuthAttempt takes back an attempt ReserveAuthAttempt counted once it turns out not to have failed
+func (s *Store) ReleaseAuthAttempt(ctx context.Context, reserved *repository.AuthThrottle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the key may have been reset since
+	if throttle, ok := s.throttles[reserved.Key]; ok {
+		throttle.Release(reserved)
+	}
+
+	return nil
+}
+
+// LockAuthThrottle refuses attempts for the key until the given time
+func (s *Store) LockAuthThrottle(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if throttle, ok := s.throttles[key]; ok {
+		throttle.LockedUntil = toSecond(sql.NullTime{Time: until, Valid: true})
+	}
+
+	return nil
+}
+
+// ResetAuthThrottle forgets the failures of the key
+func (s *Store) ResetAuthThrottle(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.throttles, key)
+
+	return nil
+}
+
+func (s *Store) CreateAuditLog(ctx context.Context, params repository.CreateAuditLogParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auditLogs = append(s.auditLogs, repository.AuditLog{
+		ID:        int64(len(s.auditLogs) + 1),
+		Event:     params.Event,
+		UserID:    nullUUID(params.UserID),
+		ActorID:   nullUUID(params.ActorID),
+		IP:        params.IP,
+		Detail:    params.Detail,
+		CreatedAt: now(),
+	})
+
+	return nil
+}
+
+// GetAuditLogs returns the most recent entries about the user
+func (s *Store) GetAuditLogs(ctx context.Context, userID uuid.UUID, limit int) ([]repository.AuditLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	logs := []repository.AuditLog{}
+	for i := len(s.auditLogs) - 1; i >= 0 && len(logs) < limit; i-- {
+		if s.auditLogs[i].UserID.String == userID.String() {
+			logs = append(logs, s.auditLogs[i])
+		}
+	}
+
+	return logs, nil
+}
+
+// deleteAccounts deletes what is the user's besides their tasks, leaving the audit log alone. s.mu must be held.
+func (s *Store) deleteAccounts(userID uuid.UUID) {
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	for hash, token := range s.refreshTokens {
+		if _, ok := s.sessions[token.SessionID]; !ok {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	delete(s.totps, userID)
+	s.replaceRecoveryCodes(userID, nil)
+	for id, token := range s.accessTokens {
+		if token.UserID == userID {
+			delete(s.accessTokens, id)
+		}
+	}
+	for hash, token := range s.userTokens {
+		if token.UserID == userID {
+			delete(s.userTokens, hash)
+		}
+	}
+	for key, identity := range s.identities {
+		if identity.UserID == userID {
+			delete(s.identities, key)
+		}
+	}
+	for id, client := range s.oauthClients {
+		if client.UserID == userID {
+			s.deleteOAuthClient(id)
+		}
+	}
+	for hash, code := range s.oauthCodes {
+		if code.UserID == userID {
+			delete(s.oauthCodes, hash)
+		}
+	}
+	for id, grant := range s.oauthGrants {
+		if grant.UserID == userID {
+			delete(s.oauthGrants, id)
+		}
+	}
+	for id, certificate := range s.certificates {
+		if certificate.UserID == userID {
+			delete(s.certificates, id)
+		}
+	}
+}
+
+// deleteOAuthClient deletes the client along with its codes and grants. s.mu must be held.
+func (s *Store) deleteOAuthClient(clientID uuid.UUID) {
+	for hash, code := range s.oauthCodes {
+		if code.ClientID == clientID {
+			delete(s.oauthCodes, hash)
+		}
+	}
+	for id, grant := range s.oauthGrants {
+		if grant.ClientID == clientID {
+			delete(s.oauthGrants, id)
+		}
+	}
+	delete(s.oauthClients, clientID)
+}
+
+// insertRefreshToken issues a refresh token for the session. s.mu must be held.
+func (s *Store) insertRefreshToken(sessionID uuid.UUID, refreshToken string) {
+	hash := repository.HashToken(refreshToken)
+	s.refreshTokens[string(hash)] = &repository.RefreshToken{
+		TokenHash: hash,
+		SessionID: sessionID,
+		CreatedAt: now(),
+	}
+}
+
+// replaceRecoveryCodes invalidates every previous recovery code of the user. s.mu must be held.
+func (s *Store) replaceRecoveryCodes(userID uuid.UUID, codes []string) {
+	for hash, c := range s.recoveryCodes {
+		if c.userID == userID {
+			delete(s.recoveryCodes, hash)
+		}
+	}
+	for _, code := range codes {
+		s.recoveryCodes[string(repository.HashToken(code))] = &recoveryCode{userID: userID}
+	}
+}
+
+// grantByRefreshToken returns nil for an unknown refresh token. s.mu must be held.
+func (s *Store) grantByRefreshToken(refreshToken string) *repository.OAuthGrant {
+	hash := repository.HashToken(refreshToken)
+	for _, grant := range s.oauthGrants {
+		if subtle.ConstantTimeCompare(grant.RefreshTokenHash, hash) == 1 {
+			return grant
+		}
+	}
+
+	return nil
+}
+
+// certificateBySubject returns nil when no certificate has the subject. s.mu must be held.
+func (s *Store) certificateBySubject(subject string) *repository.ClientCertificate {
+	for _, certificate := range s.certificates {
+		if certificate.Subject == subject {
+			return certificate
+		}
+	}
+
+	return nil
+}
+
+// revoke sets revokedAt unless it is set already
+func revoke(revokedAt *sql.NullTime) {
+	if !revokedAt.Valid {
+		*revokedAt = sql.NullTime{Time: now(), Valid: true}
+	}
+}
+
+// nullUUID stores uuid.Nil as NULL
+func nullUUID(id uuid.UUID) sql.NullString {
+	if id == uuid.Nil {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: id.String(), Valid: true}
+}
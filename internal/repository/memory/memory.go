@@ -0,0 +1,1321 @@
+// Package memory keeps everything in maps, for tests and local development without a database.
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// Store is safe for concurrent use. Everything it hands out is a copy.
+type Store struct {
+	mu        sync.RWMutex
+	passwords *password.Hasher
+	// dummyHash is compared against for unknown names so they cost as much as a wrong password
+	dummyHash []byte
+	users     map[uuid.UUID]*repository.User
+	tasks     map[uuid.UUID]*task
+	reminders map[uuid.UUID]*scheduledReminder
+	series    map[uuid.UUID]*repository.TaskSeries
+	projects  map[uuid.UUID]*repository.Project
+	labels    map[uuid.UUID]*repository.Label
+	accounts
+	// seq orders tasks by creation, which created_at is too coarse for
+	seq int
+}
+
+type task struct {
+	repository.Task
+	seq      int
+	labelIDs []uuid.UUID
+}
+
+type scheduledReminder struct {
+	repository.TaskReminder
+	taskID       uuid.UUID
+	claimedBy    uuid.UUID
+	claimedUntil time.Time
+	sent         bool
+}
+
+var _ repository.Store = (*Store)(nil)
+
+func New(passwords *password.Hasher) (*Store, error) {
+	dummyHash, err := passwords.Hash("dummy password")
+	if err != nil {
+		return nil, fmt.Errorf("hash dummy password: %w", err)
+	}
+
+	return &Store{
+		passwords: passwords,
+		dummyHash: dummyHash,
+		users:     map[uuid.UUID]*repository.User{},
+		tasks:     map[uuid.UUID]*task{},
+		reminders: map[uuid.UUID]*scheduledReminder{},
+		series:    map[uuid.UUID]*repository.TaskSeries{},
+		projects:  map[uuid.UUID]*repository.Project{},
+		labels:    map[uuid.UUID]*repository.Label{},
+		accounts:  newAccounts(),
+	}, nil
+}
+
+// GetTasks lists the user's tasks sorted by params.Sort, oldest first when it is empty.
+// Titles are sorted byte by byte, like the SQLite store does.
+func (s *Store) GetTasks(ctx context.Context, params repository.GetTasksParams) ([]repository.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type listed struct {
+		task   repository.Task
+		cursor repository.TaskCursor
+	}
+
+	title := strings.ToLower(params.TitleContains)
+	list := []listed{}
+	for _, t := range s.userTasks(params.UserID) {
+		createdAt, err := time.Parse(time.RFC3339Nano, t.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("parse created_at: %w", err)
+		}
+
+		switch {
+		case params.ProjectID != uuid.Nil && t.ProjectID != params.ProjectID,
+			params.IsDone != nil && t.IsDone != *params.IsDone,
+			!params.CreatedAfter.IsZero() && !createdAt.After(params.CreatedAfter),
+			!params.CreatedBefore.IsZero() && !createdAt.Before(params.CreatedBefore),
+			!params.DueBefore.IsZero() && !(t.DueAt.Valid && t.DueAt.Time.Before(params.DueBefore)),
+			!strings.Contains(strings.ToLower(t.Title), title),
+			!s.hasLabels(t, params.Labels, true),
+			!s.hasLabels(t, params.ExcludeLabels, false):
+			continue
+		}
+
+		cursor, err := t.Cursor(params.Sort)
+		if err != nil {
+			return nil, err
+		}
+		if params.After != nil && !before(params, *params.After, cursor) {
+			continue
+		}
+
+		list = append(list, listed{task: s.withLabels(t), cursor: cursor})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return before(params, list[i].cursor, list[j].cursor) })
+	if params.Limit > 0 && len(list) > params.Limit {
+		list = list[:params.Limit]
+	}
+
+	tasks := make([]repository.Task, len(list))
+	for i, l := range list {
+		tasks[i] = l.task
+	}
+
+	return tasks, nil
+}
+
+// GetTask returns ErrNotFound unless the task exists and belongs to userID
+func (s *Store) GetTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) (*repository.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.UserID != userID {
+		return nil, repository.ErrNotFound
+	}
+
+	task := s.withLabels(t)
+	return &task, nil
+}
+
+func (s *Store) SearchTasks(ctx context.Context, params repository.SearchTasksParams) ([]repository.SearchedTask, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tasks := []repository.SearchedTask{}
+	if params.Target.IsEmpty() {
+		return tasks, nil
+	}
+
+	// newest first among equal scores, as userTasks is oldest first and the sort is stable
+	userTasks := s.userTasks(params.UserID)
+	for i := len(userTasks) - 1; i >= 0; i-- {
+		if score := params.Target.Score(userTasks[i].Title); score > 0 {
+			tasks = append(tasks, repository.SearchedTask{Task: s.withLabels(userTasks[i]), Score: score})
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Score > tasks[j].Score })
+
+	return tasks, nil
+}
+
+// CreateTask schedules the reminders of the task along with it, and starts its series when it repeats
+func (s *Store) CreateTask(ctx context.Context, params repository.CreateTaskParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[params.UserID]; !ok {
+		return fmt.Errorf("insert task: user %s does not exist", params.UserID)
+	}
+	if err := s.checkLabels(params.UserID, params.LabelIDs); err != nil {
+		return err
+	}
+
+	projectID := params.ProjectID
+	if projectID == uuid.Nil {
+		projectID = s.inbox(params.UserID).ID
+	} else if err := s.checkProject(params.UserID, projectID); err != nil {
+		return err
+	}
+
+	t := repository.Task{
+		ID:          uuid.New(),
+		UserID:      params.UserID,
+		ProjectID:   projectID,
+		Title:       params.Title,
+		DueAt:       toSecond(params.DueAt),
+		DueTimeZone: sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""},
+		Reminders:   repository.JoinReminders(params.Reminders),
+	}
+	if series := params.Series(); series != nil {
+		s.insertSeries(series)
+		t.SeriesID = uuid.NullUUID{UUID: series.ID, Valid: true}
+		t.Recurrence = series.Recurrence
+		t.OccurrenceAt = t.DueAt
+	}
+	s.insertTask(t).labelIDs = slices.Clone(params.LabelIDs)
+
+	return nil
+}
+
+// UpdateTask reschedules the reminders of the task.
+// Completing an occurrence of a recurring task creates the next one, in the same project and with the same labels.
+func (s *Store) UpdateTask(ctx context.Context, params repository.UpdateTaskParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[params.ID]
+	if !ok || t.UserID != params.UserID {
+		return repository.ErrNotFound
+	}
+	if err := s.checkLabels(params.UserID, params.LabelIDs); err != nil {
+		return err
+	}
+	// a task may stay in a project archived since, but not be moved into one
+	if params.ProjectID != uuid.Nil && params.ProjectID != t.ProjectID {
+		if err := s.checkProject(params.UserID, params.ProjectID); err != nil {
+			return err
+		}
+	}
+
+	wasDone := t.IsDone
+	if params.ProjectID != uuid.Nil {
+		t.ProjectID = params.ProjectID
+	}
+	t.Title = params.Title
+	t.IsDone = params.IsDone
+	t.DueAt = toSecond(params.DueAt)
+	t.DueTimeZone = sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""}
+	t.Reminders = repository.JoinReminders(params.Reminders)
+
+	if params.Following {
+		var previous *repository.TaskSeries
+		if t.SeriesID.Valid {
+			previous = s.series[t.SeriesID.UUID]
+		}
+
+		series, err := params.Series(&t.Task, previous)
+		if err != nil {
+			return err
+		}
+
+		t.SeriesID, t.Recurrence, t.OccurrenceAt = uuid.NullUUID{}, "", sql.NullTime{}
+		if series != nil {
+			s.insertSeries(series)
+			t.SeriesID = uuid.NullUUID{UUID: series.ID, Valid: true}
+			t.Recurrence = series.Recurrence
+			t.OccurrenceAt = t.DueAt
+		}
+		s.pruneSeries(t.UserID)
+	}
+
+	s.setReminders(t.ID, t.DueAt, params.Reminders)
+
+	if params.LabelIDs != nil {
+		t.labelIDs = slices.Clone(params.LabelIDs)
+	}
+
+	if !wasDone && t.IsDone && t.SeriesID.Valid {
+		if err := s.createNextOccurrence(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteTask ends the series of a recurring task, as no occurrence is left to follow it
+func (s *Store) DeleteTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.UserID != userID {
+		return repository.ErrNotFound
+	}
+
+	s.deleteTask(taskID)
+	s.pruneSeries(userID)
+
+	return nil
+}
+
+// GetTaskSeries returns ErrNotFound unless the series exists and belongs to userID
+func (s *Store) GetTaskSeries(ctx context.Context, userID uuid.UUID, seriesID uuid.UUID) (*repository.TaskSeries, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	series, ok := s.series[seriesID]
+	if !ok || series.UserID != userID {
+		return nil, repository.ErrNotFound
+	}
+
+	copied := *series
+	return &copied, nil
+}
+
+// SkipOccurrence moves a recurring task on to its next occurrence, made afresh from its series.
+// It returns ErrSeriesEnded when there is none.
+func (s *Store) SkipOccurrence(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.UserID != userID {
+		return repository.ErrNotFound
+	}
+	if !t.SeriesID.Valid {
+		return repository.ErrSeriesEnded
+	}
+
+	series := s.series[t.SeriesID.UUID]
+	next, ok, err := series.NextOccurrence(t.OccurrenceAt.Time)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return repository.ErrSeriesEnded
+	}
+	for _, other := range s.tasks {
+		if other.SeriesID == t.SeriesID && other.OccurrenceAt.Time.Equal(next) {
+			return repository.ErrAlreadyExists
+		}
+	}
+
+	occurrence := series.Occurrence(next)
+	t.Title = occurrence.Title
+	t.DueAt = occurrence.DueAt
+	t.DueTimeZone = occurrence.DueTimeZone
+	t.Reminders = occurrence.Reminders
+	t.OccurrenceAt = occurrence.OccurrenceAt
+	s.setReminders(t.ID, t.DueAt, t.ReminderList())
+
+	return nil
+}
+
+// GetProjects lists the user's projects, the Inbox first and the rest by name
+func (s *Store) GetProjects(ctx context.Context, userID uuid.UUID, archived bool) ([]repository.Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	projects := []repository.Project{}
+	for _, project := range s.projects {
+		if project.UserID == userID && (archived || !project.ArchivedAt.Valid) {
+			projects = append(projects, *project)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		a, b := projects[i], projects[j]
+		if a.IsInbox != b.IsInbox {
+			return a.IsInbox
+		}
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c < 0
+		}
+		return a.ID.String() < b.ID.String()
+	})
+
+	return projects, nil
+}
+
+// GetProject returns ErrNotFound unless the project exists and belongs to userID
+func (s *Store) GetProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) (*repository.Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	project, ok := s.projects[projectID]
+	if !ok || project.UserID != userID {
+		return nil, repository.ErrNotFound
+	}
+
+	p := *project
+	return &p, nil
+}
+
+// CreateProject returns ErrAlreadyExists when the user has a project of the name
+func (s *Store) CreateProject(ctx context.Context, params repository.CreateProjectParams) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.projectByName(params.UserID, params.Name) != nil {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	projectID := uuid.New()
+	s.projects[projectID] = &repository.Project{
+		ID:        projectID,
+		UserID:    params.UserID,
+		Name:      params.Name,
+		CreatedAt: now(),
+	}
+
+	return projectID, nil
+}
+
+// UpdateProject renames and archives or restores a project other than the Inbox.
+// It returns ErrAlreadyExists when the user has another project of the name.
+func (s *Store) UpdateProject(ctx context.Context, params repository.UpdateProjectParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	project, ok := s.projects[params.ID]
+	if !ok || project.UserID != params.UserID || project.IsInbox {
+		return repository.ErrNotFound
+	}
+	if other := s.projectByName(params.UserID, params.Name); other != nil && other.ID != params.ID {
+		return repository.ErrAlreadyExists
+	}
+
+	project.Name = params.Name
+	switch {
+	case !params.Archived:
+		project.ArchivedAt = sql.NullTime{}
+	case !project.ArchivedAt.Valid:
+		project.ArchivedAt = sql.NullTime{Time: now(), Valid: true}
+	}
+
+	return nil
+}
+
+// DeleteProject deletes a project other than the Inbox, moving its tasks to the Inbox
+func (s *Store) DeleteProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	project, ok := s.projects[projectID]
+	if !ok || project.UserID != userID || project.IsInbox {
+		return repository.ErrNotFound
+	}
+
+	inboxID := s.inbox(userID).ID
+	for _, t := range s.tasks {
+		if t.ProjectID == projectID {
+			t.ProjectID = inboxID
+		}
+	}
+	delete(s.projects, projectID)
+
+	return nil
+}
+
+// MoveTasks moves the tasks to the project, all or none of them.
+// It returns ErrNotFound unless the project and every task belong to the user, and ErrProjectArchived for an archived project.
+func (s *Store) MoveTasks(ctx context.Context, params repository.MoveTasksParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(params.TaskIDs) == 0 {
+		return nil
+	}
+
+	if err := s.checkProject(params.UserID, params.ProjectID); err != nil {
+		if errors.Is(err, repository.ErrUnknownProject) {
+			return repository.ErrNotFound
+		}
+		return err
+	}
+	for _, taskID := range params.TaskIDs {
+		if t, ok := s.tasks[taskID]; !ok || t.UserID != params.UserID {
+			return repository.ErrNotFound
+		}
+	}
+
+	for _, taskID := range params.TaskIDs {
+		s.tasks[taskID].ProjectID = params.ProjectID
+	}
+
+	return nil
+}
+
+// GetLabels lists the user's labels by name
+func (s *Store) GetLabels(ctx context.Context, userID uuid.UUID) ([]repository.Label, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	labels := []repository.Label{}
+	for _, label := range s.labels {
+		if label.UserID == userID {
+			labels = append(labels, *label)
+		}
+	}
+	sortLabels(labels)
+
+	return labels, nil
+}
+
+// GetLabel returns ErrNotFound unless the label exists and belongs to userID
+func (s *Store) GetLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) (*repository.Label, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	label, ok := s.labels[labelID]
+	if !ok || label.UserID != userID {
+		return nil, repository.ErrNotFound
+	}
+
+	l := *label
+	return &l, nil
+}
+
+// CreateLabel returns ErrAlreadyExists when the user has a label of the name
+func (s *Store) CreateLabel(ctx context.Context, params repository.CreateLabelParams) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.labelByName(params.UserID, params.Name) != nil {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	labelID := uuid.New()
+	s.labels[labelID] = &repository.Label{
+		ID:        labelID,
+		UserID:    params.UserID,
+		Name:      params.Name,
+		Color:     params.Color,
+		CreatedAt: now(),
+	}
+
+	return labelID, nil
+}
+
+// UpdateLabel renames and recolors the label on every task it is on at once.
+// It returns ErrAlreadyExists when the user has another label of the name.
+func (s *Store) UpdateLabel(ctx context.Context, params repository.UpdateLabelParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	label, ok := s.labels[params.ID]
+	if !ok || label.UserID != params.UserID {
+		return repository.ErrNotFound
+	}
+	if other := s.labelByName(params.UserID, params.Name); other != nil && other.ID != params.ID {
+		return repository.ErrAlreadyExists
+	}
+
+	label.Name = params.Name
+	label.Color = params.Color
+
+	return nil
+}
+
+// DeleteLabel takes the label off every task it is on
+func (s *Store) DeleteLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	label, ok := s.labels[labelID]
+	if !ok || label.UserID != userID {
+		return repository.ErrNotFound
+	}
+
+	s.deleteLabel(labelID)
+
+	return nil
+}
+
+// MergeLabels puts the target label on every task with the source label and deletes the source label, all at once.
+// It returns ErrNotFound unless both labels belong to the user.
+func (s *Store) MergeLabels(ctx context.Context, params repository.MergeLabelsParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, labelID := range []uuid.UUID{params.SourceID, params.TargetID} {
+		if label, ok := s.labels[labelID]; !ok || label.UserID != params.UserID {
+			return repository.ErrNotFound
+		}
+	}
+
+	for _, t := range s.tasks {
+		if slices.Contains(t.labelIDs, params.SourceID) && !slices.Contains(t.labelIDs, params.TargetID) {
+			t.labelIDs = append(t.labelIDs, params.TargetID)
+		}
+	}
+	s.deleteLabel(params.SourceID)
+
+	return nil
+}
+
+// ClaimDueReminders claims the reminders due at now for lease, leaving out those of tasks that are done
+func (s *Store) ClaimDueReminders(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]reminder.Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*scheduledReminder{}
+	for _, r := range s.reminders {
+		if !r.sent && !r.RemindAt.After(now) && !r.claimedUntil.After(now) && !s.tasks[r.taskID].IsDone {
+			due = append(due, r)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RemindAt.Before(due[j].RemindAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claim := uuid.New()
+	reminders := make([]reminder.Reminder, len(due))
+	for i, r := range due {
+		r.claimedBy = claim
+		r.claimedUntil = now.Add(lease)
+
+		t := s.tasks[r.taskID]
+		user := s.users[t.UserID]
+		reminders[i] = reminder.Reminder{
+			ID:       r.ID,
+			ClaimID:  claim,
+			TaskID:   t.ID,
+			UserID:   t.UserID,
+			Title:    t.Title,
+			DueAt:    t.DueAt.Time,
+			TimeZone: t.DueTimeZone.String,
+			RemindAt: r.RemindAt,
+		}
+		if user.EmailVerifiedAt.Valid {
+			reminders[i].Email = user.Email.String
+		}
+	}
+
+	return reminders, nil
+}
+
+// MarkReminderSent keeps the reminder from being claimed again.
+// It returns reminder.ErrClaimLost when the reminder has been claimed again since claimID.
+func (s *Store) MarkReminderSent(ctx context.Context, reminderID uuid.UUID, claimID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.reminders[reminderID]
+	if !ok || r.sent || r.claimedBy != claimID {
+		return reminder.ErrClaimLost
+	}
+	r.sent = true
+
+	return nil
+}
+
+func (s *Store) GetTaskCounts(ctx context.Context, userID uuid.UUID) (*repository.TaskCounts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := &repository.TaskCounts{}
+	for _, t := range s.userTasks(userID) {
+		counts.Total++
+		if t.IsDone {
+			counts.Done++
+		}
+	}
+
+	return counts, nil
+}
+
+// CreateUser also creates the user's Inbox
+func (s *Store) CreateUser(ctx context.Context, params repository.CreateUserParams) (uuid.UUID, error) {
+	hashed, err := s.passwords.Hash(params.Password)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email := sql.NullString{String: params.Email, Valid: params.Email != ""}
+	if s.userByName(params.Name) != nil {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	return s.insertUser(params.Name, hashed, email), nil
+}
+
+// CreateUserWithIdentity creates a user who signs in through provider.
+// The password is random and unknown, so it has to be reset before it can be used.
+// It returns ErrAlreadyExists when the name is taken or the identity is already linked.
+func (s *Store) CreateUserWithIdentity(ctx context.Context, params repository.CreateUserWithIdentityParams) (uuid.UUID, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return uuid.Nil, fmt.Errorf("generate password: %w", err)
+	}
+
+	hashed, err := s.passwords.Hash(base64.RawStdEncoding.EncodeToString(b))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identityKey{provider: params.Provider, subject: params.Subject}
+	if _, ok := s.identities[key]; ok || s.userByName(params.Name) != nil {
+		return uuid.Nil, repository.ErrAlreadyExists
+	}
+
+	userID := s.insertUser(params.Name, hashed, sql.NullString{})
+	s.identities[key] = &repository.UserIdentity{
+		Provider:  params.Provider,
+		Subject:   params.Subject,
+		UserID:    userID,
+		Email:     sql.NullString{String: params.Email, Valid: params.Email != ""},
+		CreatedAt: now(),
+	}
+
+	return userID, nil
+}
+
+// GetUserIdentity returns ErrNotFound when the external account is not linked to a user
+func (s *Store) GetUserIdentity(ctx context.Context, provider string, subject string) (*repository.UserIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.identities[identityKey{provider: provider, subject: subject}]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	i := *identity
+	return &i, nil
+}
+
+func (s *Store) GetUserID(ctx context.Context, name string) (uuid.UUID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user := s.userByName(name)
+	if user == nil {
+		return uuid.Nil, repository.ErrNotFound
+	}
+
+	return user.ID, nil
+}
+
+// GetUser returns ErrNotFound for an unknown user
+func (s *Store) GetUser(ctx context.Context, userID uuid.UUID) (*repository.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	u := *user
+	return &u, nil
+}
+
+// GetUserByEmail returns the user who verified the address, or ErrNotFound when no one has
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*repository.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user := s.verifiedUserByEmail(email)
+	if user == nil {
+		return nil, repository.ErrNotFound
+	}
+
+	u := *user
+	return &u, nil
+}
+
+// UpdateName returns ErrAlreadyExists when another user has the name
+func (s *Store) UpdateName(ctx context.Context, params repository.UpdateNameParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if other := s.userByName(params.Name); other != nil && other.ID != params.ID {
+		return repository.ErrAlreadyExists
+	}
+
+	if user, ok := s.users[params.ID]; ok {
+		user.Name = params.Name
+		user.UpdatedAt = now()
+	}
+
+	return nil
+}
+
+// UpdatePass also lifts a required password reset
+func (s *Store) UpdatePass(ctx context.Context, params repository.UpdatePassParams) error {
+	hashed, err := s.passwords.Hash(params.Password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[params.ID]; ok {
+		user.Password = hashed
+		user.PasswordResetRequired = false
+		user.UpdatedAt = now()
+	}
+
+	return nil
+}
+
+// UpdateEmail sets the address, which has to be verified again unless it did not change.
+// Other users may have the address as long as none of them verified it.
+func (s *Store) UpdateEmail(ctx context.Context, params repository.UpdateEmailParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[params.ID]
+	if !ok {
+		return nil
+	}
+
+	if !user.Email.Valid || !strings.EqualFold(user.Email.String, params.Email) {
+		user.EmailVerifiedAt = sql.NullTime{}
+	}
+	user.Email = sql.NullString{String: params.Email, Valid: true}
+	user.UpdatedAt = now()
+
+	return nil
+}
+
+// VerifyEmail marks email as verified. It returns ErrNotFound when the user's address has changed since,
+// and ErrAlreadyExists when another user verified the address first.
+func (s *Store) VerifyEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || !user.Email.Valid || !strings.EqualFold(user.Email.String, email) {
+		return repository.ErrNotFound
+	}
+
+	if other := s.verifiedUserByEmail(email); other != nil && other.ID != userID {
+		return repository.ErrAlreadyExists
+	}
+
+	if !user.EmailVerifiedAt.Valid {
+		user.EmailVerifiedAt = sql.NullTime{Time: now(), Valid: true}
+	}
+
+	return nil
+}
+
+// SearchUsers lists users for the admin API, newest first.
+// Like the MySQL collation, the query matches without regard to case.
+func (s *Store) SearchUsers(ctx context.Context, params repository.SearchUsersParams) ([]repository.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := strings.ToLower(params.Query)
+	users := []repository.User{}
+	for _, user := range s.users {
+		matches := strings.Contains(strings.ToLower(user.Name), query) || (user.Email.Valid && strings.Contains(strings.ToLower(user.Email.String), query))
+		if matches && (params.Role == "" || user.Role == params.Role) {
+			users = append(users, *user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].ID.String() < users[j].ID.String()
+	})
+
+	if params.Offset >= len(users) {
+		return []repository.User{}, nil
+	}
+	users = users[params.Offset:]
+	if len(users) > params.Limit {
+		users = users[:params.Limit]
+	}
+
+	return users, nil
+}
+
+// UpdateRole returns ErrNotFound for an unknown user
+func (s *Store) UpdateRole(ctx context.Context, userID uuid.UUID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	user.Role = role
+
+	return nil
+}
+
+// SetUserDisabled disables or enables a user. It returns ErrNotFound for an unknown user.
+func (s *Store) SetUserDisabled(ctx context.Context, userID uuid.UUID, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	switch {
+	case !disabled:
+		user.DisabledAt = sql.NullTime{}
+	case !user.DisabledAt.Valid:
+		user.DisabledAt = sql.NullTime{Time: now(), Valid: true}
+	}
+
+	return nil
+}
+
+// RequirePasswordReset stops the user from signing in until the password is changed.
+// It returns ErrNotFound for an unknown user.
+func (s *Store) RequirePasswordReset(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	user.PasswordResetRequired = true
+
+	return nil
+}
+
+// DeleteUser deletes the user's tasks along with them
+func (s *Store) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.userTasks(userID) {
+		s.deleteTask(t.ID)
+	}
+	s.pruneSeries(userID)
+	for id, project := range s.projects {
+		if project.UserID == userID {
+			delete(s.projects, id)
+		}
+	}
+	for id, label := range s.labels {
+		if label.UserID == userID {
+			delete(s.labels, id)
+		}
+	}
+	s.deleteAccounts(userID)
+	delete(s.users, userID)
+
+	return nil
+}
+
+// Authenticate checks a name and password pair.
+// It returns ErrInvalidCredentials whether the name or the password is wrong,
+// and takes about as long either way so that response times do not reveal which names exist.
+// With the right password, it returns ErrAccountDisabled or ErrPasswordResetRequired when the user may not sign in.
+func (s *Store) Authenticate(ctx context.Context, name string, password string) (uuid.UUID, error) {
+	s.mu.RLock()
+	user := s.userByName(name)
+	var u repository.User
+	if user != nil {
+		u = *user
+	}
+	s.mu.RUnlock()
+
+	if user == nil {
+		_, _, _ = s.passwords.Verify(password, s.dummyHash)
+		return uuid.Nil, repository.ErrInvalidCredentials
+	}
+
+	ok, err := s.verifyPassword(&u, password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, repository.ErrInvalidCredentials
+	}
+
+	if u.DisabledAt.Valid {
+		return uuid.Nil, repository.ErrAccountDisabled
+	}
+	if u.PasswordResetRequired {
+		return uuid.Nil, repository.ErrPasswordResetRequired
+	}
+
+	return u.ID, nil
+}
+
+func (s *Store) CheckPass(ctx context.Context, userID uuid.UUID, password string) (bool, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return s.verifyPassword(user, password)
+}
+
+// verifyPassword compares password with the stored hash, which user must be a copy of.
+// A correct password stored with an outdated algorithm or cost is rehashed while it is at hand.
+func (s *Store) verifyPassword(user *repository.User, password string) (bool, error) {
+	ok, needsRehash, err := s.passwords.Verify(password, user.Password)
+	if err != nil {
+		return false, fmt.Errorf("verify password: %w", err)
+	}
+	if !ok || !needsRehash {
+		return ok, nil
+	}
+
+	hashed, err := s.passwords.Hash(password)
+	if err != nil {
+		return false, fmt.Errorf("hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// only replace the hash that was verified, in case the password changed meanwhile
+	if stored, ok := s.users[user.ID]; ok && string(stored.Password) == string(user.Password) {
+		stored.Password = hashed
+	}
+
+	return true, nil
+}
+
+// setReminders brings the scheduled reminders of a task in line with its due date.
+// A reminder that still goes off at the same time keeps its state, so that it is not sent twice,
+// and a new one that would have gone off already is left out. s.mu must be held.
+func (s *Store) setReminders(taskID uuid.UUID, dueAt sql.NullTime, reminders []int) {
+	times := repository.ReminderTimes(dueAt, reminders)
+	for id, r := range s.reminders {
+		if r.taskID != taskID {
+			continue
+		}
+
+		if remindAt, ok := times[r.MinutesBefore]; ok && remindAt.Equal(r.RemindAt) {
+			delete(times, r.MinutesBefore)
+			continue
+		}
+
+		delete(s.reminders, id)
+	}
+
+	now := time.Now()
+	for minutes, remindAt := range times {
+		if !remindAt.After(now) {
+			continue
+		}
+
+		reminderID := uuid.New()
+		s.reminders[reminderID] = &scheduledReminder{
+			TaskReminder: repository.TaskReminder{ID: reminderID, MinutesBefore: minutes, RemindAt: remindAt},
+			taskID:       taskID,
+		}
+	}
+}
+
+// deleteTask deletes the task along with its reminders. s.mu must be held.
+func (s *Store) deleteTask(taskID uuid.UUID) {
+	for id, r := range s.reminders {
+		if r.taskID == taskID {
+			delete(s.reminders, id)
+		}
+	}
+	delete(s.tasks, taskID)
+}
+
+// insertTask adds the task and schedules its reminders. s.mu must be held.
+func (s *Store) insertTask(t repository.Task) *task {
+	s.seq++
+	t.CreatedAt = now().Format(time.RFC3339Nano)
+	inserted := &task{Task: t, seq: s.seq}
+	s.tasks[t.ID] = inserted
+	s.setReminders(t.ID, t.DueAt, t.ReminderList())
+
+	return inserted
+}
+
+// insertSeries keeps series like a datetime column would. s.mu must be held.
+func (s *Store) insertSeries(series *repository.TaskSeries) {
+	series.StartsAt = series.StartsAt.UTC().Truncate(time.Second)
+	series.CreatedAt = now()
+	s.series[series.ID] = series
+}
+
+// createNextOccurrence creates the occurrence following done in its project and with its labels,
+// unless the series has ended or a later occurrence exists already. s.mu must be held.
+func (s *Store) createNextOccurrence(done *task) error {
+	seriesID, after := done.SeriesID.UUID, done.OccurrenceAt.Time
+	if s.occurrenceAfter(seriesID, after) != nil {
+		return nil
+	}
+
+	series := s.series[seriesID]
+	next, ok, err := series.NextOccurrence(after)
+	if err != nil || !ok {
+		return err
+	}
+
+	occurrence := series.Occurrence(next)
+	occurrence.ProjectID = done.ProjectID
+	s.insertTask(occurrence).labelIDs = slices.Clone(done.labelIDs)
+	return nil
+}
+
+// occurrenceAfter returns a task of the series for an occurrence later than after, or nil. s.mu must be held.
+func (s *Store) occurrenceAfter(seriesID uuid.UUID, after time.Time) *task {
+	for _, t := range s.tasks {
+		if t.SeriesID.Valid && t.SeriesID.UUID == seriesID && t.OccurrenceAt.Time.After(after) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// pruneSeries deletes the user's series that no task belongs to anymore. s.mu must be held.
+func (s *Store) pruneSeries(userID uuid.UUID) {
+	used := map[uuid.UUID]bool{}
+	for _, t := range s.tasks {
+		if t.SeriesID.Valid {
+			used[t.SeriesID.UUID] = true
+		}
+	}
+
+	for id, series := range s.series {
+		if series.UserID == userID && !used[id] {
+			delete(s.series, id)
+		}
+	}
+}
+
+// before reports whether the task at a comes before the one at b in a listing with params
+func before(params repository.GetTasksParams, a, b repository.TaskCursor) bool {
+	var c int
+	switch params.Sort {
+	case repository.TaskSortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case repository.TaskSortDue:
+		// tasks without a due date come last in either direction
+		if a.At.IsZero() != b.At.IsZero() {
+			return b.At.IsZero()
+		}
+		c = a.At.Compare(b.At)
+	default:
+		c = a.At.Compare(b.At)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID.String(), b.ID.String())
+	}
+
+	if params.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// userTasks returns the user's tasks from oldest to newest. s.mu must be held.
+func (s *Store) userTasks(userID uuid.UUID) []*task {
+	tasks := []*task{}
+	for _, t := range s.tasks {
+		if t.UserID == userID {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].seq < tasks[j].seq })
+
+	return tasks
+}
+
+// insertUser adds the user along with their Inbox. s.mu must be held.
+func (s *Store) insertUser(name string, hashed []byte, email sql.NullString) uuid.UUID {
+	userID := uuid.New()
+	s.users[userID] = &repository.User{
+		ID:        userID,
+		Name:      name,
+		Password:  hashed,
+		Email:     email,
+		Role:      "user",
+		UpdatedAt: now(),
+		CreatedAt: now(),
+	}
+
+	inboxID := uuid.New()
+	s.projects[inboxID] = &repository.Project{
+		ID:        inboxID,
+		UserID:    userID,
+		Name:      repository.InboxName,
+		IsInbox:   true,
+		CreatedAt: now(),
+	}
+
+	return userID
+}
+
+// inbox returns the user's Inbox. s.mu must be held.
+func (s *Store) inbox(userID uuid.UUID) *repository.Project {
+	for _, project := range s.projects {
+		if project.UserID == userID && project.IsInbox {
+			return project
+		}
+	}
+
+	return nil
+}
+
+// projectByName returns nil when the user has no project of the name, ignoring case. s.mu must be held.
+func (s *Store) projectByName(userID uuid.UUID, name string) *repository.Project {
+	for _, project := range s.projects {
+		if project.UserID == userID && strings.EqualFold(project.Name, name) {
+			return project
+		}
+	}
+
+	return nil
+}
+
+// withLabels returns a copy of the task with its labels. s.mu must be held.
+func (s *Store) withLabels(t *task) repository.Task {
+	copied := t.Task
+	copied.Labels = []repository.Label{}
+	for _, labelID := range t.labelIDs {
+		copied.Labels = append(copied.Labels, *s.labels[labelID])
+	}
+	sortLabels(copied.Labels)
+
+	return copied
+}
+
+// checkProject returns ErrUnknownProject or ErrProjectArchived unless tasks may be put in the user's project.
+// s.mu must be held.
+func (s *Store) checkProject(userID uuid.UUID, projectID uuid.UUID) error {
+	project, ok := s.projects[projectID]
+	if !ok || project.UserID != userID {
+		return repository.ErrUnknownProject
+	}
+	if project.ArchivedAt.Valid {
+		return repository.ErrProjectArchived
+	}
+
+	return nil
+}
+
+// checkLabels returns ErrUnknownLabel unless every label belongs to the user. s.mu must be held.
+func (s *Store) checkLabels(userID uuid.UUID, labelIDs []uuid.UUID) error {
+	for _, labelID := range labelIDs {
+		if label, ok := s.labels[labelID]; !ok || label.UserID != userID {
+			return repository.ErrUnknownLabel
+		}
+	}
+
+	return nil
+}
+
+// hasLabels reports whether the task has every label of the names when want is true,
+// or none of them when it is false. s.mu must be held.
+func (s *Store) hasLabels(t *task, names []string, want bool) bool {
+	for _, name := range names {
+		label := s.labelByName(t.UserID, name)
+		if (label != nil && slices.Contains(t.labelIDs, label.ID)) != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// labelByName returns nil when the user has no label of the name, ignoring case. s.mu must be held.
+func (s *Store) labelByName(userID uuid.UUID, name string) *repository.Label {
+	for _, label := range s.labels {
+		if label.UserID == userID && strings.EqualFold(label.Name, name) {
+			return label
+		}
+	}
+
+	return nil
+}
+
+// deleteLabel deletes the label and takes it off every task. s.mu must be held.
+func (s *Store) deleteLabel(labelID uuid.UUID) {
+	for _, t := range s.tasks {
+		if i := slices.Index(t.labelIDs, labelID); i >= 0 {
+			t.labelIDs = slices.Delete(t.labelIDs, i, i+1)
+		}
+	}
+	delete(s.labels, labelID)
+}
+
+// sortLabels sorts labels by name, ignoring case like the MySQL collation
+func sortLabels(labels []repository.Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		if c := strings.Compare(strings.ToLower(labels[i].Name), strings.ToLower(labels[j].Name)); c != 0 {
+			return c < 0
+		}
+		return labels[i].ID.String() < labels[j].ID.String()
+	})
+}
+
+// userByName returns nil when no user has the name. Like the MySQL collation, it ignores case. s.mu must be held.
+func (s *Store) userByName(name string) *repository.User {
+	for _, user := range s.users {
+		if strings.EqualFold(user.Name, name) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// verifiedUserByEmail returns nil when no user has verified the address. s.mu must be held.
+func (s *Store) verifiedUserByEmail(email string) *repository.User {
+	for _, user := range s.users {
+		if user.EmailVerifiedAt.Valid && strings.EqualFold(user.Email.String, email) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// now has the second precision of MySQL datetime columns
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// toSecond stores t like a datetime column does
+func toSecond(t sql.NullTime) sql.NullTime {
+	if !t.Valid {
+		return t
+	}
+	return sql.NullTime{Time: t.Time.UTC().Truncate(time.Second), Valid: true}
+}
@@ -0,0 +1,30 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/repository/memory"
+	"github.com/Irori235/system-design-2023-v2/internal/repository/storetest"
+)
+
+func TestStore(t *testing.T) {
+	passwords, err := password.NewHasher(password.Params{
+		Algorithm:   password.Argon2id,
+		Memory:      8 * 1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := memory.New(passwords)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storetest.Run(t, store)
+}
@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrOAuthCodeReused is returned when an authorization code is redeemed twice.
+// The grant issued for its first use has been revoked by the time it is returned.
+var ErrOAuthCodeReused = errors.New("authorization code reused")
+
+type (
+	// oauth_clients table
+	OAuthClient struct {
+		ID           uuid.UUID `db:"id"`
+		UserID       uuid.UUID `db:"user_id"`
+		Name         string    `db:"name"`
+		SecretHash   []byte    `db:"secret_hash"`
+		RedirectURIs string    `db:"redirect_uris"`
+		Scopes       string    `db:"scopes"`
+		CreatedAt    time.Time `db:"created_at"`
+	}
+
+	// oauth_codes table
+	OAuthCode struct {
+		CodeHash      []byte         `db:"code_hash"`
+		ClientID      uuid.UUID      `db:"client_id"`
+		UserID        uuid.UUID      `db:"user_id"`
+		RedirectURI   string         `db:"redirect_uri"`
+		Scopes        string         `db:"scopes"`
+		CodeChallenge string         `db:"code_challenge"`
+		ExpiresAt     time.Time      `db:"expires_at"`
+		UsedAt        sql.NullTime   `db:"used_at"`
+		GrantID       sql.NullString `db:"grant_id"`
+		CreatedAt     time.Time      `db:"created_at"`
+	}
+
+	// oauth_grants table. A grant is the access a user gave a client, renewed with its refresh token.
+	OAuthGrant struct {
+		ID               uuid.UUID    `db:"id"`
+		ClientID         uuid.UUID    `db:"client_id"`
+		UserID           uuid.UUID    `db:"user_id"`
+		Scopes           string       `db:"scopes"`
+		RefreshTokenHash []byte       `db:"refresh_token_hash"`
+		ExpiresAt        time.Time    `db:"expires_at"`
+		RevokedAt        sql.NullTime `db:"revoked_at"`
+		CreatedAt        time.Time    `db:"created_at"`
+	}
+
+	CreateOAuthClientParams struct {
+		UserID uuid.UUID
+		Name   string
+		// Secret is empty for a public client, which can only prove itself with PKCE
+		Secret       string
+		RedirectURIs []string
+		Scopes       []string
+	}
+
+	CreateOAuthCodeParams struct {
+		Code          string
+		ClientID      uuid.UUID
+		UserID        uuid.UUID
+		RedirectURI   string
+		Scopes        []string
+		CodeChallenge string
+		ExpiresAt     time.Time
+	}
+
+	RedeemOAuthCodeParams struct {
+		Code         string
+		ClientID     uuid.UUID
+		RefreshToken string
+		ExpiresAt    time.Time
+	}
+
+	RotateOAuthRefreshTokenParams struct {
+		ClientID        uuid.UUID
+		RefreshToken    string
+		NewRefreshToken string
+	}
+)
+
+// RedirectURIList splits the space separated redirect_uris column
+func (c *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// ScopeList splits the space separated scopes column
+func (c *OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// IsPublic reports whether the client has no secret
+func (c *OAuthClient) IsPublic() bool {
+	return len(c.SecretHash) == 0
+}
+
+// CheckSecret compares secret with the stored hash in constant time
+func (c *OAuthClient) CheckSecret(secret string) bool {
+	return !c.IsPublic() && subtle.ConstantTimeCompare(c.SecretHash, HashToken(secret)) == 1
+}
+
+// ScopeList splits the space separated scopes column
+func (c *OAuthCode) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// ScopeList splits the space separated scopes column
+func (g *OAuthGrant) ScopeList() []string {
+	return strings.Fields(g.Scopes)
+}
+
+// IsActive reports whether the grant can still be used at now
+func (g *OAuthGrant) IsActive(now time.Time) bool {
+	return !g.RevokedAt.Valid && now.Before(g.ExpiresAt)
+}
+
+func (r *Repository) CreateOAuthClient(ctx context.Context, params CreateOAuthClientParams) (uuid.UUID, error) {
+	clientID := uuid.New()
+
+	var secretHash []byte
+	if params.Secret != "" {
+		secretHash = HashToken(params.Secret)
+	}
+
+	query := "INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris, scopes) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := r.db.ExecContext(ctx, query, clientID, params.UserID, params.Name, secretHash, strings.Join(params.RedirectURIs, " "), strings.Join(params.Scopes, " ")); err != nil {
+		return uuid.Nil, fmt.Errorf("insert oauth client: %w", err)
+	}
+
+	return clientID, nil
+}
+
+// GetOAuthClient returns ErrNotFound for an unknown client
+func (r *Repository) GetOAuthClient(ctx context.Context, clientID uuid.UUID) (*OAuthClient, error) {
+	client := &OAuthClient{}
+	if err := r.db.GetContext(ctx, client, "SELECT * FROM oauth_clients WHERE id = ?", clientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth client: %w", err)
+	}
+
+	return client, nil
+}
+
+// GetOAuthClients lists the clients a user registered
+func (r *Repository) GetOAuthClients(ctx context.Context, userID uuid.UUID) ([]OAuthClient, error) {
+	clients := []OAuthClient{}
+	if err := r.db.SelectContext(ctx, &clients, "SELECT * FROM oauth_clients WHERE user_id = ? ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("select oauth clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes a client with its codes and grants. It returns ErrNotFound unless userID registered it.
+func (r *Repository) DeleteOAuthClient(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM oauth_clients WHERE id = ? AND user_id = ?", clientID, userID)
+	if err != nil {
+		return fmt.Errorf("delete oauth client: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+func (r *Repository) CreateOAuthCode(ctx context.Context, params CreateOAuthCodeParams) error {
+	query := "INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := r.db.ExecContext(ctx, query, HashToken(params.Code), params.ClientID, params.UserID, params.RedirectURI, strings.Join(params.Scopes, " "), params.CodeChallenge, params.ExpiresAt); err != nil {
+		return fmt.Errorf("insert oauth code: %w", err)
+	}
+
+	return nil
+}
+
+// GetOAuthCode looks a code up without redeeming it. It returns ErrNotFound for an unknown code.
+func (r *Repository) GetOAuthCode(ctx context.Context, code string) (*OAuthCode, error) {
+	c := &OAuthCode{}
+	if err := r.db.GetContext(ctx, c, "SELECT * FROM oauth_codes WHERE code_hash = ?", HashToken(code)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth code: %w", err)
+	}
+
+	return c, nil
+}
+
+// RedeemOAuthCode exchanges a code for a new grant holding its scopes.
+// It returns ErrNotFound for an unknown or expired code or one issued to another client,
+// and ErrOAuthCodeReused, after revoking the grant, when the code was redeemed before.
+func (r *Repository) RedeemOAuthCode(ctx context.Context, params RedeemOAuthCodeParams) (*OAuthGrant, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	code := &OAuthCode{}
+	if err := tx.GetContext(ctx, code, "SELECT * FROM oauth_codes WHERE code_hash = ? FOR UPDATE", HashToken(params.Code)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth code: %w", err)
+	}
+
+	now := time.Now()
+	if code.ClientID != params.ClientID {
+		return nil, ErrNotFound
+	}
+
+	if code.UsedAt.Valid {
+		if code.GrantID.Valid {
+			if _, err := tx.ExecContext(ctx, "UPDATE oauth_grants SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", now, code.GrantID.String); err != nil {
+				return nil, fmt.Errorf("revoke oauth grant: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit tx: %w", err)
+		}
+		return nil, ErrOAuthCodeReused
+	}
+
+	if !now.Before(code.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	grant := &OAuthGrant{
+		ID:               uuid.New(),
+		ClientID:         code.ClientID,
+		UserID:           code.UserID,
+		Scopes:           code.Scopes,
+		RefreshTokenHash: HashToken(params.RefreshToken),
+		ExpiresAt:        params.ExpiresAt,
+		CreatedAt:        now,
+	}
+
+	query := "INSERT INTO oauth_grants (id, client_id, user_id, scopes, refresh_token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, grant.ID, grant.ClientID, grant.UserID, grant.Scopes, grant.RefreshTokenHash, grant.ExpiresAt, grant.CreatedAt); err != nil {
+		return nil, fmt.Errorf("insert oauth grant: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE oauth_codes SET used_at = ?, grant_id = ? WHERE code_hash = ?", now, grant.ID, code.CodeHash); err != nil {
+		return nil, fmt.Errorf("use oauth code: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return grant, nil
+}
+
+// GetOAuthGrant returns ErrNotFound for an unknown grant
+func (r *Repository) GetOAuthGrant(ctx context.Context, grantID uuid.UUID) (*OAuthGrant, error) {
+	grant := &OAuthGrant{}
+	if err := r.db.GetContext(ctx, grant, "SELECT * FROM oauth_grants WHERE id = ?", grantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth grant: %w", err)
+	}
+
+	return grant, nil
+}
+
+// GetOAuthGrantByRefreshToken returns ErrNotFound for an unknown refresh token
+func (r *Repository) GetOAuthGrantByRefreshToken(ctx context.Context, refreshToken string) (*OAuthGrant, error) {
+	grant := &OAuthGrant{}
+	if err := r.db.GetContext(ctx, grant, "SELECT * FROM oauth_grants WHERE refresh_token_hash = ?", HashToken(refreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth grant: %w", err)
+	}
+
+	return grant, nil
+}
+
+// RotateOAuthRefreshToken replaces the refresh token of an active grant held by the client.
+// It returns ErrNotFound when there is no such grant, including for a refresh token already rotated.
+func (r *Repository) RotateOAuthRefreshToken(ctx context.Context, params RotateOAuthRefreshTokenParams) (*OAuthGrant, error) {
+	query := "UPDATE oauth_grants SET refresh_token_hash = ? WHERE refresh_token_hash = ? AND client_id = ? AND revoked_at IS NULL AND expires_at > ?"
+	result, err := r.db.ExecContext(ctx, query, HashToken(params.NewRefreshToken), HashToken(params.RefreshToken), params.ClientID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("rotate oauth refresh token: %w", err)
+	}
+
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	return r.GetOAuthGrantByRefreshToken(ctx, params.NewRefreshToken)
+}
+
+// RevokeOAuthGrant ends a grant. Revoking it twice is not an error.
+func (r *Repository) RevokeOAuthGrant(ctx context.Context, grantID uuid.UUID) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE oauth_grants SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", time.Now(), grantID); err != nil {
+		return fmt.Errorf("revoke oauth grant: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeOAuthGrants ends every grant the user gave, and drops the codes not yet redeemed for one
+func (r *Repository) RevokeOAuthGrants(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM oauth_codes WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return fmt.Errorf("delete oauth codes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE oauth_grants SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now(), userID); err != nil {
+		return fmt.Errorf("revoke oauth grants: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
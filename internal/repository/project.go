@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// projects table
+	Project struct {
+		ID     uuid.UUID `db:"id"`
+		UserID uuid.UUID `db:"user_id"`
+		Name   string    `db:"name"`
+		// IsInbox marks the project every user has, which tasks go to unless told otherwise.
+		// It is never renamed, archived or deleted.
+		IsInbox    bool         `db:"is_inbox"`
+		ArchivedAt sql.NullTime `db:"archived_at"`
+		CreatedAt  time.Time    `db:"created_at"`
+	}
+
+	CreateProjectParams struct {
+		UserID uuid.UUID
+		Name   string
+	}
+
+	UpdateProjectParams struct {
+		ID       uuid.UUID
+		UserID   uuid.UUID
+		Name     string
+		Archived bool
+	}
+
+	MoveTasksParams struct {
+		UserID    uuid.UUID
+		ProjectID uuid.UUID
+		// TaskIDs are distinct
+		TaskIDs []uuid.UUID
+	}
+)
+
+// InboxName is the name of the project every user has
+const InboxName = "Inbox"
+
+var (
+	// ErrUnknownProject is returned when a task is put in a project that does not exist or belongs to another user
+	ErrUnknownProject = errors.New("unknown project")
+	// ErrProjectArchived is returned when a task is put in an archived project
+	ErrProjectArchived = errors.New("project archived")
+)
+
+// GetProjects lists the user's projects, the Inbox first and the rest by name
+func (r *Repository) GetProjects(ctx context.Context, userID uuid.UUID, archived bool) ([]Project, error) {
+	query := "SELECT * FROM projects WHERE user_id = ?"
+	if !archived {
+		query += " AND archived_at IS NULL"
+	}
+	query += " ORDER BY is_inbox DESC, name, id"
+
+	projects := []Project{}
+	if err := r.db.SelectContext(ctx, &projects, query, userID); err != nil {
+		return nil, fmt.Errorf("select projects: %w", err)
+	}
+
+	return projects, nil
+}
+
+// GetProject returns ErrNotFound unless the project exists and belongs to userID
+func (r *Repository) GetProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) (*Project, error) {
+	project := &Project{}
+	if err := r.db.GetContext(ctx, project, "SELECT * FROM projects WHERE id = ? AND user_id = ?", projectID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select project: %w", err)
+	}
+
+	return project, nil
+}
+
+// CreateProject returns ErrAlreadyExists when the user has a project of the name
+func (r *Repository) CreateProject(ctx context.Context, params CreateProjectParams) (uuid.UUID, error) {
+	projectID := uuid.New()
+	if _, err := r.db.ExecContext(ctx, "INSERT INTO projects (id, user_id, name) VALUES (?, ?, ?)", projectID, params.UserID, params.Name); err != nil {
+		if isDuplicateEntry(err) {
+			return uuid.Nil, ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert project: %w", err)
+	}
+
+	return projectID, nil
+}
+
+// UpdateProject renames and archives or restores a project other than the Inbox.
+// It returns ErrAlreadyExists when the user has another project of the name.
+func (r *Repository) UpdateProject(ctx context.Context, params UpdateProjectParams) error {
+	// a project archived already keeps the time it was archived at
+	query := `UPDATE projects SET name = ?, archived_at = CASE WHEN ? THEN COALESCE(archived_at, ?) END
+		WHERE id = ? AND user_id = ? AND NOT is_inbox`
+	result, err := r.db.ExecContext(ctx, query, params.Name, params.Archived, time.Now(), params.ID, params.UserID)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("update project: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteProject deletes a project other than the Inbox, moving its tasks to the Inbox
+func (r *Repository) DeleteProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the lock waits for tasks being added to the project to commit, and holds off any more until it is gone,
+	// so that none are left behind to fail the delete
+	project := &Project{}
+	if err := tx.GetContext(ctx, project, "SELECT * FROM projects WHERE id = ? AND user_id = ? AND NOT is_inbox FOR UPDATE", projectID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("select project: %w", err)
+	}
+
+	inboxID, err := getInboxID(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET project_id = ? WHERE project_id = ?", inboxID, project.ID); err != nil {
+		return fmt.Errorf("move tasks to inbox: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM projects WHERE id = ?", project.ID); err != nil {
+		return fmt.Errorf("delete project: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// MoveTasks moves the tasks to the project, all or none of them.
+// It returns ErrNotFound unless the project and every task belong to the user, and ErrProjectArchived for an archived project.
+func (r *Repository) MoveTasks(ctx context.Context, params MoveTasksParams) error {
+	if len(params.TaskIDs) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockProject(ctx, tx, params.UserID, params.ProjectID); err != nil {
+		if errors.Is(err, ErrUnknownProject) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	query, args, err := sqlx.In("UPDATE tasks SET project_id = ? WHERE user_id = ? AND id IN (?)", params.ProjectID, params.UserID, params.TaskIDs)
+	if err != nil {
+		return fmt.Errorf("build query: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("move tasks: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n != int64(len(params.TaskIDs)) {
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func insertInbox(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) error {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO projects (id, user_id, name, is_inbox) VALUES (?, ?, ?, TRUE)", uuid.New(), userID, InboxName); err != nil {
+		return fmt.Errorf("insert inbox: %w", err)
+	}
+
+	return nil
+}
+
+// lockProject returns ErrUnknownProject or ErrProjectArchived unless tasks may be put in the user's project.
+// The lock holds off deleting or archiving the project until the tx has put its task in it.
+func lockProject(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, projectID uuid.UUID) error {
+	project := &Project{}
+	if err := tx.GetContext(ctx, project, "SELECT * FROM projects WHERE id = ? AND user_id = ? FOR UPDATE", projectID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownProject
+		}
+		return fmt.Errorf("select project: %w", err)
+	}
+
+	if project.ArchivedAt.Valid {
+		return ErrProjectArchived
+	}
+
+	return nil
+}
+
+func getInboxID(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) (uuid.UUID, error) {
+	var inboxID uuid.UUID
+	if err := tx.GetContext(ctx, &inboxID, "SELECT id FROM projects WHERE user_id = ? AND is_inbox", userID); err != nil {
+		return uuid.Nil, fmt.Errorf("select inbox: %w", err)
+	}
+
+	return inboxID, nil
+}
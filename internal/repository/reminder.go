@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// task_reminders table
+	TaskReminder struct {
+		ID            uuid.UUID `db:"id"`
+		MinutesBefore int       `db:"minutes_before"`
+		RemindAt      time.Time `db:"remind_at"`
+	}
+
+	// DueReminder is a claimed reminder along with its task
+	DueReminder struct {
+		ID          uuid.UUID      `db:"id"`
+		ClaimedBy   uuid.UUID      `db:"claimed_by"`
+		TaskID      uuid.UUID      `db:"task_id"`
+		UserID      uuid.UUID      `db:"user_id"`
+		Title       string         `db:"title"`
+		DueAt       time.Time      `db:"due_at"`
+		DueTimeZone sql.NullString `db:"due_time_zone"`
+		RemindAt    time.Time      `db:"remind_at"`
+		Email       sql.NullString `db:"email"`
+	}
+)
+
+var _ reminder.Store = (*Repository)(nil)
+
+func (d *DueReminder) Reminder() reminder.Reminder {
+	return reminder.Reminder{
+		ID:       d.ID,
+		ClaimID:  d.ClaimedBy,
+		TaskID:   d.TaskID,
+		UserID:   d.UserID,
+		Title:    d.Title,
+		DueAt:    d.DueAt,
+		TimeZone: d.DueTimeZone.String,
+		RemindAt: d.RemindAt,
+		Email:    d.Email.String,
+	}
+}
+
+// ReminderTimes returns when the reminders of a task due at dueAt go off, by minutes before
+func ReminderTimes(dueAt sql.NullTime, reminders []int) map[int]time.Time {
+	times := map[int]time.Time{}
+	if !dueAt.Valid {
+		return times
+	}
+
+	for _, minutes := range reminders {
+		times[minutes] = dueAt.Time.Add(-time.Duration(minutes) * time.Minute)
+	}
+
+	return times
+}
+
+// setReminders brings the scheduled reminders of a task in line with its due date.
+// A reminder that still goes off at the same time keeps its state, so that it is not sent twice,
+// and a new one that would have gone off already is left out.
+func setReminders(ctx context.Context, tx *sqlx.Tx, taskID uuid.UUID, dueAt sql.NullTime, reminders []int) error {
+	scheduled := []TaskReminder{}
+	if err := tx.SelectContext(ctx, &scheduled, "SELECT id, minutes_before, remind_at FROM task_reminders WHERE task_id = ?", taskID); err != nil {
+		return fmt.Errorf("select task reminders: %w", err)
+	}
+
+	times := ReminderTimes(dueAt, reminders)
+	for _, r := range scheduled {
+		if remindAt, ok := times[r.MinutesBefore]; ok && remindAt.Equal(r.RemindAt) {
+			delete(times, r.MinutesBefore)
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM task_reminders WHERE id = ?", r.ID); err != nil {
+			return fmt.Errorf("delete task reminder: %w", err)
+		}
+	}
+
+	now := time.Now()
+	for minutes, remindAt := range times {
+		if !remindAt.After(now) {
+			continue
+		}
+
+		query := "INSERT INTO task_reminders (id, task_id, minutes_before, remind_at) VALUES (?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, uuid.New(), taskID, minutes, remindAt); err != nil {
+			return fmt.Errorf("insert task reminder: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ClaimDueReminders claims the reminders due at now for lease, leaving out those of tasks that are done
+func (r *Repository) ClaimDueReminders(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]reminder.Reminder, error) {
+	claim := uuid.New()
+	query := `UPDATE task_reminders SET claimed_by = ?, claimed_until = ?
+		WHERE sent_at IS NULL AND remind_at <= ? AND (claimed_until IS NULL OR claimed_until <= ?)
+		AND task_id IN (SELECT id FROM tasks WHERE is_done = FALSE)
+		ORDER BY remind_at LIMIT ?`
+	if _, err := r.db.ExecContext(ctx, query, claim, now.Add(lease), now, now, limit); err != nil {
+		return nil, fmt.Errorf("claim reminders: %w", err)
+	}
+
+	due := []DueReminder{}
+	query = `SELECT r.id, r.claimed_by, r.task_id, t.user_id, t.title, t.due_at, t.due_time_zone, r.remind_at,
+		CASE WHEN u.email_verified_at IS NOT NULL THEN u.email END AS email
+		FROM task_reminders r JOIN tasks t ON t.id = r.task_id JOIN users u ON u.id = t.user_id
+		WHERE r.claimed_by = ? ORDER BY r.remind_at`
+	if err := r.db.SelectContext(ctx, &due, query, claim); err != nil {
+		return nil, fmt.Errorf("select claimed reminders: %w", err)
+	}
+
+	reminders := make([]reminder.Reminder, len(due))
+	for i := range due {
+		reminders[i] = due[i].Reminder()
+	}
+
+	return reminders, nil
+}
+
+// MarkReminderSent keeps the reminder from being claimed again.
+// It returns reminder.ErrClaimLost when the reminder has been claimed again since claimID.
+func (r *Repository) MarkReminderSent(ctx context.Context, reminderID uuid.UUID, claimID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE task_reminders SET sent_at = ? WHERE id = ? AND claimed_by = ? AND sent_at IS NULL", time.Now(), reminderID, claimID)
+	if err != nil {
+		return fmt.Errorf("mark reminder sent: %w", err)
+	}
+
+	if err := checkAffected(result); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return reminder.ErrClaimLost
+		}
+		return err
+	}
+
+	return nil
+}
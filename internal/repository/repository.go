@@ -1,13 +1,54 @@
 package repository
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
+var (
+	// ErrNotFound is returned when a row does not exist or is not visible to the caller
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when a row that must be unique is already there
+	ErrAlreadyExists = errors.New("already exists")
+)
+
 type Repository struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	passwords *password.Hasher
+	// dummyHash is compared against for unknown names so they cost as much as a wrong password
+	dummyHash []byte
+}
+
+func New(db *sqlx.DB, passwords *password.Hasher) (*Repository, error) {
+	dummyHash, err := passwords.Hash("dummy password")
+	if err != nil {
+		return nil, fmt.Errorf("hash dummy password: %w", err)
+	}
+
+	return &Repository{db: db, passwords: passwords, dummyHash: dummyHash}, nil
+}
+
+// checkAffected maps an update or delete that matched no rows to ErrNotFound.
+// The DSN must set clientFoundRows so that unchanged rows are still counted.
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
 }
 
-func New(db *sqlx.DB) *Repository {
-	return &Repository{db: db}
+// isDuplicateEntry reports whether err is a unique key violation
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
 }
@@ -0,0 +1,315 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/rrule"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// task_series table.
+// A series is what the occurrences of a recurring task are made from. It never changes:
+// editing an occurrence along with the following ones starts a new series from it.
+type TaskSeries struct {
+	ID     uuid.UUID `db:"id"`
+	UserID uuid.UUID `db:"user_id"`
+	// Recurrence is an RFC 5545 RRULE like "FREQ=WEEKLY;BYDAY=MO"
+	Recurrence string `db:"recurrence"`
+	// StartsAt is the first occurrence, which the rule counts from
+	StartsAt    time.Time      `db:"starts_at"`
+	Title       string         `db:"title"`
+	DueTimeZone sql.NullString `db:"due_time_zone"`
+	Reminders   string         `db:"reminders"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
+
+// ErrSeriesEnded is returned when a recurring task has no later occurrence to move to
+var ErrSeriesEnded = errors.New("series ended")
+
+// Occurrences returns up to n occurrences later than after, in the time zone of the series
+func (s *TaskSeries) Occurrences(after time.Time, n int) ([]time.Time, error) {
+	rule, start, err := s.rule()
+	if err != nil {
+		return nil, err
+	}
+
+	return rule.After(start, after, n), nil
+}
+
+// rule returns the parsed recurrence along with the start in the time zone of the series,
+// so that occurrences keep their local time of day across daylight saving time changes
+func (s *TaskSeries) rule() (*rrule.Rule, time.Time, error) {
+	rule, err := rrule.Parse(s.Recurrence)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parse recurrence: %w", err)
+	}
+
+	loc, err := time.LoadLocation(s.DueTimeZone.String)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return rule, s.StartsAt.In(loc), nil
+}
+
+// NextOccurrence returns the first occurrence later than after, or false when the series ends before
+func (s *TaskSeries) NextOccurrence(after time.Time) (time.Time, bool, error) {
+	occurrences, err := s.Occurrences(after, 1)
+	if err != nil || len(occurrences) == 0 {
+		return time.Time{}, false, err
+	}
+
+	return occurrences[0].UTC(), true, nil
+}
+
+// Occurrence returns a new task for the occurrence at
+func (s *TaskSeries) Occurrence(at time.Time) Task {
+	due := sql.NullTime{Time: at.UTC(), Valid: true}
+
+	return Task{
+		ID:           uuid.New(),
+		UserID:       s.UserID,
+		Title:        s.Title,
+		DueAt:        due,
+		DueTimeZone:  s.DueTimeZone,
+		Reminders:    s.Reminders,
+		SeriesID:     uuid.NullUUID{UUID: s.ID, Valid: true},
+		Recurrence:   s.Recurrence,
+		OccurrenceAt: due,
+	}
+}
+
+// RemainingRecurrence returns the rule of a series going on with s from its occurrence at.
+// A COUNT is lowered by the occurrences before at, so that the series as a whole keeps it.
+func (s *TaskSeries) RemainingRecurrence(at time.Time) (string, error) {
+	rule, start, err := s.rule()
+	if err != nil {
+		return "", err
+	}
+	if rule.Count == 0 {
+		return s.Recurrence, nil
+	}
+
+	past := 0
+	it := rule.Iterate(start)
+	for occurrence, ok := it.Next(); ok && occurrence.Before(at); occurrence, ok = it.Next() {
+		past++
+	}
+	if past >= rule.Count {
+		past = rule.Count - 1
+	}
+	rule.Count -= past
+
+	return rule.String(), nil
+}
+
+// Series returns the series the task starts, or nil when it does not repeat
+func (params CreateTaskParams) Series() *TaskSeries {
+	if params.Recurrence == "" || !params.DueAt.Valid {
+		return nil
+	}
+
+	return &TaskSeries{
+		ID:          uuid.New(),
+		UserID:      params.UserID,
+		Recurrence:  params.Recurrence,
+		StartsAt:    params.DueAt.Time,
+		Title:       params.Title,
+		DueTimeZone: sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""},
+		Reminders:   JoinReminders(params.Reminders),
+	}
+}
+
+// Series returns the series an edit of the task along with its following occurrences starts,
+// or nil when the task stops repeating. previous is the series the task belonged to, if any.
+func (params UpdateTaskParams) Series(task *Task, previous *TaskSeries) (*TaskSeries, error) {
+	if params.Recurrence == "" || !params.DueAt.Valid {
+		return nil, nil
+	}
+
+	recurrence := params.Recurrence
+	if previous != nil && recurrence == previous.Recurrence {
+		var err error
+		if recurrence, err = previous.RemainingRecurrence(task.OccurrenceAt.Time); err != nil {
+			return nil, err
+		}
+	}
+
+	return CreateTaskParams{
+		UserID:      task.UserID,
+		Title:       params.Title,
+		DueAt:       params.DueAt,
+		DueTimeZone: params.DueTimeZone,
+		Reminders:   params.Reminders,
+		Recurrence:  recurrence,
+	}.Series(), nil
+}
+
+// GetTaskSeries returns ErrNotFound unless the series exists and belongs to userID
+func (r *Repository) GetTaskSeries(ctx context.Context, userID uuid.UUID, seriesID uuid.UUID) (*TaskSeries, error) {
+	series := &TaskSeries{}
+	if err := r.db.GetContext(ctx, series, "SELECT * FROM task_series WHERE id = ? AND user_id = ?", seriesID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select task series: %w", err)
+	}
+
+	return series, nil
+}
+
+// SkipOccurrence moves a recurring task on to its next occurrence, made afresh from its series.
+// It returns ErrSeriesEnded when there is none.
+func (r *Repository) SkipOccurrence(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	task, err := lockTask(ctx, tx, userID, taskID)
+	if err != nil {
+		return err
+	}
+	if !task.SeriesID.Valid {
+		return ErrSeriesEnded
+	}
+
+	series := &TaskSeries{}
+	if err := tx.GetContext(ctx, series, "SELECT * FROM task_series WHERE id = ?", task.SeriesID.UUID); err != nil {
+		return fmt.Errorf("select task series: %w", err)
+	}
+
+	next, ok, err := series.NextOccurrence(task.OccurrenceAt.Time)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSeriesEnded
+	}
+
+	occurrence := series.Occurrence(next)
+	query := "UPDATE tasks SET title = ?, due_at = ?, due_time_zone = ?, reminders = ?, occurrence_at = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, occurrence.Title, occurrence.DueAt, occurrence.DueTimeZone, occurrence.Reminders, occurrence.OccurrenceAt, taskID); err != nil {
+		if isDuplicateEntry(err) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("update task: %w", err)
+	}
+
+	if err := setReminders(ctx, tx, taskID, occurrence.DueAt, occurrence.ReminderList()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// lockTask returns the task for the rest of tx, or ErrNotFound unless it belongs to userID
+func lockTask(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, taskID uuid.UUID) (*Task, error) {
+	task := &Task{}
+	if err := tx.GetContext(ctx, task, "SELECT * FROM tasks WHERE id = ? AND user_id = ? FOR UPDATE", taskID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select task: %w", err)
+	}
+
+	return task, nil
+}
+
+// insertTask inserts the task and schedules its reminders
+func insertTask(ctx context.Context, tx *sqlx.Tx, task *Task) error {
+	query := `INSERT INTO tasks (id, user_id, project_id, title, due_at, due_time_zone, reminders, series_id, recurrence, occurrence_at)
+		VALUES (:id, :user_id, :project_id, :title, :due_at, :due_time_zone, :reminders, :series_id, :recurrence, :occurrence_at)`
+	if _, err := tx.NamedExecContext(ctx, query, task); err != nil {
+		return fmt.Errorf("insert task: %w", err)
+	}
+
+	return setReminders(ctx, tx, task.ID, task.DueAt, task.ReminderList())
+}
+
+func insertTaskSeries(ctx context.Context, tx *sqlx.Tx, series *TaskSeries) error {
+	query := `INSERT INTO task_series (id, user_id, recurrence, starts_at, title, due_time_zone, reminders)
+		VALUES (:id, :user_id, :recurrence, :starts_at, :title, :due_time_zone, :reminders)`
+	if _, err := tx.NamedExecContext(ctx, query, series); err != nil {
+		return fmt.Errorf("insert task series: %w", err)
+	}
+
+	return nil
+}
+
+// setTaskSeries moves the task to series, or out of any series when it is nil
+func setTaskSeries(ctx context.Context, tx *sqlx.Tx, task *Task, series *TaskSeries) error {
+	task.SeriesID, task.Recurrence, task.OccurrenceAt = uuid.NullUUID{}, "", sql.NullTime{}
+	if series != nil {
+		if err := insertTaskSeries(ctx, tx, series); err != nil {
+			return err
+		}
+		task.SeriesID = uuid.NullUUID{UUID: series.ID, Valid: true}
+		task.Recurrence = series.Recurrence
+		task.OccurrenceAt = sql.NullTime{Time: series.StartsAt, Valid: true}
+	}
+
+	query := "UPDATE tasks SET series_id = ?, recurrence = ?, occurrence_at = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, task.SeriesID, task.Recurrence, task.OccurrenceAt, task.ID); err != nil {
+		return fmt.Errorf("update task series: %w", err)
+	}
+
+	return pruneTaskSeries(ctx, tx, task.UserID)
+}
+
+// createNextOccurrence creates the occurrence following done in its project and with its labels,
+// unless the series has ended or a later occurrence exists already
+func createNextOccurrence(ctx context.Context, tx *sqlx.Tx, done *Task) error {
+	seriesID, after := done.SeriesID.UUID, done.OccurrenceAt.Time
+
+	var later bool
+	if err := tx.GetContext(ctx, &later, "SELECT EXISTS (SELECT 1 FROM tasks WHERE series_id = ? AND occurrence_at > ?)", seriesID, after); err != nil {
+		return fmt.Errorf("select later occurrence: %w", err)
+	}
+	if later {
+		return nil
+	}
+
+	series := &TaskSeries{}
+	if err := tx.GetContext(ctx, series, "SELECT * FROM task_series WHERE id = ?", seriesID); err != nil {
+		return fmt.Errorf("select task series: %w", err)
+	}
+
+	next, ok, err := series.NextOccurrence(after)
+	if err != nil || !ok {
+		return err
+	}
+
+	occurrence := series.Occurrence(next)
+	occurrence.ProjectID = done.ProjectID
+	if err := insertTask(ctx, tx, &occurrence); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO task_labels (task_id, label_id) SELECT ?, label_id FROM task_labels WHERE task_id = ?"
+	if _, err := tx.ExecContext(ctx, query, occurrence.ID, done.ID); err != nil {
+		return fmt.Errorf("copy task labels: %w", err)
+	}
+
+	return nil
+}
+
+// pruneTaskSeries deletes the user's series that no task belongs to anymore
+func pruneTaskSeries(ctx context.Context, ex sqlx.ExecerContext, userID uuid.UUID) error {
+	query := "DELETE FROM task_series WHERE user_id = ? AND NOT EXISTS (SELECT 1 FROM tasks WHERE tasks.series_id = task_series.id)"
+	if _, err := ex.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("delete unused task series: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrSessionRevoked is returned when the session was signed out or expired
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The session has been revoked by the time it is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+type (
+	// sessions table
+	Session struct {
+		ID         uuid.UUID    `db:"id"`
+		UserID     uuid.UUID    `db:"user_id"`
+		UserAgent  string       `db:"user_agent"`
+		IP         string       `db:"ip"`
+		ExpiresAt  time.Time    `db:"expires_at"`
+		RevokedAt  sql.NullTime `db:"revoked_at"`
+		LastSeenAt time.Time    `db:"last_seen_at"`
+		CreatedAt  time.Time    `db:"created_at"`
+	}
+
+	// refresh_tokens table
+	RefreshToken struct {
+		TokenHash []byte       `db:"token_hash"`
+		SessionID uuid.UUID    `db:"session_id"`
+		UsedAt    sql.NullTime `db:"used_at"`
+		CreatedAt time.Time    `db:"created_at"`
+	}
+
+	CreateSessionParams struct {
+		UserID       uuid.UUID
+		UserAgent    string
+		IP           string
+		ExpiresAt    time.Time
+		RefreshToken string
+	}
+
+	RotateRefreshTokenParams struct {
+		RefreshToken    string
+		NewRefreshToken string
+	}
+)
+
+// IsActive reports whether the session can still be used at now
+func (s *Session) IsActive(now time.Time) bool {
+	return !s.RevokedAt.Valid && now.Before(s.ExpiresAt)
+}
+
+func (r *Repository) CreateSession(ctx context.Context, params CreateSessionParams) (uuid.UUID, error) {
+	sessionID := uuid.New()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO sessions (id, user_id, user_agent, ip, expires_at) VALUES (?, ?, ?, ?, ?)",
+		sessionID, params.UserID, truncate(params.UserAgent, 255), truncate(params.IP, 45), params.ExpiresAt,
+	); err != nil {
+		return uuid.Nil, fmt.Errorf("insert session: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id) VALUES (?, ?)", HashToken(params.RefreshToken), sessionID); err != nil {
+		return uuid.Nil, fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return sessionID, nil
+}
+
+func (r *Repository) GetSession(ctx context.Context, sessionID uuid.UUID) (*Session, error) {
+	session := &Session{}
+	if err := r.db.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = ?", sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	return session, nil
+}
+
+// GetSessionByRefreshToken finds the session a refresh token was issued for, whether or not the token was exchanged since.
+// It returns ErrNotFound for an unknown token.
+func (r *Repository) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*Session, error) {
+	session := &Session{}
+	query := "SELECT sessions.* FROM sessions JOIN refresh_tokens ON refresh_tokens.session_id = sessions.id WHERE refresh_tokens.token_hash = ?"
+	if err := r.db.GetContext(ctx, session, query, HashToken(refreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	return session, nil
+}
+
+// RevokeSessionByRefreshToken signs out the session a refresh token was issued for.
+// It returns ErrNotFound for an unknown token. A token that was already exchanged revokes the session too,
+// as in RotateRefreshToken, but returns ErrRefreshTokenReused.
+func (r *Repository) RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	token := &RefreshToken{}
+	if err := tx.GetContext(ctx, token, "SELECT * FROM refresh_tokens WHERE token_hash = ? FOR UPDATE", HashToken(refreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("select refresh token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", time.Now(), token.SessionID); err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	if token.UsedAt.Valid {
+		return ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges an unused refresh token for NewRefreshToken.
+// Presenting a token that was already exchanged revokes the whole session.
+func (r *Repository) RotateRefreshToken(ctx context.Context, params RotateRefreshTokenParams) (*Session, error) {
+	now := time.Now()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	token := &RefreshToken{}
+	if err := tx.GetContext(ctx, token, "SELECT * FROM refresh_tokens WHERE token_hash = ? FOR UPDATE", HashToken(params.RefreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select refresh token: %w", err)
+	}
+
+	session := &Session{}
+	if err := tx.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = ? FOR UPDATE", token.SessionID); err != nil {
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	if token.UsedAt.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, session.ID); err != nil {
+			return nil, fmt.Errorf("revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit tx: %w", err)
+		}
+
+		return nil, ErrRefreshTokenReused
+	}
+
+	if !session.IsActive(now) {
+		return nil, ErrSessionRevoked
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?", now, token.TokenHash); err != nil {
+		return nil, fmt.Errorf("use refresh token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id) VALUES (?, ?)", HashToken(params.NewRefreshToken), session.ID); err != nil {
+		return nil, fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", now, session.ID); err != nil {
+		return nil, fmt.Errorf("update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	session.LastSeenAt = now
+	return session, nil
+}
+
+// GetActiveSessions lists the sessions of the user that can still be used, most recently seen first
+func (r *Repository) GetActiveSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	sessions := []Session{}
+	query := "SELECT * FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_seen_at DESC"
+	if err := r.db.SelectContext(ctx, &sessions, query, userID, time.Now()); err != nil {
+		return nil, fmt.Errorf("select sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that the session was used from ip
+func (r *Repository) TouchSession(ctx context.Context, sessionID uuid.UUID, ip string) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ?, ip = ? WHERE id = ?", time.Now(), truncate(ip, 45), sessionID); err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except keepID and returns how many sessions were revoked
+func (r *Repository) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL", time.Now(), userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return n, nil
+}
+
+// RevokeSession signs the session out. Revoking it twice is not an error.
+func (r *Repository) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND user_id = ?", time.Now(), sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// HashToken is how tokens are stored. High-entropy random tokens need no salt or slow hash.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+
+	return string(r[:n])
+}
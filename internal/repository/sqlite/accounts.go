@@ -0,0 +1,886 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+func (s *Store) CreateSession(ctx context.Context, params repository.CreateSessionParams) (uuid.UUID, error) {
+	sessionID := uuid.New()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO sessions (id, user_id, user_agent, ip, expires_at) VALUES (?, ?, ?, ?, ?)",
+		sessionID, params.UserID, params.UserAgent, params.IP, timestamp(params.ExpiresAt),
+	); err != nil {
+		return uuid.Nil, fmt.Errorf("insert session: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id) VALUES (?, ?)", repository.HashToken(params.RefreshToken), sessionID); err != nil {
+		return uuid.Nil, fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return sessionID, nil
+}
+
+func (s *Store) GetSession(ctx context.Context, sessionID uuid.UUID) (*repository.Session, error) {
+	session := &repository.Session{}
+	if err := s.db.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = ?", sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	return session, nil
+}
+
+// GetSessionByRefreshToken finds the session a refresh token was issued for, whether or not the token was exchanged since.
+// It returns ErrNotFound for an unknown token.
+func (s *Store) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*repository.Session, error) {
+	session := &repository.Session{}
+	query := "SELECT sessions.* FROM sessions JOIN refresh_tokens ON refresh_tokens.session_id = sessions.id WHERE refresh_tokens.token_hash = ?"
+	if err := s.db.GetContext(ctx, session, query, repository.HashToken(refreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	return session, nil
+}
+
+// GetActiveSessions lists the sessions of the user that can still be used, most recently seen first
+func (s *Store) GetActiveSessions(ctx context.Context, userID uuid.UUID) ([]repository.Session, error) {
+	sessions := []repository.Session{}
+	query := "SELECT * FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_seen_at DESC"
+	if err := s.db.SelectContext(ctx, &sessions, query, userID, timestamp(time.Now())); err != nil {
+		return nil, fmt.Errorf("select sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that the session was used from ip
+func (s *Store) TouchSession(ctx context.Context, sessionID uuid.UUID, ip string) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ?, ip = ? WHERE id = ?", timestamp(time.Now()), ip, sessionID); err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges an unused refresh token for NewRefreshToken.
+// Presenting a token that was already exchanged revokes the whole session.
+func (s *Store) RotateRefreshToken(ctx context.Context, params repository.RotateRefreshTokenParams) (*repository.Session, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	token, err := getRefreshToken(ctx, tx, params.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &repository.Session{}
+	if err := tx.GetContext(ctx, session, "SELECT * FROM sessions WHERE id = ?", token.SessionID); err != nil {
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	if token.UsedAt.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", timestamp(now), session.ID); err != nil {
+			return nil, fmt.Errorf("revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit tx: %w", err)
+		}
+
+		return nil, repository.ErrRefreshTokenReused
+	}
+
+	if !session.IsActive(now) {
+		return nil, repository.ErrSessionRevoked
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?", timestamp(now), token.TokenHash); err != nil {
+		return nil, fmt.Errorf("use refresh token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (token_hash, session_id) VALUES (?, ?)", repository.HashToken(params.NewRefreshToken), session.ID); err != nil {
+		return nil, fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", timestamp(now), session.ID); err != nil {
+		return nil, fmt.Errorf("update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	session.LastSeenAt = now
+	return session, nil
+}
+
+// RevokeSession signs the session out. Revoking it twice is not an error.
+func (s *Store) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND user_id = ?", timestamp(time.Now()), sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// RevokeSessionByRefreshToken signs out the session a refresh token was issued for.
+// It returns ErrNotFound for an unknown token. A token that was already exchanged revokes the session too,
+// as in RotateRefreshToken, but returns ErrRefreshTokenReused.
+func (s *Store) RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	token, err := getRefreshToken(ctx, tx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", timestamp(time.Now()), token.SessionID); err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	if token.UsedAt.Valid {
+		return repository.ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except keepID and returns how many sessions were revoked
+func (s *Store) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL", timestamp(time.Now()), userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return n, nil
+}
+
+func (s *Store) GetTOTP(ctx context.Context, userID uuid.UUID) (*repository.UserTOTP, error) {
+	totp := &repository.UserTOTP{}
+	if err := s.db.GetContext(ctx, totp, "SELECT * FROM user_totp WHERE user_id = ?", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select totp: %w", err)
+	}
+
+	return totp, nil
+}
+
+// SetPendingTOTP stores a secret waiting for confirmation, replacing an earlier unconfirmed one.
+// It returns ErrAlreadyExists when two-factor authentication is already enabled.
+func (s *Store) SetPendingTOTP(ctx context.Context, params repository.SetTOTPParams) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	totp := &repository.UserTOTP{}
+	err = tx.GetContext(ctx, totp, "SELECT * FROM user_totp WHERE user_id = ?", params.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select totp: %w", err)
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		return repository.ErrAlreadyExists
+	}
+
+	if _, err := tx.ExecContext(ctx, "REPLACE INTO user_totp (user_id, secret) VALUES (?, ?)", params.UserID, params.Secret); err != nil {
+		return fmt.Errorf("replace totp: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records a verified code so the same or an earlier one is not accepted again.
+// It returns ErrNotFound when the step was already used.
+func (s *Store) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?", step, userID, step)
+	if err != nil {
+		return fmt.Errorf("update totp step: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// ConfirmTOTP enables two-factor authentication and replaces the recovery codes
+func (s *Store) ConfirmTOTP(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE user_totp SET confirmed_at = ? WHERE user_id = ? AND confirmed_at IS NULL", timestamp(time.Now()), userID)
+	if err != nil {
+		return fmt.Errorf("confirm totp: %w", err)
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates every previous recovery code
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns ErrNotFound when there is none.
+func (s *Store) UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error {
+	query := "UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"
+	result, err := s.db.ExecContext(ctx, query, timestamp(time.Now()), userID, repository.HashToken(code))
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	if err := s.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+
+	return n, nil
+}
+
+// DeleteTOTP disables two-factor authentication
+func (s *Store) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete totp: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) CreatePersonalAccessToken(ctx context.Context, params repository.CreatePersonalAccessTokenParams) (uuid.UUID, error) {
+	tokenID := uuid.New()
+
+	var expiresAt sql.NullTime
+	if params.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *params.ExpiresAt, Valid: true}
+	}
+
+	query := "INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, tokenID, params.UserID, params.Name, repository.HashToken(params.Token), strings.Join(params.Scopes, " "), nullTimestamp(expiresAt)); err != nil {
+		return uuid.Nil, fmt.Errorf("insert personal access token: %w", err)
+	}
+
+	return tokenID, nil
+}
+
+func (s *Store) GetPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]repository.PersonalAccessToken, error) {
+	tokens := []repository.PersonalAccessToken{}
+	if err := s.db.SelectContext(ctx, &tokens, "SELECT * FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("select personal access tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// GetPersonalAccessTokenByToken looks a token up by its secret value
+func (s *Store) GetPersonalAccessTokenByToken(ctx context.Context, token string) (*repository.PersonalAccessToken, error) {
+	pat := &repository.PersonalAccessToken{}
+	if err := s.db.GetContext(ctx, pat, "SELECT * FROM personal_access_tokens WHERE token_hash = ?", repository.HashToken(token)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select personal access token: %w", err)
+	}
+
+	return pat, nil
+}
+
+func (s *Store) TouchPersonalAccessToken(ctx context.Context, tokenID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE personal_access_tokens SET last_used_at = ? WHERE id = ?", timestamp(time.Now()), tokenID); err != nil {
+		return fmt.Errorf("touch personal access token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeletePersonalAccessToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("delete personal access token: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeletePersonalAccessTokens deletes every token of the user
+func (s *Store) DeletePersonalAccessTokens(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM personal_access_tokens WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete personal access tokens: %w", err)
+	}
+
+	return nil
+}
+
+// CreateUserToken stores a single-use token and retires the user's earlier ones of the same purpose.
+// It returns ErrAlreadyExists while the previous token is younger than the cooldown.
+func (s *Store) CreateUserToken(ctx context.Context, params repository.CreateUserTokenParams) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// MAX(created_at) would come back as text, which does not scan into a time
+	var latest time.Time
+	err = tx.GetContext(ctx, &latest, "SELECT created_at FROM user_tokens WHERE user_id = ? AND purpose = ? ORDER BY created_at DESC LIMIT 1", params.UserID, params.Purpose)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select user token: %w", err)
+	}
+	if err == nil && time.Since(latest) < params.Cooldown {
+		return repository.ErrAlreadyExists
+	}
+
+	now := timestamp(time.Now())
+	if _, err := tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", now, params.UserID, params.Purpose); err != nil {
+		return fmt.Errorf("retire user tokens: %w", err)
+	}
+
+	query := "INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, repository.HashToken(params.Token), params.UserID, params.Purpose, params.Email, timestamp(params.ExpiresAt), now); err != nil {
+		return fmt.Errorf("insert user token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserToken looks up a usable token without redeeming it.
+// It returns ErrNotFound for an unknown, used or expired token.
+func (s *Store) GetUserToken(ctx context.Context, purpose string, token string) (*repository.UserToken, error) {
+	t := &repository.UserToken{}
+	if err := s.db.GetContext(ctx, t, "SELECT * FROM user_tokens WHERE token_hash = ? AND purpose = ?", repository.HashToken(token), purpose); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select user token: %w", err)
+	}
+
+	if !t.IsUsable(time.Now()) {
+		return nil, repository.ErrNotFound
+	}
+
+	return t, nil
+}
+
+// UseUserToken redeems a token. Only one caller succeeds; the rest get ErrNotFound.
+func (s *Store) UseUserToken(ctx context.Context, purpose string, token string) error {
+	now := timestamp(time.Now())
+	query := "UPDATE user_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?"
+	result, err := s.db.ExecContext(ctx, query, now, repository.HashToken(token), purpose, now)
+	if err != nil {
+		return fmt.Errorf("use user token: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+func (s *Store) CreateOAuthClient(ctx context.Context, params repository.CreateOAuthClientParams) (uuid.UUID, error) {
+	clientID := uuid.New()
+
+	var secretHash []byte
+	if params.Secret != "" {
+		secretHash = repository.HashToken(params.Secret)
+	}
+
+	query := "INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris, scopes) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, clientID, params.UserID, params.Name, secretHash, strings.Join(params.RedirectURIs, " "), strings.Join(params.Scopes, " ")); err != nil {
+		return uuid.Nil, fmt.Errorf("insert oauth client: %w", err)
+	}
+
+	return clientID, nil
+}
+
+// GetOAuthClient returns ErrNotFound for an unknown client
+func (s *Store) GetOAuthClient(ctx context.Context, clientID uuid.UUID) (*repository.OAuthClient, error) {
+	client := &repository.OAuthClient{}
+	if err := s.db.GetContext(ctx, client, "SELECT * FROM oauth_clients WHERE id = ?", clientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth client: %w", err)
+	}
+
+	return client, nil
+}
+
+// GetOAuthClients lists the clients a user registered
+func (s *Store) GetOAuthClients(ctx context.Context, userID uuid.UUID) ([]repository.OAuthClient, error) {
+	clients := []repository.OAuthClient{}
+	if err := s.db.SelectContext(ctx, &clients, "SELECT * FROM oauth_clients WHERE user_id = ? ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("select oauth clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes a client with its codes and grants. It returns ErrNotFound unless userID registered it.
+func (s *Store) DeleteOAuthClient(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM oauth_clients WHERE id = ? AND user_id = ?", clientID, userID)
+	if err != nil {
+		return fmt.Errorf("delete oauth client: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+func (s *Store) CreateOAuthCode(ctx context.Context, params repository.CreateOAuthCodeParams) error {
+	query := "INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, repository.HashToken(params.Code), params.ClientID, params.UserID, params.RedirectURI, strings.Join(params.Scopes, " "), params.CodeChallenge, timestamp(params.ExpiresAt)); err != nil {
+		return fmt.Errorf("insert oauth code: %w", err)
+	}
+
+	return nil
+}
+
+// GetOAuthCode looks a code up without redeeming it. It returns ErrNotFound for an unknown code.
+func (s *Store) GetOAuthCode(ctx context.Context, code string) (*repository.OAuthCode, error) {
+	c := &repository.OAuthCode{}
+	if err := s.db.GetContext(ctx, c, "SELECT * FROM oauth_codes WHERE code_hash = ?", repository.HashToken(code)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth code: %w", err)
+	}
+
+	return c, nil
+}
+
+// RedeemOAuthCode exchanges a code for a new grant holding its scopes.
+// It returns ErrNotFound for an unknown or expired code or one issued to another client,
+// and ErrOAuthCodeReused, after revoking the grant, when the code was redeemed before.
+func (s *Store) RedeemOAuthCode(ctx context.Context, params repository.RedeemOAuthCodeParams) (*repository.OAuthGrant, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	code := &repository.OAuthCode{}
+	if err := tx.GetContext(ctx, code, "SELECT * FROM oauth_codes WHERE code_hash = ?", repository.HashToken(params.Code)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth code: %w", err)
+	}
+
+	now := time.Now()
+	if code.ClientID != params.ClientID {
+		return nil, repository.ErrNotFound
+	}
+
+	if code.UsedAt.Valid {
+		if code.GrantID.Valid {
+			if _, err := tx.ExecContext(ctx, "UPDATE oauth_grants SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", timestamp(now), code.GrantID.String); err != nil {
+				return nil, fmt.Errorf("revoke oauth grant: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit tx: %w", err)
+		}
+		return nil, repository.ErrOAuthCodeReused
+	}
+
+	if !now.Before(code.ExpiresAt) {
+		return nil, repository.ErrNotFound
+	}
+
+	grant := &repository.OAuthGrant{
+		ID:               uuid.New(),
+		ClientID:         code.ClientID,
+		UserID:           code.UserID,
+		Scopes:           code.Scopes,
+		RefreshTokenHash: repository.HashToken(params.RefreshToken),
+		ExpiresAt:        params.ExpiresAt,
+		CreatedAt:        now,
+	}
+
+	query := "INSERT INTO oauth_grants (id, client_id, user_id, scopes, refresh_token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, grant.ID, grant.ClientID, grant.UserID, grant.Scopes, grant.RefreshTokenHash, timestamp(grant.ExpiresAt), timestamp(grant.CreatedAt)); err != nil {
+		return nil, fmt.Errorf("insert oauth grant: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE oauth_codes SET used_at = ?, grant_id = ? WHERE code_hash = ?", timestamp(now), grant.ID, code.CodeHash); err != nil {
+		return nil, fmt.Errorf("use oauth code: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return grant, nil
+}
+
+// GetOAuthGrant returns ErrNotFound for an unknown grant
+func (s *Store) GetOAuthGrant(ctx context.Context, grantID uuid.UUID) (*repository.OAuthGrant, error) {
+	grant := &repository.OAuthGrant{}
+	if err := s.db.GetContext(ctx, grant, "SELECT * FROM oauth_grants WHERE id = ?", grantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth grant: %w", err)
+	}
+
+	return grant, nil
+}
+
+// GetOAuthGrantByRefreshToken returns ErrNotFound for an unknown refresh token
+func (s *Store) GetOAuthGrantByRefreshToken(ctx context.Context, refreshToken string) (*repository.OAuthGrant, error) {
+	grant := &repository.OAuthGrant{}
+	if err := s.db.GetContext(ctx, grant, "SELECT * FROM oauth_grants WHERE refresh_token_hash = ?", repository.HashToken(refreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select oauth grant: %w", err)
+	}
+
+	return grant, nil
+}
+
+// RotateOAuthRefreshToken replaces the refresh token of an active grant held by the client.
+// It returns ErrNotFound when there is no such grant, including for a refresh token already rotated.
+func (s *Store) RotateOAuthRefreshToken(ctx context.Context, params repository.RotateOAuthRefreshTokenParams) (*repository.OAuthGrant, error) {
+	query := "UPDATE oauth_grants SET refresh_token_hash = ? WHERE refresh_token_hash = ? AND client_id = ? AND revoked_at IS NULL AND expires_at > ?"
+	result, err := s.db.ExecContext(ctx, query, repository.HashToken(params.NewRefreshToken), repository.HashToken(params.RefreshToken), params.ClientID, timestamp(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("rotate oauth refresh token: %w", err)
+	}
+
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	return s.GetOAuthGrantByRefreshToken(ctx, params.NewRefreshToken)
+}
+
+// RevokeOAuthGrant ends a grant. Revoking it twice is not an error.
+func (s *Store) RevokeOAuthGrant(ctx context.Context, grantID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE oauth_grants SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", timestamp(time.Now()), grantID); err != nil {
+		return fmt.Errorf("revoke oauth grant: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeOAuthGrants ends every grant the user gave, and drops the codes not yet redeemed for one
+func (s *Store) RevokeOAuthGrants(ctx context.Context, userID uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM oauth_codes WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return fmt.Errorf("delete oauth codes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE oauth_grants SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", timestamp(time.Now()), userID); err != nil {
+		return fmt.Errorf("revoke oauth grants: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// CreateClientCertificate returns ErrAlreadyExists when the subject is mapped to a user already
+func (s *Store) CreateClientCertificate(ctx context.Context, params repository.CreateClientCertificateParams) (uuid.UUID, error) {
+	certificateID := uuid.New()
+
+	query := "INSERT INTO client_certificates (id, user_id, subject, scopes) VALUES (?, ?, ?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, certificateID, params.UserID, params.Subject, strings.Join(params.Scopes, " ")); err != nil {
+		if isUniqueViolation(err) {
+			return uuid.Nil, repository.ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert client certificate: %w", err)
+	}
+
+	return certificateID, nil
+}
+
+func (s *Store) GetClientCertificates(ctx context.Context, userID uuid.UUID) ([]repository.ClientCertificate, error) {
+	certificates := []repository.ClientCertificate{}
+	if err := s.db.SelectContext(ctx, &certificates, "SELECT * FROM client_certificates WHERE user_id = ? ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("select client certificates: %w", err)
+	}
+
+	return certificates, nil
+}
+
+// GetClientCertificateBySubject looks up who a verified certificate belongs to
+func (s *Store) GetClientCertificateBySubject(ctx context.Context, subject string) (*repository.ClientCertificate, error) {
+	certificate := &repository.ClientCertificate{}
+	if err := s.db.GetContext(ctx, certificate, "SELECT * FROM client_certificates WHERE subject = ?", subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select client certificate: %w", err)
+	}
+
+	return certificate, nil
+}
+
+func (s *Store) TouchClientCertificate(ctx context.Context, certificateID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE client_certificates SET last_used_at = ? WHERE id = ?", timestamp(time.Now()), certificateID); err != nil {
+		return fmt.Errorf("touch client certificate: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteClientCertificate(ctx context.Context, userID uuid.UUID, certificateID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM client_certificates WHERE id = ? AND user_id = ?", certificateID, userID)
+	if err != nil {
+		return fmt.Errorf("delete client certificate: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// GetAuthThrottle returns ErrNotFound when the key has no recorded failures
+func (s *Store) GetAuthThrottle(ctx context.Context, key string) (*repository.AuthThrottle, error) {
+	throttle := &repository.AuthThrottle{}
+	if err := s.db.GetContext(ctx, throttle, "SELECT * FROM auth_throttles WHERE throttle_key = ?", key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select auth throttle: %w", err)
+	}
+
+	return throttle, nil
+}
+
+// ReserveAuthAttempt counts an attempt against the key before its credentials are checked.
+// It returns ErrThrottled while attempts are refused, and the updated row otherwise.
+func (s *Store) ReserveAuthAttempt(ctx context.Context, params repository.ReserveAuthAttemptParams) (*repository.AuthThrottle, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := now()
+	query := "INSERT INTO auth_throttles (throttle_key, failures, last_failure_at) VALUES (?, 0, ?) ON CONFLICT (throttle_key) DO NOTHING"
+	if _, err := tx.ExecContext(ctx, query, params.Key, timestamp(now)); err != nil {
+		return nil, fmt.Errorf("insert auth throttle: %w", err)
+	}
+
+	throttle := &repository.AuthThrottle{}
+	if err := tx.GetContext(ctx, throttle, "SELECT * FROM auth_throttles WHERE throttle_key = ?", params.Key); err != nil {
+		return nil, fmt.Errorf("select auth throttle: %w", err)
+	}
+
+	if err := throttle.Reserve(params, now); err != nil {
+		return nil, err
+	}
+
+	query = "UPDATE auth_throttles SET failures = ?, locked_until = ?, last_failure_at = ? WHERE throttle_key = ?"
+	if _, err := tx.ExecContext(ctx, query, throttle.Failures, nullTimestamp(throttle.LockedUntil), timestamp(throttle.LastFailureAt), params.Key); err != nil {
+		return nil, fmt.Errorf("reserve auth attempt: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return throttle, nil
+}
+
+// ReleaseAuthAttempt takes back an attempt ReserveAuthAttempt counted once it turns out not to have failed
+func (s *Store) ReleaseAuthAttempt(ctx context.Context, reserved *repository.AuthThrottle) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	throttle := &repository.AuthThrottle{}
+	if err := tx.GetContext(ctx, throttle, "SELECT * FROM auth_throttles WHERE throttle_key = ?", reserved.Key); err != nil {
+		// the key has been reset since
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("select auth throttle: %w", err)
+	}
+
+	throttle.Release(reserved)
+	if _, err := tx.ExecContext(ctx, "UPDATE auth_throttles SET failures = ?, locked_until = ? WHERE throttle_key = ?", throttle.Failures, nullTimestamp(throttle.LockedUntil), reserved.Key); err != nil {
+		return fmt.Errorf("release auth attempt: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// LockAuthThrottle refuses attempts for the key until the given time
+func (s *Store) LockAuthThrottle(ctx context.Context, key string, until time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE auth_throttles SET locked_until = ? WHERE throttle_key = ?", timestamp(until), key); err != nil {
+		return fmt.Errorf("lock auth throttle: %w", err)
+	}
+
+	return nil
+}
+
+// ResetAuthThrottle forgets the failures of the key
+func (s *Store) ResetAuthThrottle(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM auth_throttles WHERE throttle_key = ?", key); err != nil {
+		return fmt.Errorf("reset auth throttle: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) CreateAuditLog(ctx context.Context, params repository.CreateAuditLogParams) error {
+	query := "INSERT INTO audit_logs (event, user_id, actor_id, ip, detail) VALUES (?, ?, ?, ?, ?)"
+	if _, err := s.db.ExecContext(ctx, query, params.Event, nullUUID(params.UserID), nullUUID(params.ActorID), params.IP, params.Detail); err != nil {
+		return fmt.Errorf("insert audit log: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditLogs returns the most recent entries about the user
+func (s *Store) GetAuditLogs(ctx context.Context, userID uuid.UUID, limit int) ([]repository.AuditLog, error) {
+	logs := []repository.AuditLog{}
+	if err := s.db.SelectContext(ctx, &logs, "SELECT * FROM audit_logs WHERE user_id = ? ORDER BY id DESC LIMIT ?", userID, limit); err != nil {
+		return nil, fmt.Errorf("select audit logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// getRefreshToken returns ErrNotFound for an unknown token
+func getRefreshToken(ctx context.Context, tx *sqlx.Tx, refreshToken string) (*repository.RefreshToken, error) {
+	token := &repository.RefreshToken{}
+	if err := tx.GetContext(ctx, token, "SELECT * FROM refresh_tokens WHERE token_hash = ?", repository.HashToken(refreshToken)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, codes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (code_hash, user_id) VALUES (?, ?)", repository.HashToken(code), userID); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// nullUUID stores uuid.Nil as NULL
+func nullUUID(id uuid.UUID) sql.NullString {
+	if id == uuid.Nil {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: id.String(), Valid: true}
+}
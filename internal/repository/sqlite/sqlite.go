@@ -0,0 +1,1300 @@
+// Package sqlite keeps everything in an SQLite database, for local development without a database server.
+package sqlite
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/migration"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	sqlitedriver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type Store struct {
+	db        *sqlx.DB
+	passwords *password.Hasher
+	// dummyHash is compared against for unknown names so they cost as much as a wrong password
+	dummyHash []byte
+}
+
+var _ repository.Store = (*Store)(nil)
+
+// Open opens the database file at path, or a private in-memory database for ":memory:", and migrates it
+func Open(path string) (*sqlx.DB, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma":      {"foreign_keys(1)", "busy_timeout(5000)"},
+		"_time_format": {"sqlite"},
+	}.Encode()
+
+	db, err := sqlx.Connect("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+
+	// every connection to :memory: would get a database of its own, and SQLite has a single writer anyway
+	db.SetMaxOpenConns(1)
+
+	if err := migration.MigrateSQLiteTables(db.DB); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func New(db *sqlx.DB, passwords *password.Hasher) (*Store, error) {
+	dummyHash, err := passwords.Hash("dummy password")
+	if err != nil {
+		return nil, fmt.Errorf("hash dummy password: %w", err)
+	}
+
+	return &Store{db: db, passwords: passwords, dummyHash: dummyHash}, nil
+}
+
+// GetTasks lists the user's tasks sorted by params.Sort, oldest first when it is empty
+func (s *Store) GetTasks(ctx context.Context, params repository.GetTasksParams) ([]repository.Task, error) {
+	query := "SELECT * FROM tasks WHERE user_id = ?"
+	args := []interface{}{params.UserID}
+
+	if params.ProjectID != uuid.Nil {
+		query += " AND project_id = ?"
+		args = append(args, params.ProjectID)
+	}
+	if params.IsDone != nil {
+		query += " AND is_done = ?"
+		args = append(args, *params.IsDone)
+	}
+	if !params.CreatedAfter.IsZero() {
+		query += " AND created_at > ?"
+		args = append(args, timestamp(params.CreatedAfter))
+	}
+	if !params.CreatedBefore.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, timestamp(params.CreatedBefore))
+	}
+	if !params.DueBefore.IsZero() {
+		query += " AND due_at < ?"
+		args = append(args, timestamp(params.DueBefore))
+	}
+	if params.TitleContains != "" {
+		query += ` AND title LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(params.TitleContains)+"%")
+	}
+	// names compare like the column, ignoring case
+	hasLabel := "EXISTS (SELECT 1 FROM task_labels tl JOIN labels l ON l.id = tl.label_id WHERE tl.task_id = tasks.id AND l.name = ?)"
+	for _, name := range params.Labels {
+		query += " AND " + hasLabel
+		args = append(args, name)
+	}
+	for _, name := range params.ExcludeLabels {
+		query += " AND NOT " + hasLabel
+		args = append(args, name)
+	}
+
+	op, dir := ">", "ASC"
+	if params.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	column := "created_at"
+	switch params.Sort {
+	case repository.TaskSortTitle:
+		column = "title"
+	case repository.TaskSortDue:
+		column = "due_at"
+	}
+
+	if after := params.After; after != nil {
+		switch {
+		case params.Sort == repository.TaskSortTitle:
+			query += fmt.Sprintf(" AND (title %s ? OR (title = ? AND id %s ?))", op, op)
+			args = append(args, after.Title, after.Title, after.ID)
+		case params.Sort == repository.TaskSortDue && after.At.IsZero():
+			query += fmt.Sprintf(" AND due_at IS NULL AND id %s ?", op)
+			args = append(args, after.ID)
+		case params.Sort == repository.TaskSortDue:
+			query += fmt.Sprintf(" AND (due_at IS NULL OR due_at %s ? OR (due_at = ? AND id %s ?))", op, op)
+			args = append(args, timestamp(after.At), timestamp(after.At), after.ID)
+		default:
+			query += fmt.Sprintf(" AND (created_at %s ? OR (created_at = ? AND id %s ?))", op, op)
+			args = append(args, timestamp(after.At), timestamp(after.At), after.ID)
+		}
+	}
+
+	order := fmt.Sprintf("%s %s, id %s", column, dir, dir)
+	if params.Sort == repository.TaskSortDue {
+		order = "due_at IS NULL, " + order
+	}
+	query += " ORDER BY " + order
+	if params.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, params.Limit)
+	}
+
+	tasks := []repository.Task{}
+	if err := s.db.SelectContext(ctx, &tasks, query, args...); err != nil {
+		return nil, fmt.Errorf("select tasks: %w", err)
+	}
+
+	loaded := make([]*repository.Task, len(tasks))
+	for i := range tasks {
+		loaded[i] = &tasks[i]
+	}
+	if err := loadLabels(ctx, s.db, loaded); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// GetTask returns ErrNotFound unless the task exists and belongs to userID
+func (s *Store) GetTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) (*repository.Task, error) {
+	task := &repository.Task{}
+	if err := s.db.GetContext(ctx, task, "SELECT * FROM tasks WHERE id = ? AND user_id = ?", taskID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select task: %w", err)
+	}
+
+	if err := loadLabels(ctx, s.db, []*repository.Task{task}); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// SearchTasks narrows the tasks down with LIKE and scores them in Go, as SQLite has no ngram index
+func (s *Store) SearchTasks(ctx context.Context, params repository.SearchTasksParams) ([]repository.SearchedTask, error) {
+	searched := []repository.SearchedTask{}
+	if params.Target.IsEmpty() {
+		return searched, nil
+	}
+
+	query := "SELECT * FROM tasks WHERE user_id = ?"
+	args := []interface{}{params.UserID}
+	for _, term := range params.Target.Terms {
+		query += ` AND title LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(term.Text)+"%")
+	}
+	query += " ORDER BY created_at DESC, rowid DESC"
+
+	tasks := []repository.Task{}
+	if err := s.db.SelectContext(ctx, &tasks, query, args...); err != nil {
+		return nil, fmt.Errorf("search tasks: %w", err)
+	}
+
+	for _, task := range tasks {
+		// LIKE ignores the case of ASCII letters only
+		if score := params.Target.Score(task.Title); score > 0 {
+			searched = append(searched, repository.SearchedTask{Task: task, Score: score})
+		}
+	}
+	sort.SliceStable(searched, func(i, j int) bool { return searched[i].Score > searched[j].Score })
+
+	loaded := make([]*repository.Task, len(searched))
+	for i := range searched {
+		loaded[i] = &searched[i].Task
+	}
+	if err := loadLabels(ctx, s.db, loaded); err != nil {
+		return nil, err
+	}
+
+	return searched, nil
+}
+
+// CreateTask schedules the reminders of the task along with it, and starts its series when it repeats
+func (s *Store) CreateTask(ctx context.Context, params repository.CreateTaskParams) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	projectID := params.ProjectID
+	if projectID == uuid.Nil {
+		if projectID, err = getInboxID(ctx, tx, params.UserID); err != nil {
+			return err
+		}
+	} else if err := checkProject(ctx, tx, params.UserID, projectID); err != nil {
+		return err
+	}
+
+	task := &repository.Task{
+		ID:          uuid.New(),
+		UserID:      params.UserID,
+		ProjectID:   projectID,
+		Title:       params.Title,
+		DueAt:       params.DueAt,
+		DueTimeZone: sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""},
+		Reminders:   repository.JoinReminders(params.Reminders),
+	}
+	if series := params.Series(); series != nil {
+		if err := insertTaskSeries(ctx, tx, series); err != nil {
+			return err
+		}
+		task.SeriesID = uuid.NullUUID{UUID: series.ID, Valid: true}
+		task.Recurrence = series.Recurrence
+		task.OccurrenceAt = params.DueAt
+	}
+
+	if err := insertTask(ctx, tx, task); err != nil {
+		return err
+	}
+
+	if err := setTaskLabels(ctx, tx, params.UserID, task.ID, params.LabelIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTask reschedules the reminders of the task.
+// Completing an occurrence of a recurring task creates the next one, in the same project and with the same labels.
+func (s *Store) UpdateTask(ctx context.Context, params repository.UpdateTaskParams) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	task, err := getTask(ctx, tx, params.UserID, params.ID)
+	if err != nil {
+		return err
+	}
+
+	// a task may stay in a project archived since, but not be moved into one
+	if params.ProjectID == uuid.Nil {
+		params.ProjectID = task.ProjectID
+	} else if params.ProjectID != task.ProjectID {
+		if err := checkProject(ctx, tx, params.UserID, params.ProjectID); err != nil {
+			return err
+		}
+	}
+
+	timeZone := sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""}
+	query := "UPDATE tasks SET project_id = ?, title = ?, is_done = ?, due_at = ?, due_time_zone = ?, reminders = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, params.ProjectID, params.Title, params.IsDone, nullTimestamp(params.DueAt), timeZone, repository.JoinReminders(params.Reminders), params.ID); err != nil {
+		return fmt.Errorf("update task: %w", err)
+	}
+
+	if params.Following {
+		var previous *repository.TaskSeries
+		if task.SeriesID.Valid {
+			previous = &repository.TaskSeries{}
+			if err := tx.GetContext(ctx, previous, "SELECT * FROM task_series WHERE id = ?", task.SeriesID.UUID); err != nil {
+				return fmt.Errorf("select task series: %w", err)
+			}
+		}
+
+		series, err := params.Series(task, previous)
+		if err != nil {
+			return err
+		}
+		if err := setTaskSeries(ctx, tx, task, series); err != nil {
+			return err
+		}
+	}
+
+	if err := setReminders(ctx, tx, params.ID, params.DueAt, params.Reminders); err != nil {
+		return err
+	}
+
+	if params.LabelIDs != nil {
+		if err := setTaskLabels(ctx, tx, params.UserID, params.ID, params.LabelIDs); err != nil {
+			return err
+		}
+	}
+
+	if !task.IsDone && params.IsDone && task.SeriesID.Valid {
+		task.ProjectID = params.ProjectID
+		if err := createNextOccurrence(ctx, tx, task); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteTask ends the series of a recurring task, as no occurrence is left to follow it
+func (s *Store) DeleteTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tasks WHERE id = ? AND user_id = ?", taskID, userID)
+	if err != nil {
+		return fmt.Errorf("delete task: %w", err)
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	return pruneTaskSeries(ctx, s.db, userID)
+}
+
+// GetTaskSeries returns ErrNotFound unless the series exists and belongs to userID
+func (s *Store) GetTaskSeries(ctx context.Context, userID uuid.UUID, seriesID uuid.UUID) (*repository.TaskSeries, error) {
+	series := &repository.TaskSeries{}
+	if err := s.db.GetContext(ctx, series, "SELECT * FROM task_series WHERE id = ? AND user_id = ?", seriesID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select task series: %w", err)
+	}
+
+	return series, nil
+}
+
+// SkipOccurrence moves a recurring task on to its next occurrence, made afresh from its series.
+// It returns ErrSeriesEnded when there is none.
+func (s *Store) SkipOccurrence(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	task, err := getTask(ctx, tx, userID, taskID)
+	if err != nil {
+		return err
+	}
+	if !task.SeriesID.Valid {
+		return repository.ErrSeriesEnded
+	}
+
+	series := &repository.TaskSeries{}
+	if err := tx.GetContext(ctx, series, "SELECT * FROM task_series WHERE id = ?", task.SeriesID.UUID); err != nil {
+		return fmt.Errorf("select task series: %w", err)
+	}
+
+	next, ok, err := series.NextOccurrence(task.OccurrenceAt.Time)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return repository.ErrSeriesEnded
+	}
+
+	occurrence := series.Occurrence(next)
+	query := "UPDATE tasks SET title = ?, due_at = ?, due_time_zone = ?, reminders = ?, occurrence_at = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, occurrence.Title, nullTimestamp(occurrence.DueAt), occurrence.DueTimeZone, occurrence.Reminders, nullTimestamp(occurrence.OccurrenceAt), taskID); err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrAlreadyExists
+		}
+		return fmt.Errorf("update task: %w", err)
+	}
+
+	if err := setReminders(ctx, tx, taskID, occurrence.DueAt, occurrence.ReminderList()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetTaskCounts(ctx context.Context, userID uuid.UUID) (*repository.TaskCounts, error) {
+	counts := &repository.TaskCounts{}
+	if err := s.db.GetContext(ctx, counts, "SELECT COUNT(*) AS total, COALESCE(SUM(is_done), 0) AS done FROM tasks WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("count tasks: %w", err)
+	}
+
+	return counts, nil
+}
+
+// CreateUser also creates the user's Inbox
+func (s *Store) CreateUser(ctx context.Context, params repository.CreateUserParams) (uuid.UUID, error) {
+	userID := uuid.New()
+	hashed, err := s.passwords.Hash(params.Password)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	email := sql.NullString{String: params.Email, Valid: params.Email != ""}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO users (id, name, password, email) VALUES (?, ?, ?, ?)", userID, params.Name, hashed, email); err != nil {
+		if isUniqueViolation(err) {
+			return uuid.Nil, repository.ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert user: %w", err)
+	}
+
+	if err := insertInbox(ctx, tx, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return userID, nil
+}
+
+// CreateUserWithIdentity creates a user who signs in through provider.
+// The password is random and unknown, so it has to be reset before it can be used.
+// It returns ErrAlreadyExists when the name is taken or the identity is already linked.
+func (s *Store) CreateUserWithIdentity(ctx context.Context, params repository.CreateUserWithIdentityParams) (uuid.UUID, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return uuid.Nil, fmt.Errorf("generate password: %w", err)
+	}
+
+	hashed, err := s.passwords.Hash(base64.RawStdEncoding.EncodeToString(b))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID := uuid.New()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO users (id, name, password) VALUES (?, ?, ?)", userID, params.Name, hashed); err != nil {
+		if isUniqueViolation(err) {
+			return uuid.Nil, repository.ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert user: %w", err)
+	}
+
+	if err := insertInbox(ctx, tx, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	email := sql.NullString{String: params.Email, Valid: params.Email != ""}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO user_identities (provider, subject, user_id, email) VALUES (?, ?, ?, ?)", params.Provider, params.Subject, userID, email); err != nil {
+		if isPrimaryKeyViolation(err) {
+			return uuid.Nil, repository.ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert user identity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return userID, nil
+}
+
+// GetUserIdentity returns ErrNotFound when the external account is not linked to a user
+func (s *Store) GetUserIdentity(ctx context.Context, provider string, subject string) (*repository.UserIdentity, error) {
+	identity := &repository.UserIdentity{}
+	if err := s.db.GetContext(ctx, identity, "SELECT * FROM user_identities WHERE provider = ? AND subject = ?", provider, subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select user identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// GetProjects lists the user's projects, the Inbox first and the rest by name
+func (s *Store) GetProjects(ctx context.Context, userID uuid.UUID, archived bool) ([]repository.Project, error) {
+	query := "SELECT * FROM projects WHERE user_id = ?"
+	if !archived {
+		query += " AND archived_at IS NULL"
+	}
+	query += " ORDER BY is_inbox DESC, name, id"
+
+	projects := []repository.Project{}
+	if err := s.db.SelectContext(ctx, &projects, query, userID); err != nil {
+		return nil, fmt.Errorf("select projects: %w", err)
+	}
+
+	return projects, nil
+}
+
+// GetProject returns ErrNotFound unless the project exists and belongs to userID
+func (s *Store) GetProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) (*repository.Project, error) {
+	project := &repository.Project{}
+	if err := s.db.GetContext(ctx, project, "SELECT * FROM projects WHERE id = ? AND user_id = ?", projectID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select project: %w", err)
+	}
+
+	return project, nil
+}
+
+// CreateProject returns ErrAlreadyExists when the user has a project of the name
+func (s *Store) CreateProject(ctx context.Context, params repository.CreateProjectParams) (uuid.UUID, error) {
+	projectID := uuid.New()
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO projects (id, user_id, name) VALUES (?, ?, ?)", projectID, params.UserID, params.Name); err != nil {
+		if isUniqueViolation(err) {
+			return uuid.Nil, repository.ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert project: %w", err)
+	}
+
+	return projectID, nil
+}
+
+// UpdateProject renames and archives or restores a project other than the Inbox.
+// It returns ErrAlreadyExists when the user has another project of the name.
+func (s *Store) UpdateProject(ctx context.Context, params repository.UpdateProjectParams) error {
+	// a project archived already keeps the time it was archived at
+	query := `UPDATE projects SET name = ?, archived_at = CASE WHEN ? THEN COALESCE(archived_at, ?) END
+		WHERE id = ? AND user_id = ? AND NOT is_inbox`
+	result, err := s.db.ExecContext(ctx, query, params.Name, params.Archived, now(), params.ID, params.UserID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrAlreadyExists
+		}
+		return fmt.Errorf("update project: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteProject deletes a project other than the Inbox, moving its tasks to the Inbox
+func (s *Store) DeleteProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	inboxID, err := getInboxID(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET project_id = ? WHERE project_id = ? AND user_id = ?", inboxID, projectID, userID); err != nil {
+		return fmt.Errorf("move tasks to inbox: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM projects WHERE id = ? AND user_id = ? AND NOT is_inbox", projectID, userID)
+	if err != nil {
+		return fmt.Errorf("delete project: %w", err)
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// MoveTasks moves the tasks to the project, all or none of them.
+// It returns ErrNotFound unless the project and every task belong to the user, and ErrProjectArchived for an archived project.
+func (s *Store) MoveTasks(ctx context.Context, params repository.MoveTasksParams) error {
+	if len(params.TaskIDs) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkProject(ctx, tx, params.UserID, params.ProjectID); err != nil {
+		if errors.Is(err, repository.ErrUnknownProject) {
+			return repository.ErrNotFound
+		}
+		return err
+	}
+
+	query, args, err := sqlx.In("UPDATE tasks SET project_id = ? WHERE user_id = ? AND id IN (?)", params.ProjectID, params.UserID, params.TaskIDs)
+	if err != nil {
+		return fmt.Errorf("build query: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("move tasks: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n != int64(len(params.TaskIDs)) {
+		return repository.ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// GetLabels lists the user's labels by name
+func (s *Store) GetLabels(ctx context.Context, userID uuid.UUID) ([]repository.Label, error) {
+	labels := []repository.Label{}
+	if err := s.db.SelectContext(ctx, &labels, "SELECT * FROM labels WHERE user_id = ? ORDER BY name, id", userID); err != nil {
+		return nil, fmt.Errorf("select labels: %w", err)
+	}
+
+	return labels, nil
+}
+
+// GetLabel returns ErrNotFound unless the label exists and belongs to userID
+func (s *Store) GetLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) (*repository.Label, error) {
+	label := &repository.Label{}
+	if err := s.db.GetContext(ctx, label, "SELECT * FROM labels WHERE id = ? AND user_id = ?", labelID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select label: %w", err)
+	}
+
+	return label, nil
+}
+
+// CreateLabel returns ErrAlreadyExists when the user has a label of the name
+func (s *Store) CreateLabel(ctx context.Context, params repository.CreateLabelParams) (uuid.UUID, error) {
+	labelID := uuid.New()
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO labels (id, user_id, name, color) VALUES (?, ?, ?, ?)", labelID, params.UserID, params.Name, params.Color); err != nil {
+		if isUniqueViolation(err) {
+			return uuid.Nil, repository.ErrAlreadyExists
+		}
+		return uuid.Nil, fmt.Errorf("insert label: %w", err)
+	}
+
+	return labelID, nil
+}
+
+// UpdateLabel renames and recolors the label on every task it is on at once.
+// It returns ErrAlreadyExists when the user has another label of the name.
+func (s *Store) UpdateLabel(ctx context.Context, params repository.UpdateLabelParams) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE labels SET name = ?, color = ? WHERE id = ? AND user_id = ?", params.Name, params.Color, params.ID, params.UserID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrAlreadyExists
+		}
+		return fmt.Errorf("update label: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteLabel takes the label off every task it is on
+func (s *Store) DeleteLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM labels WHERE id = ? AND user_id = ?", labelID, userID)
+	if err != nil {
+		return fmt.Errorf("delete label: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// MergeLabels puts the target label on every task with the source label and deletes the source label, all at once.
+// It returns ErrNotFound unless both labels belong to the user.
+func (s *Store) MergeLabels(ctx context.Context, params repository.MergeLabelsParams) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.GetContext(ctx, &n, "SELECT COUNT(*) FROM labels WHERE user_id = ? AND id IN (?, ?)", params.UserID, params.SourceID, params.TargetID); err != nil {
+		return fmt.Errorf("select labels: %w", err)
+	}
+	if n != 2 {
+		return repository.ErrNotFound
+	}
+
+	// tasks with both labels keep the one link they have to the target
+	query := "INSERT OR IGNORE INTO task_labels (task_id, label_id) SELECT task_id, ? FROM task_labels WHERE label_id = ?"
+	if _, err := tx.ExecContext(ctx, query, params.TargetID, params.SourceID); err != nil {
+		return fmt.Errorf("relabel tasks: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM labels WHERE id = ?", params.SourceID); err != nil {
+		return fmt.Errorf("delete label: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetUserID(ctx context.Context, name string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	if err := s.db.GetContext(ctx, &userID, "SELECT id FROM users WHERE name = ?", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, repository.ErrNotFound
+		}
+		return uuid.Nil, fmt.Errorf("select user: %w", err)
+	}
+
+	return userID, nil
+}
+
+// GetUser returns ErrNotFound for an unknown user
+func (s *Store) GetUser(ctx context.Context, userID uuid.UUID) (*repository.User, error) {
+	user := &repository.User{}
+	if err := s.db.GetContext(ctx, user, "SELECT * FROM users WHERE id = ?", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select user: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail returns the user who verified the address, or ErrNotFound when no one has
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*repository.User, error) {
+	user := &repository.User{}
+	if err := s.db.GetContext(ctx, user, "SELECT * FROM users WHERE email = ? AND email_verified_at IS NOT NULL", email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select user: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdateName returns ErrAlreadyExists when another user has the name
+func (s *Store) UpdateName(ctx context.Context, params repository.UpdateNameParams) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE users SET name = ?, updated_at = ? WHERE id = ?", params.Name, now(), params.ID); err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrAlreadyExists
+		}
+		return fmt.Errorf("update user name: %w", err)
+	}
+
+	return nil
+}
+
+// UpdatePass also lifts a required password reset
+func (s *Store) UpdatePass(ctx context.Context, params repository.UpdatePassParams) error {
+	hashed, err := s.passwords.Hash(params.Password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE users SET password = ?, password_reset_required = FALSE, updated_at = ? WHERE id = ?", hashed, now(), params.ID); err != nil {
+		return fmt.Errorf("update user password: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateEmail sets the address, which has to be verified again unless it did not change.
+// Other users may have the address as long as none of them verified it.
+func (s *Store) UpdateEmail(ctx context.Context, params repository.UpdateEmailParams) error {
+	// unlike MySQL, every assignment sees the old row
+	query := "UPDATE users SET email_verified_at = CASE WHEN email IS ? THEN email_verified_at END, email = ?, updated_at = ? WHERE id = ?"
+	if _, err := s.db.ExecContext(ctx, query, params.Email, params.Email, now(), params.ID); err != nil {
+		return fmt.Errorf("update user email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks email as verified. It returns ErrNotFound when the user's address has changed since,
+// and ErrAlreadyExists when another user verified the address first.
+func (s *Store) VerifyEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ? AND email = ?", now(), userID, email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return repository.ErrAlreadyExists
+		}
+		return fmt.Errorf("verify user email: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// SearchUsers lists users for the admin API, newest first
+func (s *Store) SearchUsers(ctx context.Context, params repository.SearchUsersParams) ([]repository.User, error) {
+	query := "SELECT * FROM users WHERE 1 = 1"
+	args := []interface{}{}
+
+	if params.Query != "" {
+		pattern := "%" + escapeLike(params.Query) + "%"
+		query += ` AND (name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+	if params.Role != "" {
+		query += " AND role = ?"
+		args = append(args, params.Role)
+	}
+
+	query += " ORDER BY created_at DESC, id LIMIT ? OFFSET ?"
+	args = append(args, params.Limit, params.Offset)
+
+	users := []repository.User{}
+	if err := s.db.SelectContext(ctx, &users, query, args...); err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateRole returns ErrNotFound for an unknown user
+func (s *Store) UpdateRole(ctx context.Context, userID uuid.UUID, role string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("update user role: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// SetUserDisabled disables or enables a user. It returns ErrNotFound for an unknown user.
+func (s *Store) SetUserDisabled(ctx context.Context, userID uuid.UUID, disabled bool) error {
+	query := "UPDATE users SET disabled_at = NULL WHERE id = ?"
+	args := []interface{}{userID}
+	if disabled {
+		query = "UPDATE users SET disabled_at = COALESCE(disabled_at, ?) WHERE id = ?"
+		args = []interface{}{timestamp(now()), userID}
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("update user disabled_at: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// RequirePasswordReset stops the user from signing in until the password is changed.
+// It returns ErrNotFound for an unknown user.
+func (s *Store) RequirePasswordReset(ctx context.Context, userID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET password_reset_required = TRUE WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("update user password_reset_required: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteUser deletes the user's tasks along with them
+func (s *Store) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate checks a name and password pair.
+// It returns ErrInvalidCredentials whether the name or the password is wrong,
+// and takes about as long either way so that response times do not reveal which names exist.
+// With the right password, it returns ErrAccountDisabled or ErrPasswordResetRequired when the user may not sign in.
+func (s *Store) Authenticate(ctx context.Context, name string, password string) (uuid.UUID, error) {
+	user := &repository.User{}
+	if err := s.db.GetContext(ctx, user, "SELECT * FROM users WHERE name = ?", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_, _, _ = s.passwords.Verify(password, s.dummyHash)
+			return uuid.Nil, repository.ErrInvalidCredentials
+		}
+		return uuid.Nil, fmt.Errorf("select user: %w", err)
+	}
+
+	ok, err := s.verifyPassword(ctx, user, password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, repository.ErrInvalidCredentials
+	}
+
+	if user.DisabledAt.Valid {
+		return uuid.Nil, repository.ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		return uuid.Nil, repository.ErrPasswordResetRequired
+	}
+
+	return user.ID, nil
+}
+
+func (s *Store) CheckPass(ctx context.Context, userID uuid.UUID, password string) (bool, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return s.verifyPassword(ctx, user, password)
+}
+
+// verifyPassword compares password with the stored hash.
+// A correct password stored with an outdated algorithm or cost is rehashed while it is at hand.
+func (s *Store) verifyPassword(ctx context.Context, user *repository.User, password string) (bool, error) {
+	ok, needsRehash, err := s.passwords.Verify(password, user.Password)
+	if err != nil {
+		return false, fmt.Errorf("verify password: %w", err)
+	}
+	if !ok || !needsRehash {
+		return ok, nil
+	}
+
+	hashed, err := s.passwords.Hash(password)
+	if err != nil {
+		return false, fmt.Errorf("hash password: %w", err)
+	}
+
+	// only replace the hash that was verified, in case the password changed meanwhile
+	if _, err := s.db.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?", hashed, user.ID, user.Password); err != nil {
+		return false, fmt.Errorf("rehash user password: %w", err)
+	}
+
+	return true, nil
+}
+
+// setReminders brings the scheduled reminders of a task in line with its due date.
+// A reminder that still goes off at the same time keeps its state, so that it is not sent twice,
+// and a new one that would have gone off already is left out.
+func setReminders(ctx context.Context, tx *sqlx.Tx, taskID uuid.UUID, dueAt sql.NullTime, reminders []int) error {
+	scheduled := []repository.TaskReminder{}
+	if err := tx.SelectContext(ctx, &scheduled, "SELECT id, minutes_before, remind_at FROM task_reminders WHERE task_id = ?", taskID); err != nil {
+		return fmt.Errorf("select task reminders: %w", err)
+	}
+
+	times := repository.ReminderTimes(dueAt, reminders)
+	for _, r := range scheduled {
+		if remindAt, ok := times[r.MinutesBefore]; ok && remindAt.Equal(r.RemindAt) {
+			delete(times, r.MinutesBefore)
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM task_reminders WHERE id = ?", r.ID); err != nil {
+			return fmt.Errorf("delete task reminder: %w", err)
+		}
+	}
+
+	now := time.Now()
+	for minutes, remindAt := range times {
+		if !remindAt.After(now) {
+			continue
+		}
+
+		query := "INSERT INTO task_reminders (id, task_id, minutes_before, remind_at) VALUES (?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, uuid.New(), taskID, minutes, timestamp(remindAt)); err != nil {
+			return fmt.Errorf("insert task reminder: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getTask returns ErrNotFound unless the task exists and belongs to userID
+func getTask(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, taskID uuid.UUID) (*repository.Task, error) {
+	task := &repository.Task{}
+	if err := tx.GetContext(ctx, task, "SELECT * FROM tasks WHERE id = ? AND user_id = ?", taskID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrNotFound
+		}
+		return nil, fmt.Errorf("select task: %w", err)
+	}
+
+	return task, nil
+}
+
+func insertInbox(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) error {
+	query := "INSERT INTO projects (id, user_id, name, is_inbox) VALUES (?, ?, ?, TRUE)"
+	if _, err := tx.ExecContext(ctx, query, uuid.New(), userID, repository.InboxName); err != nil {
+		return fmt.Errorf("insert inbox: %w", err)
+	}
+
+	return nil
+}
+
+// checkProject returns repository.ErrUnknownProject or repository.ErrProjectArchived
+// unless tasks may be put in the user's project
+func checkProject(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, projectID uuid.UUID) error {
+	project := &repository.Project{}
+	if err := tx.GetContext(ctx, project, "SELECT * FROM projects WHERE id = ? AND user_id = ?", projectID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrUnknownProject
+		}
+		return fmt.Errorf("select project: %w", err)
+	}
+
+	if project.ArchivedAt.Valid {
+		return repository.ErrProjectArchived
+	}
+
+	return nil
+}
+
+func getInboxID(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) (uuid.UUID, error) {
+	var inboxID uuid.UUID
+	if err := tx.GetContext(ctx, &inboxID, "SELECT id FROM projects WHERE user_id = ? AND is_inbox", userID); err != nil {
+		return uuid.Nil, fmt.Errorf("select inbox: %w", err)
+	}
+
+	return inboxID, nil
+}
+
+// setTaskLabels replaces the labels on the task
+func setTaskLabels(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, taskID uuid.UUID, labelIDs []uuid.UUID) error {
+	if len(labelIDs) > 0 {
+		query, args, err := sqlx.In("SELECT COUNT(*) FROM labels WHERE user_id = ? AND id IN (?)", userID, labelIDs)
+		if err != nil {
+			return fmt.Errorf("build query: %w", err)
+		}
+
+		var n int
+		if err := tx.GetContext(ctx, &n, query, args...); err != nil {
+			return fmt.Errorf("select labels: %w", err)
+		}
+		if n != len(labelIDs) {
+			return repository.ErrUnknownLabel
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM task_labels WHERE task_id = ?", taskID); err != nil {
+		return fmt.Errorf("delete task labels: %w", err)
+	}
+
+	for _, labelID := range labelIDs {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO task_labels (task_id, label_id) VALUES (?, ?)", taskID, labelID); err != nil {
+			return fmt.Errorf("insert task label: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// loadLabels sets the labels of the tasks, sorted by name
+func loadLabels(ctx context.Context, q sqlx.QueryerContext, tasks []*repository.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*repository.Task, len(tasks))
+	taskIDs := make([]uuid.UUID, len(tasks))
+	for i, task := range tasks {
+		task.Labels = []repository.Label{}
+		byID[task.ID] = task
+		taskIDs[i] = task.ID
+	}
+
+	query, args, err := sqlx.In(`SELECT tl.task_id, l.* FROM task_labels tl JOIN labels l ON l.id = tl.label_id
+		WHERE tl.task_id IN (?) ORDER BY l.name, l.id`, taskIDs)
+	if err != nil {
+		return fmt.Errorf("build query: %w", err)
+	}
+
+	rows := []struct {
+		TaskID uuid.UUID `db:"task_id"`
+		repository.Label
+	}{}
+	if err := sqlx.SelectContext(ctx, q, &rows, query, args...); err != nil {
+		return fmt.Errorf("select task labels: %w", err)
+	}
+	for _, row := range rows {
+		task := byID[row.TaskID]
+		task.Labels = append(task.Labels, row.Label)
+	}
+
+	return nil
+}
+
+// insertTask inserts the task and schedules its reminders
+func insertTask(ctx context.Context, tx *sqlx.Tx, task *repository.Task) error {
+	query := `INSERT INTO tasks (id, user_id, project_id, title, due_at, due_time_zone, reminders, series_id, recurrence, occurrence_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.ExecContext(ctx, query, task.ID, task.UserID, task.ProjectID, task.Title, nullTimestamp(task.DueAt), task.DueTimeZone, task.Reminders,
+		task.SeriesID, task.Recurrence, nullTimestamp(task.OccurrenceAt))
+	if err != nil {
+		return fmt.Errorf("insert task: %w", err)
+	}
+
+	return setReminders(ctx, tx, task.ID, task.DueAt, task.ReminderList())
+}
+
+func insertTaskSeries(ctx context.Context, tx *sqlx.Tx, series *repository.TaskSeries) error {
+	query := "INSERT INTO task_series (id, user_id, recurrence, starts_at, title, due_time_zone, reminders) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err := tx.ExecContext(ctx, query, series.ID, series.UserID, series.Recurrence, timestamp(series.StartsAt), series.Title, series.DueTimeZone, series.Reminders)
+	if err != nil {
+		return fmt.Errorf("insert task series: %w", err)
+	}
+
+	return nil
+}
+
+// setTaskSeries moves the task to series, or out of any series when it is nil
+func setTaskSeries(ctx context.Context, tx *sqlx.Tx, task *repository.Task, series *repository.TaskSeries) error {
+	task.SeriesID, task.Recurrence, task.OccurrenceAt = uuid.NullUUID{}, "", sql.NullTime{}
+	if series != nil {
+		if err := insertTaskSeries(ctx, tx, series); err != nil {
+			return err
+		}
+		task.SeriesID = uuid.NullUUID{UUID: series.ID, Valid: true}
+		task.Recurrence = series.Recurrence
+		task.OccurrenceAt = sql.NullTime{Time: series.StartsAt, Valid: true}
+	}
+
+	query := "UPDATE tasks SET series_id = ?, recurrence = ?, occurrence_at = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, task.SeriesID, task.Recurrence, nullTimestamp(task.OccurrenceAt), task.ID); err != nil {
+		return fmt.Errorf("update task series: %w", err)
+	}
+
+	return pruneTaskSeries(ctx, tx, task.UserID)
+}
+
+// createNextOccurrence creates the occurrence following done in its project and with its labels,
+// unless the series has ended or a later occurrence exists already
+func createNextOccurrence(ctx context.Context, tx *sqlx.Tx, done *repository.Task) error {
+	seriesID, after := done.SeriesID.UUID, done.OccurrenceAt.Time
+
+	var later bool
+	if err := tx.GetContext(ctx, &later, "SELECT EXISTS (SELECT 1 FROM tasks WHERE series_id = ? AND occurrence_at > ?)", seriesID, timestamp(after)); err != nil {
+		return fmt.Errorf("select later occurrence: %w", err)
+	}
+	if later {
+		return nil
+	}
+
+	series := &repository.TaskSeries{}
+	if err := tx.GetContext(ctx, series, "SELECT * FROM task_series WHERE id = ?", seriesID); err != nil {
+		return fmt.Errorf("select task series: %w", err)
+	}
+
+	next, ok, err := series.NextOccurrence(after)
+	if err != nil || !ok {
+		return err
+	}
+
+	occurrence := series.Occurrence(next)
+	occurrence.ProjectID = done.ProjectID
+	if err := insertTask(ctx, tx, &occurrence); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO task_labels (task_id, label_id) SELECT ?, label_id FROM task_labels WHERE task_id = ?"
+	if _, err := tx.ExecContext(ctx, query, occurrence.ID, done.ID); err != nil {
+		return fmt.Errorf("copy task labels: %w", err)
+	}
+
+	return nil
+}
+
+// pruneTaskSeries deletes the user's series that no task belongs to anymore
+func pruneTaskSeries(ctx context.Context, ex sqlx.ExecerContext, userID uuid.UUID) error {
+	query := "DELETE FROM task_series WHERE user_id = ? AND NOT EXISTS (SELECT 1 FROM tasks WHERE tasks.series_id = task_series.id)"
+	if _, err := ex.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("delete unused task series: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimDueReminders claims the reminders due at now for lease, leaving out those of tasks that are done
+func (s *Store) ClaimDueReminders(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]reminder.Reminder, error) {
+	claim := uuid.New()
+	query := `UPDATE task_reminders SET claimed_by = ?, claimed_until = ? WHERE id IN (
+		SELECT r.id FROM task_reminders r JOIN tasks t ON t.id = r.task_id
+		WHERE r.sent_at IS NULL AND r.remind_at <= ? AND (r.claimed_until IS NULL OR r.claimed_until <= ?) AND t.is_done = FALSE
+		ORDER BY r.remind_at LIMIT ?
+	)`
+	if _, err := s.db.ExecContext(ctx, query, claim, timestamp(now.Add(lease)), timestamp(now), timestamp(now), limit); err != nil {
+		return nil, fmt.Errorf("claim reminders: %w", err)
+	}
+
+	due := []repository.DueReminder{}
+	query = `SELECT r.id, r.claimed_by, r.task_id, t.user_id, t.title, t.due_at, t.due_time_zone, r.remind_at,
+		CASE WHEN u.email_verified_at IS NOT NULL THEN u.email END AS email
+		FROM task_reminders r JOIN tasks t ON t.id = r.task_id JOIN users u ON u.id = t.user_id
+		WHERE r.claimed_by = ? ORDER BY r.remind_at`
+	if err := s.db.SelectContext(ctx, &due, query, claim); err != nil {
+		return nil, fmt.Errorf("select claimed reminders: %w", err)
+	}
+
+	reminders := make([]reminder.Reminder, len(due))
+	for i := range due {
+		reminders[i] = due[i].Reminder()
+	}
+
+	return reminders, nil
+}
+
+// MarkReminderSent keeps the reminder from being claimed again.
+// It returns reminder.ErrClaimLost when the reminder has been claimed again since claimID.
+func (s *Store) MarkReminderSent(ctx context.Context, reminderID uuid.UUID, claimID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE task_reminders SET sent_at = ? WHERE id = ? AND claimed_by = ? AND sent_at IS NULL", now(), reminderID, claimID)
+	if err != nil {
+		return fmt.Errorf("mark reminder sent: %w", err)
+	}
+
+	if err := checkAffected(result); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return reminder.ErrClaimLost
+		}
+		return err
+	}
+
+	return nil
+}
+
+// checkAffected maps an update or delete that matched no rows to ErrNotFound.
+// SQLite counts rows that matched even when nothing changed.
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlitedriver.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// isPrimaryKeyViolation reports whether err is a primary key violation, which SQLite tells apart from other unique ones
+func isPrimaryKeyViolation(err error) bool {
+	var sqliteErr *sqlitedriver.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, with \ as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// timestamp formats t the way CURRENT_TIMESTAMP does, as datetime columns are compared as text
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func nullTimestamp(t sql.NullTime) sql.NullString {
+	return sql.NullString{String: timestamp(t.Time), Valid: t.Valid}
+}
+
+// now has the second precision of the datetime columns of the MySQL store
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/google/uuid"
+)
+
+// TaskStore keeps users' tasks.
+// Every method scoped to a user returns ErrNotFound for a task of another user.
+type TaskStore interface {
+	GetTasks(ctx context.Context, params GetTasksParams) ([]Task, error)
+	GetTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) (*Task, error)
+	SearchTasks(ctx context.Context, params SearchTasksParams) ([]SearchedTask, error)
+	CreateTask(ctx context.Context, params CreateTaskParams) error
+	UpdateTask(ctx context.Context, params UpdateTaskParams) error
+	DeleteTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error
+	GetTaskCounts(ctx context.Context, userID uuid.UUID) (*TaskCounts, error)
+	GetTaskSeries(ctx context.Context, userID uuid.UUID, seriesID uuid.UUID) (*TaskSeries, error)
+	SkipOccurrence(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error
+	GetProjects(ctx context.Context, userID uuid.UUID, archived bool) ([]Project, error)
+	GetProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) (*Project, error)
+	CreateProject(ctx context.Context, params CreateProjectParams) (uuid.UUID, error)
+	UpdateProject(ctx context.Context, params UpdateProjectParams) error
+	DeleteProject(ctx context.Context, userID uuid.UUID, projectID uuid.UUID) error
+	MoveTasks(ctx context.Context, params MoveTasksParams) error
+	GetLabels(ctx context.Context, userID uuid.UUID) ([]Label, error)
+	GetLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) (*Label, error)
+	CreateLabel(ctx context.Context, params CreateLabelParams) (uuid.UUID, error)
+	UpdateLabel(ctx context.Context, params UpdateLabelParams) error
+	DeleteLabel(ctx context.Context, userID uuid.UUID, labelID uuid.UUID) error
+	MergeLabels(ctx context.Context, params MergeLabelsParams) error
+}
+
+// UserStore keeps user accounts, their passwords and the external accounts linked to them.
+// Every user is created with an Inbox project.
+// Names and verified emails are unique; taking one already in use returns ErrAlreadyExists.
+type UserStore interface {
+	CreateUser(ctx context.Context, params CreateUserParams) (uuid.UUID, error)
+	CreateUserWithIdentity(ctx context.Context, params CreateUserWithIdentityParams) (uuid.UUID, error)
+	GetUserIdentity(ctx context.Context, provider string, subject string) (*UserIdentity, error)
+	GetUserID(ctx context.Context, name string) (uuid.UUID, error)
+	GetUser(ctx context.Context, userID uuid.UUID) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	SearchUsers(ctx context.Context, params SearchUsersParams) ([]User, error)
+	UpdateName(ctx context.Context, params UpdateNameParams) error
+	UpdatePass(ctx context.Context, params UpdatePassParams) error
+	UpdateEmail(ctx context.Context, params UpdateEmailParams) error
+	VerifyEmail(ctx context.Context, userID uuid.UUID, email string) error
+	UpdateRole(ctx context.Context, userID uuid.UUID, role string) error
+	SetUserDisabled(ctx context.Context, userID uuid.UUID, disabled bool) error
+	RequirePasswordReset(ctx context.Context, userID uuid.UUID) error
+	// DeleteUser deletes the user's tasks along with them
+	DeleteUser(ctx context.Context, userID uuid.UUID) error
+	Authenticate(ctx context.Context, name string, password string) (uuid.UUID, error)
+	CheckPass(ctx context.Context, userID uuid.UUID, password string) (bool, error)
+}
+
+// AccountStore keeps how users sign in and what they let in: sessions, second factors, tokens,
+// OAuth clients and grants and client certificates, along with sign-in throttling and the audit log.
+// Deleting a user deletes what is theirs, except audit log entries.
+type AccountStore interface {
+	CreateSession(ctx context.Context, params CreateSessionParams) (uuid.UUID, error)
+	GetSession(ctx context.Context, sessionID uuid.UUID) (*Session, error)
+	GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*Session, error)
+	GetActiveSessions(ctx context.Context, userID uuid.UUID) ([]Session, error)
+	TouchSession(ctx context.Context, sessionID uuid.UUID, ip string) error
+	RotateRefreshToken(ctx context.Context, params RotateRefreshTokenParams) (*Session, error)
+	RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error
+	RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error
+	RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) (int64, error)
+
+	GetTOTP(ctx context.Context, userID uuid.UUID) (*UserTOTP, error)
+	SetPendingTOTP(ctx context.Context, params SetTOTPParams) error
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error
+	CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) error
+
+	CreatePersonalAccessToken(ctx context.Context, params CreatePersonalAccessTokenParams) (uuid.UUID, error)
+	GetPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]PersonalAccessToken, error)
+	GetPersonalAccessTokenByToken(ctx context.Context, token string) (*PersonalAccessToken, error)
+	TouchPersonalAccessToken(ctx context.Context, tokenID uuid.UUID) error
+	DeletePersonalAccessToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID) error
+	DeletePersonalAccessTokens(ctx context.Context, userID uuid.UUID) error
+
+	CreateUserToken(ctx context.Context, params CreateUserTokenParams) error
+	GetUserToken(ctx context.Context, purpose string, token string) (*UserToken, error)
+	UseUserToken(ctx context.Context, purpose string, token string) error
+
+	CreateOAuthClient(ctx context.Context, params CreateOAuthClientParams) (uuid.UUID, error)
+	GetOAuthClient(ctx context.Context, clientID uuid.UUID) (*OAuthClient, error)
+	GetOAuthClients(ctx context.Context, userID uuid.UUID) ([]OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) error
+	CreateOAuthCode(ctx context.Context, params CreateOAuthCodeParams) error
+	GetOAuthCode(ctx context.Context, code string) (*OAuthCode, error)
+	RedeemOAuthCode(ctx context.Context, params RedeemOAuthCodeParams) (*OAuthGrant, error)
+	GetOAuthGrant(ctx context.Context, grantID uuid.UUID) (*OAuthGrant, error)
+	GetOAuthGrantByRefreshToken(ctx context.Context, refreshToken string) (*OAuthGrant, error)
+	RotateOAuthRefreshToken(ctx context.Context, params RotateOAuthRefreshTokenParams) (*OAuthGrant, error)
+	RevokeOAuthGrant(ctx context.Context, grantID uuid.UUID) error
+	RevokeOAuthGrants(ctx context.Context, userID uuid.UUID) error
+
+	CreateClientCertificate(ctx context.Context, params CreateClientCertificateParams) (uuid.UUID, error)
+	GetClientCertificates(ctx context.Context, userID uuid.UUID) ([]ClientCertificate, error)
+	GetClientCertificateBySubject(ctx context.Context, subject string) (*ClientCertificate, error)
+	TouchClientCertificate(ctx context.Context, certificateID uuid.UUID) error
+	DeleteClientCertificate(ctx context.Context, userID uuid.UUID, certificateID uuid.UUID) error
+
+	GetAuthThrottle(ctx context.Context, key string) (*AuthThrottle, error)
+	ReserveAuthAttempt(ctx context.Context, params ReserveAuthAttemptParams) (*AuthThrottle, error)
+	ReleaseAuthAttempt(ctx context.Context, reserved *AuthThrottle) error
+	LockAuthThrottle(ctx context.Context, key string, until time.Time) error
+	ResetAuthThrottle(ctx context.Context, key string) error
+
+	CreateAuditLog(ctx context.Context, params CreateAuditLogParams) error
+	GetAuditLogs(ctx context.Context, userID uuid.UUID, limit int) ([]AuditLog, error)
+}
+
+// Store keeps everything, so that the app runs on any one implementation of it
+type Store interface {
+	TaskStore
+	UserStore
+	AccountStore
+	reminder.Store
+}
+
+var _ Store = (*Repository)(nil)
@@ -0,0 +1,744 @@
+package storetest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+func testAdmin(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	base := uniqueName("admin")
+
+	aliceID, err := users.CreateUser(ctx, repository.CreateUserParams{Name: base + "_alice", Password: "pass"})
+	assert(t, nil, err)
+	bobID, err := users.CreateUser(ctx, repository.CreateUserParams{Name: base + "_bob", Password: "pass"})
+	assert(t, nil, err)
+
+	found, err := users.SearchUsers(ctx, repository.SearchUsersParams{Query: strings.ToUpper(base), Limit: 10})
+	assert(t, nil, err)
+	assert(t, []string{base + "_alice", base + "_bob"}, sortedNames(found))
+
+	found, err = users.SearchUsers(ctx, repository.SearchUsersParams{Query: base, Limit: 1})
+	assert(t, nil, err)
+	assert(t, 1, len(found))
+
+	found, err = users.SearchUsers(ctx, repository.SearchUsersParams{Query: base, Limit: 10, Offset: 1})
+	assert(t, nil, err)
+	assert(t, 1, len(found))
+
+	// wildcards in the query match literally
+	found, err = users.SearchUsers(ctx, repository.SearchUsersParams{Query: base + "_%", Limit: 10})
+	assert(t, nil, err)
+	assert(t, 0, len(found))
+
+	assert(t, nil, users.UpdateRole(ctx, aliceID, "admin"))
+	assertErr(t, repository.ErrNotFound, users.UpdateRole(ctx, uuid.New(), "admin"))
+
+	found, err = users.SearchUsers(ctx, repository.SearchUsersParams{Query: base, Role: "admin", Limit: 10})
+	assert(t, nil, err)
+	assert(t, []string{base + "_alice"}, sortedNames(found))
+
+	bob, err := users.GetUser(ctx, bobID)
+	assert(t, nil, err)
+	assert(t, "user", bob.Role)
+
+	assert(t, nil, users.SetUserDisabled(ctx, bobID, true))
+	bob, err = users.GetUser(ctx, bobID)
+	assert(t, nil, err)
+	assert(t, true, bob.DisabledAt.Valid)
+
+	assert(t, nil, users.SetUserDisabled(ctx, bobID, false))
+	bob, err = users.GetUser(ctx, bobID)
+	assert(t, nil, err)
+	assert(t, false, bob.DisabledAt.Valid)
+
+	assertErr(t, repository.ErrNotFound, users.SetUserDisabled(ctx, uuid.New(), true))
+
+	assert(t, nil, users.RequirePasswordReset(ctx, bobID))
+	bob, err = users.GetUser(ctx, bobID)
+	assert(t, nil, err)
+	assert(t, true, bob.PasswordResetRequired)
+
+	assertErr(t, repository.ErrNotFound, users.RequirePasswordReset(ctx, uuid.New()))
+
+	counts, err := tasks.GetTaskCounts(ctx, aliceID)
+	assert(t, nil, err)
+	assert(t, repository.TaskCounts{}, *counts)
+
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: aliceID, Title: "open"}))
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: aliceID, Title: "done"}))
+	got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: aliceID})
+	assert(t, nil, err)
+	for _, task := range got {
+		if task.Title == "done" {
+			assert(t, nil, tasks.UpdateTask(ctx, repository.UpdateTaskParams{ID: task.ID, UserID: aliceID, Title: task.Title, IsDone: true}))
+		}
+	}
+
+	counts, err = tasks.GetTaskCounts(ctx, aliceID)
+	assert(t, nil, err)
+	assert(t, repository.TaskCounts{Total: 2, Done: 1}, *counts)
+}
+
+func testIdentities(t *testing.T, users repository.UserStore) {
+	ctx := context.Background()
+	name := uniqueName("identity")
+	subject := uuid.NewString()
+
+	userID, err := users.CreateUserWithIdentity(ctx, repository.CreateUserWithIdentityParams{Name: name, Provider: "storetest", Subject: subject, Email: "identity@example.com"})
+	assert(t, nil, err)
+
+	user, err := users.GetUser(ctx, userID)
+	assert(t, nil, err)
+	assert(t, name, user.Name)
+
+	identity, err := users.GetUserIdentity(ctx, "storetest", subject)
+	assert(t, nil, err)
+	assert(t, userID, identity.UserID)
+	assert(t, "identity@example.com", identity.Email.String)
+
+	_, err = users.GetUserIdentity(ctx, "storetest", uuid.NewString())
+	assertErr(t, repository.ErrNotFound, err)
+
+	// the identity is linked already
+	_, err = users.CreateUserWithIdentity(ctx, repository.CreateUserWithIdentityParams{Name: uniqueName("identity"), Provider: "storetest", Subject: subject})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	// the name is taken
+	_, err = users.CreateUserWithIdentity(ctx, repository.CreateUserWithIdentityParams{Name: name, Provider: "storetest", Subject: uuid.NewString()})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	// neither failed attempt left a user behind
+	found, err := users.SearchUsers(ctx, repository.SearchUsersParams{Query: name, Limit: 10})
+	assert(t, nil, err)
+	assert(t, []string{name}, sortedNames(found))
+}
+
+func testSessions(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+
+	first := uuid.NewString()
+	sessionID, err := accounts.CreateSession(ctx, createSessionParams(userID, first))
+	assert(t, nil, err)
+
+	session, err := accounts.GetSession(ctx, sessionID)
+	assert(t, nil, err)
+	assert(t, userID, session.UserID)
+	assert(t, "storetest", session.UserAgent)
+	assert(t, true, session.IsActive(time.Now()))
+
+	_, err = accounts.GetSession(ctx, uuid.New())
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, accounts.TouchSession(ctx, sessionID, "192.0.2.1"))
+	session, err = accounts.GetSession(ctx, sessionID)
+	assert(t, nil, err)
+	assert(t, "192.0.2.1", session.IP)
+
+	second := uuid.NewString()
+	session, err = accounts.RotateRefreshToken(ctx, repository.RotateRefreshTokenParams{RefreshToken: first, NewRefreshToken: second})
+	assert(t, nil, err)
+	assert(t, sessionID, session.ID)
+
+	// both the exchanged token and the new one lead to the session
+	for _, refreshToken := range []string{first, second} {
+		session, err = accounts.GetSessionByRefreshToken(ctx, refreshToken)
+		assert(t, nil, err)
+		assert(t, sessionID, session.ID)
+	}
+	_, err = accounts.GetSessionByRefreshToken(ctx, uuid.NewString())
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.RotateRefreshToken(ctx, repository.RotateRefreshTokenParams{RefreshToken: uuid.NewString(), NewRefreshToken: uuid.NewString()})
+	assertErr(t, repository.ErrNotFound, err)
+
+	// replaying an exchanged token revokes the session, so the current token stops working too
+	_, err = accounts.RotateRefreshToken(ctx, repository.RotateRefreshTokenParams{RefreshToken: first, NewRefreshToken: uuid.NewString()})
+	assertErr(t, repository.ErrRefreshTokenReused, err)
+
+	session, err = accounts.GetSession(ctx, sessionID)
+	assert(t, nil, err)
+	assert(t, true, session.RevokedAt.Valid)
+
+	_, err = accounts.RotateRefreshToken(ctx, repository.RotateRefreshTokenParams{RefreshToken: second, NewRefreshToken: uuid.NewString()})
+	assertErr(t, repository.ErrSessionRevoked, err)
+
+	keepID, err := accounts.CreateSession(ctx, createSessionParams(userID, uuid.NewString()))
+	assert(t, nil, err)
+	_, err = accounts.CreateSession(ctx, createSessionParams(userID, uuid.NewString()))
+	assert(t, nil, err)
+	othersID, err := accounts.CreateSession(ctx, createSessionParams(otherID, uuid.NewString()))
+	assert(t, nil, err)
+
+	n, err := accounts.RevokeOtherSessions(ctx, userID, keepID)
+	assert(t, nil, err)
+	assert(t, int64(1), n)
+
+	active, err := accounts.GetActiveSessions(ctx, userID)
+	assert(t, nil, err)
+	assert(t, []uuid.UUID{keepID}, sessionIDs(active))
+
+	active, err = accounts.GetActiveSessions(ctx, otherID)
+	assert(t, nil, err)
+	assert(t, []uuid.UUID{othersID}, sessionIDs(active))
+
+	assertErr(t, repository.ErrNotFound, accounts.RevokeSession(ctx, otherID, keepID))
+	assert(t, nil, accounts.RevokeSession(ctx, userID, keepID))
+	assert(t, nil, accounts.RevokeSession(ctx, userID, keepID))
+
+	active, err = accounts.GetActiveSessions(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 0, len(active))
+
+	refreshToken := uuid.NewString()
+	signedOutID, err := accounts.CreateSession(ctx, createSessionParams(userID, refreshToken))
+	assert(t, nil, err)
+	assert(t, nil, accounts.RevokeSessionByRefreshToken(ctx, refreshToken))
+
+	session, err = accounts.GetSession(ctx, signedOutID)
+	assert(t, nil, err)
+	assert(t, true, session.RevokedAt.Valid)
+
+	assertErr(t, repository.ErrNotFound, accounts.RevokeSessionByRefreshToken(ctx, uuid.NewString()))
+	assertErr(t, repository.ErrRefreshTokenReused, accounts.RevokeSessionByRefreshToken(ctx, first))
+}
+
+func testTOTP(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+
+	_, err := accounts.GetTOTP(ctx, userID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, accounts.SetPendingTOTP(ctx, repository.SetTOTPParams{UserID: userID, Secret: []byte("first")}))
+	assert(t, nil, accounts.SetPendingTOTP(ctx, repository.SetTOTPParams{UserID: userID, Secret: []byte("second")}))
+
+	totp, err := accounts.GetTOTP(ctx, userID)
+	assert(t, nil, err)
+	assert(t, []byte("second"), totp.Secret)
+	assert(t, false, totp.ConfirmedAt.Valid)
+
+	assert(t, nil, accounts.UseTOTPStep(ctx, userID, 5))
+	assertErr(t, repository.ErrNotFound, accounts.UseTOTPStep(ctx, userID, 5))
+	assertErr(t, repository.ErrNotFound, accounts.UseTOTPStep(ctx, userID, 4))
+	assert(t, nil, accounts.UseTOTPStep(ctx, userID, 6))
+	assertErr(t, repository.ErrNotFound, accounts.UseTOTPStep(ctx, otherID, 1))
+
+	codes := []string{uuid.NewString(), uuid.NewString()}
+	assert(t, nil, accounts.ConfirmTOTP(ctx, userID, codes))
+	assertErr(t, repository.ErrNotFound, accounts.ConfirmTOTP(ctx, userID, codes))
+	assertErr(t, repository.ErrNotFound, accounts.ConfirmTOTP(ctx, otherID, nil))
+
+	totp, err = accounts.GetTOTP(ctx, userID)
+	assert(t, nil, err)
+	assert(t, true, totp.ConfirmedAt.Valid)
+
+	err = accounts.SetPendingTOTP(ctx, repository.SetTOTPParams{UserID: userID, Secret: []byte("third")})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	n, err := accounts.CountRecoveryCodes(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 2, n)
+
+	assert(t, nil, accounts.UseRecoveryCode(ctx, userID, codes[0]))
+	assertErr(t, repository.ErrNotFound, accounts.UseRecoveryCode(ctx, userID, codes[0]))
+	assertErr(t, repository.ErrNotFound, accounts.UseRecoveryCode(ctx, otherID, codes[1]))
+
+	n, err = accounts.CountRecoveryCodes(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 1, n)
+
+	replaced := uuid.NewString()
+	assert(t, nil, accounts.ReplaceRecoveryCodes(ctx, userID, []string{replaced}))
+	assertErr(t, repository.ErrNotFound, accounts.UseRecoveryCode(ctx, userID, codes[1]))
+
+	n, err = accounts.CountRecoveryCodes(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 1, n)
+
+	assert(t, nil, accounts.DeleteTOTP(ctx, userID))
+
+	_, err = accounts.GetTOTP(ctx, userID)
+	assertErr(t, repository.ErrNotFound, err)
+	assertErr(t, repository.ErrNotFound, accounts.UseRecoveryCode(ctx, userID, replaced))
+}
+
+func testAccessTokens(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+
+	expiresAt := time.Now().Add(time.Hour)
+	token := uuid.NewString()
+	tokenID, err := accounts.CreatePersonalAccessToken(ctx, repository.CreatePersonalAccessTokenParams{UserID: userID, Name: "ci", Token: token, Scopes: []string{"tasks:read", "tasks:write"}, ExpiresAt: &expiresAt})
+	assert(t, nil, err)
+	_, err = accounts.CreatePersonalAccessToken(ctx, repository.CreatePersonalAccessTokenParams{UserID: userID, Name: "forever", Token: uuid.NewString()})
+	assert(t, nil, err)
+
+	pat, err := accounts.GetPersonalAccessTokenByToken(ctx, token)
+	assert(t, nil, err)
+	assert(t, tokenID, pat.ID)
+	assert(t, userID, pat.UserID)
+	assert(t, "tasks:read tasks:write", pat.Scopes)
+	assert(t, true, pat.ExpiresAt.Valid)
+	assert(t, false, pat.LastUsedAt.Valid)
+
+	_, err = accounts.GetPersonalAccessTokenByToken(ctx, uuid.NewString())
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, accounts.TouchPersonalAccessToken(ctx, tokenID))
+	pat, err = accounts.GetPersonalAccessTokenByToken(ctx, token)
+	assert(t, nil, err)
+	assert(t, true, pat.LastUsedAt.Valid)
+
+	tokens, err := accounts.GetPersonalAccessTokens(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 2, len(tokens))
+
+	assertErr(t, repository.ErrNotFound, accounts.DeletePersonalAccessToken(ctx, otherID, tokenID))
+	assert(t, nil, accounts.DeletePersonalAccessToken(ctx, userID, tokenID))
+	assertErr(t, repository.ErrNotFound, accounts.DeletePersonalAccessToken(ctx, userID, tokenID))
+
+	_, err = accounts.GetPersonalAccessTokenByToken(ctx, token)
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, accounts.DeletePersonalAccessTokens(ctx, userID))
+	tokens, err = accounts.GetPersonalAccessTokens(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 0, len(tokens))
+}
+
+func testUserTokens(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	create := func(token string, cooldown time.Duration) error {
+		return accounts.CreateUserToken(ctx, repository.CreateUserTokenParams{
+			UserID:    userID,
+			Purpose:   repository.TokenPurposePasswordReset,
+			Email:     "reset@example.com",
+			Token:     token,
+			ExpiresAt: time.Now().Add(time.Hour),
+			Cooldown:  cooldown,
+		})
+	}
+
+	first := uuid.NewString()
+	assert(t, nil, create(first, time.Hour))
+
+	got, err := accounts.GetUserToken(ctx, repository.TokenPurposePasswordReset, first)
+	assert(t, nil, err)
+	assert(t, userID, got.UserID)
+	assert(t, "reset@example.com", got.Email)
+
+	_, err = accounts.GetUserToken(ctx, repository.TokenPurposeEmailVerification, first)
+	assertErr(t, repository.ErrNotFound, err)
+
+	// the previous token is younger than the cooldown
+	assertErr(t, repository.ErrAlreadyExists, create(uuid.NewString(), time.Hour))
+
+	// a new token retires the previous one
+	second := uuid.NewString()
+	assert(t, nil, create(second, 0))
+	assertErr(t, repository.ErrNotFound, accounts.UseUserToken(ctx, repository.TokenPurposePasswordReset, first))
+
+	assertErr(t, repository.ErrNotFound, accounts.UseUserToken(ctx, repository.TokenPurposeEmailVerification, second))
+	assert(t, nil, accounts.UseUserToken(ctx, repository.TokenPurposePasswordReset, second))
+	assertErr(t, repository.ErrNotFound, accounts.UseUserToken(ctx, repository.TokenPurposePasswordReset, second))
+
+	_, err = accounts.GetUserToken(ctx, repository.TokenPurposePasswordReset, second)
+	assertErr(t, repository.ErrNotFound, err)
+
+	expired := uuid.NewString()
+	err = accounts.CreateUserToken(ctx, repository.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   repository.TokenPurposeEmailVerification,
+		Email:     "verify@example.com",
+		Token:     expired,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	assert(t, nil, err)
+
+	_, err = accounts.GetUserToken(ctx, repository.TokenPurposeEmailVerification, expired)
+	assertErr(t, repository.ErrNotFound, err)
+	assertErr(t, repository.ErrNotFound, accounts.UseUserToken(ctx, repository.TokenPurposeEmailVerification, expired))
+}
+
+func testOAuth(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	ownerID := createUser(t, users)
+	userID := createUser(t, users)
+
+	clientID, err := accounts.CreateOAuthClient(ctx, repository.CreateOAuthClientParams{
+		UserID:       ownerID,
+		Name:         "storetest",
+		Secret:       "secret",
+		RedirectURIs: []string{"https://example.com/a", "https://example.com/b"},
+		Scopes:       []string{"tasks:read"},
+	})
+	assert(t, nil, err)
+	otherClientID, err := accounts.CreateOAuthClient(ctx, repository.CreateOAuthClientParams{UserID: ownerID, Name: "public", RedirectURIs: []string{"https://example.com"}})
+	assert(t, nil, err)
+
+	client, err := accounts.GetOAuthClient(ctx, clientID)
+	assert(t, nil, err)
+	assert(t, ownerID, client.UserID)
+	assert(t, "https://example.com/a https://example.com/b", client.RedirectURIs)
+	assert(t, repository.HashToken("secret"), client.SecretHash)
+
+	client, err = accounts.GetOAuthClient(ctx, otherClientID)
+	assert(t, nil, err)
+	assert(t, 0, len(client.SecretHash))
+
+	_, err = accounts.GetOAuthClient(ctx, uuid.New())
+	assertErr(t, repository.ErrNotFound, err)
+
+	clients, err := accounts.GetOAuthClients(ctx, ownerID)
+	assert(t, nil, err)
+	assert(t, 2, len(clients))
+
+	createCode := func(code string, expiresAt time.Time) {
+		t.Helper()
+		err := accounts.CreateOAuthCode(ctx, repository.CreateOAuthCodeParams{
+			Code:          code,
+			ClientID:      clientID,
+			UserID:        userID,
+			RedirectURI:   "https://example.com/a",
+			Scopes:        []string{"tasks:read"},
+			CodeChallenge: "challenge",
+			ExpiresAt:     expiresAt,
+		})
+		assert(t, nil, err)
+	}
+
+	code := uuid.NewString()
+	createCode(code, time.Now().Add(time.Minute))
+
+	got, err := accounts.GetOAuthCode(ctx, code)
+	assert(t, nil, err)
+	assert(t, userID, got.UserID)
+	assert(t, "challenge", got.CodeChallenge)
+
+	_, err = accounts.GetOAuthCode(ctx, uuid.NewString())
+	assertErr(t, repository.ErrNotFound, err)
+
+	redeem := func(code string, clientID uuid.UUID) (*repository.OAuthGrant, error) {
+		return accounts.RedeemOAuthCode(ctx, repository.RedeemOAuthCodeParams{Code: code, ClientID: clientID, RefreshToken: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour)})
+	}
+
+	_, err = redeem(code, otherClientID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = redeem(uuid.NewString(), clientID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	grant, err := redeem(code, clientID)
+	assert(t, nil, err)
+	assert(t, userID, grant.UserID)
+	assert(t, "tasks:read", grant.Scopes)
+	assert(t, true, grant.IsActive(time.Now()))
+
+	// redeeming a code twice revokes the grant it was redeemed for
+	_, err = redeem(code, clientID)
+	assertErr(t, repository.ErrOAuthCodeReused, err)
+
+	grant, err = accounts.GetOAuthGrant(ctx, grant.ID)
+	assert(t, nil, err)
+	assert(t, true, grant.RevokedAt.Valid)
+
+	expired := uuid.NewString()
+	createCode(expired, time.Now().Add(-time.Minute))
+	_, err = redeem(expired, clientID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	code = uuid.NewString()
+	createCode(code, time.Now().Add(time.Minute))
+	refreshToken := uuid.NewString()
+	grant, err = accounts.RedeemOAuthCode(ctx, repository.RedeemOAuthCodeParams{Code: code, ClientID: clientID, RefreshToken: refreshToken, ExpiresAt: time.Now().Add(time.Hour)})
+	assert(t, nil, err)
+
+	found, err := accounts.GetOAuthGrantByRefreshToken(ctx, refreshToken)
+	assert(t, nil, err)
+	assert(t, grant.ID, found.ID)
+
+	_, err = accounts.GetOAuthGrant(ctx, uuid.New())
+	assertErr(t, repository.ErrNotFound, err)
+
+	rotated := uuid.NewString()
+	_, err = accounts.RotateOAuthRefreshToken(ctx, repository.RotateOAuthRefreshTokenParams{ClientID: otherClientID, RefreshToken: refreshToken, NewRefreshToken: rotated})
+	assertErr(t, repository.ErrNotFound, err)
+
+	found, err = accounts.RotateOAuthRefreshToken(ctx, repository.RotateOAuthRefreshTokenParams{ClientID: clientID, RefreshToken: refreshToken, NewRefreshToken: rotated})
+	assert(t, nil, err)
+	assert(t, grant.ID, found.ID)
+
+	_, err = accounts.RotateOAuthRefreshToken(ctx, repository.RotateOAuthRefreshTokenParams{ClientID: clientID, RefreshToken: refreshToken, NewRefreshToken: uuid.NewString()})
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetOAuthGrantByRefreshToken(ctx, refreshToken)
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, accounts.RevokeOAuthGrant(ctx, grant.ID))
+	assert(t, nil, accounts.RevokeOAuthGrant(ctx, grant.ID))
+
+	_, err = accounts.RotateOAuthRefreshToken(ctx, repository.RotateOAuthRefreshTokenParams{ClientID: clientID, RefreshToken: rotated, NewRefreshToken: uuid.NewString()})
+	assertErr(t, repository.ErrNotFound, err)
+
+	// revoking everything the user gave drops unredeemed codes too
+	code = uuid.NewString()
+	createCode(code, time.Now().Add(time.Minute))
+	active, err := redeem(code, clientID)
+	assert(t, nil, err)
+
+	pending := uuid.NewString()
+	createCode(pending, time.Now().Add(time.Minute))
+
+	assert(t, nil, accounts.RevokeOAuthGrants(ctx, userID))
+
+	active, err = accounts.GetOAuthGrant(ctx, active.ID)
+	assert(t, nil, err)
+	assert(t, true, active.RevokedAt.Valid)
+
+	_, err = accounts.GetOAuthCode(ctx, pending)
+	assertErr(t, repository.ErrNotFound, err)
+
+	// deleting a client deletes its grants
+	assertErr(t, repository.ErrNotFound, accounts.DeleteOAuthClient(ctx, userID, clientID))
+	assert(t, nil, accounts.DeleteOAuthClient(ctx, ownerID, clientID))
+
+	_, err = accounts.GetOAuthClient(ctx, clientID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetOAuthGrant(ctx, active.ID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	clients, err = accounts.GetOAuthClients(ctx, ownerID)
+	assert(t, nil, err)
+	assert(t, 1, len(clients))
+}
+
+func testCertificates(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+	subject := "CN=" + uniqueName("certificate")
+
+	certificateID, err := accounts.CreateClientCertificate(ctx, repository.CreateClientCertificateParams{UserID: userID, Subject: subject, Scopes: []string{"tasks:read"}})
+	assert(t, nil, err)
+
+	_, err = accounts.CreateClientCertificate(ctx, repository.CreateClientCertificateParams{UserID: otherID, Subject: subject})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	certificate, err := accounts.GetClientCertificateBySubject(ctx, subject)
+	assert(t, nil, err)
+	assert(t, certificateID, certificate.ID)
+	assert(t, userID, certificate.UserID)
+	assert(t, "tasks:read", certificate.Scopes)
+	assert(t, false, certificate.LastUsedAt.Valid)
+
+	_, err = accounts.GetClientCertificateBySubject(ctx, "CN="+uniqueName("nobody"))
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, accounts.TouchClientCertificate(ctx, certificateID))
+	certificate, err = accounts.GetClientCertificateBySubject(ctx, subject)
+	assert(t, nil, err)
+	assert(t, true, certificate.LastUsedAt.Valid)
+
+	certificates, err := accounts.GetClientCertificates(ctx, userID)
+	assert(t, nil, err)
+	assert(t, 1, len(certificates))
+
+	certificates, err = accounts.GetClientCertificates(ctx, otherID)
+	assert(t, nil, err)
+	assert(t, 0, len(certificates))
+
+	assertErr(t, repository.ErrNotFound, accounts.DeleteClientCertificate(ctx, otherID, certificateID))
+	assert(t, nil, accounts.DeleteClientCertificate(ctx, userID, certificateID))
+	assertErr(t, repository.ErrNotFound, accounts.DeleteClientCertificate(ctx, userID, certificateID))
+
+	// the subject can be mapped again once the certificate is gone
+	_, err = accounts.CreateClientCertificate(ctx, repository.CreateClientCertificateParams{UserID: otherID, Subject: subject})
+	assert(t, nil, err)
+}
+
+func testThrottle(t *testing.T, accounts repository.AccountStore) {
+	ctx := context.Background()
+	key := uniqueName("throttle")
+
+	_, err := accounts.GetAuthThrottle(ctx, key)
+	assertErr(t, repository.ErrNotFound, err)
+
+	params := repository.ReserveAuthAttemptParams{
+		Key:    key,
+		Window: time.Hour,
+		Delay: func(failures int) time.Duration {
+			if failures < 3 {
+				return 0
+			}
+			return time.Hour
+		},
+	}
+	var reserved *repository.AuthThrottle
+	for i := 1; i <= 3; i++ {
+		reserved, err = accounts.ReserveAuthAttempt(ctx, params)
+		assert(t, nil, err)
+		assert(t, i, reserved.Failures)
+	}
+	assert(t, true, reserved.IsLocked(time.Now()))
+
+	// a locked key refuses attempts without counting them
+	_, err = accounts.ReserveAuthAttempt(ctx, params)
+	assertErr(t, repository.ErrThrottled, err)
+	throttle, err := accounts.GetAuthThrottle(ctx, key)
+	assert(t, nil, err)
+	assert(t, 3, throttle.Failures)
+	assert(t, true, throttle.IsLocked(time.Now()))
+
+	// an attempt that did not fail is taken back along with its lock
+	assert(t, nil, accounts.ReleaseAuthAttempt(ctx, reserved))
+	throttle, err = accounts.GetAuthThrottle(ctx, key)
+	assert(t, nil, err)
+	assert(t, 2, throttle.Failures)
+	assert(t, false, throttle.IsLocked(time.Now()))
+
+	assert(t, nil, accounts.LockAuthThrottle(ctx, key, time.Now().Add(time.Hour)))
+	throttle, err = accounts.GetAuthThrottle(ctx, key)
+	assert(t, nil, err)
+	assert(t, true, throttle.IsLocked(time.Now()))
+	assert(t, nil, accounts.LockAuthThrottle(ctx, key, time.Now()))
+
+	// failures older than the window are forgotten
+	time.Sleep(1100 * time.Millisecond)
+	params.Window = time.Millisecond
+	throttle, err = accounts.ReserveAuthAttempt(ctx, params)
+	assert(t, nil, err)
+	assert(t, 1, throttle.Failures)
+
+	assert(t, nil, accounts.ResetAuthThrottle(ctx, key))
+	_, err = accounts.GetAuthThrottle(ctx, key)
+	assertErr(t, repository.ErrNotFound, err)
+	assert(t, nil, accounts.ReleaseAuthAttempt(ctx, reserved))
+}
+
+func testAuditLogs(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	actorID := createUser(t, users)
+
+	assert(t, nil, accounts.CreateAuditLog(ctx, repository.CreateAuditLogParams{Event: "first", UserID: userID, IP: "192.0.2.1", Detail: "{}"}))
+	assert(t, nil, accounts.CreateAuditLog(ctx, repository.CreateAuditLogParams{Event: "second", UserID: userID, ActorID: actorID, Detail: "{}"}))
+	assert(t, nil, accounts.CreateAuditLog(ctx, repository.CreateAuditLogParams{Event: "third", UserID: userID, Detail: "{}"}))
+	assert(t, nil, accounts.CreateAuditLog(ctx, repository.CreateAuditLogParams{Event: "anonymous", Detail: "{}"}))
+
+	logs, err := accounts.GetAuditLogs(ctx, userID, 2)
+	assert(t, nil, err)
+	assert(t, []string{"third", "second"}, events(logs))
+	assert(t, false, logs[0].ActorID.Valid)
+	assert(t, actorID.String(), logs[1].ActorID.String)
+
+	logs, err = accounts.GetAuditLogs(ctx, userID, 10)
+	assert(t, nil, err)
+	assert(t, []string{"third", "second", "first"}, events(logs))
+	assert(t, "192.0.2.1", logs[2].IP)
+
+	// entries outlive the user they are about
+	assert(t, nil, users.DeleteUser(ctx, userID))
+	logs, err = accounts.GetAuditLogs(ctx, userID, 10)
+	assert(t, nil, err)
+	assert(t, 3, len(logs))
+}
+
+func testDeleteUserAccounts(t *testing.T, users repository.UserStore, accounts repository.AccountStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	ownerID := createUser(t, users)
+
+	sessionID, err := accounts.CreateSession(ctx, createSessionParams(userID, uuid.NewString()))
+	assert(t, nil, err)
+	assert(t, nil, accounts.SetPendingTOTP(ctx, repository.SetTOTPParams{UserID: userID, Secret: []byte("secret")}))
+	token := uuid.NewString()
+	_, err = accounts.CreatePersonalAccessToken(ctx, repository.CreatePersonalAccessTokenParams{UserID: userID, Name: "left behind", Token: token})
+	assert(t, nil, err)
+	subject := "CN=" + uniqueName("certificate")
+	_, err = accounts.CreateClientCertificate(ctx, repository.CreateClientCertificateParams{UserID: userID, Subject: subject})
+	assert(t, nil, err)
+	clientID, err := accounts.CreateOAuthClient(ctx, repository.CreateOAuthClientParams{UserID: userID, Name: "left behind", RedirectURIs: []string{"https://example.com"}})
+	assert(t, nil, err)
+
+	// a grant the user gave to someone else's client
+	otherClientID, err := accounts.CreateOAuthClient(ctx, repository.CreateOAuthClientParams{UserID: ownerID, Name: "kept", RedirectURIs: []string{"https://example.com"}})
+	assert(t, nil, err)
+	code := uuid.NewString()
+	err = accounts.CreateOAuthCode(ctx, repository.CreateOAuthCodeParams{Code: code, ClientID: otherClientID, UserID: userID, RedirectURI: "https://example.com", ExpiresAt: time.Now().Add(time.Minute)})
+	assert(t, nil, err)
+	grant, err := accounts.RedeemOAuthCode(ctx, repository.RedeemOAuthCodeParams{Code: code, ClientID: otherClientID, RefreshToken: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour)})
+	assert(t, nil, err)
+
+	assert(t, nil, users.DeleteUser(ctx, userID))
+
+	_, err = accounts.GetSession(ctx, sessionID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetTOTP(ctx, userID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetPersonalAccessTokenByToken(ctx, token)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetClientCertificateBySubject(ctx, subject)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetOAuthClient(ctx, clientID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetOAuthGrant(ctx, grant.ID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = accounts.GetOAuthClient(ctx, otherClientID)
+	assert(t, nil, err)
+}
+
+func createSessionParams(userID uuid.UUID, refreshToken string) repository.CreateSessionParams {
+	return repository.CreateSessionParams{
+		UserID:       userID,
+		UserAgent:    "storetest",
+		IP:           "127.0.0.1",
+		ExpiresAt:    time.Now().Add(time.Hour),
+		RefreshToken: refreshToken,
+	}
+}
+
+func sortedNames(users []repository.User) []string {
+	names := []string{}
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sessionIDs(sessions []repository.Session) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+func events(logs []repository.AuditLog) []string {
+	names := []string{}
+	for _, log := range logs {
+		names = append(names, log.Event)
+	}
+	return names
+}
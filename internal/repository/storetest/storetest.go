@@ -0,0 +1,1012 @@
+// Package storetest is the conformance suite every repository.Store implementation has to pass
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/search"
+	"github.com/Irori235/system-design-2023-v2/internal/repository"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// Run checks store, which may already hold data from other tests; the suite only looks at users it creates
+func Run(t *testing.T, store repository.Store) {
+	var (
+		tasks     repository.TaskStore    = store
+		users     repository.UserStore    = store
+		accounts  repository.AccountStore = store
+		reminders reminder.Store          = store
+	)
+
+	t.Run("users", func(t *testing.T) { testUsers(t, users) })
+	t.Run("emails", func(t *testing.T) { testEmails(t, users) })
+	t.Run("passwords", func(t *testing.T) { testPasswords(t, users) })
+	t.Run("tasks", func(t *testing.T) { testTasks(t, tasks, users) })
+	t.Run("list tasks", func(t *testing.T) { testListTasks(t, tasks, users) })
+	t.Run("search tasks", func(t *testing.T) { testSearchTasks(t, tasks, users) })
+	t.Run("reminders", func(t *testing.T) { testReminders(t, tasks, users, reminders) })
+	t.Run("recurrence", func(t *testing.T) { testRecurrence(t, tasks, users) })
+	t.Run("projects", func(t *testing.T) { testProjects(t, tasks, users) })
+	t.Run("labels", func(t *testing.T) { testLabels(t, tasks, users) })
+	t.Run("delete user", func(t *testing.T) { testDeleteUser(t, tasks, users) })
+	t.Run("concurrent writes", func(t *testing.T) { testConcurrentWrites(t, tasks, users) })
+	t.Run("admin", func(t *testing.T) { testAdmin(t, tasks, users) })
+	t.Run("identities", func(t *testing.T) { testIdentities(t, users) })
+	t.Run("sessions", func(t *testing.T) { testSessions(t, users, accounts) })
+	t.Run("totp", func(t *testing.T) { testTOTP(t, users, accounts) })
+	t.Run("access tokens", func(t *testing.T) { testAccessTokens(t, users, accounts) })
+	t.Run("user tokens", func(t *testing.T) { testUserTokens(t, users, accounts) })
+	t.Run("oauth", func(t *testing.T) { testOAuth(t, users, accounts) })
+	t.Run("certificates", func(t *testing.T) { testCertificates(t, users, accounts) })
+	t.Run("throttle", func(t *testing.T) { testThrottle(t, accounts) })
+	t.Run("audit logs", func(t *testing.T) { testAuditLogs(t, users, accounts) })
+	t.Run("delete user accounts", func(t *testing.T) { testDeleteUserAccounts(t, users, accounts) })
+}
+
+func testUsers(t *testing.T, users repository.UserStore) {
+	ctx := context.Background()
+	name := uniqueName("user")
+
+	userID, err := users.CreateUser(ctx, repository.CreateUserParams{Name: name, Password: "pass"})
+	assert(t, nil, err)
+
+	user, err := users.GetUser(ctx, userID)
+	assert(t, nil, err)
+	assert(t, name, user.Name)
+	assert(t, false, user.Email.Valid)
+	assert(t, "user", user.Role)
+	assert(t, false, user.DisabledAt.Valid)
+	assert(t, false, user.CreatedAt.IsZero())
+
+	gotID, err := users.GetUserID(ctx, name)
+	assert(t, nil, err)
+	assert(t, userID, gotID)
+
+	// names are compared without regard to case, like the MySQL collation does
+	_, err = users.CreateUser(ctx, repository.CreateUserParams{Name: strings.ToUpper(name), Password: "pass"})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	_, err = users.GetUser(ctx, uuid.New())
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = users.GetUserID(ctx, uniqueName("nobody"))
+	assertErr(t, repository.ErrNotFound, err)
+
+	other := uniqueName("other")
+	_, err = users.CreateUser(ctx, repository.CreateUserParams{Name: other, Password: "pass"})
+	assert(t, nil, err)
+
+	err = users.UpdateName(ctx, repository.UpdateNameParams{ID: userID, Name: other})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	renamed := uniqueName("renamed")
+	assert(t, nil, users.UpdateName(ctx, repository.UpdateNameParams{ID: userID, Name: renamed}))
+	assert(t, nil, users.UpdateName(ctx, repository.UpdateNameParams{ID: userID, Name: renamed}))
+
+	gotID, err = users.GetUserID(ctx, renamed)
+	assert(t, nil, err)
+	assert(t, userID, gotID)
+
+	_, err = users.GetUserID(ctx, name)
+	assertErr(t, repository.ErrNotFound, err)
+}
+
+func testEmails(t *testing.T, users repository.UserStore) {
+	ctx := context.Background()
+	name := uniqueName("email")
+	email := name + "@example.com"
+
+	userID, err := users.CreateUser(ctx, repository.CreateUserParams{Name: name, Password: "pass", Email: email})
+	assert(t, nil, err)
+
+	user, err := users.GetUser(ctx, userID)
+	assert(t, nil, err)
+	assert(t, email, user.Email.String)
+	assert(t, false, user.EmailVerifiedAt.Valid)
+
+	// an unverified address is nobody's yet
+	_, err = users.GetUserByEmail(ctx, email)
+	assertErr(t, repository.ErrNotFound, err)
+
+	otherID, err := users.CreateUser(ctx, repository.CreateUserParams{Name: uniqueName("email"), Password: "pass", Email: email})
+	assert(t, nil, err)
+
+	// a verification for an address the user no longer has does not count
+	err = users.VerifyEmail(ctx, userID, "old-"+email)
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, users.VerifyEmail(ctx, userID, email))
+	user, err = users.GetUserByEmail(ctx, strings.ToUpper(email))
+	assert(t, nil, err)
+	assert(t, userID, user.ID)
+	assert(t, true, user.EmailVerifiedAt.Valid)
+
+	// the first to verify keeps the address
+	err = users.VerifyEmail(ctx, otherID, email)
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	// verifying again keeps the first time
+	verifiedAt := user.EmailVerifiedAt.Time
+	assert(t, nil, users.VerifyEmail(ctx, userID, email))
+	user, err = users.GetUser(ctx, userID)
+	assert(t, nil, err)
+	assert(t, true, verifiedAt.Equal(user.EmailVerifiedAt.Time))
+
+	// setting the same address keeps it verified
+	assert(t, nil, users.UpdateEmail(ctx, repository.UpdateEmailParams{ID: userID, Email: email}))
+	user, err = users.GetUser(ctx, userID)
+	assert(t, nil, err)
+	assert(t, true, user.EmailVerifiedAt.Valid)
+
+	changed := "new-" + email
+	assert(t, nil, users.UpdateEmail(ctx, repository.UpdateEmailParams{ID: userID, Email: changed}))
+	user, err = users.GetUser(ctx, userID)
+	assert(t, nil, err)
+	assert(t, changed, user.Email.String)
+	assert(t, false, user.EmailVerifiedAt.Valid)
+
+	// giving the address up lets the other user verify it
+	assert(t, nil, users.VerifyEmail(ctx, otherID, email))
+	user, err = users.GetUserByEmail(ctx, email)
+	assert(t, nil, err)
+	assert(t, otherID, user.ID)
+}
+
+func testPasswords(t *testing.T, users repository.UserStore) {
+	ctx := context.Background()
+	name := uniqueName("pass")
+
+	userID, err := users.CreateUser(ctx, repository.CreateUserParams{Name: name, Password: "pass"})
+	assert(t, nil, err)
+
+	gotID, err := users.Authenticate(ctx, name, "pass")
+	assert(t, nil, err)
+	assert(t, userID, gotID)
+
+	_, err = users.Authenticate(ctx, name, "wrong")
+	assertErr(t, repository.ErrInvalidCredentials, err)
+
+	_, err = users.Authenticate(ctx, uniqueName("nobody"), "pass")
+	assertErr(t, repository.ErrInvalidCredentials, err)
+
+	ok, err := users.CheckPass(ctx, userID, "pass")
+	assert(t, nil, err)
+	assert(t, true, ok)
+
+	ok, err = users.CheckPass(ctx, userID, "wrong")
+	assert(t, nil, err)
+	assert(t, false, ok)
+
+	_, err = users.CheckPass(ctx, uuid.New(), "pass")
+	assertErr(t, repository.ErrNotFound, err)
+
+	assert(t, nil, users.UpdatePass(ctx, repository.UpdatePassParams{ID: userID, Password: "changed"}))
+
+	_, err = users.Authenticate(ctx, name, "pass")
+	assertErr(t, repository.ErrInvalidCredentials, err)
+
+	gotID, err = users.Authenticate(ctx, name, "changed")
+	assert(t, nil, err)
+	assert(t, userID, gotID)
+}
+
+func testTasks(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+
+	for _, title := range []string{"task1", "task2"} {
+		assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, Title: title}))
+	}
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: otherID, Title: "other task"}))
+
+	// tasks need an existing owner
+	assert(t, true, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: uuid.New(), Title: "orphan"}) != nil)
+
+	got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, []string{"task1", "task2"}, titles(got))
+	for _, task := range got {
+		assert(t, userID, task.UserID)
+		assert(t, false, task.IsDone)
+		assert(t, true, task.CreatedAt != "")
+	}
+
+	none, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: uuid.New()})
+	assert(t, nil, err)
+	assert(t, 0, len(none))
+
+	task, err := tasks.GetTask(ctx, userID, got[0].ID)
+	assert(t, nil, err)
+	assert(t, got[0], *task)
+
+	_, err = tasks.GetTask(ctx, otherID, got[0].ID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	update := repository.UpdateTaskParams{ID: got[0].ID, UserID: userID, Title: "task1 done", IsDone: true}
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+	// an update that changes nothing still finds the task
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+
+	task, err = tasks.GetTask(ctx, userID, got[0].ID)
+	assert(t, nil, err)
+	assert(t, "task1 done", task.Title)
+	assert(t, true, task.IsDone)
+	assert(t, got[0].CreatedAt, task.CreatedAt)
+
+	update.UserID = otherID
+	assertErr(t, repository.ErrNotFound, tasks.UpdateTask(ctx, update))
+
+	update = repository.UpdateTaskParams{ID: uuid.New(), UserID: userID, Title: "missing"}
+	assertErr(t, repository.ErrNotFound, tasks.UpdateTask(ctx, update))
+
+	assertErr(t, repository.ErrNotFound, tasks.DeleteTask(ctx, otherID, got[1].ID))
+	assert(t, nil, tasks.DeleteTask(ctx, userID, got[1].ID))
+	assertErr(t, repository.ErrNotFound, tasks.DeleteTask(ctx, userID, got[1].ID))
+
+	got, err = tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, []string{"task1 done"}, titles(got))
+}
+
+func testListTasks(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+
+	due := time.Now().UTC().Truncate(time.Second).Add(24 * time.Hour)
+	for _, task := range []struct {
+		title string
+		due   time.Duration
+	}{
+		{"carrot", 2 * time.Hour},
+		{"apple", 0},
+		{"banana pie", time.Hour},
+		{"date", 0},
+		{"eggplant", 3 * time.Hour},
+		{"fig", 0},
+	} {
+		params := repository.CreateTaskParams{UserID: userID, Title: task.title}
+		if task.due != 0 {
+			params.DueAt = sql.NullTime{Time: due.Add(task.due), Valid: true}
+		}
+		assert(t, nil, tasks.CreateTask(ctx, params))
+	}
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: otherID, Title: "apple"}))
+
+	list := func(t *testing.T, params repository.GetTasksParams) []repository.Task {
+		t.Helper()
+
+		params.UserID = userID
+		got, err := tasks.GetTasks(ctx, params)
+		assert(t, nil, err)
+		return got
+	}
+	inOrder := func(tasks []repository.Task) []string {
+		titles := make([]string, len(tasks))
+		for i, task := range tasks {
+			titles[i] = task.Title
+		}
+		return titles
+	}
+
+	all := list(t, repository.GetTasksParams{Sort: repository.TaskSortTitle})
+	assert(t, []string{"apple", "banana pie", "carrot", "date", "eggplant", "fig"}, inOrder(all))
+	assert(t, true, all[1].DueAt.Valid && all[1].DueAt.Time.Equal(due.Add(time.Hour)))
+	assert(t, false, all[0].DueAt.Valid)
+
+	desc := list(t, repository.GetTasksParams{Sort: repository.TaskSortTitle, Desc: true})
+	assert(t, []string{"fig", "eggplant", "date", "carrot", "banana pie", "apple"}, inOrder(desc))
+
+	// tasks without a due date come last in either direction
+	byDue := list(t, repository.GetTasksParams{Sort: repository.TaskSortDue})
+	assert(t, []string{"banana pie", "carrot", "eggplant"}, inOrder(byDue[:3]))
+	assert(t, []string{"apple", "date", "fig"}, titles(byDue[3:]))
+	byDue = list(t, repository.GetTasksParams{Sort: repository.TaskSortDue, Desc: true})
+	assert(t, []string{"eggplant", "carrot", "banana pie"}, inOrder(byDue[:3]))
+	assert(t, []string{"apple", "date", "fig"}, titles(byDue[3:]))
+
+	assert(t, []string{"banana pie", "carrot"}, titles(list(t, repository.GetTasksParams{DueBefore: due.Add(150 * time.Minute)})))
+
+	update := repository.UpdateTaskParams{ID: all[2].ID, UserID: userID, Title: "carrot", IsDone: true}
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+
+	done, notDone := true, false
+	assert(t, []string{"carrot"}, titles(list(t, repository.GetTasksParams{IsDone: &done})))
+	assert(t, 5, len(list(t, repository.GetTasksParams{IsDone: &notDone})))
+
+	// an update without a due date clears it
+	assert(t, false, list(t, repository.GetTasksParams{IsDone: &done})[0].DueAt.Valid)
+
+	assert(t, []string{"banana pie"}, titles(list(t, repository.GetTasksParams{TitleContains: "PIE"})))
+	assert(t, 0, len(list(t, repository.GetTasksParams{TitleContains: "%"})))
+
+	hourAgo := time.Now().Add(-time.Hour)
+	assert(t, 6, len(list(t, repository.GetTasksParams{CreatedAfter: hourAgo})))
+	assert(t, 0, len(list(t, repository.GetTasksParams{CreatedBefore: hourAgo})))
+	assert(t, 0, len(list(t, repository.GetTasksParams{CreatedAfter: time.Now().Add(time.Hour)})))
+
+	// walking the pages gives the whole listing, whatever the ties
+	for _, sort := range []repository.TaskSort{repository.TaskSortCreated, repository.TaskSortTitle, repository.TaskSortDue} {
+		for _, desc := range []bool{false, true} {
+			params := repository.GetTasksParams{Sort: sort, Desc: desc}
+			whole := list(t, params)
+			assert(t, 6, len(whole))
+
+			walked := []repository.Task{}
+			params.Limit = 4
+			for i := 0; i < 3; i++ {
+				page := list(t, params)
+				walked = append(walked, page...)
+				if len(page) < params.Limit {
+					break
+				}
+
+				cursor, err := page[len(page)-1].Cursor(sort)
+				assert(t, nil, err)
+				params.After = &cursor
+			}
+			assert(t, whole, walked)
+		}
+	}
+}
+
+func testSearchTasks(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+	otherID := createUser(t, users)
+
+	for _, title := range []string{"buy milk", "buy bread", "Milkshake recipe", "milk and more milk", "e-mail the baker"} {
+		assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, Title: title}))
+	}
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: otherID, Title: "milk tea"}))
+
+	find := func(t *testing.T, q string) []repository.SearchedTask {
+		t.Helper()
+
+		found, err := tasks.SearchTasks(ctx, repository.SearchTasksParams{UserID: userID, Target: search.Parse(q)})
+		assert(t, nil, err)
+		return found
+	}
+
+	// matches ignore case and only cover the user's tasks
+	found := find(t, "MILK")
+	assert(t, []string{"Milkshake recipe", "buy milk", "milk and more milk"}, sortedTitles(found))
+	for _, task := range found {
+		assert(t, true, task.Score > 0)
+	}
+	// the task mentioning milk twice ranks first
+	assert(t, "milk and more milk", found[0].Title)
+
+	// every term is required
+	assert(t, []string{"buy milk"}, sortedTitles(find(t, "buy milk")))
+	assert(t, []string{"buy milk"}, sortedTitles(find(t, `"buy milk"`)))
+	assert(t, []string{"buy bread", "buy milk"}, sortedTitles(find(t, "bu*")))
+	assert(t, []string{"e-mail the baker"}, sortedTitles(find(t, "e-mail")))
+	assert(t, 0, len(find(t, "mail-e")))
+	assert(t, 0, len(find(t, "coffee")))
+	assert(t, 0, len(find(t, "")))
+}
+
+func testReminders(t *testing.T, tasks repository.TaskStore, users repository.UserStore, reminders reminder.Store) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	due := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	params := repository.CreateTaskParams{
+		UserID:      userID,
+		Title:       "reminded",
+		DueAt:       sql.NullTime{Time: due, Valid: true},
+		DueTimeZone: "Asia/Tokyo",
+		Reminders:   []int{30, 90},
+	}
+	assert(t, nil, tasks.CreateTask(ctx, params))
+
+	got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, 1, len(got))
+	assert(t, []int{30, 90}, got[0].ReminderList())
+	assert(t, "Asia/Tokyo", got[0].DueTimeZone.String)
+	taskID := got[0].ID
+
+	// other tests may have reminders due as well
+	claim := func(t *testing.T, now time.Time) []reminder.Reminder {
+		t.Helper()
+
+		claimed, err := reminders.ClaimDueReminders(ctx, now, time.Minute, 1000)
+		assert(t, nil, err)
+
+		own := []reminder.Reminder{}
+		for _, r := range claimed {
+			if r.TaskID == taskID {
+				own = append(own, r)
+			}
+		}
+		return own
+	}
+
+	assert(t, 0, len(claim(t, time.Now())))
+
+	// the reminder 90 minutes before was past already and is left out
+	claimed := claim(t, due.Add(-29*time.Minute))
+	assert(t, 1, len(claimed))
+	assert(t, userID, claimed[0].UserID)
+	assert(t, "reminded", claimed[0].Title)
+	assert(t, true, due.Equal(claimed[0].DueAt))
+	assert(t, "Asia/Tokyo", claimed[0].TimeZone)
+	assert(t, true, due.Add(-30*time.Minute).Equal(claimed[0].RemindAt))
+	assert(t, "", claimed[0].Email)
+
+	// a claimed reminder is handed out again only once its lease runs out
+	assert(t, 0, len(claim(t, due.Add(-29*time.Minute))))
+	retried := claim(t, due.Add(-27*time.Minute))
+	assert(t, 1, len(retried))
+	assert(t, claimed[0].ID, retried[0].ID)
+
+	// the first claim ran out, so only the second may record the delivery
+	assertErr(t, reminder.ErrClaimLost, reminders.MarkReminderSent(ctx, claimed[0].ID, claimed[0].ClaimID))
+	assert(t, nil, reminders.MarkReminderSent(ctx, retried[0].ID, retried[0].ClaimID))
+	assertErr(t, reminder.ErrClaimLost, reminders.MarkReminderSent(ctx, retried[0].ID, retried[0].ClaimID))
+	assert(t, 0, len(claim(t, due.Add(-20*time.Minute))))
+
+	// an update that keeps the due date does not send it again
+	update := repository.UpdateTaskParams{ID: taskID, UserID: userID, Title: "renamed", DueAt: params.DueAt, Reminders: []int{30}}
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+	assert(t, 0, len(claim(t, due.Add(-20*time.Minute))))
+
+	// a new due date reschedules it
+	update.DueAt.Time = due.Add(time.Hour)
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+	claimed = claim(t, due.Add(31*time.Minute))
+	assert(t, 1, len(claimed))
+	assert(t, "renamed", claimed[0].Title)
+	assert(t, "", claimed[0].TimeZone)
+
+	// reminders of tasks that are done are not sent
+	update.DueAt.Time = due.Add(2 * time.Hour)
+	update.IsDone = true
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+	assert(t, 0, len(claim(t, due.Add(2*time.Hour))))
+
+	// clearing the due date drops them
+	update.DueAt = sql.NullTime{}
+	update.IsDone = false
+	assert(t, nil, tasks.UpdateTask(ctx, update))
+	assert(t, 0, len(claim(t, due.Add(3*time.Hour))))
+
+	task, err := tasks.GetTask(ctx, userID, taskID)
+	assert(t, nil, err)
+	assert(t, false, task.DueAt.Valid)
+}
+
+func testRecurrence(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	// Mondays at 9:00 in Tokyo, five times
+	first := time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	params := repository.CreateTaskParams{
+		UserID:      userID,
+		Title:       "chores",
+		DueAt:       sql.NullTime{Time: first, Valid: true},
+		DueTimeZone: "Asia/Tokyo",
+		Reminders:   []int{10},
+		Recurrence:  "FREQ=WEEKLY;BYDAY=MO;COUNT=5",
+	}
+	assert(t, nil, tasks.CreateTask(ctx, params))
+
+	open := func(t *testing.T) repository.Task {
+		t.Helper()
+
+		notDone := false
+		got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID, IsDone: &notDone})
+		assert(t, nil, err)
+		assert(t, 1, len(got))
+		return got[0]
+	}
+	complete := func(t *testing.T, task repository.Task) {
+		t.Helper()
+
+		update := repository.UpdateTaskParams{
+			ID:          task.ID,
+			UserID:      userID,
+			Title:       task.Title,
+			IsDone:      true,
+			DueAt:       task.DueAt,
+			DueTimeZone: task.DueTimeZone.String,
+			Reminders:   task.ReminderList(),
+		}
+		assert(t, nil, tasks.UpdateTask(ctx, update))
+	}
+
+	task := open(t)
+	assert(t, true, task.SeriesID.Valid)
+	assert(t, "FREQ=WEEKLY;BYDAY=MO;COUNT=5", task.Recurrence)
+	assert(t, true, first.Equal(task.OccurrenceAt.Time))
+
+	series, err := tasks.GetTaskSeries(ctx, userID, task.SeriesID.UUID)
+	assert(t, nil, err)
+	assert(t, true, first.Equal(series.StartsAt))
+	_, err = tasks.GetTaskSeries(ctx, createUser(t, users), task.SeriesID.UUID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	occurrences, err := series.Occurrences(first, 10)
+	assert(t, nil, err)
+	assert(t, 4, len(occurrences))
+	assert(t, true, first.Add(4*week).Equal(occurrences[3]))
+
+	// completing an occurrence creates the next one, only once
+	complete(t, task)
+	next := open(t)
+	assert(t, "chores", next.Title)
+	assert(t, true, first.Add(week).Equal(next.DueAt.Time))
+	assert(t, []int{10}, next.ReminderList())
+	assert(t, task.SeriesID, next.SeriesID)
+
+	reopen := repository.UpdateTaskParams{ID: task.ID, UserID: userID, Title: task.Title, DueAt: task.DueAt}
+	assert(t, nil, tasks.UpdateTask(ctx, reopen))
+	reopen.IsDone = true
+	assert(t, nil, tasks.UpdateTask(ctx, reopen))
+	all, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, 2, len(all))
+
+	// an edit of this occurrence only does not carry over
+	edit := repository.UpdateTaskParams{ID: next.ID, UserID: userID, Title: "big chores", DueAt: next.DueAt, DueTimeZone: "Asia/Tokyo"}
+	assert(t, nil, tasks.UpdateTask(ctx, edit))
+
+	// skipping moves it on afresh from the series
+	assert(t, nil, tasks.SkipOccurrence(ctx, userID, next.ID))
+	skipped := open(t)
+	assert(t, next.ID, skipped.ID)
+	assert(t, "chores", skipped.Title)
+	assert(t, true, first.Add(2*week).Equal(skipped.DueAt.Time))
+	assert(t, true, first.Add(2*week).Equal(skipped.OccurrenceAt.Time))
+
+	// editing this and the following occurrences starts a new series, which keeps the count
+	following := repository.UpdateTaskParams{
+		ID:          skipped.ID,
+		UserID:      userID,
+		Title:       "laundry",
+		DueAt:       sql.NullTime{Time: first.Add(2*week + time.Hour), Valid: true},
+		DueTimeZone: "Asia/Tokyo",
+		Following:   true,
+		Recurrence:  skipped.Recurrence,
+	}
+	assert(t, nil, tasks.UpdateTask(ctx, following))
+	split := open(t)
+	assert(t, true, split.SeriesID != skipped.SeriesID)
+	assert(t, "FREQ=WEEKLY;BYDAY=MO;COUNT=3", split.Recurrence)
+
+	complete(t, split)
+	last := open(t)
+	assert(t, "laundry", last.Title)
+	assert(t, true, first.Add(3*week+time.Hour).Equal(last.DueAt.Time))
+	assert(t, []int{}, last.ReminderList())
+
+	assert(t, nil, tasks.SkipOccurrence(ctx, userID, last.ID))
+	last = open(t)
+	assert(t, true, first.Add(4*week+time.Hour).Equal(last.DueAt.Time))
+	assertErr(t, repository.ErrSeriesEnded, tasks.SkipOccurrence(ctx, userID, last.ID))
+
+	// the last occurrence has no next one
+	complete(t, last)
+	all, err = tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, 3, len(all))
+
+	// stopping the series leaves a plain task
+	stop := repository.UpdateTaskParams{ID: last.ID, UserID: userID, Title: "laundry", DueAt: last.DueAt, Following: true}
+	assert(t, nil, tasks.UpdateTask(ctx, stop))
+	stopped, err := tasks.GetTask(ctx, userID, last.ID)
+	assert(t, nil, err)
+	assert(t, false, stopped.SeriesID.Valid)
+	assert(t, "", stopped.Recurrence)
+	assertErr(t, repository.ErrSeriesEnded, tasks.SkipOccurrence(ctx, userID, last.ID))
+
+	// a series goes away with its last task
+	for _, task := range all {
+		assert(t, nil, tasks.DeleteTask(ctx, userID, task.ID))
+	}
+	_, err = tasks.GetTaskSeries(ctx, userID, task.SeriesID.UUID)
+	assertErr(t, repository.ErrNotFound, err)
+}
+
+func testProjects(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	// every user starts with an Inbox, which tasks go to by default
+	projects, err := tasks.GetProjects(ctx, userID, false)
+	assert(t, nil, err)
+	assert(t, 1, len(projects))
+	inbox := projects[0]
+	assert(t, repository.InboxName, inbox.Name)
+	assert(t, true, inbox.IsInbox)
+
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, Title: "loose end"}))
+
+	workID, err := tasks.CreateProject(ctx, repository.CreateProjectParams{UserID: userID, Name: "work"})
+	assert(t, nil, err)
+	_, err = tasks.CreateProject(ctx, repository.CreateProjectParams{UserID: userID, Name: "Work"})
+	assertErr(t, repository.ErrAlreadyExists, err)
+	homeID, err := tasks.CreateProject(ctx, repository.CreateProjectParams{UserID: userID, Name: "home"})
+	assert(t, nil, err)
+
+	// names are per user
+	otherID := createUser(t, users)
+	_, err = tasks.CreateProject(ctx, repository.CreateProjectParams{UserID: otherID, Name: "work"})
+	assert(t, nil, err)
+	_, err = tasks.GetProject(ctx, otherID, workID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	projects, err = tasks.GetProjects(ctx, userID, false)
+	assert(t, nil, err)
+	assert(t, 3, len(projects))
+	assert(t, []uuid.UUID{inbox.ID, homeID, workID}, []uuid.UUID{projects[0].ID, projects[1].ID, projects[2].ID})
+
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, ProjectID: workID, Title: "report"}))
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, ProjectID: workID, Title: "meeting"}))
+
+	list := func(t *testing.T, projectID uuid.UUID) []repository.Task {
+		t.Helper()
+
+		got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID, ProjectID: projectID})
+		assert(t, nil, err)
+		return got
+	}
+	assert(t, []string{"loose end"}, titles(list(t, inbox.ID)))
+	assert(t, []string{"meeting", "report"}, titles(list(t, workID)))
+	assert(t, 3, len(list(t, uuid.Nil)))
+
+	// an update without a project keeps the task where it is, and one with a project moves it
+	var report repository.Task
+	for _, task := range list(t, workID) {
+		if task.Title == "report" {
+			report = task
+		}
+	}
+	keep := repository.UpdateTaskParams{ID: report.ID, UserID: userID, Title: "report"}
+	assert(t, nil, tasks.UpdateTask(ctx, keep))
+	moved, err := tasks.GetTask(ctx, userID, report.ID)
+	assert(t, nil, err)
+	assert(t, workID, moved.ProjectID)
+	move := repository.UpdateTaskParams{ID: report.ID, UserID: userID, ProjectID: homeID, Title: "report"}
+	assert(t, nil, tasks.UpdateTask(ctx, move))
+	assert(t, []string{"report"}, titles(list(t, homeID)))
+
+	// moving tasks goes all or nothing
+	all := list(t, uuid.Nil)
+	ids := make([]uuid.UUID, len(all))
+	for i, task := range all {
+		ids[i] = task.ID
+	}
+	foreign := repository.MoveTasksParams{UserID: userID, ProjectID: workID, TaskIDs: append(ids[:len(ids):len(ids)], uuid.New())}
+	assertErr(t, repository.ErrNotFound, tasks.MoveTasks(ctx, foreign))
+	assert(t, []string{"loose end"}, titles(list(t, inbox.ID)))
+	assertErr(t, repository.ErrNotFound, tasks.MoveTasks(ctx, repository.MoveTasksParams{UserID: otherID, ProjectID: workID, TaskIDs: ids}))
+	assert(t, nil, tasks.MoveTasks(ctx, repository.MoveTasksParams{UserID: userID, ProjectID: workID, TaskIDs: ids}))
+	assert(t, 3, len(list(t, workID)))
+
+	// archiving hides a project from the default listing, and restoring brings it back
+	archive := repository.UpdateProjectParams{ID: homeID, UserID: userID, Name: "house", Archived: true}
+	assert(t, nil, tasks.UpdateProject(ctx, archive))
+	projects, err = tasks.GetProjects(ctx, userID, false)
+	assert(t, nil, err)
+	assert(t, 2, len(projects))
+	projects, err = tasks.GetProjects(ctx, userID, true)
+	assert(t, nil, err)
+	assert(t, 3, len(projects))
+	house, err := tasks.GetProject(ctx, userID, homeID)
+	assert(t, nil, err)
+	assert(t, "house", house.Name)
+	assert(t, true, house.ArchivedAt.Valid)
+
+	// tasks cannot be put in an archived project or another user's, but may stay in one archived since
+	assertErr(t, repository.ErrProjectArchived, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, ProjectID: homeID, Title: "chores"}))
+	assertErr(t, repository.ErrUnknownProject, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: otherID, ProjectID: workID, Title: "chores"}))
+	move = repository.UpdateTaskParams{ID: report.ID, UserID: userID, ProjectID: homeID, Title: "report"}
+	assertErr(t, repository.ErrProjectArchived, tasks.UpdateTask(ctx, move))
+	move = repository.UpdateTaskParams{ID: report.ID, UserID: userID, ProjectID: uuid.New(), Title: "report"}
+	assertErr(t, repository.ErrUnknownProject, tasks.UpdateTask(ctx, move))
+	assertErr(t, repository.ErrProjectArchived, tasks.MoveTasks(ctx, repository.MoveTasksParams{UserID: userID, ProjectID: homeID, TaskIDs: []uuid.UUID{report.ID}}))
+	assert(t, 3, len(list(t, workID)))
+	assert(t, nil, tasks.UpdateProject(ctx, repository.UpdateProjectParams{ID: workID, UserID: userID, Name: "work", Archived: true}))
+	keep = repository.UpdateTaskParams{ID: report.ID, UserID: userID, ProjectID: workID, Title: "report"}
+	assert(t, nil, tasks.UpdateTask(ctx, keep))
+	assert(t, nil, tasks.UpdateProject(ctx, repository.UpdateProjectParams{ID: workID, UserID: userID, Name: "work"}))
+
+	assert(t, nil, tasks.UpdateProject(ctx, repository.UpdateProjectParams{ID: homeID, UserID: userID, Name: "house"}))
+	house, err = tasks.GetProject(ctx, userID, homeID)
+	assert(t, nil, err)
+	assert(t, false, house.ArchivedAt.Valid)
+
+	rename := repository.UpdateProjectParams{ID: homeID, UserID: userID, Name: "WORK"}
+	assertErr(t, repository.ErrAlreadyExists, tasks.UpdateProject(ctx, rename))
+
+	// the Inbox stays as it is
+	rename = repository.UpdateProjectParams{ID: inbox.ID, UserID: userID, Name: "Outbox"}
+	assertErr(t, repository.ErrNotFound, tasks.UpdateProject(ctx, rename))
+	assertErr(t, repository.ErrNotFound, tasks.DeleteProject(ctx, userID, inbox.ID))
+
+	// deleting a project moves its tasks to the Inbox
+	assertErr(t, repository.ErrNotFound, tasks.DeleteProject(ctx, otherID, workID))
+	assert(t, nil, tasks.DeleteProject(ctx, userID, workID))
+	_, err = tasks.GetProject(ctx, userID, workID)
+	assertErr(t, repository.ErrNotFound, err)
+	assert(t, 3, len(list(t, inbox.ID)))
+
+	// the next occurrence of a recurring task stays in its project
+	assert(t, nil, tasks.MoveTasks(ctx, repository.MoveTasksParams{UserID: userID, ProjectID: homeID, TaskIDs: ids[:1]}))
+	for _, task := range list(t, uuid.Nil) {
+		assert(t, nil, tasks.DeleteTask(ctx, userID, task.ID))
+	}
+	due := sql.NullTime{Time: time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC), Valid: true}
+	create := repository.CreateTaskParams{UserID: userID, ProjectID: homeID, Title: "dishes", DueAt: due, Recurrence: "FREQ=DAILY"}
+	assert(t, nil, tasks.CreateTask(ctx, create))
+	dishes := list(t, homeID)
+	assert(t, 1, len(dishes))
+	done := repository.UpdateTaskParams{ID: dishes[0].ID, UserID: userID, Title: "dishes", IsDone: true, DueAt: due}
+	assert(t, nil, tasks.UpdateTask(ctx, done))
+	assert(t, 2, len(list(t, homeID)))
+}
+
+func testLabels(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	newLabel := func(t *testing.T, name string) uuid.UUID {
+		t.Helper()
+
+		labelID, err := tasks.CreateLabel(ctx, repository.CreateLabelParams{UserID: userID, Name: name, Color: "#ff0000"})
+		assert(t, nil, err)
+		return labelID
+	}
+	workID, homeID, somedayID := newLabel(t, "work"), newLabel(t, "home"), newLabel(t, "someday")
+
+	_, err := tasks.CreateLabel(ctx, repository.CreateLabelParams{UserID: userID, Name: "Work", Color: "#00ff00"})
+	assertErr(t, repository.ErrAlreadyExists, err)
+
+	labels, err := tasks.GetLabels(ctx, userID)
+	assert(t, nil, err)
+	assert(t, []uuid.UUID{homeID, somedayID, workID}, []uuid.UUID{labels[0].ID, labels[1].ID, labels[2].ID})
+
+	otherID := createUser(t, users)
+	_, err = tasks.GetLabel(ctx, otherID, workID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	for _, task := range []struct {
+		title    string
+		labelIDs []uuid.UUID
+	}{
+		{"report", []uuid.UUID{workID}},
+		{"plan", []uuid.UUID{workID, somedayID}},
+		{"dishes", []uuid.UUID{homeID}},
+		{"novel", []uuid.UUID{somedayID}},
+		{"nothing", nil},
+	} {
+		create := repository.CreateTaskParams{UserID: userID, Title: task.title, LabelIDs: task.labelIDs}
+		assert(t, nil, tasks.CreateTask(ctx, create))
+	}
+
+	list := func(t *testing.T, include []string, exclude []string) []repository.Task {
+		t.Helper()
+
+		got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID, Labels: include, ExcludeLabels: exclude})
+		assert(t, nil, err)
+		return got
+	}
+	find := func(t *testing.T, title string) repository.Task {
+		t.Helper()
+
+		for _, task := range list(t, nil, nil) {
+			if task.Title == title {
+				return task
+			}
+		}
+		t.Fatalf("task %q not found", title)
+		return repository.Task{}
+	}
+	names := func(task repository.Task) []string {
+		names := make([]string, len(task.Labels))
+		for i, label := range task.Labels {
+			names[i] = label.Name
+		}
+		return names
+	}
+
+	// labels come with the task, by name
+	assert(t, []string{"someday", "work"}, names(find(t, "plan")))
+	assert(t, []string{}, names(find(t, "nothing")))
+	plan, err := tasks.GetTask(ctx, userID, find(t, "plan").ID)
+	assert(t, nil, err)
+	assert(t, []string{"someday", "work"}, names(*plan))
+
+	found, err := tasks.SearchTasks(ctx, repository.SearchTasksParams{UserID: userID, Target: search.Parse("plan")})
+	assert(t, nil, err)
+	assert(t, 1, len(found))
+	assert(t, []string{"someday", "work"}, names(found[0].Task))
+
+	// a task has to have every included label and none of the excluded ones, matched ignoring case
+	assert(t, []string{"plan", "report"}, titles(list(t, []string{"WORK"}, nil)))
+	assert(t, []string{"report"}, titles(list(t, []string{"work"}, []string{"someday"})))
+	assert(t, []string{"plan"}, titles(list(t, []string{"work", "someday"}, nil)))
+	assert(t, []string{"dishes", "nothing"}, titles(list(t, nil, []string{"work", "someday"})))
+	assert(t, []string{}, titles(list(t, []string{"unknown"}, nil)))
+	assert(t, 5, len(list(t, nil, []string{"unknown"})))
+
+	// an update without labels keeps them, and one with labels replaces them
+	report := find(t, "report")
+	assert(t, nil, tasks.UpdateTask(ctx, repository.UpdateTaskParams{ID: report.ID, UserID: userID, Title: "report"}))
+	assert(t, []string{"work"}, names(find(t, "report")))
+	relabel := repository.UpdateTaskParams{ID: report.ID, UserID: userID, Title: "report", LabelIDs: []uuid.UUID{homeID, workID}}
+	assert(t, nil, tasks.UpdateTask(ctx, relabel))
+	assert(t, []string{"home", "work"}, names(find(t, "report")))
+	clear := repository.UpdateTaskParams{ID: report.ID, UserID: userID, Title: "report", LabelIDs: []uuid.UUID{}}
+	assert(t, nil, tasks.UpdateTask(ctx, clear))
+	assert(t, []string{}, names(find(t, "report")))
+
+	// labels that are unknown or another user's are refused, and the task is left as it was
+	otherLabelID, err := tasks.CreateLabel(ctx, repository.CreateLabelParams{UserID: otherID, Name: "other", Color: "#000000"})
+	assert(t, nil, err)
+	stray := repository.UpdateTaskParams{ID: report.ID, UserID: userID, Title: "report", LabelIDs: []uuid.UUID{homeID, uuid.New()}}
+	assertErr(t, repository.ErrUnknownLabel, tasks.UpdateTask(ctx, stray))
+	assert(t, []string{}, names(find(t, "report")))
+	assertErr(t, repository.ErrUnknownLabel, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, Title: "stray", LabelIDs: []uuid.UUID{otherLabelID}}))
+	assert(t, 5, len(list(t, nil, nil)))
+
+	// renaming shows on every task at once
+	rename := repository.UpdateLabelParams{ID: workID, UserID: userID, Name: "job", Color: "#0000ff"}
+	assert(t, nil, tasks.UpdateLabel(ctx, rename))
+	assert(t, []string{"job", "someday"}, names(find(t, "plan")))
+	assert(t, "#0000ff", find(t, "plan").Labels[0].Color)
+	assert(t, []string{"plan"}, titles(list(t, []string{"job"}, nil)))
+	assertErr(t, repository.ErrAlreadyExists, tasks.UpdateLabel(ctx, repository.UpdateLabelParams{ID: workID, UserID: userID, Name: "Home", Color: "#0000ff"}))
+	assertErr(t, repository.ErrNotFound, tasks.UpdateLabel(ctx, repository.UpdateLabelParams{ID: workID, UserID: otherID, Name: "x", Color: "#0000ff"}))
+
+	// merging moves the source label's tasks to the target, once each, and deletes the source
+	assertErr(t, repository.ErrNotFound, tasks.MergeLabels(ctx, repository.MergeLabelsParams{UserID: userID, SourceID: somedayID, TargetID: otherLabelID}))
+	assert(t, nil, tasks.MergeLabels(ctx, repository.MergeLabelsParams{UserID: userID, SourceID: somedayID, TargetID: workID}))
+	_, err = tasks.GetLabel(ctx, userID, somedayID)
+	assertErr(t, repository.ErrNotFound, err)
+	assert(t, []string{"job"}, names(find(t, "plan")))
+	assert(t, []string{"job"}, names(find(t, "novel")))
+	assert(t, []string{"novel", "plan"}, titles(list(t, []string{"job"}, nil)))
+
+	// deleting a label takes it off its tasks
+	assert(t, nil, tasks.DeleteLabel(ctx, userID, homeID))
+	assertErr(t, repository.ErrNotFound, tasks.DeleteLabel(ctx, userID, homeID))
+	assert(t, []string{}, names(find(t, "dishes")))
+
+	// the next occurrence of a recurring task keeps its labels
+	due := sql.NullTime{Time: time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC), Valid: true}
+	create := repository.CreateTaskParams{UserID: userID, Title: "standup", DueAt: due, Recurrence: "FREQ=DAILY", LabelIDs: []uuid.UUID{workID}}
+	assert(t, nil, tasks.CreateTask(ctx, create))
+	standup := find(t, "standup")
+	done := repository.UpdateTaskParams{ID: standup.ID, UserID: userID, Title: "standup", IsDone: true, DueAt: due}
+	assert(t, nil, tasks.UpdateTask(ctx, done))
+	assert(t, []string{"novel", "plan", "standup", "standup"}, titles(list(t, []string{"job"}, nil)))
+
+	// a task goes away from its labels with it
+	assert(t, nil, tasks.DeleteTask(ctx, userID, standup.ID))
+	assert(t, []string{"novel", "plan", "standup"}, titles(list(t, []string{"job"}, nil)))
+}
+
+func testDeleteUser(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	assert(t, nil, tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, Title: "left behind"}))
+	projectID, err := tasks.CreateProject(ctx, repository.CreateProjectParams{UserID: userID, Name: "left behind"})
+	assert(t, nil, err)
+	labelID, err := tasks.CreateLabel(ctx, repository.CreateLabelParams{UserID: userID, Name: "left behind", Color: "#000000"})
+	assert(t, nil, err)
+	create := repository.CreateTaskParams{UserID: userID, ProjectID: projectID, Title: "left behind", LabelIDs: []uuid.UUID{labelID}}
+	assert(t, nil, tasks.CreateTask(ctx, create))
+	got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, 2, len(got))
+
+	assert(t, nil, users.DeleteUser(ctx, userID))
+
+	_, err = users.GetUser(ctx, userID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = tasks.GetTask(ctx, userID, got[0].ID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	got, err = tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, 0, len(got))
+
+	_, err = tasks.GetProject(ctx, userID, projectID)
+	assertErr(t, repository.ErrNotFound, err)
+
+	_, err = tasks.GetLabel(ctx, userID, labelID)
+	assertErr(t, repository.ErrNotFound, err)
+}
+
+func testConcurrentWrites(t *testing.T, tasks repository.TaskStore, users repository.UserStore) {
+	ctx := context.Background()
+	userID := createUser(t, users)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- tasks.CreateTask(ctx, repository.CreateTaskParams{UserID: userID, Title: fmt.Sprintf("task%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert(t, nil, err)
+	}
+
+	got, err := tasks.GetTasks(ctx, repository.GetTasksParams{UserID: userID})
+	assert(t, nil, err)
+	assert(t, n, len(got))
+}
+
+func createUser(t *testing.T, users repository.UserStore) uuid.UUID {
+	t.Helper()
+
+	userID, err := users.CreateUser(context.Background(), repository.CreateUserParams{Name: uniqueName("user"), Password: "pass"})
+	assert(t, nil, err)
+	return userID
+}
+
+// uniqueName keeps users apart from those of other tests sharing the store
+func uniqueName(prefix string) string {
+	return "storetest_" + prefix + "_" + uuid.NewString()[:8]
+}
+
+func titles(tasks []repository.Task) []string {
+	titles := make([]string, len(tasks))
+	for i, task := range tasks {
+		titles[i] = task.Title
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func sortedTitles(tasks []repository.SearchedTask) []string {
+	titles := make([]string, len(tasks))
+	for i, task := range tasks {
+		titles[i] = task.Title
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func assert(t *testing.T, expected any, actual any) {
+	t.Helper()
+
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("diff: %v", diff)
+	}
+}
+
+func assertErr(t *testing.T, expected error, actual error) {
+	t.Helper()
+
+	if !errors.Is(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -2,75 +2,436 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/search"
 	"github.com/google/uuid"
 )
 
 type (
 	// tasks table
 	Task struct {
-		ID        uuid.UUID `db:"id"`
-		UserID    uuid.UUID `db:"user_id"`
-		Title     string    `db:"title"`
-		IsDone    bool      `db:"is_done"`
-		CreatedAt string    `db:"created_at"`
+		ID          uuid.UUID      `db:"id"`
+		UserID      uuid.UUID      `db:"user_id"`
+		ProjectID   uuid.UUID      `db:"project_id"`
+		Title       string         `db:"title"`
+		IsDone      bool           `db:"is_done"`
+		DueAt       sql.NullTime   `db:"due_at"`
+		DueTimeZone sql.NullString `db:"due_time_zone"`
+		Reminders   string         `db:"reminders"`
+		// SeriesID is the series a recurring task is an occurrence of
+		SeriesID   uuid.NullUUID `db:"series_id"`
+		Recurrence string        `db:"recurrence"`
+		// OccurrenceAt is the occurrence of the series the task stands for, whatever its due date has been moved to
+		OccurrenceAt sql.NullTime `db:"occurrence_at"`
+		CreatedAt    string       `db:"created_at"`
+		// Labels are loaded along with the task, sorted by name
+		Labels []Label `db:"-"`
+	}
+
+	GetTasksParams struct {
+		UserID uuid.UUID
+		// ProjectID filters on the project unless uuid.Nil
+		ProjectID uuid.UUID
+		// IsDone filters on completion unless nil
+		IsDone *bool
+		// CreatedAfter and CreatedBefore are exclusive bounds, ignored when zero
+		CreatedAfter  time.Time
+		CreatedBefore time.Time
+		// DueBefore is an exclusive bound that leaves out tasks without a due date, ignored when zero
+		DueBefore time.Time
+		// TitleContains matches titles containing it, ignoring case
+		TitleContains string
+		// Labels are names of labels the tasks have every one of, and ExcludeLabels of those they have none of
+		Labels        []string
+		ExcludeLabels []string
+		Sort          TaskSort
+		Desc          bool
+		// After continues a listing behind the task the cursor was taken from
+		After *TaskCursor
+		// Limit caps the number of tasks unless 0
+		Limit int
+	}
+
+	// TaskCursor is the position of a task in a listing
+	TaskCursor struct {
+		ID    uuid.UUID
+		Title string
+		// At is the creation time or the due date, whichever the listing is sorted by. It is zero for a task without a due date.
+		At time.Time
 	}
 
 	SearchTasksParams struct {
 		UserID uuid.UUID
-		Target string
+		Target search.Query
+	}
+
+	SearchedTask struct {
+		Task
+		Score float64 `db:"score"`
 	}
 
 	CreateTaskParams struct {
 		UserID uuid.UUID
-		Title  string
+		// ProjectID is the Inbox when uuid.Nil. Otherwise it must be a project of the user that is not archived.
+		ProjectID   uuid.UUID
+		Title       string
+		DueAt       sql.NullTime
+		DueTimeZone string
+		// Reminders are minutes before DueAt
+		Reminders []int
+		// Recurrence is an RRULE repeating the task from DueAt, which it requires
+		Recurrence string
+		// LabelIDs must be labels of the user, and must not repeat
+		LabelIDs []uuid.UUID
 	}
 
 	UpdateTaskParams struct {
 		ID     uuid.UUID
-		Title  string
-		IsDone bool
+		UserID uuid.UUID
+		// ProjectID keeps the task in its project when uuid.Nil.
+		// A task may stay in a project archived since, but is only moved like CreateTaskParams.ProjectID.
+		ProjectID   uuid.UUID
+		Title       string
+		IsDone      bool
+		DueAt       sql.NullTime
+		DueTimeZone string
+		Reminders   []int
+		// Following applies the edit to the following occurrences of a recurring task too,
+		// by starting a new series with Recurrence from this one. The task stops repeating when Recurrence is empty.
+		// Otherwise the edit is for this occurrence only and Recurrence is ignored.
+		Following  bool
+		Recurrence string
+		// LabelIDs replace the labels of the task unless nil, like CreateTaskParams.LabelIDs
+		LabelIDs []uuid.UUID
 	}
 )
 
-func (r *Repository) GetTasks(ctx context.Context, UserID uuid.UUID) ([]Task, error) {
+// TaskSort is the key tasks are listed by. Ties are broken by ID.
+type TaskSort string
+
+const (
+	TaskSortCreated TaskSort = "created"
+	TaskSortTitle   TaskSort = "title"
+	// TaskSortDue puts tasks without a due date last in either direction
+	TaskSortDue TaskSort = "due"
+)
+
+// ReminderList returns the minutes before due_at that reminders go off
+func (t *Task) ReminderList() []int {
+	reminders := []int{}
+	for _, field := range strings.Fields(t.Reminders) {
+		if minutes, err := strconv.Atoi(field); err == nil {
+			reminders = append(reminders, minutes)
+		}
+	}
+
+	return reminders
+}
+
+// JoinReminders formats reminders for the reminders column
+func JoinReminders(reminders []int) string {
+	fields := make([]string, len(reminders))
+	for i, minutes := range reminders {
+		fields[i] = strconv.Itoa(minutes)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// Cursor returns the position of the task in a listing sorted by sort
+func (t Task) Cursor(sort TaskSort) (TaskCursor, error) {
+	cursor := TaskCursor{ID: t.ID}
+	switch sort {
+	case TaskSortTitle:
+		cursor.Title = t.Title
+	case TaskSortDue:
+		if t.DueAt.Valid {
+			cursor.At = t.DueAt.Time
+		}
+	default:
+		createdAt, err := time.Parse(time.RFC3339Nano, t.CreatedAt)
+		if err != nil {
+			return TaskCursor{}, fmt.Errorf("parse created_at: %w", err)
+		}
+		cursor.At = createdAt
+	}
+
+	return cursor, nil
+}
+
+// GetTasks lists the user's tasks sorted by params.Sort, oldest first when it is empty
+func (r *Repository) GetTasks(ctx context.Context, params GetTasksParams) ([]Task, error) {
+	query := "SELECT * FROM tasks WHERE user_id = ?"
+	args := []interface{}{params.UserID}
+
+	if params.ProjectID != uuid.Nil {
+		query += " AND project_id = ?"
+		args = append(args, params.ProjectID)
+	}
+	if params.IsDone != nil {
+		query += " AND is_done = ?"
+		args = append(args, *params.IsDone)
+	}
+	if !params.CreatedAfter.IsZero() {
+		query += " AND created_at > ?"
+		args = append(args, params.CreatedAfter)
+	}
+	if !params.CreatedBefore.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, params.CreatedBefore)
+	}
+	if !params.DueBefore.IsZero() {
+		query += " AND due_at < ?"
+		args = append(args, params.DueBefore)
+	}
+	if params.TitleContains != "" {
+		query += " AND title LIKE ?"
+		args = append(args, "%"+escapeLike(params.TitleContains)+"%")
+	}
+	hasLabel := "EXISTS (SELECT 1 FROM task_labels tl JOIN labels l ON l.id = tl.label_id WHERE tl.task_id = tasks.id AND l.name = ?)"
+	for _, name := range params.Labels {
+		query += " AND " + hasLabel
+		args = append(args, name)
+	}
+	for _, name := range params.ExcludeLabels {
+		query += " AND NOT " + hasLabel
+		args = append(args, name)
+	}
+
+	op, dir := ">", "ASC"
+	if params.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	column := "created_at"
+	switch params.Sort {
+	case TaskSortTitle:
+		column = "title"
+	case TaskSortDue:
+		column = "due_at"
+	}
+
+	if after := params.After; after != nil {
+		switch {
+		case params.Sort == TaskSortTitle:
+			query += fmt.Sprintf(" AND (title %s ? OR (title = ? AND id %s ?))", op, op)
+			args = append(args, after.Title, after.Title, after.ID)
+		case params.Sort == TaskSortDue && after.At.IsZero():
+			query += fmt.Sprintf(" AND due_at IS NULL AND id %s ?", op)
+			args = append(args, after.ID)
+		case params.Sort == TaskSortDue:
+			query += fmt.Sprintf(" AND (due_at IS NULL OR due_at %s ? OR (due_at = ? AND id %s ?))", op, op)
+			args = append(args, after.At, after.At, after.ID)
+		default:
+			query += fmt.Sprintf(" AND (created_at %s ? OR (created_at = ? AND id %s ?))", op, op)
+			args = append(args, after.At, after.At, after.ID)
+		}
+	}
+
+	order := fmt.Sprintf("%s %s, id %s", column, dir, dir)
+	if params.Sort == TaskSortDue {
+		order = "due_at IS NULL, " + order
+	}
+	query += " ORDER BY " + order
+	if params.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, params.Limit)
+	}
+
 	tasks := []Task{}
-	if err := r.db.SelectContext(ctx, &tasks, "SELECT * FROM tasks WHERE user_id = ?", UserID); err != nil {
+	if err := r.db.SelectContext(ctx, &tasks, query, args...); err != nil {
+		return nil, fmt.Errorf("select tasks: %w", err)
+	}
+
+	loaded := make([]*Task, len(tasks))
+	for i := range tasks {
+		loaded[i] = &tasks[i]
+	}
+	if err := loadLabels(ctx, r.db, loaded); err != nil {
 		return nil, err
 	}
 
 	return tasks, nil
 }
 
-func (r *Repository) SearchTasks(ctx context.Context, params SearchTasksParams) ([]Task, error) {
-	tasks := []Task{}
+// GetTask returns ErrNotFound unless the task exists and belongs to userID
+func (r *Repository) GetTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) (*Task, error) {
+	task := &Task{}
+	if err := r.db.GetContext(ctx, task, "SELECT * FROM tasks WHERE id = ? AND user_id = ?", taskID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select task: %w", err)
+	}
 
-	// TODO
+	if err := loadLabels(ctx, r.db, []*Task{task}); err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (r *Repository) SearchTasks(ctx context.Context, params SearchTasksParams) ([]SearchedTask, error) {
+	tasks := []SearchedTask{}
+	if params.Target.IsEmpty() {
+		return tasks, nil
+	}
+
+	against := params.Target.Boolean()
+	query := `SELECT *, MATCH (title) AGAINST (? IN BOOLEAN MODE) AS score FROM tasks
+		WHERE user_id = ? AND MATCH (title) AGAINST (? IN BOOLEAN MODE)
+		ORDER BY score DESC, created_at DESC`
+	if err := r.db.SelectContext(ctx, &tasks, query, against, params.UserID, against); err != nil {
+		return nil, fmt.Errorf("search tasks: %w", err)
+	}
+
+	loaded := make([]*Task, len(tasks))
+	for i := range tasks {
+		loaded[i] = &tasks[i].Task
+	}
+	if err := loadLabels(ctx, r.db, loaded); err != nil {
+		return nil, err
+	}
 
 	return tasks, nil
 }
 
+// CreateTask schedules the reminders of the task along with it, and starts its series when it repeats
 func (r *Repository) CreateTask(ctx context.Context, params CreateTaskParams) error {
-	taskID := uuid.New()
-	if _, err := r.db.ExecContext(ctx, "INSERT INTO tasks (id, user_id, title) VALUES (?, ?, ?)", taskID, params.UserID, params.Title); err != nil {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	projectID := params.ProjectID
+	if projectID == uuid.Nil {
+		if projectID, err = getInboxID(ctx, tx, params.UserID); err != nil {
+			return err
+		}
+	} else if err := lockProject(ctx, tx, params.UserID, projectID); err != nil {
+		return err
+	}
+
+	task := &Task{
+		ID:          uuid.New(),
+		UserID:      params.UserID,
+		ProjectID:   projectID,
+		Title:       params.Title,
+		DueAt:       params.DueAt,
+		DueTimeZone: sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""},
+		Reminders:   JoinReminders(params.Reminders),
+	}
+	if series := params.Series(); series != nil {
+		if err := insertTaskSeries(ctx, tx, series); err != nil {
+			return err
+		}
+		task.SeriesID = uuid.NullUUID{UUID: series.ID, Valid: true}
+		task.Recurrence = series.Recurrence
+		task.OccurrenceAt = params.DueAt
+	}
+
+	if err := insertTask(ctx, tx, task); err != nil {
+		return err
+	}
+
+	if err := setTaskLabels(ctx, tx, params.UserID, task.ID, params.LabelIDs); err != nil {
 		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
 	return nil
 }
 
+// UpdateTask reschedules the reminders of the task.
+// Completing an occurrence of a recurring task creates the next one, in the same project and with the same labels.
 func (r *Repository) UpdateTask(ctx context.Context, params UpdateTaskParams) error {
-	if _, err := r.db.ExecContext(ctx, "UPDATE tasks SET title = ?, is_done = ? WHERE id = ?", params.Title, params.IsDone, params.ID); err != nil {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	task, err := lockTask(ctx, tx, params.UserID, params.ID)
+	if err != nil {
+		return err
+	}
+
+	// a task may stay in a project archived since, but not be moved into one
+	if params.ProjectID == uuid.Nil {
+		params.ProjectID = task.ProjectID
+	} else if params.ProjectID != task.ProjectID {
+		if err := lockProject(ctx, tx, params.UserID, params.ProjectID); err != nil {
+			return err
+		}
+	}
+
+	timeZone := sql.NullString{String: params.DueTimeZone, Valid: params.DueTimeZone != ""}
+	query := "UPDATE tasks SET project_id = ?, title = ?, is_done = ?, due_at = ?, due_time_zone = ?, reminders = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, params.ProjectID, params.Title, params.IsDone, params.DueAt, timeZone, JoinReminders(params.Reminders), params.ID); err != nil {
 		return err
 	}
 
+	if params.Following {
+		var previous *TaskSeries
+		if task.SeriesID.Valid {
+			previous = &TaskSeries{}
+			if err := tx.GetContext(ctx, previous, "SELECT * FROM task_series WHERE id = ?", task.SeriesID.UUID); err != nil {
+				return fmt.Errorf("select task series: %w", err)
+			}
+		}
+
+		series, err := params.Series(task, previous)
+		if err != nil {
+			return err
+		}
+		if err := setTaskSeries(ctx, tx, task, series); err != nil {
+			return err
+		}
+	}
+
+	if err := setReminders(ctx, tx, params.ID, params.DueAt, params.Reminders); err != nil {
+		return err
+	}
+
+	if params.LabelIDs != nil {
+		if err := setTaskLabels(ctx, tx, params.UserID, params.ID, params.LabelIDs); err != nil {
+			return err
+		}
+	}
+
+	if !task.IsDone && params.IsDone && task.SeriesID.Valid {
+		task.ProjectID = params.ProjectID
+		if err := createNextOccurrence(ctx, tx, task); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
 	return nil
 }
 
-func (r *Repository) DeleteTask(ctx context.Context, taskID uuid.UUID) error {
-	if _, err := r.db.ExecContext(ctx, "DELETE FROM tasks WHERE id = ?", taskID); err != nil {
+// DeleteTask ends the series of a recurring task, as no occurrence is left to follow it
+func (r *Repository) DeleteTask(ctx context.Context, userID uuid.UUID, taskID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM tasks WHERE id = ? AND user_id = ?", taskID, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(result); err != nil {
 		return err
 	}
 
-	return nil
+	return pruneTaskSeries(ctx, r.db, userID)
 }
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// auth_throttles table
+	AuthThrottle struct {
+		Key           string       `db:"throttle_key"`
+		Failures      int          `db:"failures"`
+		LockedUntil   sql.NullTime `db:"locked_until"`
+		LastFailureAt time.Time    `db:"last_failure_at"`
+	}
+
+	ReserveAuthAttemptParams struct {
+		Key string
+		// Window is how long a failure is remembered; an older count starts over
+		Window time.Duration
+		// Delay is how long attempts are refused after the given number of failures
+		Delay func(failures int) time.Duration
+	}
+)
+
+// ErrThrottled is returned by ReserveAuthAttempt while attempts for the key are refused
+var ErrThrottled = errors.New("throttled")
+
+// IsLocked reports whether attempts are refused at now
+func (t *AuthThrottle) IsLocked(now time.Time) bool {
+	return t.LockedUntil.Valid && now.Before(t.LockedUntil.Time)
+}
+
+// GetAuthThrottle returns ErrNotFound when the key has no recorded failures
+func (r *Repository) GetAuthThrottle(ctx context.Context, key string) (*AuthThrottle, error) {
+	throttle := &AuthThrottle{}
+	if err := r.db.GetContext(ctx, throttle, "SELECT * FROM auth_throttles WHERE throttle_key = ?", key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select auth throttle: %w", err)
+	}
+
+	return throttle, nil
+}
+
+// Reserve counts an attempt at now as a failure and locks the throttle for the delay that many failures earn.
+// It returns ErrThrottled, leaving t as it is, while t is locked.
+func (t *AuthThrottle) Reserve(params ReserveAuthAttemptParams, now time.Time) error {
+	if t.IsLocked(now) {
+		return ErrThrottled
+	}
+
+	if t.LastFailureAt.Before(now.Add(-params.Window)) {
+		t.Failures = 0
+	}
+	t.Failures++
+	t.LastFailureAt = now
+
+	t.LockedUntil = sql.NullTime{}
+	if delay := params.Delay(t.Failures); delay > 0 {
+		t.LockedUntil = sql.NullTime{Time: now.Add(delay), Valid: true}
+	}
+
+	return nil
+}
+
+// Release takes back the attempt counted by the reservation that left t as reserved.
+// The lock it set is lifted unless a later attempt has replaced it.
+func (t *AuthThrottle) Release(reserved *AuthThrottle) {
+	if t.Failures > 0 {
+		t.Failures--
+	}
+	if t.LockedUntil.Valid && reserved.LockedUntil.Valid && t.LockedUntil.Time.Equal(reserved.LockedUntil.Time) {
+		t.LockedUntil = sql.NullTime{}
+	}
+}
+
+// ReserveAuthAttempt counts an attempt against the key before its credentials are checked,
+// so that parallel attempts cannot all get in before the first failure is recorded.
+// It returns ErrThrottled while attempts are refused, and the updated row otherwise.
+func (r *Repository) ReserveAuthAttempt(ctx context.Context, params ReserveAuthAttemptParams) (*AuthThrottle, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	throttle, err := lockAuthThrottleRow(ctx, tx, params.Key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := throttle.Reserve(params, now); err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE auth_throttles SET failures = ?, locked_until = ?, last_failure_at = ? WHERE throttle_key = ?"
+	if _, err := tx.ExecContext(ctx, query, throttle.Failures, throttle.LockedUntil, throttle.LastFailureAt, params.Key); err != nil {
+		return nil, fmt.Errorf("reserve auth attempt: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return throttle, nil
+}
+
+// ReleaseAuthAttempt takes back an attempt ReserveAuthAttempt counted once it turns out not to have failed
+func (r *Repository) ReleaseAuthAttempt(ctx context.Context, reserved *AuthThrottle) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	throttle := &AuthThrottle{}
+	if err := tx.GetContext(ctx, throttle, "SELECT * FROM auth_throttles WHERE throttle_key = ? FOR UPDATE", reserved.Key); err != nil {
+		// the key has been reset since
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("select auth throttle: %w", err)
+	}
+
+	throttle.Release(reserved)
+	if _, err := tx.ExecContext(ctx, "UPDATE auth_throttles SET failures = ?, locked_until = ? WHERE throttle_key = ?", throttle.Failures, throttle.LockedUntil, reserved.Key); err != nil {
+		return fmt.Errorf("release auth attempt: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// LockAuthThrottle refuses attempts for the key until the given time
+func (r *Repository) LockAuthThrottle(ctx context.Context, key string, until time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE auth_throttles SET locked_until = ? WHERE throttle_key = ?", until, key); err != nil {
+		return fmt.Errorf("lock auth throttle: %w", err)
+	}
+
+	return nil
+}
+
+// ResetAuthThrottle forgets the failures of the key
+func (r *Repository) ResetAuthThrottle(ctx context.Context, key string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM auth_throttles WHERE throttle_key = ?", key); err != nil {
+		return fmt.Errorf("reset auth throttle: %w", err)
+	}
+
+	return nil
+}
+
+// lockAuthThrottleRow returns the row of the key, created without failures if there is none, locked until the tx ends
+func lockAuthThrottleRow(ctx context.Context, tx *sqlx.Tx, key string, now time.Time) (*AuthThrottle, error) {
+	query := `INSERT INTO auth_throttles (throttle_key, failures, last_failure_at) VALUES (?, 0, ?)
+		ON DUPLICATE KEY UPDATE throttle_key = throttle_key`
+	if _, err := tx.ExecContext(ctx, query, key, now); err != nil {
+		return nil, fmt.Errorf("insert auth throttle: %w", err)
+	}
+
+	throttle := &AuthThrottle{}
+	if err := tx.GetContext(ctx, throttle, "SELECT * FROM auth_throttles WHERE throttle_key = ? FOR UPDATE", key); err != nil {
+		return nil, fmt.Errorf("select auth throttle: %w", err)
+	}
+
+	return throttle, nil
+}
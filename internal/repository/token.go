@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// personal_access_tokens table
+	PersonalAccessToken struct {
+		ID         uuid.UUID    `db:"id"`
+		UserID     uuid.UUID    `db:"user_id"`
+		Name       string       `db:"name"`
+		TokenHash  []byte       `db:"token_hash"`
+		Scopes     string       `db:"scopes"`
+		ExpiresAt  sql.NullTime `db:"expires_at"`
+		LastUsedAt sql.NullTime `db:"last_used_at"`
+		CreatedAt  time.Time    `db:"created_at"`
+	}
+
+	CreatePersonalAccessTokenParams struct {
+		UserID uuid.UUID
+		Name   string
+		Token  string
+		Scopes []string
+		// ExpiresAt is nil for a token that does not expire
+		ExpiresAt *time.Time
+	}
+)
+
+// ScopeList splits the space separated scopes column
+func (t *PersonalAccessToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// IsActive reports whether the token can still be used at now
+func (t *PersonalAccessToken) IsActive(now time.Time) bool {
+	return !t.ExpiresAt.Valid || now.Before(t.ExpiresAt.Time)
+}
+
+func (r *Repository) CreatePersonalAccessToken(ctx context.Context, params CreatePersonalAccessTokenParams) (uuid.UUID, error) {
+	tokenID := uuid.New()
+
+	var expiresAt sql.NullTime
+	if params.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *params.ExpiresAt, Valid: true}
+	}
+
+	query := "INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := r.db.ExecContext(ctx, query, tokenID, params.UserID, params.Name, HashToken(params.Token), strings.Join(params.Scopes, " "), expiresAt); err != nil {
+		return uuid.Nil, fmt.Errorf("insert personal access token: %w", err)
+	}
+
+	return tokenID, nil
+}
+
+func (r *Repository) GetPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]PersonalAccessToken, error) {
+	tokens := []PersonalAccessToken{}
+	if err := r.db.SelectContext(ctx, &tokens, "SELECT * FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC", userID); err != nil {
+		return nil, fmt.Errorf("select personal access tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// GetPersonalAccessTokenByToken looks a token up by its secret value
+func (r *Repository) GetPersonalAccessTokenByToken(ctx context.Context, token string) (*PersonalAccessToken, error) {
+	pat := &PersonalAccessToken{}
+	if err := r.db.GetContext(ctx, pat, "SELECT * FROM personal_access_tokens WHERE token_hash = ?", HashToken(token)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select personal access token: %w", err)
+	}
+
+	return pat, nil
+}
+
+func (r *Repository) TouchPersonalAccessToken(ctx context.Context, tokenID uuid.UUID) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE personal_access_tokens SET last_used_at = ? WHERE id = ?", time.Now(), tokenID); err != nil {
+		return fmt.Errorf("touch personal access token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeletePersonalAccessToken(ctx context.Context, userID uuid.UUID, tokenID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("delete personal access token: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeletePersonalAccessTokens deletes every token of the user
+func (r *Repository) DeletePersonalAccessTokens(ctx context.Context, userID uuid.UUID) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM personal_access_tokens WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete personal access tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// user_totp table
+	UserTOTP struct {
+		UserID       uuid.UUID    `db:"user_id"`
+		Secret       []byte       `db:"secret"`
+		LastUsedStep int64        `db:"last_used_step"`
+		ConfirmedAt  sql.NullTime `db:"confirmed_at"`
+		CreatedAt    time.Time    `db:"created_at"`
+	}
+
+	SetTOTPParams struct {
+		UserID uuid.UUID
+		// Secret is already encrypted
+		Secret []byte
+	}
+)
+
+func (r *Repository) GetTOTP(ctx context.Context, userID uuid.UUID) (*UserTOTP, error) {
+	totp := &UserTOTP{}
+	if err := r.db.GetContext(ctx, totp, "SELECT * FROM user_totp WHERE user_id = ?", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select totp: %w", err)
+	}
+
+	return totp, nil
+}
+
+// SetPendingTOTP stores a secret waiting for confirmation, replacing an earlier unconfirmed one.
+// It returns ErrAlreadyExists when two-factor authentication is already enabled.
+func (r *Repository) SetPendingTOTP(ctx context.Context, params SetTOTPParams) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	totp := &UserTOTP{}
+	err = tx.GetContext(ctx, totp, "SELECT * FROM user_totp WHERE user_id = ? FOR UPDATE", params.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("select totp: %w", err)
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		return ErrAlreadyExists
+	}
+
+	if _, err := tx.ExecContext(ctx, "REPLACE INTO user_totp (user_id, secret) VALUES (?, ?)", params.UserID, params.Secret); err != nil {
+		return fmt.Errorf("replace totp: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records a verified code so the same or an earlier one is not accepted again.
+// It returns ErrNotFound when the step was already used.
+func (r *Repository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?", step, userID, step)
+	if err != nil {
+		return fmt.Errorf("update totp step: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// ConfirmTOTP enables two-factor authentication and replaces the recovery codes
+func (r *Repository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE user_totp SET confirmed_at = ? WHERE user_id = ? AND confirmed_at IS NULL", time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("confirm totp: %w", err)
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates every previous recovery code.
+// Codes are stored like other tokens with HashToken, so they must carry at least 128 random bits.
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, recoveryCodes []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns ErrNotFound when there is none.
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL", time.Now(), userID, HashToken(code))
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+func (r *Repository) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	if err := r.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+
+	return n, nil
+}
+
+// DeleteTOTP disables two-factor authentication
+func (r *Repository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete totp: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, codes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (code_hash, user_id) VALUES (?, ?)", HashToken(code), userID); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -2,26 +2,34 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type (
 	// users table
 	User struct {
-		ID        uuid.UUID `db:"id"`
-		Name      string    `db:"name"`
-		Password  []byte    `db:"password"`
-		UpdatedAt time.Time `db:"updated_at"`
-		CreatedAt time.Time `db:"created_at"`
+		ID                    uuid.UUID      `db:"id"`
+		Name                  string         `db:"name"`
+		Password              []byte         `db:"password"`
+		Email                 sql.NullString `db:"email"`
+		EmailVerifiedAt       sql.NullTime   `db:"email_verified_at"`
+		Role                  string         `db:"role"`
+		DisabledAt            sql.NullTime   `db:"disabled_at"`
+		PasswordResetRequired bool           `db:"password_reset_required"`
+		UpdatedAt             time.Time      `db:"updated_at"`
+		CreatedAt             time.Time      `db:"created_at"`
 	}
 
 	CreateUserParams struct {
 		Name     string
 		Password string
+		// Email is optional, and unverified until the user follows the mailed link
+		Email string
 	}
 
 	UpdateNameParams struct {
@@ -33,84 +41,234 @@ type (
 		ID       uuid.UUID
 		Password string
 	}
+
+	UpdateEmailParams struct {
+		ID    uuid.UUID
+		Email string
+	}
 )
 
+// CreateUser also creates the user's Inbox
 func (r *Repository) CreateUser(ctx context.Context, params CreateUserParams) (uuid.UUID, error) {
 	userID := uuid.New()
-	hased, err := hashPassword(params.Password)
+	hased, err := r.passwords.Hash(params.Password)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("hash password: %w", err)
 	}
 
-	if _, err := r.db.ExecContext(ctx, "INSERT INTO users (id, name, password) VALUES (?, ?, ?)", userID, params.Name, hased); err != nil {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	email := sql.NullString{String: params.Email, Valid: params.Email != ""}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO users (id, name, password, email) VALUES (?, ?, ?, ?)", userID, params.Name, hased, email); err != nil {
+		if isDuplicateEntry(err) {
+			return uuid.Nil, ErrAlreadyExists
+		}
 		return uuid.Nil, fmt.Errorf("insert user: %w", err)
 	}
 
+	if err := insertInbox(ctx, tx, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("commit tx: %w", err)
+	}
+
 	return userID, nil
 }
 
 func (r *Repository) GetUserID(ctx context.Context, name string) (uuid.UUID, error) {
 	user := &User{}
 	if err := r.db.GetContext(ctx, user, "SELECT * FROM users WHERE name = ?", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrNotFound
+		}
 		return uuid.Nil, fmt.Errorf("select user: %w", err)
 	}
 
 	return user.ID, nil
 }
 
+// GetUser returns ErrNotFound for an unknown user
 func (r *Repository) GetUser(ctx context.Context, userID uuid.UUID) (*User, error) {
 	user := &User{}
 	if err := r.db.GetContext(ctx, user, "SELECT * FROM users WHERE id = ?", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
 		return nil, fmt.Errorf("select user: %w", err)
 	}
 
 	return user, nil
 }
 
+// UpdateName returns ErrAlreadyExists when another user has the name
 func (r *Repository) UpdateName(ctx context.Context, params UpdateNameParams) error {
 	if _, err := r.db.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ?", params.Name, params.ID); err != nil {
+		if isDuplicateEntry(err) {
+			return ErrAlreadyExists
+		}
 		return fmt.Errorf("update user name: %w", err)
 	}
 
 	return nil
 }
 
+// UpdatePass also lifts a required password reset
 func (r *Repository) UpdatePass(ctx context.Context, params UpdatePassParams) error {
 
-	hashed, err := hashPassword(params.Password)
+	hashed, err := r.passwords.Hash(params.Password)
 	if err != nil {
 		return fmt.Errorf("hash password: %w", err)
 	}
 
-	if _, err := r.db.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", hashed, params.ID); err != nil {
+	if _, err := r.db.ExecContext(ctx, "UPDATE users SET password = ?, password_reset_required = FALSE WHERE id = ?", hashed, params.ID); err != nil {
 		return fmt.Errorf("update user password: %w", err)
 	}
 
 	return nil
 }
 
+// GetUserByEmail returns the user who verified the address, or ErrNotFound when no one has
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user := &User{}
+	if err := r.db.GetContext(ctx, user, "SELECT * FROM users WHERE email = ? AND email_verified_at IS NOT NULL", email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select user: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdateEmail sets the address, which has to be verified again unless it did not change.
+// Other users may have the address as long as none of them verified it.
+func (r *Repository) UpdateEmail(ctx context.Context, params UpdateEmailParams) error {
+	// assignments run left to right, so email_verified_at still compares against the old address
+	query := "UPDATE users SET email_verified_at = IF(email <=> ?, email_verified_at, NULL), email = ? WHERE id = ?"
+	if _, err := r.db.ExecContext(ctx, query, params.Email, params.Email, params.ID); err != nil {
+		return fmt.Errorf("update user email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks email as verified. It returns ErrNotFound when the user's address has changed since,
+// and ErrAlreadyExists when another user verified the address first.
+func (r *Repository) VerifyEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ? AND email = ?", time.Now(), userID, email)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("verify user email: %w", err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteUser deletes the user's tasks along with them
 func (r *Repository) DeleteUser(ctx context.Context, userID uuid.UUID) error {
-	if _, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// tasks reference users without ON DELETE CASCADE
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tasks WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete user tasks: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
 		return fmt.Errorf("delete user: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
 	return nil
 }
 
+// Authenticate checks a name and password pair.
+// It returns ErrInvalidCredentials whether the name or the password is wrong,
+// and takes about as long either way so that response times do not reveal which names exist.
+// With the right password, it returns ErrAccountDisabled or ErrPasswordResetRequired when the user may not sign in.
+func (r *Repository) Authenticate(ctx context.Context, name string, password string) (uuid.UUID, error) {
+	user := &User{}
+	if err := r.db.GetContext(ctx, user, "SELECT * FROM users WHERE name = ?", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_, _, _ = r.passwords.Verify(password, r.dummyHash)
+			return uuid.Nil, ErrInvalidCredentials
+		}
+		return uuid.Nil, fmt.Errorf("select user: %w", err)
+	}
+
+	ok, err := r.verifyPassword(ctx, user, password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, ErrInvalidCredentials
+	}
+
+	if user.DisabledAt.Valid {
+		return uuid.Nil, ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		return uuid.Nil, ErrPasswordResetRequired
+	}
+
+	return user.ID, nil
+}
+
 func (r *Repository) CheckPass(ctx context.Context, userID uuid.UUID, password string) (bool, error) {
 	user := &User{}
 	if err := r.db.GetContext(ctx, user, "SELECT * FROM users WHERE id = ?", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNotFound
+		}
 		return false, fmt.Errorf("select user: %w", err)
 	}
 
-	return comparePassword(password, user.Password), nil
+	return r.verifyPassword(ctx, user, password)
 }
 
-func hashPassword(pass string) ([]byte, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
-	return hash, err
-}
+var (
+	// ErrInvalidCredentials is returned by Authenticate for an unknown name or a wrong password
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAccountDisabled is returned by Authenticate for a user an admin disabled
+	ErrAccountDisabled = errors.New("account disabled")
+	// ErrPasswordResetRequired is returned by Authenticate for a user who has to choose a new password first
+	ErrPasswordResetRequired = errors.New("password reset required")
+)
+
+// verifyPassword compares password with the stored hash.
+// A correct password stored with an outdated algorithm or cost is rehashed while it is at hand.
+func (r *Repository) verifyPassword(ctx context.Context, user *User, password string) (bool, error) {
+	ok, needsRehash, err := r.passwords.Verify(password, user.Password)
+	if err != nil {
+		return false, fmt.Errorf("verify password: %w", err)
+	}
+	if !ok || !needsRehash {
+		return ok, nil
+	}
+
+	hashed, err := r.passwords.Hash(password)
+	if err != nil {
+		return false, fmt.Errorf("hash password: %w", err)
+	}
+
+	// only replace the hash that was verified, in case the password changed meanwhile
+	if _, err := r.db.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?", hashed, user.ID, user.Password); err != nil {
+		return false, fmt.Errorf("rehash user password: %w", err)
+	}
 
-func comparePassword(pass string, hash []byte) bool {
-	return bcrypt.CompareHashAndPassword(hash, []byte(pass)) == nil
+	return true, nil
 }
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of a user token
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+type (
+	// user_tokens table
+	UserToken struct {
+		TokenHash []byte       `db:"token_hash"`
+		UserID    uuid.UUID    `db:"user_id"`
+		Purpose   string       `db:"purpose"`
+		Email     string       `db:"email"`
+		ExpiresAt time.Time    `db:"expires_at"`
+		UsedAt    sql.NullTime `db:"used_at"`
+		CreatedAt time.Time    `db:"created_at"`
+	}
+
+	CreateUserTokenParams struct {
+		UserID  uuid.UUID
+		Purpose string
+		// Email is the address the token was mailed to
+		Email     string
+		Token     string
+		ExpiresAt time.Time
+		// Cooldown is how long after the previous token of the same purpose no new one is issued
+		Cooldown time.Duration
+	}
+)
+
+// IsUsable reports whether the token can still be redeemed at now
+func (t *UserToken) IsUsable(now time.Time) bool {
+	return !t.UsedAt.Valid && now.Before(t.ExpiresAt)
+}
+
+// CreateUserToken stores a single-use token and retires the user's earlier ones of the same purpose.
+// It returns ErrAlreadyExists while the previous token is younger than the cooldown.
+func (r *Repository) CreateUserToken(ctx context.Context, params CreateUserTokenParams) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var latest sql.NullTime
+	if err := tx.GetContext(ctx, &latest, "SELECT MAX(created_at) FROM user_tokens WHERE user_id = ? AND purpose = ? FOR UPDATE", params.UserID, params.Purpose); err != nil {
+		return fmt.Errorf("select user token: %w", err)
+	}
+	if latest.Valid && time.Since(latest.Time) < params.Cooldown {
+		return ErrAlreadyExists
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", now, params.UserID, params.Purpose); err != nil {
+		return fmt.Errorf("retire user tokens: %w", err)
+	}
+
+	query := "INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, HashToken(params.Token), params.UserID, params.Purpose, params.Email, params.ExpiresAt, now); err != nil {
+		return fmt.Errorf("insert user token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserToken looks up a usable token without redeeming it.
+// It returns ErrNotFound for an unknown, used or expired token.
+func (r *Repository) GetUserToken(ctx context.Context, purpose string, token string) (*UserToken, error) {
+	t := &UserToken{}
+	if err := r.db.GetContext(ctx, t, "SELECT * FROM user_tokens WHERE token_hash = ? AND purpose = ?", HashToken(token), purpose); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select user token: %w", err)
+	}
+
+	if !t.IsUsable(time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	return t, nil
+}
+
+// UseUserToken redeems a token. Only one caller succeeds; the rest get ErrNotFound.
+func (r *Repository) UseUserToken(ctx context.Context, purpose string, token string) error {
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", now, HashToken(token), purpose, now)
+	if err != nil {
+		return fmt.Errorf("use user token: %w", err)
+	}
+
+	return checkAffected(result)
+}
@@ -1,15 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/Irori235/system-design-2023-v2/internal/handler"
 	"github.com/Irori235/system-design-2023-v2/internal/migration"
 	"github.com/Irori235/system-design-2023-v2/internal/pkg/config"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/keys"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/oidc"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/password"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/reminder"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/secretbox"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/security"
+	"github.com/Irori235/system-design-2023-v2/internal/pkg/tlscert"
 	"github.com/Irori235/system-design-2023-v2/internal/repository"
-	"github.com/gin-contrib/cors"
+	"github.com/Irori235/system-design-2023-v2/internal/repository/memory"
+	"github.com/Irori235/system-design-2023-v2/internal/repository/sqlite"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -17,42 +32,218 @@ func main() {
 	// setup gin
 	r := gin.Default()
 
-	// Middleware CORS
-	if config.AppEnv() == "development" {
-		r.Use(cors.New(cors.Config{
-			AllowOrigins: []string{"http://localhost:3000"},
-			AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-			AllowHeaders: []string{
-				"Access-Control-Allow-Origin",
-				"Access-Control-Allow-Methods",
-				"Access-Control-Allow-Headers",
-				"Access-Control-Allow-Credentials",
-				"Content-Type",
-				"Authorization",
-			},
-			AllowCredentials: true,
-		}))
+	// client addresses feed sign-in throttling, so only believe forwarded headers from our proxies
+	if err := r.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		log.Fatal(err)
+	}
+
+	// cookie, CORS and security header policy
+	securityPolicy, err := config.SecurityPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Use(security.Middleware(securityPolicy))
+
+	// setup store
+	passwordParams, err := config.PasswordHash()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passwords, err := password.NewHasher(passwordParams)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// connect to database
-	db, err := sqlx.Connect("mysql", config.MySQL().FormatDSN())
+	store, closeStore, err := openStore(passwords)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	defer closeStore()
 
-	// migrate tables
-	if err := migration.MigrateTables(db.DB); err != nil {
+	adminIDs, err := config.AdminUserIDs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := bootstrapAdmins(context.Background(), store, adminIDs); err != nil {
 		log.Fatal(err)
 	}
 
-	// setup repository
-	repo := repository.New(db)
+	// setup signing keys
+	keysConfig, err := config.JWTKeys(handler.MaxTokenTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keySet, err := keys.NewKeySet(keysConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go keySet.Run(context.Background())
+
+	// setup encryption of secrets at rest
+	secretKey, err := config.SecretKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if secretKey == nil {
+		if config.AppEnv() != "development" {
+			log.Fatal("SECRET_KEY is required")
+		}
+
+		log.Println("SECRET_KEY is not set; encrypted secrets will not survive a restart")
+		secretKey = make([]byte, secretbox.KeySize)
+		if _, err := rand.Read(secretKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	secrets, err := secretbox.New(secretKey)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// setup routes
-	h := handler.New(repo)
+	passwordPolicy, err := config.PasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := handler.New(store, keySet, secrets)
+	h.SetPasswordPolicy(passwordPolicy)
+	h.SetCookiePolicy(securityPolicy.Cookie)
+
+	mailer, err := config.Mailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	h.SetMailer(mailer, config.MailFrom())
+	h.SetPublicURL(config.PublicURL())
+
+	// deliver task reminders
+	reminderConfig, err := config.Reminders()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifier, err := config.Notifier(mailer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go reminder.NewScheduler(store, notifier, reminderConfig).Run(context.Background())
+
+	oidcConfigs, err := config.OIDCProviders()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oidcProviders := map[string]*oidc.Provider{}
+	for name, oidcConfig := range oidcConfigs {
+		oidcProviders[name] = oidc.NewProvider(oidcConfig, &http.Client{Timeout: 10 * time.Second})
+	}
+	h.SetOIDCProviders(oidcProviders)
 	v1API := r.Group("/api/v1")
 	h.SetupRoutes(v1API)
+	h.SetupWellKnownRoutes(r.Group("/.well-known"))
+
+	// serve HTTPS ourselves when there is no proxy in front to do it
+	tlsConfig, err := config.TLS()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !tlsConfig.Enabled() {
+		log.Fatal(r.Run(config.AppAddr()))
+	}
+
+	certs, err := tlscert.NewReloader(tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go certs.Run(context.Background())
+
+	server := &http.Server{
+		Addr:              config.AppAddr(),
+		Handler:           r,
+		TLSConfig:         certs.TLSConfig(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Listening and serving HTTPS on %s\n", server.Addr)
+	log.Fatal(server.ListenAndServeTLS("", ""))
+}
+
+// openStore connects to the store picked by STORE and migrates it. The returned func closes it.
+func openStore(passwords *password.Hasher) (repository.Store, func() error, error) {
+	switch driver := config.Store(); driver {
+	case "mysql":
+		db, err := sqlx.Connect("mysql", config.MySQL().FormatDSN())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := migration.MigrateTables(db.DB); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		repo, err := repository.New(db, passwords)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		return repo, db.Close, nil
+	case "sqlite":
+		db, err := sqlite.Open(config.SQLitePath())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		store, err := sqlite.New(db, passwords)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		return store, db.Close, nil
+	case "memory":
+		log.Println("STORE is memory; nothing will survive a restart")
+
+		store, err := memory.New(passwords)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return store, func() error { return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported STORE: %q", driver)
+	}
+}
+
+// bootstrapAdmins makes the given users admins, but only while there is no admin yet,
+// so that a demotion through the admin API is not undone by the next restart.
+// An unknown user ID is an error rather than something to wait for.
+func bootstrapAdmins(ctx context.Context, store repository.Store, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	admins, err := store.SearchUsers(ctx, repository.SearchUsersParams{Role: handler.RoleAdmin, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(admins) > 0 {
+		return nil
+	}
+
+	for _, userID := range userIDs {
+		err := store.UpdateRole(ctx, userID, handler.RoleAdmin)
+		if errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("ADMIN_USER_IDS: no user with ID %s", userID)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-	log.Fatal(r.Run(config.AppAddr()))
+	return nil
 }